@@ -6,6 +6,7 @@ require (
 	github.com/bmatcuk/doublestar/v4 v4.7.1
 	github.com/cakturk/go-netstat v0.0.0-20200220111822-e5b49efee7a5
 	github.com/gentlemanautomaton/cmdline v0.0.0-20190611233644-681aa5e68f1c
+	github.com/klauspost/compress v1.18.0
 	github.com/lestrrat-go/file-rotatelogs v2.4.0+incompatible
 	github.com/mattn/go-zglob v0.0.6
 	github.com/mitchellh/go-ps v1.0.0
@@ -1,9 +1,12 @@
 package agent
 
 import (
+	"context"
 	"errors"
 	"net"
+	"path/filepath"
 	"strconv"
+	"sync"
 	"yc-agent/internal/agent/api"
 	"yc-agent/internal/agent/common"
 	"yc-agent/internal/agent/m3"
@@ -16,7 +19,14 @@ import (
 
 var ErrNothingCanBeDone = errors.New("nothing can be done")
 
-func Run() error {
+var (
+	stopUploadSpool = make(chan struct{})
+	shutdownOnce    sync.Once
+)
+
+// Run runs the agent until its work is done, or ctx is done for the long-running
+// M3 and API modes. Cancelling ctx stops the running captures.
+func Run(ctx context.Context) error {
 	startupLogs()
 
 	onDemandMode := len(config.GlobalConfig.Pid) > 0
@@ -30,37 +40,51 @@ func Run() error {
 		return ErrNothingCanBeDone
 	}
 
+	err := capture.InitUploadState()
+	if err != nil {
+		logger.Log("WARNING: failed to resolve the storage path, upload state will be kept in the temp dir: %s", err)
+	}
+	startUploadSpool()
+
 	// TODO: This is for backward compatibility: API mode can run along with on demand and M3.
 	// Nobody of us knows whether there's any customer using this (on demand + API mode)
 	// I think we should clean it up eventually.
 	// On demand (short lived) run along with API mode feels strange.
 	// To clean it up: API mode can run standalone or along with M3, but not with on demand.
 	if apiMode {
-		go runAPIMode()
+		go runAPIMode(ctx)
 	}
 
 	if onDemandMode {
-		runOnDemandMode()
+		runOnDemandMode(ctx)
 	} else {
 		if m3Mode {
-			go runM3Mode()
+			go runM3Mode(ctx)
 		}
 
 		if m3Mode || apiMode {
 			// M3 and API mode keep running until the process is killed with a SIGTERM signal,
 			// so they need to block here
-			for {
-				dailyAttendance()
-			}
+			go func() {
+				for {
+					dailyAttendance()
+				}
+			}()
+			<-ctx.Done()
 		}
 	}
 
 	return nil
 }
 
+// Shutdown waits for the running captures and stops the upload spool. It may be
+// called more than once.
 func Shutdown() {
 	ondemand.Wg.Wait()
-	executils.RemoveFromTempPath()
+	shutdownOnce.Do(func() {
+		close(stopUploadSpool)
+		executils.RemoveFromTempPath()
+	})
 }
 
 func startupLogs() {
@@ -77,24 +101,43 @@ Resp: %s
 `, ok, msg)
 }
 
-func runAPIMode() {
-	apiServer := api.NewServer(config.GlobalConfig.Address, config.GlobalConfig.Port)
+// startUploadSpool starts retrying failed uploads in the background,
+// including the ones left over from previous runs.
+func startUploadSpool() {
+	if !config.GlobalConfig.UploadSpool || config.GlobalConfig.OnlyCapture {
+		return
+	}
+
+	_, err := capture.StartUploadSpool(stopUploadSpool)
+	if err != nil {
+		logger.Log("WARNING: failed to start upload spool, failed uploads won't be retried: %s", err)
+	}
+}
+
+func runAPIMode(ctx context.Context) {
+	apiServer := api.NewServer(ctx, config.GlobalConfig.Address, config.GlobalConfig.Port)
+	jobs, err := api.OpenJobStore(filepath.Join(config.GlobalConfig.StoragePath, "yc-jobs"))
+	if err != nil {
+		logger.Log("WARNING: failed to open the job store, jobs won't survive a restart: %s", err)
+	} else {
+		apiServer.Jobs = jobs
+	}
 	logger.Log("Running API mode on %s", net.JoinHostPort(config.GlobalConfig.Address, strconv.Itoa(config.GlobalConfig.Port)))
 
-	err := apiServer.Serve()
+	err = apiServer.Serve()
 	if err != nil {
 		logger.Log("WARNING: %s", err)
 	}
 }
 
-func runM3Mode() {
+func runM3Mode(ctx context.Context) {
 	logger.Log("Running M3 mode")
 
 	m3App := m3.NewM3App()
-	m3App.RunLoop()
+	m3App.RunLoop(ctx)
 }
 
-func runOnDemandMode() {
+func runOnDemandMode(ctx context.Context) {
 	pidStr := config.GlobalConfig.Pid
 	logger.Log("Running OnDemand mode with PID: %s", pidStr)
 
@@ -109,8 +152,14 @@ func runOnDemandMode() {
 		pids = append(pids, resolvedPids...)
 	}
 
+	// The processes matching the token are captured in parallel, see ProcessPids
+	jfrPids := make(map[int]bool, len(pids))
 	for _, pid := range pids {
-		ondemand.FullCapture(pid, config.GlobalConfig.AppName, config.GlobalConfig.HeapDump, config.GlobalConfig.Tags, "")
+		jfrPids[pid] = config.GlobalConfig.JFR
+	}
+	_, err = ondemand.ProcessPids(ctx, "", pids, nil, config.GlobalConfig.HeapDump, jfrPids, config.GlobalConfig.Tags, nil)
+	if err != nil {
+		logger.Log("WARNING: failed to capture %v: %s", pids, err)
 	}
 }
 
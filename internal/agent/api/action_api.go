@@ -4,12 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
-	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"yc-agent/internal/agent/common"
 	"yc-agent/internal/agent/ondemand"
@@ -18,8 +18,47 @@ import (
 	"yc-agent/internal/logger"
 )
 
-// only one goroutine can run the capture process
-var one sync.Mutex
+// pidLocks serializes the captures of a process, captures of different
+// processes run concurrently.
+var (
+	pidLocksMu sync.Mutex
+	pidLocks   = make(map[int]*sync.Mutex)
+)
+
+// lockPids locks the pids, in ascending order so that concurrent requests can't
+// deadlock, and returns the func unlocking them.
+func lockPids(pids []int) (unlock func()) {
+	sorted := make([]int, 0, len(pids))
+	seen := make(map[int]bool, len(pids))
+	for _, pid := range pids {
+		if !seen[pid] {
+			seen[pid] = true
+			sorted = append(sorted, pid)
+		}
+	}
+	sort.Ints(sorted)
+
+	locks := make([]*sync.Mutex, len(sorted))
+	pidLocksMu.Lock()
+	for i, pid := range sorted {
+		lock, ok := pidLocks[pid]
+		if !ok {
+			lock = &sync.Mutex{}
+			pidLocks[pid] = lock
+		}
+		locks[i] = lock
+	}
+	pidLocksMu.Unlock()
+
+	for _, lock := range locks {
+		lock.Lock()
+	}
+	return func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			locks[i].Unlock()
+		}
+	}
+}
 
 type ActionRequest struct {
 	Key     string
@@ -34,45 +73,54 @@ type ActionResponse struct {
 	Msg                 string
 	DashboardReportURLs []string   `json:",omitempty"`
 	Output              [][]string `json:",omitempty"`
+	// JobID is the job of the capture, see GetJob.
+	JobID string `json:",omitempty"`
 }
 
 func (s *Server) Action(writer http.ResponseWriter, request *http.Request) {
+	if request.Header.Get(ForwardHeader) != "" {
+		s.handleYcrashForward(writer, request)
+		return
+	}
+
 	resp := &ActionResponse{}
 
-	if request.Header.Get("ycrash-forward") != "" {
-		s.handleYcrashForward(writer, request, resp)
+	// Decode request
+	req := &ActionRequest{}
+	decoder := json.NewDecoder(request.Body)
+	err := decoder.Decode(req)
+	if err != nil {
+		resp.Code = -1
+		resp.Msg = err.Error()
 	} else {
-		// Decode request
-		req := &ActionRequest{}
-		decoder := json.NewDecoder(request.Body)
-		err := decoder.Decode(req)
-		if err != nil {
-			resp.Code = -1
-			resp.Msg = err.Error()
-		} else {
-			logger.Info().Msgf("action request: %#v", req)
-			s.handleActionAPI(req, resp)
-		}
+		logger.Info().Msgf("action request: %#v", req)
+		s.handleActionAPI(clientOf(request.Context()), req, resp)
 	}
 
 	// Send response
 	encoder := json.NewEncoder(writer)
 	encoder.SetEscapeHTML(false)
 
-	err := encoder.Encode(resp)
+	err = encoder.Encode(resp)
 	if err != nil {
 		logger.Log("failed to encode response(%#v): %v", resp, err)
 	}
 }
 
-func (s *Server) handleActionAPI(req *ActionRequest, resp *ActionResponse) {
-	if config.GlobalConfig.ApiKey != req.Key {
-		resp.Code = -1
-		resp.Msg = "invalid key passed"
-		return
+// handleActionAPI runs the actions of the request of the authenticated client, or
+// of the request with the API key if the client is empty. Without a configured API
+// key, only authenticated clients are allowed.
+func (s *Server) handleActionAPI(client string, req *ActionRequest, resp *ActionResponse) {
+	if client == "" {
+		if !equal(config.GlobalConfig.ApiKey, req.Key) {
+			resp.Code = -1
+			resp.Msg = "invalid key passed"
+			return
+		}
+		client = apiKeyClient
 	}
 
-	result, pid2Name, hasCmd, err := parseActions(req.Actions)
+	result, pid2Name, jfrPids, hasCmd, err := parseActions(req.Actions)
 	if err != nil {
 		resp.Code = -1
 		resp.Msg = err.Error()
@@ -102,107 +150,117 @@ func (s *Server) handleActionAPI(req *ActionRequest, resp *ActionResponse) {
 		shouldWait = true
 	}
 
+	var pids []int
+	for _, pidAny := range result {
+		if pid, ok := pidAny.(int); ok {
+			pids = append(pids, pid)
+		}
+	}
+
+	// Every capture is a job, to follow up on it and to cancel it
+	ctx, cancel := context.WithCancel(s.ctx)
+	job := s.Jobs.Create(req.Actions, cancel)
+	resp.JobID = job.ID
+	logger.Info().Str("client", client).Strs("actions", req.Actions).Str("job", job.ID).Msg("api audit: action")
+
+	// The response of the capture, returned only when waiting for it
+	out := &ActionResponse{JobID: job.ID}
 	wg := sync.WaitGroup{}
 	wg.Add(1)
 
 	go func() {
 		defer wg.Done()
+		defer cancel()
 
-		// temporary / scoped to this func
-		type processPidsResult struct {
-			rUrls  []string
-			err    error
-			output []string
-		}
-
-		processPidsResults := map[any]processPidsResult{}
-		for _, pidAny := range result {
-			pResult := processPidsResult{}
-
-			if pid, ok := pidAny.(int); ok {
-				pResult.output = append(pResult.output, strconv.Itoa(pid))
-				pResult.rUrls, pResult.err = s.ProcessPids([]int{pid}, pid2Name, needHeapDump, req.Tags)
-
-				if pResult.err == nil {
-					pResult.output = append(pResult.output, pResult.rUrls...)
-				} else {
-					pResult.output = append(pResult.output, err.Error())
-				}
-			} else if _, ok := pidAny.(string); ok {
-				pResult.output = append(pResult.output, "Unsupported Operation")
+		s.Jobs.Update(job.ID, func(job *Job) {
+			if job.State == JobQueued {
+				job.State = JobRunning
+				job.Started = time.Now()
+			}
+			for _, pid := range pids {
+				job.pid(pid)
+			}
+		})
+
+		// The pids are captured together, sharing the host-wide data
+		urls := make(map[int]string, len(pids))
+		var err error
+		if len(pids) > 0 {
+			var pidResults []ondemand.PidResult
+			progressCtx := ondemand.WithEvents(ondemand.WithProgress(ctx, s.Jobs.Progress(job.ID)), s.Jobs.Events(job.ID))
+			pidResults, err = s.ProcessPids(progressCtx, pids, pid2Name, needHeapDump, jfrPids, req.Tags)
+			for _, r := range pidResults {
+				urls[r.Pid] = r.URL
 			}
-
-			processPidsResults[pidAny] = pResult
 		}
 
 		// Populate Resp
-		if len(processPidsResults) == 1 {
+		if len(result) == 1 {
 			// Maintain legacy response for 1 pid
-			for _, r := range processPidsResults {
-				if r.err != nil {
-					resp.Code = -1
-					resp.Msg = err.Error()
-				} else {
-					resp.DashboardReportURLs = r.rUrls
-				}
+			if err != nil {
+				out.Code = -1
+				out.Msg = err.Error()
+			} else if pid, ok := result[0].(int); ok && urls[pid] != "" {
+				out.DashboardReportURLs = []string{urls[pid]}
 			}
-		} else if len(processPidsResults) > 1 {
-			for _, r := range processPidsResults {
-				resp.DashboardReportURLs = append(resp.DashboardReportURLs, r.rUrls...)
-				resp.Output = append(resp.Output, r.output)
+		} else if len(result) > 1 {
+			for _, pidAny := range result {
+				var output []string
+				if pid, ok := pidAny.(int); ok {
+					output = append(output, strconv.Itoa(pid))
+					if err != nil {
+						output = append(output, err.Error())
+					} else if urls[pid] != "" {
+						output = append(output, urls[pid])
+						out.DashboardReportURLs = append(out.DashboardReportURLs, urls[pid])
+					}
+				} else if _, ok := pidAny.(string); ok {
+					output = append(output, "Unsupported Operation")
+				}
+				out.Output = append(out.Output, output)
 			}
 		}
 
-		logger.Log("action api completed: %v", resp)
+		s.Jobs.Update(job.ID, func(job *Job) {
+			for _, p := range job.Pids {
+				p.URL = urls[p.Pid]
+			}
+			job.ReportURLs = out.DashboardReportURLs
+			job.Ended = time.Now()
+			switch {
+			case job.State == JobCanceled:
+			case err != nil:
+				job.State = JobFailed
+				job.Msg = err.Error()
+			default:
+				job.State = JobSucceeded
+			}
+		})
+
+		logger.Log("action api completed: %v", out)
 	}()
 
 	if shouldWait {
 		wg.Wait()
+		*resp = *out
 	}
 }
 
-func (s *Server) handleYcrashForward(writer http.ResponseWriter, request *http.Request, resp *ActionResponse) {
-	var err error
-
-	forward := request.Header.Get("ycrash-forward")
-	fr := request.Clone(context.Background())
-	fr.URL, err = url.Parse(forward)
-	if err != nil {
-		resp.Code = -1
-		resp.Msg = err.Error()
-		return
-	}
-	fr.RequestURI = ""
-	fr.Header.Del("ycrash-forward")
-	fr.Close = true
-	client := http.Client{}
-	r, err := client.Do(fr)
-	if err != nil {
-		resp.Code = -2
-		resp.Msg = err.Error()
-		return
-	}
-	defer func() {
-		if err := r.Body.Close(); err != nil {
-			logger.Log("failed to close response body: %v", err)
+// parseActions parses the pids to capture of the actions, "capture <pid or token>",
+// or "jfr <pid or token>" for a capture with a Java Flight Recording.
+func parseActions(actions []string) (result []interface{}, pid2Name map[int]string, jfrPids map[int]bool, hasCmd bool, err error) {
+	jfrPids = map[int]bool{}
+	for _, s := range actions {
+		jfr := strings.HasPrefix(s, "jfr ")
+		if jfr {
+			s = "capture " + strings.TrimPrefix(s, "jfr ")
 		}
-	}()
-	for key, v := range r.Header {
-		for _, value := range v {
-			writer.Header().Add(key, value)
+		addPid := func(pid int) {
+			result = append(result, pid)
+			if jfr {
+				jfrPids[pid] = true
+			}
 		}
-	}
-	writer.WriteHeader(r.StatusCode)
-	_, err = io.Copy(writer, r.Body)
-	if err != nil {
-		resp.Code = -1
-		resp.Msg = err.Error()
-		return
-	}
-}
-
-func parseActions(actions []string) (result []interface{}, pid2Name map[int]string, hasCmd bool, err error) {
-	for _, s := range actions {
 		if strings.HasPrefix(s, "capture ") {
 			ss := strings.Split(s, " ")
 			if len(ss) == 2 {
@@ -225,7 +283,7 @@ func parseActions(actions []string) (result []interface{}, pid2Name map[int]stri
 						return
 					}
 					if pid > 0 {
-						result = append(result, pid)
+						addPid(pid)
 					}
 					pid, err = capture.GetTopMem()
 					if err != nil {
@@ -245,7 +303,7 @@ func parseActions(actions []string) (result []interface{}, pid2Name map[int]stri
 								if pid2Name == nil {
 									pid2Name = make(map[int]string, len(p2n))
 								}
-								result = append(result, pid)
+								addPid(pid)
 								pid2Name[pid] = name
 							}
 						}
@@ -253,7 +311,7 @@ func parseActions(actions []string) (result []interface{}, pid2Name map[int]stri
 					}
 				}
 				if pid > 0 {
-					result = append(result, pid)
+					addPid(pid)
 				}
 			}
 		} else if s == "attendance" {
@@ -332,11 +390,10 @@ func (s *Server) validateActionAPIParseResult(result []interface{}) (valid bool,
 	return
 }
 
-// ProcessPidsWithMutext runs ProcessPids, synchronized with mutex lock
-// to allow only one function running at a time.
-func ProcessPidsWithMutex(pids []int, pid2Name map[int]string, hd bool, tags string) (rUrls []string, err error) {
-	one.Lock()
-	defer one.Unlock()
+// ProcessPidsWithMutex runs ProcessPids, synchronized with the other captures
+// of the pids. Captures of other processes run concurrently.
+func ProcessPidsWithMutex(ctx context.Context, pids []int, pid2Name map[int]string, hd bool, jfrPids map[int]bool, tags string) (results []ondemand.PidResult, err error) {
+	defer lockPids(pids)()
 
 	tmp := config.GlobalConfig.Tags
 	if len(tmp) > 0 {
@@ -346,5 +403,5 @@ func ProcessPidsWithMutex(pids []int, pid2Name map[int]string, hd bool, tags str
 		tmp = strings.Trim(tags, ",")
 	}
 
-	return ondemand.ProcessPids(pids, pid2Name, hd, tmp, []string{""})
+	return ondemand.ProcessPids(ctx, "", pids, pid2Name, hd, jfrPids, tmp, []string{""})
 }
@@ -0,0 +1,298 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"yc-agent/internal/config"
+	"yc-agent/internal/logger"
+)
+
+// The headers of the HMAC signed requests, see Sign.
+const (
+	ClientHeader    = "X-YC-Client"
+	TimestampHeader = "X-YC-Timestamp"
+	SignatureHeader = "X-YC-Signature"
+)
+
+// apiKeyClient is the client authenticated with the API key of the config.
+const apiKeyClient = "apiKey"
+
+type clientKey struct{}
+
+// clientOf returns the authenticated client of the request context, empty if none.
+func clientOf(ctx context.Context) string {
+	client, _ := ctx.Value(clientKey{}).(string)
+	return client
+}
+
+// Sign returns the HMAC-SHA256 signature of a request with the secret of the client:
+// the hex of the HMAC of the method, the request URI, the unix timestamp in seconds
+// and the hex SHA-256 of the body, separated by new lines. The timestamp and the
+// signature are sent in the TimestampHeader and the SignatureHeader.
+func Sign(secret, method, requestURI, timestamp string, body []byte) string {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + requestURI + "\n" + timestamp + "\n" + hex.EncodeToString(sum[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// authenticate authenticates the requests before next, rate limits them per client,
+// and the failed authentications per remote address, and logs who requested what. The client is identified by its certificate when
+// served with mutual TLS, its bearer token, or the HMAC signature of the request.
+// Requests with no credentials are passed on only if there are no ApiClients, to be
+// authenticated with the API key by the handlers.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		remote := request.RemoteAddr
+		if host, _, err := net.SplitHostPort(remote); err == nil {
+			remote = host
+		}
+
+		// Checked before authenticating, so that a remote over its limit of failures
+		// can't guess the credentials either
+		now := time.Now()
+		if ok, retryAfter := s.failures.check(remote, now); !ok {
+			logger.Warn().Str("remote", remote).Str("path", request.URL.Path).Msg("api audit: rate limited after failed authentications")
+			tooManyRequests(writer, retryAfter)
+			return
+		}
+		client, err := s.identify(writer, request)
+		if err != nil {
+			s.failures.allow(remote, now)
+			logger.Warn().Str("remote", remote).Str("method", request.Method).Str("path", request.URL.Path).Err(err).Msg("api audit: authentication failed")
+			status := http.StatusUnauthorized
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			writeJSON(writer, status, &ActionResponse{Code: -1, Msg: err.Error()})
+			return
+		}
+
+		limitKey := "client:" + client
+		if client == "" {
+			limitKey = "remote:" + remote
+		}
+		if ok, retryAfter := s.limiter.allow(limitKey, now); !ok {
+			logger.Warn().Str("client", client).Str("remote", remote).Str("path", request.URL.Path).Msg("api audit: rate limited")
+			tooManyRequests(writer, retryAfter)
+			return
+		}
+
+		logger.Info().Str("client", client).Str("remote", remote).Str("method", request.Method).Str("path", request.URL.Path).Msg("api audit: request")
+		if client != "" {
+			request = request.WithContext(context.WithValue(request.Context(), clientKey{}, client))
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// tooManyRequests responds with 429, and when to retry.
+func tooManyRequests(writer http.ResponseWriter, retryAfter time.Duration) {
+	writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writeJSON(writer, http.StatusTooManyRequests, &ActionResponse{Code: -1, Msg: "too many requests"})
+}
+
+// identify returns the client authenticated by the credentials of the request,
+// empty if it has none and there are no ApiClients.
+func (s *Server) identify(writer http.ResponseWriter, request *http.Request) (string, error) {
+	clients := config.GlobalConfig.ApiClients
+
+	if request.TLS != nil && len(request.TLS.VerifiedChains) > 0 && len(request.TLS.VerifiedChains[0]) > 0 {
+		name := request.TLS.VerifiedChains[0][0].Subject.CommonName
+		if _, ok := clients[name]; ok {
+			return name, nil
+		}
+	}
+
+	if auth := request.Header.Get("Authorization"); auth != "" {
+		token, ok := strings.CutPrefix(auth, "Bearer ")
+		if !ok {
+			return "", errors.New("unsupported authorization scheme")
+		}
+		for _, name := range sortedClients(clients) {
+			if equal(clients[name].Token, token) {
+				return name, nil
+			}
+		}
+		if equal(config.GlobalConfig.ApiKey, token) {
+			return apiKeyClient, nil
+		}
+		return "", errors.New("invalid bearer token")
+	}
+
+	if name := request.Header.Get(ClientHeader); name != "" {
+		return name, s.verifySignature(writer, request, name)
+	}
+
+	if len(clients) > 0 {
+		return "", errors.New("authentication required")
+	}
+	return "", nil
+}
+
+// verifySignature verifies the HMAC signature of the request of the client, within
+// the ApiSignatureWindow of its timestamp and not replayed. The body is read up to
+// the ForwardMaxBodySize, the largest body of the API requests.
+func (s *Server) verifySignature(writer http.ResponseWriter, request *http.Request, name string) error {
+	client, ok := config.GlobalConfig.ApiClients[name]
+	if !ok || client.Secret == "" {
+		return fmt.Errorf("unknown client %s", name)
+	}
+
+	timestamp := request.Header.Get(TimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q", TimestampHeader, timestamp)
+	}
+	window := config.GlobalConfig.ApiSignatureWindow
+	signed := time.Unix(unix, 0)
+	if age := time.Since(signed); age > window || age < -window {
+		return fmt.Errorf("%s %s is not within %s of now", TimestampHeader, timestamp, window)
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, config.GlobalConfig.ForwardMaxBodySize))
+	if err != nil {
+		return fmt.Errorf("failed to read body: %w", err)
+	}
+	request.Body = io.NopCloser(bytes.NewReader(body))
+
+	signature := request.Header.Get(SignatureHeader)
+	if !hmac.Equal([]byte(signature), []byte(Sign(client.Secret, request.Method, request.URL.RequestURI(), timestamp, body))) {
+		return errors.New("invalid signature")
+	}
+	if !s.replays.first(signature, signed.Add(window)) {
+		return errors.New("replayed request")
+	}
+	return nil
+}
+
+func sortedClients(clients config.ApiClients) []string {
+	names := make([]string, 0, len(clients))
+	for name := range clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// equal reports whether the secrets are equal and not empty, in constant time.
+func equal(secret, given string) bool {
+	return secret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(given)) == 1
+}
+
+// replayCache remembers the signatures of the requests until they expire.
+type replayCache struct {
+	mtx  sync.Mutex
+	seen map[string]time.Time
+}
+
+// first reports whether the signature is seen for the first time, remembering it until expiry.
+func (c *replayCache) first(signature string, expiry time.Time) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := time.Now()
+	for s, e := range c.seen {
+		if now.After(e) {
+			delete(c.seen, s)
+		}
+	}
+	if _, ok := c.seen[signature]; ok {
+		return false
+	}
+	if c.seen == nil {
+		c.seen = map[string]time.Time{}
+	}
+	c.seen[signature] = expiry
+	return true
+}
+
+// rateLimiter is a token bucket per key, refilled with ApiRateLimit tokens per minute.
+type rateLimiter struct {
+	mtx     sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token of the key, or returns how long until there is one.
+func (l *rateLimiter) allow(key string, now time.Time) (ok bool, retryAfter time.Duration) {
+	return l.take(key, now, 1)
+}
+
+// check returns how long until the key has a token like allow, without taking it.
+func (l *rateLimiter) check(key string, now time.Time) (ok bool, retryAfter time.Duration) {
+	return l.take(key, now, 0)
+}
+
+// take takes n tokens of the key if it has one, or returns how long until it has.
+func (l *rateLimiter) take(key string, now time.Time, n float64) (ok bool, retryAfter time.Duration) {
+	limit := float64(config.GlobalConfig.ApiRateLimit)
+	if limit <= 0 {
+		return true, 0
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.buckets == nil {
+		l.buckets = map[string]*bucket{}
+	}
+	b, found := l.buckets[key]
+	if !found {
+		b = &bucket{tokens: limit, last: now}
+		l.buckets[key] = b
+	}
+	perSecond := limit / 60
+	b.tokens = math.Min(limit, b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	}
+	b.tokens -= n
+	return true, 0
+}
+
+// apiTLSConfig returns the TLS config of the API service, requiring client
+// certificates verified with the ApiTLSClientCA if any.
+func apiTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.GlobalConfig.ApiTLSClientCA == "" {
+		return tlsConfig, nil
+	}
+
+	pem, err := os.ReadFile(config.GlobalConfig.ApiTLSClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("read apiTLSClientCA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in apiTLSClientCA %s", config.GlobalConfig.ApiTLSClientCA)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	return tlsConfig, nil
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"yc-agent/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withAPIClients(t *testing.T, clients config.ApiClients, rateLimit int) {
+	old := config.GlobalConfig
+	t.Cleanup(func() { config.GlobalConfig = old })
+	config.GlobalConfig.ApiKey = "buggycompany@e094aasdsa-c3eb-4c9a-8254-f0dd107245cc"
+	config.GlobalConfig.ApiClients = clients
+	config.GlobalConfig.ApiSignatureWindow = time.Minute
+	config.GlobalConfig.ApiRateLimit = rateLimit
+}
+
+func TestAuthenticate(t *testing.T) {
+	withAPIClients(t, config.ApiClients{
+		"automation": {Token: "automation-token"},
+		"ci":         {Secret: "ci-secret"},
+	}, 0)
+	s := NewServer(context.Background(), "localhost", 0)
+
+	do := func(req *http.Request) int {
+		w := httptest.NewRecorder()
+		s.Handler.ServeHTTP(w, req)
+		return w.Code
+	}
+	signed := func(secret string, at time.Time, body string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/jobs?limit=1", bytes.NewBufferString(body))
+		ts := strconv.FormatInt(at.Unix(), 10)
+		req.Header.Set(ClientHeader, "ci")
+		req.Header.Set(TimestampHeader, ts)
+		req.Header.Set(SignatureHeader, Sign(secret, http.MethodGet, "/jobs?limit=1", ts, []byte(body)))
+		return req
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/jobs", nil)
+	assert.Equal(t, http.StatusUnauthorized, do(req), "credentials are required once there are clients")
+
+	req = httptest.NewRequest(http.MethodGet, "/jobs", nil)
+	req.Header.Set("Authorization", "Bearer automation-token")
+	assert.Equal(t, http.StatusOK, do(req))
+
+	req = httptest.NewRequest(http.MethodGet, "/jobs", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	assert.Equal(t, http.StatusUnauthorized, do(req))
+
+	req = signed("ci-secret", time.Now(), "body")
+	assert.Equal(t, http.StatusOK, do(req))
+	replayed := signed("ci-secret", time.Now(), "body")
+	replayed.Header = req.Header.Clone()
+	assert.Equal(t, http.StatusUnauthorized, do(replayed), "a replayed request is rejected")
+
+	assert.Equal(t, http.StatusUnauthorized, do(signed("wrong", time.Now(), "body")))
+	assert.Equal(t, http.StatusUnauthorized, do(signed("ci-secret", time.Now().Add(-2*time.Minute), "body")), "stale timestamp")
+
+	tampered := signed("ci-secret", time.Now().Add(time.Second), "body")
+	tampered.Body = http.NoBody
+	assert.Equal(t, http.StatusUnauthorized, do(tampered))
+
+	config.GlobalConfig.ForwardMaxBodySize = 4
+	assert.Equal(t, http.StatusRequestEntityTooLarge, do(signed("ci-secret", time.Now().Add(2*time.Second), "too large")))
+
+	// The authenticated client doesn't need the key in the body
+	req = httptest.NewRequest(http.MethodPost, "/action", bytes.NewBufferString(`{"actions": ["capture 999999999"]}`))
+	req.Header.Set("Authorization", "Bearer automation-token")
+	w := httptest.NewRecorder()
+	s.Handler.ServeHTTP(w, req)
+	resp := &ActionResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), resp))
+	assert.Equal(t, "You have entered non-existent process ids.", resp.Msg)
+}
+
+func TestAuthenticate_Legacy(t *testing.T) {
+	withAPIClients(t, nil, 0)
+	s := NewServer(context.Background(), "localhost", 0)
+
+	w := httptest.NewRecorder()
+	s.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/action", bytes.NewBufferString(`{"key": "wrong", "actions": ["capture 1"]}`)))
+	resp := &ActionResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), resp))
+	assert.Equal(t, "invalid key passed", resp.Msg)
+
+	// Without a configured key, an empty key isn't a match
+	key := config.GlobalConfig.ApiKey
+	config.GlobalConfig.ApiKey = ""
+	w = httptest.NewRecorder()
+	s.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/action", bytes.NewBufferString(`{"actions": ["capture 1"]}`)))
+	resp = &ActionResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), resp))
+	assert.Equal(t, "invalid key passed", resp.Msg)
+	config.GlobalConfig.ApiKey = key
+
+	req := httptest.NewRequest(http.MethodGet, "/jobs", nil)
+	req.Header.Set("Authorization", "Bearer "+config.GlobalConfig.ApiKey)
+	w = httptest.NewRecorder()
+	s.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAuthenticate_RateLimit(t *testing.T) {
+	withAPIClients(t, config.ApiClients{"automation": {Token: "automation-token"}}, 2)
+	s := NewServer(context.Background(), "localhost", 0)
+
+	var codes []int
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/jobs", nil)
+		req.Header.Set("Authorization", "Bearer automation-token")
+		w := httptest.NewRecorder()
+		s.Handler.ServeHTTP(w, req)
+		codes = append(codes, w.Code)
+		if w.Code == http.StatusTooManyRequests {
+			assert.Equal(t, "30", w.Header().Get("Retry-After"))
+		}
+	}
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes)
+
+	// The failed authentications are limited per remote address, even the
+	// request with the right token is denied once over the limit
+	codes = nil
+	for _, token := range []string{"wrong-1", "wrong-2", "automation-token"} {
+		req := httptest.NewRequest(http.MethodGet, "/jobs", nil)
+		req.RemoteAddr = "192.0.2.2:1234"
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		s.Handler.ServeHTTP(w, req)
+		codes = append(codes, w.Code)
+	}
+	assert.Equal(t, []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests}, codes)
+
+	var l rateLimiter
+	now := time.Now()
+	l.allow("a", now)
+	l.allow("a", now)
+	ok, _ := l.allow("a", now.Add(30*time.Second))
+	assert.True(t, ok, "a token is refilled every 30s")
+}
+
+func TestServe_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := newCert(t, "ca", nil, nil)
+	server, serverKey := newCert(t, "localhost", ca, caKey)
+	client, clientKey := newCert(t, "automation", ca, caKey)
+	stranger, strangerKey := newCert(t, "automation", nil, nil)
+
+	withAPIClients(t, config.ApiClients{"automation": {}}, 0)
+	config.GlobalConfig.ApiTLSCert = writePEM(t, dir, "server.pem", "CERTIFICATE", server.Raw)
+	config.GlobalConfig.ApiTLSKey = writePEM(t, dir, "server-key.pem", "EC PRIVATE KEY", marshalKey(t, serverKey))
+	config.GlobalConfig.ApiTLSClientCA = writePEM(t, dir, "ca.pem", "CERTIFICATE", ca.Raw)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := ln.Addr().(*net.TCPAddr).Port
+	require.NoError(t, ln.Close())
+
+	s := NewServer(context.Background(), "127.0.0.1", port)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Serve()
+	}()
+	defer func() {
+		_ = s.Close()
+		err := <-errCh
+		assert.True(t, errors.Is(err, http.ErrServerClosed), err)
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	get := func(cert *x509.Certificate, key *ecdsa.PrivateKey) (int, error) {
+		tlsConfig := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+		if cert != nil {
+			tlsConfig.Certificates = []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}}
+		}
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		resp, err := c.Get(fmt.Sprintf("https://%s/jobs", s.Addr()))
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	var code int
+	require.Eventually(t, func() bool {
+		code, err = get(client, clientKey)
+		return err == nil
+	}, 5*time.Second, 50*time.Millisecond, "%v", err)
+	assert.Equal(t, http.StatusOK, code, "the client is authenticated by its certificate")
+
+	_, err = get(nil, nil)
+	assert.Error(t, err, "a client certificate is required")
+	_, err = get(stranger, strangerKey)
+	assert.Error(t, err, "the client certificate has to be issued by the client CA")
+}
+
+// newCert returns a certificate of the common name issued by the parent, self-signed if nil.
+func newCert(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+		DNSNames:              []string{cn},
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+func marshalKey(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	der, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return der
+}
+
+func writePEM(t *testing.T, dir, name, typ string, der []byte) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600))
+	return path
+}
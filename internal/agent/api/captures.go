@@ -0,0 +1,215 @@
+package api
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"yc-agent/internal/agent/ondemand"
+	"yc-agent/internal/capture"
+	"yc-agent/internal/config"
+)
+
+var ErrCaptureNotFound = errors.New("capture not found")
+
+// CaptureSession is a capture kept in the storage path, in its yc-<timestamp> dir
+// and/or the zip of the dir.
+type CaptureSession struct {
+	Name      string
+	CaptureID string `json:",omitempty"`
+	Pid       int    `json:",omitempty"`
+	AppName   string `json:",omitempty"`
+	Time      time.Time
+	// Dir reports whether the dir of the capture is kept, the artifacts can be downloaded one by one.
+	Dir bool
+	// ZipSize is the size of the zip of the dir, 0 if there is none yet.
+	ZipSize int64 `json:",omitempty"`
+}
+
+// storagePath returns the dir the captures are kept in.
+func storagePath() string {
+	if config.GlobalConfig.StoragePath == "" {
+		return "."
+	}
+	return config.GlobalConfig.StoragePath
+}
+
+// captureNameRegexp matches the names of the capture dirs, yc-<timestamp>, with
+// the -<n> suffix of the captures started in the same second.
+var captureNameRegexp = regexp.MustCompile(`^yc-\d{4}-\d{2}-\d{2}T\d{2}-\d{2}-\d{2}(-\d+)?$`)
+
+// isCaptureName reports whether name is the name of a capture in the storage
+// path, and not a dir of the agent like the job store or the upload spool.
+func isCaptureName(name string) bool {
+	return captureNameRegexp.MatchString(name)
+}
+
+// ListCaptures returns the captures kept in the storage path, the latest first.
+func ListCaptures() ([]*CaptureSession, error) {
+	entries, err := os.ReadDir(storagePath())
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := map[string]*CaptureSession{}
+	for _, entry := range entries {
+		name := entry.Name()
+		isZip := !entry.IsDir() && strings.HasSuffix(name, ".zip")
+		name = strings.TrimSuffix(name, ".zip")
+		if !isCaptureName(name) || (!entry.IsDir() && !isZip) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		session, ok := sessions[name]
+		if !ok {
+			session = &CaptureSession{Name: name, Time: info.ModTime()}
+			sessions[name] = session
+		}
+		if isZip {
+			session.ZipSize = info.Size()
+		} else {
+			session.Dir = true
+			session.Time = info.ModTime()
+		}
+	}
+
+	result := make([]*CaptureSession, 0, len(sessions))
+	for _, session := range sessions {
+		if m, err := ReadCaptureManifest(session.Name); err == nil {
+			session.CaptureID, session.Pid, session.AppName = m.CaptureID, m.Pid, m.AppName
+			if !m.Start.IsZero() {
+				session.Time = m.Start
+			}
+		}
+		result = append(result, session)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].Time.Equal(result[j].Time) {
+			return result[i].Time.After(result[j].Time)
+		}
+		return result[i].Name > result[j].Name
+	})
+	return result, nil
+}
+
+// ReadCaptureManifest returns the manifest of the capture, read from its dir, or
+// from its zip if the dir is deleted.
+func ReadCaptureManifest(name string) (*capture.Manifest, error) {
+	if !isCaptureName(name) {
+		return nil, ErrCaptureNotFound
+	}
+
+	var data []byte
+	file, err := CaptureFile(name, capture.ManifestFile)
+	if err == nil {
+		data, err = io.ReadAll(file)
+		file.Close()
+	} else if errors.Is(err, ErrCaptureNotFound) {
+		data, err = readZipEntry(filepath.Join(storagePath(), name+".zip"), name+"/"+capture.ManifestFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := &capture.Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", capture.ManifestFile, err)
+	}
+	return m, nil
+}
+
+func readZipEntry(zipPath, entry string) ([]byte, error) {
+	r, err := zip.OpenReader(zipPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrCaptureNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	f, err := r.Open(entry)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrCaptureNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// CaptureFile opens the file of the capture, a path relative to its dir.
+// Files out of the dir, through symlinks too, are not found.
+func CaptureFile(name, file string) (*os.File, error) {
+	if !isCaptureName(name) || !filepath.IsLocal(filepath.FromSlash(file)) {
+		return nil, ErrCaptureNotFound
+	}
+
+	dir, err := filepath.EvalSymlinks(filepath.Join(storagePath(), name))
+	if err != nil {
+		return nil, ErrCaptureNotFound
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(dir, filepath.FromSlash(file)))
+	if err != nil {
+		return nil, ErrCaptureNotFound
+	}
+	if rel, err := filepath.Rel(dir, path); err != nil || !filepath.IsLocal(rel) {
+		return nil, ErrCaptureNotFound
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, ErrCaptureNotFound
+	}
+	if info, err := f.Stat(); err != nil || !info.Mode().IsRegular() {
+		f.Close()
+		return nil, ErrCaptureNotFound
+	}
+	return f, nil
+}
+
+// zipMtx serializes the zipping of the captures, so that a zip is never served half written.
+var zipMtx sync.Mutex
+
+// CaptureZip opens the zip of the capture, zipping its dir with ondemand.ZipFolder
+// first if there is no zip yet.
+func CaptureZip(name string) (*os.File, error) {
+	if !isCaptureName(name) {
+		return nil, ErrCaptureNotFound
+	}
+
+	zipMtx.Lock()
+	defer zipMtx.Unlock()
+
+	path := filepath.Join(storagePath(), name+".zip")
+	f, err := os.Open(path)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return f, err
+	}
+
+	dir := filepath.Join(storagePath(), name)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, ErrCaptureNotFound
+	}
+	path, err = ondemand.ZipFolder(dir)
+	if err != nil {
+		_ = os.Remove(path)
+		return nil, fmt.Errorf("failed to zip %s: %w", name, err)
+	}
+	return os.Open(path)
+}
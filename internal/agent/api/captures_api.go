@@ -0,0 +1,97 @@
+package api
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"path"
+
+	"yc-agent/internal/capture"
+	"yc-agent/internal/logger"
+)
+
+// CapturesResponse is the response of the captures API.
+type CapturesResponse struct {
+	Code     int
+	Msg      string
+	Captures []*CaptureSession `json:",omitempty"`
+	Manifest *capture.Manifest `json:",omitempty"`
+}
+
+// ListCaptures serves GET /captures, the captures kept in the storage path.
+func (s *Server) ListCaptures(writer http.ResponseWriter, request *http.Request) {
+	if !authorized(writer, request) {
+		return
+	}
+	captures, err := ListCaptures()
+	if err != nil {
+		writeJSON(writer, http.StatusInternalServerError, &CapturesResponse{Code: -1, Msg: err.Error()})
+		return
+	}
+	writeJSON(writer, http.StatusOK, &CapturesResponse{Captures: captures})
+}
+
+// GetCaptureManifest serves GET /captures/{name}/manifest, the manifest of the artifacts of the capture.
+func (s *Server) GetCaptureManifest(writer http.ResponseWriter, request *http.Request) {
+	if !authorized(writer, request) {
+		return
+	}
+	m, err := ReadCaptureManifest(request.PathValue("name"))
+	if err != nil {
+		writeCaptureError(writer, err)
+		return
+	}
+	writeJSON(writer, http.StatusOK, &CapturesResponse{Manifest: m})
+}
+
+// GetCaptureFile serves GET /captures/{name}/files/{file...}, an artifact of the
+// capture, with range requests for the large ones like heap dumps.
+func (s *Server) GetCaptureFile(writer http.ResponseWriter, request *http.Request) {
+	if !authorized(writer, request) {
+		return
+	}
+	name, file := request.PathValue("name"), request.PathValue("file")
+	f, err := CaptureFile(name, file)
+	if err != nil {
+		writeCaptureError(writer, err)
+		return
+	}
+	defer f.Close()
+	s.serveCaptureFile(writer, request, name, path.Base(file), f)
+}
+
+// GetCaptureZip serves GET /captures/{name}/zip, the zip of the dir of the
+// capture, with range requests.
+func (s *Server) GetCaptureZip(writer http.ResponseWriter, request *http.Request) {
+	if !authorized(writer, request) {
+		return
+	}
+	name := request.PathValue("name")
+	f, err := CaptureZip(name)
+	if err != nil {
+		writeCaptureError(writer, err)
+		return
+	}
+	defer f.Close()
+	s.serveCaptureFile(writer, request, name, name+".zip", f)
+}
+
+func (s *Server) serveCaptureFile(writer http.ResponseWriter, request *http.Request, name, file string, f http.File) {
+	info, err := f.Stat()
+	if err != nil {
+		writeCaptureError(writer, err)
+		return
+	}
+	logger.Info().Str("client", clientOf(request.Context())).Str("capture", name).Str("file", file).
+		Str("range", request.Header.Get("Range")).Msg("api audit: download")
+	writer.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file}))
+	http.ServeContent(writer, request, file, info.ModTime(), f)
+}
+
+func writeCaptureError(writer http.ResponseWriter, err error) {
+	if errors.Is(err, ErrCaptureNotFound) {
+		writeJSON(writer, http.StatusNotFound, &CapturesResponse{Code: -1, Msg: err.Error()})
+		return
+	}
+	writeJSON(writer, http.StatusInternalServerError, &CapturesResponse{Code: -1, Msg: err.Error()})
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"yc-agent/internal/capture"
+	"yc-agent/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCaptures(t *testing.T) {
+	withAPIClients(t, nil, 0)
+	storage := t.TempDir()
+	config.GlobalConfig.StoragePath = storage
+
+	dir := filepath.Join(storage, "yc-2024-01-02T03-04-05")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "heap_dump.hprof"), []byte("0123456789"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "gc.log"), []byte("gc"), 0644))
+	m := capture.NewManifest("abc", 42, "app")
+	m.Record("hd", "hd", "", m.Start, filepath.Join(dir, "heap_dump.hprof"), "ok", true)
+	require.NoError(t, m.Write(dir))
+	require.NoError(t, os.WriteFile(filepath.Join(storage, "secret.txt"), []byte("secret"), 0644))
+	require.NoError(t, os.Symlink(filepath.Join(storage, "secret.txt"), filepath.Join(dir, "link")))
+	require.NoError(t, os.MkdirAll(filepath.Join(storage, "yc-jobs"), 0777))
+	require.NoError(t, os.MkdirAll(filepath.Join(storage, "yc-host-1"), 0777))
+	require.NoError(t, os.MkdirAll(filepath.Join(storage, "yc-upload-spool"), 0777))
+	require.NoError(t, os.MkdirAll(filepath.Join(storage, "yc-upload-sessions"), 0777))
+
+	// A capture of which only the zip is kept
+	zipped := filepath.Join(storage, "yc-2023-01-02T03-04-05.zip")
+	zf, err := os.Create(zipped)
+	require.NoError(t, err)
+	zw := zip.NewWriter(zf)
+	w, err := zw.Create("yc-2023-01-02T03-04-05/" + capture.ManifestFile)
+	require.NoError(t, err)
+	_, err = w.Write([]byte(`{"captureId": "old", "start": "2023-01-02T03:04:05Z"}`))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	require.NoError(t, zf.Close())
+
+	s := NewServer(context.Background(), "localhost", 0)
+	do := func(path, key string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header = header
+		if req.Header == nil {
+			req.Header = http.Header{}
+		}
+		req.Header.Set("ApiKey", key)
+		w := httptest.NewRecorder()
+		s.Handler.ServeHTTP(w, req)
+		return w
+	}
+	key := config.GlobalConfig.ApiKey
+
+	assert.Equal(t, http.StatusUnauthorized, do("/captures", "wrong", nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, do("/captures/yc-2024-01-02T03-04-05/zip", "", nil).Code)
+	assert.Equal(t, http.StatusNotFound, do("/captures/yc-upload-spool/zip", key, nil).Code, "the state dirs of the agent aren't captures")
+	assert.Equal(t, http.StatusNotFound, do("/captures/yc-upload-sessions/files/x", key, nil).Code)
+	assert.True(t, isCaptureName("yc-2024-01-02T03-04-05-1"), "a capture started in the same second as another")
+
+	resp := &CapturesResponse{}
+	require.NoError(t, json.Unmarshal(do("/captures", key, nil).Body.Bytes(), resp))
+	require.Len(t, resp.Captures, 2)
+	assert.Equal(t, "yc-2024-01-02T03-04-05", resp.Captures[0].Name)
+	assert.Equal(t, "abc", resp.Captures[0].CaptureID)
+	assert.Equal(t, 42, resp.Captures[0].Pid)
+	assert.True(t, resp.Captures[0].Dir)
+	assert.Equal(t, "old", resp.Captures[1].CaptureID)
+	assert.False(t, resp.Captures[1].Dir)
+	assert.NotZero(t, resp.Captures[1].ZipSize)
+
+	resp = &CapturesResponse{}
+	require.NoError(t, json.Unmarshal(do("/captures/yc-2024-01-02T03-04-05/manifest", key, nil).Body.Bytes(), resp))
+	require.NotNil(t, resp.Manifest)
+	assert.Equal(t, "heap_dump.hprof", resp.Manifest.Artifacts[0].File)
+	resp = &CapturesResponse{}
+	require.NoError(t, json.Unmarshal(do("/captures/yc-2023-01-02T03-04-05/manifest", key, nil).Body.Bytes(), resp))
+	assert.Equal(t, "old", resp.Manifest.CaptureID)
+
+	rec := do("/captures/yc-2024-01-02T03-04-05/files/heap_dump.hprof", key, http.Header{"Range": {"bytes=2-5"}})
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, "2345", rec.Body.String())
+	rec = do("/captures/yc-2024-01-02T03-04-05/files/sub/gc.log", key, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "gc", rec.Body.String())
+
+	for _, path := range []string{
+		"/captures/yc-2024-01-02T03-04-05/files/link",
+		"/captures/yc-2024-01-02T03-04-05/files/..%2Fsecret.txt",
+		"/captures/yc-2024-01-02T03-04-05/files/sub",
+		"/captures/yc-jobs/files/x",
+		"/captures/unknown/manifest",
+		"/captures/yc-unknown/zip",
+	} {
+		rec = do(path, key, nil)
+		assert.Equal(t, http.StatusNotFound, rec.Code, path)
+		assert.NotContains(t, rec.Body.String(), "secret", path)
+	}
+
+	rec = do("/captures/yc-2024-01-02T03-04-05/zip", key, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	require.NoError(t, err)
+	f, err := zr.Open("yc-2024-01-02T03-04-05/heap_dump.hprof")
+	require.NoError(t, err)
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(data))
+	assert.FileExists(t, filepath.Join(storage, "yc-2024-01-02T03-04-05.zip"))
+}
@@ -0,0 +1,393 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"yc-agent/internal/capture/httpclient"
+	"yc-agent/internal/config"
+	"yc-agent/internal/logger"
+)
+
+// ForwardHeader is the header of the API requests to forward, the URL to forward them to.
+const ForwardHeader = "ycrash-forward"
+
+// hopHeaders are the hop-by-hop headers, not forwarded in either direction.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// credentialHeaders are the credentials of the request to the agent, not forwarded
+// so that the targets never see them.
+var credentialHeaders = []string{
+	"Cookie",
+	ClientHeader,
+	TimestampHeader,
+	SignatureHeader,
+}
+
+// serverCredentialHeaders are the credentials forwarded to the yCrash server only,
+// which authenticates the requests with the API key too.
+var serverCredentialHeaders = []string{
+	"Authorization",
+	"ApiKey",
+}
+
+// forwardRule is an entry of the ForwardAllowlist.
+type forwardRule struct {
+	// scheme is http or https, any if empty.
+	scheme string
+	// host is the host name, or the domain of its sub domains if prefixed by "*.".
+	host string
+	// network is the IP or CIDR, matching only the targets with an IP.
+	network *net.IPNet
+	// port is any if empty.
+	port string
+}
+
+// ParseForwardAllowlist parses the entries of the ForwardAllowlist: a host, *.domain,
+// host:port, IP or CIDR, optionally prefixed by http:// or https://.
+func ParseForwardAllowlist(entries []string) ([]forwardRule, error) {
+	rules := make([]forwardRule, 0, len(entries))
+	for _, entry := range entries {
+		rule, err := parseForwardRule(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid forwardAllowlist entry %q: %w", entry, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseForwardRule(entry string) (forwardRule, error) {
+	rule := forwardRule{}
+	s := strings.TrimSpace(entry)
+	if scheme, rest, ok := strings.Cut(s, "://"); ok {
+		rule.scheme = strings.ToLower(scheme)
+		if rule.scheme != "http" && rule.scheme != "https" {
+			return rule, fmt.Errorf("unsupported scheme %s", scheme)
+		}
+		s = rest
+	}
+	s = strings.TrimSuffix(s, "/")
+	if s == "" {
+		return rule, errors.New("no host")
+	}
+
+	if _, network, err := net.ParseCIDR(s); err == nil {
+		rule.network = network
+		return rule, nil
+	}
+
+	host := s
+	if h, port, err := net.SplitHostPort(s); err == nil {
+		if port == "" {
+			return rule, errors.New("no port")
+		}
+		host, rule.port = h, port
+	} else if strings.Count(s, ":") == 1 {
+		return rule, err
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		bits := 8 * len(ip.To16())
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		rule.network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		return rule, nil
+	}
+
+	rule.host = strings.TrimSuffix(strings.ToLower(host), ".")
+	domain := strings.TrimPrefix(rule.host, "*.")
+	if domain == "" || strings.ContainsAny(domain, "*/ ") {
+		return rule, fmt.Errorf("invalid host %s", host)
+	}
+	return rule, nil
+}
+
+// matches reports whether the target is allowed by the rule.
+func (r forwardRule) matches(target *url.URL) bool {
+	if r.scheme != "" && r.scheme != target.Scheme {
+		return false
+	}
+	if r.port != "" && r.port != targetPort(target) {
+		return false
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(target.Hostname()), ".")
+	if r.network != nil {
+		// Host names aren't resolved, they could resolve to anything by the time of the request
+		ip := net.ParseIP(host)
+		return ip != nil && r.network.Contains(ip)
+	}
+	if domain, ok := strings.CutPrefix(r.host, "*."); ok {
+		return strings.HasSuffix(host, "."+domain)
+	}
+	return host == r.host
+}
+
+func targetPort(target *url.URL) string {
+	if port := target.Port(); port != "" {
+		return port
+	}
+	if target.Scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+// forwardAllowed returns why the target isn't allowed, nil if it's the yCrash
+// server or allowed by the ForwardAllowlist.
+func forwardAllowed(target *url.URL) error {
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", target.Scheme)
+	}
+	if target.Host == "" {
+		return errors.New("no host")
+	}
+	if target.User != nil {
+		return errors.New("credentials in the URL are not allowed")
+	}
+
+	if isYcrashServer(target) {
+		return nil
+	}
+
+	rules, err := ParseForwardAllowlist(config.GlobalConfig.ForwardAllowlist)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if rule.matches(target) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not in the forwardAllowlist", target.Host)
+}
+
+// forwardClient returns the client a request is forwarded with, which doesn't follow
+// redirects. Only the yCrash server gets the client of the agent, with its client
+// certificate and proxy; the other targets get a client without them.
+func forwardClient(toServer bool) (*http.Client, error) {
+	var client *http.Client
+	var err error
+	if toServer {
+		client, err = httpclient.Shared()
+	} else {
+		opts := httpclient.FromConfig(config.GlobalConfig.Options)
+		opts.ClientCertPath = ""
+		opts.ClientKeyPath = ""
+		opts.Proxy = ""
+		opts.DisableKeepAlives = true
+		client, err = httpclient.New(opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := *client
+	c.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &c, nil
+}
+
+// isYcrashServer reports whether the target is the yCrash server of the config.
+func isYcrashServer(target *url.URL) bool {
+	server, err := url.Parse(config.GlobalConfig.Server)
+	if err != nil || server.Host == "" {
+		return false
+	}
+	return strings.EqualFold(server.Scheme, target.Scheme) &&
+		strings.EqualFold(server.Hostname(), target.Hostname()) &&
+		targetPort(server) == targetPort(target)
+}
+
+// stripKey returns the JSON object body without its key, the API key of an
+// action request, the body as is if it's not a JSON object.
+func stripKey(body []byte) []byte {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return body
+	}
+	stripped := false
+	for name := range fields {
+		// Matched like json.Unmarshal matches the Key of ActionRequest
+		if strings.EqualFold(name, "key") {
+			delete(fields, name)
+			stripped = true
+		}
+	}
+	if !stripped {
+		return body
+	}
+	result, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return result
+}
+
+// forwardAuthorized reports whether the request to forward is of an authenticated
+// client, or has the API key in its ApiKey header or in the key of its action request body.
+func forwardAuthorized(request *http.Request, body []byte) bool {
+	if clientOf(request.Context()) != "" || equal(config.GlobalConfig.ApiKey, request.Header.Get("ApiKey")) {
+		return true
+	}
+	req := &ActionRequest{}
+	if err := json.Unmarshal(body, req); err != nil {
+		return false
+	}
+	return equal(config.GlobalConfig.ApiKey, req.Key)
+}
+
+// handleYcrashForward forwards the request to the URL of its ForwardHeader, if
+// allowed, and copies back the response. The request and the response are limited
+// to ForwardMaxBodySize and the round trip to ForwardTimeout. Redirects are not
+// followed, they could lead anywhere.
+func (s *Server) handleYcrashForward(writer http.ResponseWriter, request *http.Request) {
+	client := clientOf(request.Context())
+	forward := request.Header.Get(ForwardHeader)
+	audit := func(status int, err error) {
+		event := logger.Info()
+		if err != nil {
+			event = logger.Warn().Err(err)
+		}
+		event.Str("client", client).Str("remote", request.RemoteAddr).Str("method", request.Method).Str("target", forward).Int("status", status).Msg("api audit: forward")
+	}
+	deny := func(status int, code int, err error) {
+		audit(status, err)
+		writeJSON(writer, status, &ActionResponse{Code: code, Msg: err.Error()})
+	}
+
+	target, err := url.Parse(forward)
+	if err != nil {
+		deny(http.StatusBadRequest, -1, errors.New("invalid "+ForwardHeader+" header"))
+		return
+	}
+	// The target is logged without its query, it may hold secrets
+	forward = (&url.URL{Scheme: target.Scheme, Host: target.Host, Path: target.Path}).String()
+
+	maxBodySize := config.GlobalConfig.ForwardMaxBodySize
+	body, err := io.ReadAll(io.LimitReader(request.Body, maxBodySize+1))
+	if err != nil {
+		deny(http.StatusBadRequest, -1, fmt.Errorf("failed to read body: %w", err))
+		return
+	}
+	if int64(len(body)) > maxBodySize {
+		deny(http.StatusRequestEntityTooLarge, -1, fmt.Errorf("request body is larger than %d bytes", maxBodySize))
+		return
+	}
+
+	if !forwardAuthorized(request, body) {
+		deny(http.StatusUnauthorized, -1, errors.New("invalid key passed"))
+		return
+	}
+	if client == "" {
+		client = apiKeyClient
+	}
+	if err := forwardAllowed(target); err != nil {
+		deny(http.StatusForbidden, -1, fmt.Errorf("forward denied: %w", err))
+		return
+	}
+
+	// Only the yCrash server gets the credentials, in the headers and in the body
+	toServer := isYcrashServer(target)
+	if !toServer {
+		body = stripKey(body)
+	}
+
+	ctx, cancel := contextWithTimeout(s.ctx, config.GlobalConfig.ForwardTimeout)
+	defer cancel()
+	fr, err := http.NewRequestWithContext(ctx, request.Method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		deny(http.StatusBadRequest, -1, err)
+		return
+	}
+	fr.Header = request.Header.Clone()
+	stripHeaders(fr.Header)
+	fr.Header.Del(ForwardHeader)
+	for _, h := range credentialHeaders {
+		fr.Header.Del(h)
+	}
+	if !toServer {
+		for _, h := range serverCredentialHeaders {
+			fr.Header.Del(h)
+		}
+	}
+	fr.Close = true
+
+	httpClient, err := forwardClient(toServer)
+	if err != nil {
+		deny(http.StatusInternalServerError, -2, err)
+		return
+	}
+	r, err := httpClient.Do(fr)
+	if err != nil {
+		deny(http.StatusBadGateway, -2, err)
+		return
+	}
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			logger.Log("failed to close response body: %v", err)
+		}
+	}()
+	if r.ContentLength > maxBodySize {
+		deny(http.StatusBadGateway, -2, fmt.Errorf("response body is larger than %d bytes", maxBodySize))
+		return
+	}
+
+	stripHeaders(r.Header)
+	for key, v := range r.Header {
+		for _, value := range v {
+			writer.Header().Add(key, value)
+		}
+	}
+	writer.WriteHeader(r.StatusCode)
+	n, err := io.Copy(writer, io.LimitReader(r.Body, maxBodySize))
+	if err == nil && n == maxBodySize {
+		// The response can't be failed anymore, it's only truncated
+		if extra, _ := r.Body.Read(make([]byte, 1)); extra > 0 {
+			err = fmt.Errorf("response body truncated to %d bytes", maxBodySize)
+		}
+	}
+	audit(r.StatusCode, err)
+}
+
+// stripHeaders deletes the hop-by-hop headers, including the ones listed in the Connection header.
+func stripHeaders(header http.Header) {
+	for _, value := range header.Values("Connection") {
+		for _, h := range strings.Split(value, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				header.Del(h)
+			}
+		}
+	}
+	for _, h := range hopHeaders {
+		header.Del(h)
+	}
+}
+
+func contextWithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
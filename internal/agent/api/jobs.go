@@ -0,0 +1,432 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"yc-agent/internal/agent/ondemand"
+	"yc-agent/internal/capture"
+	"yc-agent/internal/logger"
+)
+
+// The states of a job.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+)
+
+// MaxJobs is how many jobs are kept, the oldest finished ones are removed first.
+var MaxJobs = 100
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobFinished = errors.New("job already finished")
+)
+
+// Job is a capture requested through the action API.
+type Job struct {
+	ID         string
+	State      string
+	Msg        string `json:",omitempty"`
+	Actions    []string
+	Created    time.Time
+	Started    time.Time
+	Ended      time.Time
+	ReportURLs []string `json:",omitempty"`
+	Pids       []*JobPid
+}
+
+// JobPid is the capture of a process of a job.
+type JobPid struct {
+	Pid        int
+	URL        string `json:",omitempty"`
+	Collectors []*JobCollector
+}
+
+// JobCollector is the progress and the result of a collector of the capture of a process.
+type JobCollector struct {
+	Name         string
+	State        string
+	Transmission string `json:",omitempty"`
+	Msg          string `json:",omitempty"`
+	Started      time.Time
+	Ended        time.Time
+}
+
+// Finished reports whether the job won't change anymore.
+func (j *Job) Finished() bool {
+	return j.State != JobQueued && j.State != JobRunning
+}
+
+func (j *Job) pid(pid int) *JobPid {
+	for _, p := range j.Pids {
+		if p.Pid == pid {
+			return p
+		}
+	}
+	p := &JobPid{Pid: pid}
+	j.Pids = append(j.Pids, p)
+	return p
+}
+
+func (j *Job) clone() *Job {
+	data, _ := json.Marshal(j)
+	c := &Job{}
+	_ = json.Unmarshal(data, c)
+	return c
+}
+
+// The types of the events of a job.
+const (
+	// JobEventState is the job changing state.
+	JobEventState = "state"
+	// JobEventCollector is a collector of the job changing state.
+	JobEventCollector = "collector"
+	// JobEventFallback is a collector falling back to another method.
+	JobEventFallback = capture.EventFallback
+	// JobEventUpload is the progress of an upload of a collector.
+	JobEventUpload = capture.EventUpload
+)
+
+// JobEvent is a step of a job in progress, see Subscribe.
+type JobEvent struct {
+	Type  string
+	Time  time.Time
+	State string `json:",omitempty"`
+	Msg   string `json:",omitempty"`
+	// Pid and Collector are the collector of the event, empty for the job state.
+	Pid       int    `json:",omitempty"`
+	Collector string `json:",omitempty"`
+	// Method is the method a collector falls back to, From the one that failed.
+	Method string `json:",omitempty"`
+	From   string `json:",omitempty"`
+	// DataType is the dt of an upload, Bytes of its Total are uploaded.
+	DataType string `json:",omitempty"`
+	Bytes    int64  `json:",omitempty"`
+	Total    int64  `json:",omitempty"`
+	Percent  int    `json:",omitempty"`
+	Done     bool   `json:",omitempty"`
+}
+
+// jobEventsBuffer is how many events a subscriber may lag behind, the next ones are dropped.
+const jobEventsBuffer = 64
+
+// JobStore keeps the jobs, saved to a dir so that they survive a restart of the agent.
+type JobStore struct {
+	dir     string
+	mtx     sync.Mutex
+	jobs    map[string]*Job
+	cancels map[string]context.CancelFunc
+	subs    map[string][]chan JobEvent
+}
+
+// NewJobStore returns a store keeping the jobs in memory only.
+func NewJobStore() *JobStore {
+	return &JobStore{jobs: map[string]*Job{}, cancels: map[string]context.CancelFunc{}, subs: map[string][]chan JobEvent{}}
+}
+
+// OpenJobStore returns a store saving the jobs to dir, with the jobs saved by the
+// previous runs of the agent. Jobs that were in flight are failed.
+func OpenJobStore(dir string) (*JobStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	s := NewJobStore()
+	s.dir = dir
+
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			logger.Log("WARNING: failed to read job %s: %s", name, err)
+			continue
+		}
+		job := &Job{}
+		err = json.Unmarshal(data, job)
+		if err != nil || job.ID == "" {
+			logger.Log("WARNING: invalid job %s: %v", name, err)
+			continue
+		}
+		if !job.Finished() {
+			job.State = JobFailed
+			job.Msg = "interrupted by a restart of the agent"
+			job.Ended = time.Now()
+			s.save(job)
+		}
+		s.jobs[job.ID] = job
+	}
+	s.prune()
+	return s, nil
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Create adds a queued job of the actions, canceled with cancel.
+func (s *JobStore) Create(actions []string, cancel context.CancelFunc) *Job {
+	job := &Job{
+		ID:      newJobID(),
+		State:   JobQueued,
+		Actions: actions,
+		Created: time.Now(),
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.jobs[job.ID] = job
+	s.cancels[job.ID] = cancel
+	s.save(job)
+	s.prune()
+	return job.clone()
+}
+
+// Get returns a copy of the job id.
+func (s *JobStore) Get(id string) (*Job, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return job.clone(), nil
+}
+
+// List returns copies of the jobs, the most recent first.
+func (s *JobStore) List() []*Job {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	jobs := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job.clone())
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Created.After(jobs[j].Created)
+	})
+	return jobs
+}
+
+// Cancel cancels the job id, killing the commands of its capture.
+func (s *JobStore) Cancel(id string) (*Job, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	if job.Finished() {
+		return job.clone(), ErrJobFinished
+	}
+	job.State = JobCanceled
+	job.Msg = "canceled"
+	if cancel := s.cancels[id]; cancel != nil {
+		cancel()
+	}
+	s.save(job)
+	s.publish(job, JobEvent{Type: JobEventState, State: job.State, Msg: job.Msg})
+	return job.clone(), nil
+}
+
+// Update runs fn with the job id and saves it.
+func (s *JobStore) Update(id string, fn func(job *Job)) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return
+	}
+	state := job.State
+	fn(job)
+	if job.Finished() {
+		delete(s.cancels, id)
+	}
+	s.save(job)
+	if job.State != state {
+		s.publish(job, JobEvent{Type: JobEventState, State: job.State, Msg: job.Msg})
+	}
+}
+
+// Subscribe returns the events of the job id from now on, and the func to call
+// once done with them. The events are closed once the job finishes, right away
+// if it's finished already. Events are dropped while the subscriber lags behind
+// by more than jobEventsBuffer.
+func (s *JobStore) Subscribe(id string) (events <-chan JobEvent, unsubscribe func(), err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, nil, ErrJobNotFound
+	}
+	ch := make(chan JobEvent, jobEventsBuffer)
+	if job.Finished() {
+		close(ch)
+		return ch, func() {}, nil
+	}
+	s.subs[id] = append(s.subs[id], ch)
+
+	return ch, func() {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		subs := s.subs[id]
+		for i, sub := range subs {
+			if sub == ch {
+				s.subs[id] = append(subs[:i:i], subs[i+1:]...)
+				close(ch)
+				break
+			}
+		}
+		if len(s.subs[id]) == 0 {
+			delete(s.subs, id)
+		}
+	}, nil
+}
+
+// publish sends the event of the job to its subscribers, and closes them once
+// the job is finished. s.mtx must be held.
+func (s *JobStore) publish(job *Job, e JobEvent) {
+	e.Time = time.Now()
+	for _, sub := range s.subs[job.ID] {
+		select {
+		case sub <- e:
+		default:
+		}
+	}
+	if job.Finished() {
+		for _, sub := range s.subs[job.ID] {
+			close(sub)
+		}
+		delete(s.subs, job.ID)
+	}
+}
+
+// Events returns the func publishing the events of the collectors of the job id, see Subscribe.
+func (s *JobStore) Events(id string) ondemand.EventFunc {
+	return func(pid int, collector string, event capture.Event) {
+		e := JobEvent{
+			Type:      event.Type,
+			Pid:       pid,
+			Collector: collector,
+			Method:    event.Method,
+			From:      event.From,
+			DataType:  event.DataType,
+			Bytes:     event.Bytes,
+			Total:     event.Total,
+			Done:      event.Done,
+		}
+		if event.Total > 0 {
+			e.Percent = int(event.Bytes * 100 / event.Total)
+		}
+		if event.Done && !event.Ok {
+			e.Msg = "upload failed"
+		}
+
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		if job, ok := s.jobs[id]; ok {
+			s.publish(job, e)
+		}
+	}
+}
+
+// Progress returns the func updating the collectors of the job id with the progress of its capture.
+func (s *JobStore) Progress(id string) ondemand.ProgressFunc {
+	return func(pid int, collector string, state string, result capture.Result) {
+		s.Update(id, func(job *Job) {
+			p := job.pid(pid)
+			var c *JobCollector
+			for _, existing := range p.Collectors {
+				if existing.Name == collector {
+					c = existing
+					break
+				}
+			}
+			if c == nil {
+				c = &JobCollector{Name: collector}
+				p.Collectors = append(p.Collectors, c)
+			}
+			c.State = state
+			if state == ondemand.CollectorRunning {
+				c.Started = time.Now()
+			} else {
+				c.Ended = time.Now()
+				c.Msg = result.Msg
+				c.Transmission = result.Transmission()
+			}
+			s.publish(job, JobEvent{Type: JobEventCollector, Pid: pid, Collector: collector, State: state, Msg: c.Msg})
+		})
+	}
+}
+
+// save writes the job to the dir of the store, if any. s.mtx must be held.
+func (s *JobStore) save(job *Job) {
+	if s.dir == "" {
+		return
+	}
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		logger.Log("WARNING: failed to encode job %s: %s", job.ID, err)
+		return
+	}
+	name := filepath.Join(s.dir, job.ID+".json")
+	tmp := name + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		logger.Log("WARNING: failed to save job %s: %s", job.ID, err)
+	}
+}
+
+// prune removes the oldest finished jobs beyond MaxJobs. s.mtx must be held.
+func (s *JobStore) prune() {
+	if len(s.jobs) <= MaxJobs {
+		return
+	}
+	var finished []*Job
+	for _, job := range s.jobs {
+		if job.Finished() {
+			finished = append(finished, job)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].Created.Before(finished[j].Created)
+	})
+	for _, job := range finished {
+		if len(s.jobs) <= MaxJobs {
+			return
+		}
+		delete(s.jobs, job.ID)
+		if s.dir != "" {
+			err := os.Remove(filepath.Join(s.dir, job.ID+".json"))
+			if err != nil && !os.IsNotExist(err) {
+				logger.Log("WARNING: failed to remove job %s: %s", job.ID, err)
+			}
+		}
+	}
+}
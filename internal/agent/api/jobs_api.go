@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"yc-agent/internal/config"
+	"yc-agent/internal/logger"
+)
+
+// JobsResponse is the response of the jobs API.
+type JobsResponse struct {
+	Code int
+	Msg  string
+	Job  *Job   `json:",omitempty"`
+	Jobs []*Job `json:",omitempty"`
+}
+
+// authorized reports whether the request is of an authenticated client, or has the
+// API key in its ApiKey header, responding with 401 if it isn't. With neither an
+// API key nor ApiClients configured, every request is denied.
+func authorized(writer http.ResponseWriter, request *http.Request) bool {
+	if clientOf(request.Context()) != "" || equal(config.GlobalConfig.ApiKey, request.Header.Get("ApiKey")) {
+		return true
+	}
+	writeJSON(writer, http.StatusUnauthorized, &JobsResponse{Code: -1, Msg: "invalid key passed"})
+	return false
+}
+
+// ListJobs serves GET /jobs, the recent jobs.
+func (s *Server) ListJobs(writer http.ResponseWriter, request *http.Request) {
+	if !authorized(writer, request) {
+		return
+	}
+	writeJSON(writer, http.StatusOK, &JobsResponse{Jobs: s.Jobs.List()})
+}
+
+// GetJob serves GET /jobs/{id}, the progress and the results of the collectors of the job.
+func (s *Server) GetJob(writer http.ResponseWriter, request *http.Request) {
+	if !authorized(writer, request) {
+		return
+	}
+	job, err := s.Jobs.Get(request.PathValue("id"))
+	if err != nil {
+		writeJSON(writer, http.StatusNotFound, &JobsResponse{Code: -1, Msg: err.Error()})
+		return
+	}
+	writeJSON(writer, http.StatusOK, &JobsResponse{Job: job})
+}
+
+// CancelJob serves DELETE /jobs/{id}, canceling the job in flight.
+func (s *Server) CancelJob(writer http.ResponseWriter, request *http.Request) {
+	if !authorized(writer, request) {
+		return
+	}
+	job, err := s.Jobs.Cancel(request.PathValue("id"))
+	switch {
+	case errors.Is(err, ErrJobNotFound):
+		writeJSON(writer, http.StatusNotFound, &JobsResponse{Code: -1, Msg: err.Error()})
+	case errors.Is(err, ErrJobFinished):
+		writeJSON(writer, http.StatusConflict, &JobsResponse{Code: -1, Msg: err.Error(), Job: job})
+	default:
+		logger.Info().Str("client", clientOf(request.Context())).Str("job", job.ID).Msg("api audit: job canceled")
+		writeJSON(writer, http.StatusAccepted, &JobsResponse{Job: job})
+	}
+}
+
+// JobEventsKeepAlive is how often a comment is sent to the subscribers of the job
+// events while there are none, so that proxies don't close the stream.
+var JobEventsKeepAlive = 15 * time.Second
+
+// GetJobEvents serves GET /jobs/{id}/events, the events of the job as Server-Sent
+// Events until it finishes: the collectors starting and finishing, falling back to
+// another method, and the progress of their uploads. The stream starts with the
+// state of the job, and ends with its final state.
+func (s *Server) GetJobEvents(writer http.ResponseWriter, request *http.Request) {
+	if !authorized(writer, request) {
+		return
+	}
+	id := request.PathValue("id")
+	events, unsubscribe, err := s.Jobs.Subscribe(id)
+	if err != nil {
+		writeJSON(writer, http.StatusNotFound, &JobsResponse{Code: -1, Msg: err.Error()})
+		return
+	}
+	defer unsubscribe()
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("X-Accel-Buffering", "no")
+	writer.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(writer)
+
+	var state string
+	send := func(e JobEvent) bool {
+		if e.Type == JobEventState {
+			state = e.State
+		}
+		data, err := json.Marshal(e)
+		if err == nil {
+			_, err = fmt.Fprintf(writer, "event: %s\ndata: %s\n\n", e.Type, data)
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		return err == nil
+	}
+	sendState := func() bool {
+		job, err := s.Jobs.Get(id)
+		if err != nil || job.State == state {
+			return false
+		}
+		return send(JobEvent{Type: JobEventState, Time: time.Now(), State: job.State, Msg: job.Msg})
+	}
+
+	if !sendState() {
+		return
+	}
+	keepAlive := time.NewTicker(JobEventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				// The job finished, its final state is sent unless it already was
+				sendState()
+				return
+			}
+			if !send(e) {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(writer, ": keep-alive\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		case <-request.Context().Done():
+			return
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func writeJSON(writer http.ResponseWriter, status int, v interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(v)
+	if err != nil {
+		logger.Log("failed to encode response(%#v): %v", v, err)
+	}
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"yc-agent/internal/agent/ondemand"
+	"yc-agent/internal/capture"
+	"yc-agent/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobs(t *testing.T) {
+	config.GlobalConfig.ApiKey = "buggycompany@e094aasdsa-c3eb-4c9a-8254-f0dd107245cc"
+	s := NewServer(context.Background(), "localhost", 0)
+	returned := make(chan error, 1)
+	s.ProcessPids = func(ctx context.Context, pids []int, pid2Name map[int]string, hd bool, jfrPids map[int]bool, tags string) (results []ondemand.PidResult, err error) {
+		<-ctx.Done()
+		returned <- ctx.Err()
+		return nil, ctx.Err()
+	}
+
+	do := func(method, path, key string, body string) (*httptest.ResponseRecorder, *JobsResponse) {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("ApiKey", key)
+		w := httptest.NewRecorder()
+		s.Handler.ServeHTTP(w, req)
+		resp := &JobsResponse{}
+		_ = json.Unmarshal(w.Body.Bytes(), resp)
+		return w, resp
+	}
+	key := config.GlobalConfig.ApiKey
+
+	w, _ := do(http.MethodPost, "/action", "", fmt.Sprintf(`{"key": %q, "actions": ["capture %d"]}`, key, os.Getpid()))
+	action := &ActionResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), action))
+	require.NotEmpty(t, action.JobID)
+
+	assert.Eventually(t, func() bool {
+		_, resp := do(http.MethodGet, "/jobs/"+action.JobID, key, "")
+		return resp.Job != nil && resp.Job.State == JobRunning
+	}, time.Second, 10*time.Millisecond)
+
+	w, _ = do(http.MethodGet, "/jobs", "wrong", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w, _ = do(http.MethodGet, "/jobs/unknown", key, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w, resp := do(http.MethodDelete, "/jobs/"+action.JobID, key, "")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	require.NotNil(t, resp.Job)
+	assert.Equal(t, JobCanceled, resp.Job.State)
+	select {
+	case err := <-returned:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("the capture of the canceled job is still running")
+	}
+
+	assert.Eventually(t, func() bool {
+		_, resp := do(http.MethodGet, "/jobs", key, "")
+		return len(resp.Jobs) == 1 && resp.Jobs[0].State == JobCanceled && !resp.Jobs[0].Ended.IsZero()
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, os.Getpid(), resp.Job.Pids[0].Pid)
+
+	w, _ = do(http.MethodDelete, "/jobs/"+action.JobID, key, "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	config.GlobalConfig.ApiKey = ""
+	defer func() { config.GlobalConfig.ApiKey = key }()
+	w, _ = do(http.MethodGet, "/jobs", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "with no API key configured, a request with no key is denied")
+}
+
+func TestJobStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenJobStore(dir)
+	require.NoError(t, err)
+
+	job := store.Create([]string{"capture 1"}, func() {})
+	progress := store.Progress(job.ID)
+	progress(1, "gc", ondemand.CollectorRunning, capture.Result{})
+	progress(1, "gc", ondemand.CollectorSucceeded, capture.Result{Msg: "uploaded", Ok: true})
+	progress(1, "heap", ondemand.CollectorRunning, capture.Result{})
+
+	got, err := store.Get(job.ID)
+	require.NoError(t, err)
+	require.Len(t, got.Pids, 1)
+	require.Len(t, got.Pids[0].Collectors, 2)
+	gc := got.Pids[0].Collectors[0]
+	assert.Equal(t, "gc", gc.Name)
+	assert.Equal(t, ondemand.CollectorSucceeded, gc.State)
+	assert.Equal(t, "true", gc.Transmission)
+	assert.Equal(t, "uploaded", gc.Msg)
+	assert.False(t, gc.Ended.Before(gc.Started))
+	assert.Equal(t, ondemand.CollectorRunning, got.Pids[0].Collectors[1].State)
+
+	// The job in flight is failed by a restart
+	reopened, err := OpenJobStore(dir)
+	require.NoError(t, err)
+	got, err = reopened.Get(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, JobFailed, got.State)
+	assert.Equal(t, "interrupted by a restart of the agent", got.Msg)
+	assert.Equal(t, "uploaded", got.Pids[0].Collectors[0].Msg)
+
+	MaxJobs = 1
+	t.Cleanup(func() { MaxJobs = 100 })
+	second := reopened.Create(nil, func() {})
+	jobs := reopened.List()
+	require.Len(t, jobs, 1)
+	assert.Equal(t, second.ID, jobs[0].ID)
+	assert.NoFileExists(t, filepath.Join(dir, job.ID+".json"))
+}
+
+func TestJobEvents(t *testing.T) {
+	withAPIClients(t, nil, 0)
+	s := NewServer(context.Background(), "localhost", 0)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	s.ProcessPids = func(ctx context.Context, pids []int, pid2Name map[int]string, hd bool, jfrPids map[int]bool, tags string) (results []ondemand.PidResult, err error) {
+		close(started)
+		<-release
+		return nil, nil
+	}
+	server := httptest.NewServer(s.Handler)
+	defer server.Close()
+	key := config.GlobalConfig.ApiKey
+
+	req := httptest.NewRequest(http.MethodPost, "/action", bytes.NewBufferString(fmt.Sprintf(`{"key": %q, "actions": ["capture %d"]}`, key, os.Getpid())))
+	w := httptest.NewRecorder()
+	s.Handler.ServeHTTP(w, req)
+	action := &ActionResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), action))
+	<-started
+
+	get := func(path, key string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("ApiKey", key)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	resp := get("/jobs/unknown/events", key)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = get("/jobs/"+action.JobID+"/events", "wrong")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = get("/jobs/"+action.JobID+"/events", key)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	scanner := bufio.NewScanner(resp.Body)
+	next := func() (string, JobEvent) {
+		var name string
+		var e JobEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			if event, ok := strings.CutPrefix(line, "event: "); ok {
+				name = event
+			} else if data, ok := strings.CutPrefix(line, "data: "); ok {
+				require.NoError(t, json.Unmarshal([]byte(data), &e))
+			} else if line == "" && name != "" {
+				return name, e
+			}
+		}
+		return "", e
+	}
+
+	name, e := next()
+	assert.Equal(t, JobEventState, name)
+	assert.Equal(t, JobRunning, e.State)
+
+	s.Jobs.Progress(action.JobID)(os.Getpid(), "td", ondemand.CollectorRunning, capture.Result{})
+	events := s.Jobs.Events(action.JobID)
+	events(os.Getpid(), "td", capture.Event{Type: capture.EventFallback, Method: "jattach", From: "jstack"})
+	events(os.Getpid(), "hd", capture.Event{Type: capture.EventUpload, DataType: "hd", Bytes: 40, Total: 100})
+	close(release)
+
+	name, e = next()
+	assert.Equal(t, JobEventCollector, name)
+	assert.Equal(t, "td", e.Collector)
+	assert.Equal(t, ondemand.CollectorRunning, e.State)
+	name, e = next()
+	assert.Equal(t, JobEventFallback, name)
+	assert.Equal(t, "jattach", e.Method)
+	assert.Equal(t, "jstack", e.From)
+	name, e = next()
+	assert.Equal(t, JobEventUpload, name)
+	assert.Equal(t, 40, e.Percent)
+	name, e = next()
+	assert.Equal(t, JobEventState, name)
+	assert.Equal(t, JobSucceeded, e.State)
+	name, _ = next()
+	assert.Empty(t, name, "the stream ends once the job finished")
+}
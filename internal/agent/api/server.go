@@ -1,28 +1,53 @@
 package api
 
 import (
+	"context"
 	"net"
 	"net/http"
 	"strconv"
+
+	"yc-agent/internal/agent/ondemand"
+	"yc-agent/internal/config"
+	"yc-agent/internal/metrics"
 )
 
 type Server struct {
 	*http.Server
-	ProcessPids func(pids []int, pid2Name map[int]string, hd bool, tags string) (rUrls []string, err error)
+	// ctx is the context of the captures triggered through the API, done when the agent shuts down.
+	ctx         context.Context
+	ProcessPids func(ctx context.Context, pids []int, pid2Name map[int]string, hd bool, jfrPids map[int]bool, tags string) (results []ondemand.PidResult, err error)
+	// Jobs are the captures requested through the action API, in memory only unless set to an opened store.
+	Jobs *JobStore
+
+	limiter rateLimiter
+	// failures rate limits the failed authentications per remote address.
+	failures rateLimiter
+	replays  replayCache
 }
 
-func NewServer(host string, port int) *Server {
+func NewServer(ctx context.Context, host string, port int) *Server {
 	mux := http.NewServeMux()
 
 	s := &Server{
 		Server: &http.Server{
-			Handler: mux,
-			Addr:    net.JoinHostPort(host, strconv.Itoa(port)),
+			Addr: net.JoinHostPort(host, strconv.Itoa(port)),
 		},
+		ctx:         ctx,
 		ProcessPids: ProcessPidsWithMutex,
+		Jobs:        NewJobStore(),
 	}
 
 	mux.HandleFunc("/action", s.Action)
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("GET /jobs", s.ListJobs)
+	mux.HandleFunc("GET /jobs/{id}", s.GetJob)
+	mux.HandleFunc("DELETE /jobs/{id}", s.CancelJob)
+	mux.HandleFunc("GET /jobs/{id}/events", s.GetJobEvents)
+	mux.HandleFunc("GET /captures", s.ListCaptures)
+	mux.HandleFunc("GET /captures/{name}/manifest", s.GetCaptureManifest)
+	mux.HandleFunc("GET /captures/{name}/files/{file...}", s.GetCaptureFile)
+	mux.HandleFunc("GET /captures/{name}/zip", s.GetCaptureZip)
+	s.Server.Handler = s.authenticate(mux)
 
 	return s
 }
@@ -34,7 +59,15 @@ func (s *Server) Serve() error {
 		return err
 	}
 
-	return s.Server.Serve(ln)
+	if config.GlobalConfig.ApiTLSCert == "" {
+		return s.Server.Serve(ln)
+	}
+	s.Server.TLSConfig, err = apiTLSConfig()
+	if err != nil {
+		_ = ln.Close()
+		return err
+	}
+	return s.Server.ServeTLS(ln, config.GlobalConfig.ApiTLSCert, config.GlobalConfig.ApiTLSKey)
 }
 
 func (s *Server) Addr() string {
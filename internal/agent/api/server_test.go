@@ -2,18 +2,24 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"yc-agent/internal/agent/ondemand"
 	"yc-agent/internal/config"
+	"yc-agent/internal/metrics"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestServer(t *testing.T) {
-	s := NewServer("localhost", 0)
-	s.ProcessPids = func(pids []int, pid2Name map[int]string, hd bool, tags string) (rUrls []string, err error) {
+	s := NewServer(context.Background(), "localhost", 0)
+	s.ProcessPids = func(ctx context.Context, pids []int, pid2Name map[int]string, hd bool, jfrPids map[int]bool, tags string) (results []ondemand.PidResult, err error) {
 		t.Log(pids)
 		return
 	}
@@ -42,7 +48,7 @@ func TestServer(t *testing.T) {
 				t.Fatal(err)
 			}
 			all = bytes.TrimSpace(all)
-			if string(all) != `{"Code":0,"Msg":""}` {
+			if !bytes.HasPrefix(all, []byte(`{"Code":0,"Msg":"","JobID":"`)) {
 				t.Fatal(string(all), all)
 			}
 		}
@@ -57,8 +63,8 @@ func TestServer(t *testing.T) {
 }
 
 func TestServerCmdActions(t *testing.T) {
-	s := NewServer("localhost", 0)
-	s.ProcessPids = func(pids []int, pid2Name map[int]string, hd bool, tags string) (rUrls []string, err error) {
+	s := NewServer(context.Background(), "localhost", 0)
+	s.ProcessPids = func(ctx context.Context, pids []int, pid2Name map[int]string, hd bool, jfrPids map[int]bool, tags string) (results []ondemand.PidResult, err error) {
 		t.Log(pids)
 		return
 	}
@@ -102,8 +108,8 @@ func TestServerCmdActions(t *testing.T) {
 }
 
 func TestServerForward(t *testing.T) {
-	s := NewServer("localhost", 0)
-	s.ProcessPids = func(pids []int, pid2Name map[int]string, hd bool, tags string) (rUrls []string, err error) {
+	s := NewServer(context.Background(), "localhost", 0)
+	s.ProcessPids = func(ctx context.Context, pids []int, pid2Name map[int]string, hd bool, jfrPids map[int]bool, tags string) (results []ondemand.PidResult, err error) {
 		t.Log(pids)
 		return
 	}
@@ -117,8 +123,8 @@ func TestServerForward(t *testing.T) {
 		close(errCh)
 	}()
 
-	rs := NewServer("localhost", 0)
-	rs.ProcessPids = func(pids []int, pid2Name map[int]string, hd bool, tags string) (rUrls []string, err error) {
+	rs := NewServer(context.Background(), "localhost", 0)
+	rs.ProcessPids = func(ctx context.Context, pids []int, pid2Name map[int]string, hd bool, jfrPids map[int]bool, tags string) (results []ondemand.PidResult, err error) {
 		t.Log("ok", pids)
 		return
 	}
@@ -136,6 +142,7 @@ func TestServerForward(t *testing.T) {
 		defer s.Close()
 		defer rs.Close()
 		config.GlobalConfig.ApiKey = "buggycompany@e094aasdsa-c3eb-4c9a-8254-f0dd107245cc"
+		config.GlobalConfig.ForwardAllowlist = config.ForwardAllowlist{"localhost"}
 		buf := bytes.NewBufferString(`{"key": "buggycompany@e094aasdsa-c3eb-4c9a-8254-f0dd107245cc", "actions":[ "capture 12321", "capture 2341", "capture findmydeviced"] }`)
 		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/action", s.Addr()), buf)
 		if err != nil {
@@ -154,7 +161,7 @@ func TestServerForward(t *testing.T) {
 				t.Fatal(err)
 			}
 			all = bytes.TrimSpace(all)
-			if string(all) != `{"Code":0,"Msg":""}` {
+			if !bytes.HasPrefix(all, []byte(`{"Code":0,"Msg":"","JobID":"`)) {
 				t.Fatal(string(all), all)
 			}
 		}
@@ -173,8 +180,8 @@ func TestServerForward(t *testing.T) {
 }
 
 func TestAttendanceAPI(t *testing.T) {
-	s := NewServer("localhost", 0)
-	s.ProcessPids = func(pids []int, pid2Name map[int]string, hd bool, tags string) (rUrls []string, err error) {
+	s := NewServer(context.Background(), "localhost", 0)
+	s.ProcessPids = func(ctx context.Context, pids []int, pid2Name map[int]string, hd bool, jfrPids map[int]bool, tags string) (results []ondemand.PidResult, err error) {
 		t.Log(pids)
 		return
 	}
@@ -244,3 +251,17 @@ func TestJSON(t *testing.T) {
 		t.Fatal("should be true")
 	}
 }
+
+func TestMetrics(t *testing.T) {
+	s := NewServer(context.Background(), "localhost", 0)
+	metrics.CapturesStarted.WithLabelValues("gc").Inc()
+
+	w := httptest.NewRecorder()
+	s.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+	assert.Contains(t, w.Body.String(), "# TYPE yc_agent_captures_started_total counter")
+	assert.Contains(t, w.Body.String(), `yc_agent_captures_started_total{collector="gc"}`)
+	assert.Contains(t, w.Body.String(), "yc_agent_spool_queue_depth")
+}
@@ -20,6 +20,7 @@ import (
 	"yc-agent/internal/capture/executils"
 	"yc-agent/internal/config"
 	"yc-agent/internal/logger"
+	"yc-agent/internal/metrics"
 
 	"github.com/bmatcuk/doublestar/v4"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -27,6 +28,11 @@ import (
 	"k8s.io/client-go/rest"
 )
 
+// m3PressureDuration is the window the pressure is sampled over in m3 mode,
+// about the time of the rounds of top, shorter than SCRIPT_SPAN to keep the
+// cycles short.
+const m3PressureDuration = time.Minute
+
 type M3App struct {
 	runLock  sync.Mutex
 	appLogM3 *capture.AppLogM3
@@ -40,17 +46,30 @@ func NewM3App() *M3App {
 	}
 }
 
-func (m3 *M3App) RunLoop() {
+// RunLoop runs the m3 capture every M3Frequency until ctx is done.
+func (m3 *M3App) RunLoop(ctx context.Context) {
 	for {
-		m3.RunSingle()
-		time.Sleep(config.GlobalConfig.M3Frequency)
+		m3.RunSingle(ctx)
+		select {
+		case <-time.After(config.GlobalConfig.M3Frequency):
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
-func (m3 *M3App) RunSingle() error {
+func (m3 *M3App) RunSingle(ctx context.Context) (err error) {
 	m3.runLock.Lock()
 	defer m3.runLock.Unlock()
 
+	start := time.Now()
+	defer func() {
+		metrics.M3CycleDuration.Set(time.Since(start).Seconds())
+		if err == nil {
+			metrics.M3LastSuccess.SetToCurrentTime()
+		}
+	}()
+
 	now, timezone := common.GetAgentCurrentTime()
 	timestamp := now.Format("2006-01-02T15-04-05")
 
@@ -64,8 +83,8 @@ func (m3 *M3App) RunSingle() error {
 
 	// Init directory
 	// TODO: This has a similar functionality with ondemand. It might be good to extract this to a common reusable function.
+	captureDir := "yc-" + timestamp
 	{
-		captureDir := "yc-" + timestamp
 		if len(config.GlobalConfig.StoragePath) > 0 {
 			captureDir = filepath.Join(config.GlobalConfig.StoragePath, captureDir)
 		}
@@ -87,28 +106,11 @@ func (m3 *M3App) RunSingle() error {
 				}()
 			}
 		}
-
-		{
-			dir, err := os.Getwd()
-			if err != nil {
-				return err
-			}
-
-			// @Andy: This prevents concurrent uses
-			// Could be eliminated to prevent issues
-			err = os.Chdir(captureDir)
-			if err != nil {
-				return err
-			}
-
-			// Reset Chdir
-			defer os.Chdir(dir)
-		}
 	}
 
 	// Capture
 	{
-		err = m3.captureAndTransmit(pids, GetM3ReceiverEndpoint(timestamp, timezone))
+		err = m3.captureAndTransmit(ctx, captureDir, pids, GetM3ReceiverEndpoint(timestamp, timezone))
 		if err != nil {
 			logger.Log("WARNING: processM3 failed, %s", err)
 			return err
@@ -118,7 +120,7 @@ func (m3 *M3App) RunSingle() error {
 	// Finish
 	{
 		finEndpoint := GetM3FinEndpoint(timestamp, timezone, pids)
-		resp, err := ondemand.RequestFin(finEndpoint)
+		resp, err := ondemand.RequestFin(ctx, finEndpoint)
 
 		if err != nil {
 			logger.Log("WARNING: Request M3 Fin failed, %s", err)
@@ -130,7 +132,7 @@ func (m3 *M3App) RunSingle() error {
 			return err
 		}
 
-		err = processM3FinResponse(resp, pids)
+		err = processM3FinResponse(ctx, captureDir, resp, pids)
 
 		if err != nil {
 			logger.Log("WARNING: processResp failed, %s", err)
@@ -196,29 +198,43 @@ func GetM3CommonEndpointParameters(timestamp string, timezone string) string {
 	return parameters
 }
 
-func (m3 *M3App) captureAndTransmit(pids map[int]string, endpoint string) (err error) {
+func (m3 *M3App) captureAndTransmit(ctx context.Context, captureDir string, pids map[int]string, endpoint string) (err error) {
 	logger.Log("yc agent version: " + executils.SCRIPT_VERSION)
 	logger.Log("yc script starting in m3 mode...")
 
 	logger.Log("Starting collection of top data...")
 	capTop := &capture.Top4M3{}
-	top := capture.GoCapture(endpoint, capture.WrapRun(capTop))
+	capTop.SetDir(captureDir)
+	top := capture.GoCapture(ctx, endpoint, capture.WrapRun(capTop))
 	logger.Log("Collection of top data started.")
 
+	logger.Log("Starting collection of pressure data...")
+	capPressure := &capture.Pressure{Duration: m3PressureDuration}
+	for pid := range pids {
+		capPressure.Pids = append(capPressure.Pids, pid)
+	}
+	capPressure.SetDir(captureDir)
+	pressure := capture.GoCapture(ctx, endpoint, capture.WrapRun(capPressure))
+	logger.Log("Collection of pressure data started.")
+
 	if len(pids) > 0 {
 		// @Andy: Existing code does this synchronously. Why not async like on-demand?
 		for pid, appName := range pids {
+			if ctx.Err() != nil {
+				break
+			}
+
 			logger.Log("uploading gc log for pid %d", pid)
-			gcPath := uploadGCLogM3(endpoint, pid)
+			gcPath := uploadGCLogM3(ctx, endpoint, captureDir, pid)
 
 			logger.Log("uploading thread dump for pid %d", pid)
-			uploadThreadDumpM3(endpoint, pid, true)
+			uploadThreadDumpM3(ctx, endpoint, captureDir, pid, true)
 
 			logger.Log("Starting collection of app logs data...")
-			m3.uploadAppLogM3(endpoint, pid, appName, gcPath)
+			m3.uploadAppLogM3(ctx, endpoint, captureDir, pid, appName, gcPath)
 
 			if healthCheckCfg, ok := config.GlobalConfig.HealthChecks[appName]; ok {
-				uploadHealthCheck(endpoint, appName, healthCheckCfg)
+				uploadHealthCheck(ctx, endpoint, captureDir, appName, healthCheckCfg)
 			}
 		}
 	}
@@ -228,31 +244,41 @@ func (m3 *M3App) captureAndTransmit(pids map[int]string, endpoint string) (err e
 		result := <-top
 		logger.Log(
 			`TOP DATA
-Is transmission completed: %t
+Is transmission completed: %s
 Resp: %s
 
 --------------------------------
-`, result.Ok, result.Msg)
+`, result.Transmission(), result.Msg)
+	}
+	if pressure != nil {
+		result := <-pressure
+		logger.Log(
+			`PRESSURE DATA
+Is transmission completed: %s
+Resp: %s
+
+--------------------------------
+`, result.Transmission(), result.Msg)
 	}
 
 	return
 }
 
-func uploadGCLogM3(endpoint string, pid int) string {
+func uploadGCLogM3(ctx context.Context, endpoint string, captureDir string, pid int) string {
 	var gcPath string
 	bs, err := ondemand.RunGCCaptureCmd(pid)
 	dockerID, _ := capture.GetDockerID(pid)
 	if err == nil && len(bs) > 0 {
 		gcPath = string(bs)
 	} else {
-		output, err := ondemand.GetGCLogFile(pid)
+		output, err := ondemand.GetGCLogFile(ctx, pid)
 		if err == nil && len(output) > 0 {
 			gcPath = output
 		}
 	}
 	var gc *os.File
-	fn := fmt.Sprintf("gc.%d.log", pid)
-	gc, err = capture.ProcessGCLogFile(gcPath, fn, dockerID, pid)
+	fn := filepath.Join(captureDir, fmt.Sprintf("gc.%d.log", pid))
+	gc, err = capture.ProcessGCLogFile(ctx, gcPath, fn, dockerID, pid)
 	if err != nil {
 		logger.Log("process log file failed %s, err: %s", gcPath, err.Error())
 	}
@@ -310,11 +336,11 @@ func uploadGCLogM3(endpoint string, pid int) string {
 	logger.Log(
 		`GC LOG DATA
 %s
-Is transmission completed: %t
+Is transmission completed: %s
 Resp: %s
 
 --------------------------------
-`, absGCPath, ok, msg)
+`, absGCPath, capture.TransmissionStatus(ok, msg), msg)
 
 	return gcPath
 }
@@ -336,7 +362,7 @@ func captureGC(pid int, gc *os.File, fn string) (file *os.File, jstat executils.
 	return
 }
 
-func uploadThreadDumpM3(endpoint string, pid int, sendPidParam bool) {
+func uploadThreadDumpM3(ctx context.Context, endpoint string, captureDir string, pid int, sendPidParam bool) {
 	var threadDump chan capture.Result
 	gcPath := config.GlobalConfig.GCPath
 	tdPath := config.GlobalConfig.ThreadDumpPath
@@ -352,12 +378,13 @@ func uploadThreadDumpM3(endpoint string, pid int, sendPidParam bool) {
 		TdPath:   tdPath,
 		JavaHome: config.GlobalConfig.JavaHomePath,
 	}
+	capThreadDump.SetDir(captureDir)
 	if sendPidParam {
 		capThreadDump.SetEndpointParam("pid", strconv.Itoa(pid))
 	}
 	capThreadDump.SetEndpointParam("cpuCount", strconv.Itoa(runtime.NumCPU()))
 	
-	threadDump = capture.GoCapture(endpoint, capture.WrapRun(capThreadDump))
+	threadDump = capture.GoCapture(ctx, endpoint, capture.WrapRun(capThreadDump))
 	// -------------------------------
 	//     Log Thread dump
 	// -------------------------------
@@ -370,15 +397,15 @@ func uploadThreadDumpM3(endpoint string, pid int, sendPidParam bool) {
 		logger.Log(
 			`THREAD DUMP DATA
 %s
-Is transmission completed: %t
+Is transmission completed: %s
 Resp: %s
 
 --------------------------------
-`, absTDPath, result.Ok, result.Msg)
+`, absTDPath, result.Transmission(), result.Msg)
 	}
 }
 
-func (m3 *M3App) uploadAppLogM3(endpoint string, pid int, appName string, gcPath string) {
+func (m3 *M3App) uploadAppLogM3(ctx context.Context, endpoint string, captureDir string, pid int, appName string, gcPath string) {
 	var appLogM3Chan chan capture.Result
 
 	useGlobalConfigAppLogs := false
@@ -412,9 +439,10 @@ func (m3 *M3App) uploadAppLogM3(endpoint string, pid int, appName string, gcPath
 
 			appLogM3 := m3.appLogM3
 			appLogM3.SetPaths(paths)
+			appLogM3.SetDir(captureDir)
 
 			useGlobalConfigAppLogs = true
-			appLogM3Chan = capture.GoCapture(endpoint, capture.WrapRun(appLogM3))
+			appLogM3Chan = capture.GoCapture(ctx, endpoint, capture.WrapRun(appLogM3))
 		}
 	}
 
@@ -457,8 +485,9 @@ func (m3 *M3App) uploadAppLogM3(endpoint string, pid int, appName string, gcPath
 		paths[pid] = appLogs
 
 		appLogM3.SetPaths(paths)
+		appLogM3.SetDir(captureDir)
 
-		appLogM3Chan = capture.GoCapture(endpoint, capture.WrapRun(appLogM3))
+		appLogM3Chan = capture.GoCapture(ctx, endpoint, capture.WrapRun(appLogM3))
 	}
 
 	logger.Log("Collection of app logs data started.")
@@ -475,27 +504,28 @@ Resps: %s
 	}
 }
 
-func uploadHealthCheck(endpoint, appName string, healthCheckCfg config.HealthCheck) {
+func uploadHealthCheck(ctx context.Context, endpoint, captureDir, appName string, healthCheckCfg config.HealthCheck) {
 	capHealthCheck := &capture.HealthCheck{
 		AppName: appName,
 		Cfg:     healthCheckCfg,
 	}
-	chanHealthCheck := capture.GoCapture(endpoint, capture.WrapRun(capHealthCheck))
+	capHealthCheck.SetDir(captureDir)
+	chanHealthCheck := capture.GoCapture(ctx, endpoint, capture.WrapRun(capHealthCheck))
 
 	if chanHealthCheck != nil {
 		result := <-chanHealthCheck
 		logger.Log(
 			`HEALTH CHECK DATA
-Is transmission completed: %t
+Is transmission completed: %s
 Resp: %s
 
 --------------------------------
-`, result.Ok, result.Msg)
+`, result.Transmission(), result.Msg)
 	}
 }
 
-func processM3FinResponse(resp []byte, pid2Name map[int]string) (err error) {
-	pids, tags, timestamps, err := ParseM3FinResponse(resp)
+func processM3FinResponse(ctx context.Context, captureDir string, resp []byte, pid2Name map[int]string) (err error) {
+	pids, jfrPids, tags, timestamps, err := parseM3FinResponse(resp)
 	if err != nil {
 		logger.Log("WARNING: Get PID from ParseJsonResp failed, %s", err)
 		return
@@ -509,7 +539,14 @@ func processM3FinResponse(resp []byte, pid2Name map[int]string) (err error) {
 	} else {
 		tmp = strings.Trim(t, ",")
 	}
-	_, err = ondemand.ProcessPids(pids, pid2Name, config.GlobalConfig.HeapDump, tmp, timestamps)
+	results, err := ondemand.ProcessPids(ctx, captureDir, pids, pid2Name, config.GlobalConfig.HeapDump, jfrPids, tmp, timestamps)
+	for _, r := range results {
+		url := r.URL
+		if url == "" {
+			url = "no report"
+		}
+		logger.Log("M3 capture of %d (%s): %s", r.Pid, pid2Name[r.Pid], url)
+	}
 	return
 }
 
@@ -520,9 +557,17 @@ type M3FinResponse struct {
 	Timestamps []string
 }
 
+// ParseM3FinResponse returns the pids to capture of the actions of the fin response,
+// "capture <pid>", or "jfr <pid>" for a capture with a Java Flight Recording.
 func ParseM3FinResponse(resp []byte) (pids []int, tags []string, timestamps []string, err error) {
+	pids, _, tags, timestamps, err = parseM3FinResponse(resp)
+	return
+}
+
+func parseM3FinResponse(resp []byte) (pids []int, jfrPids map[int]bool, tags []string, timestamps []string, err error) {
 	// Init empty slice instead of []int(nil)
 	pids = []int{}
+	jfrPids = map[int]bool{}
 	tags = []string{}
 	timestamps = []string{}
 
@@ -543,7 +588,7 @@ func ParseM3FinResponse(resp []byte) (pids []int, tags []string, timestamps []st
 	}
 
 	for _, s := range r.Actions {
-		if strings.HasPrefix(s, "capture ") {
+		if strings.HasPrefix(s, "capture ") || strings.HasPrefix(s, "jfr ") {
 			ss := strings.Split(s, " ")
 			if len(ss) == 2 {
 				id := ss[1]
@@ -552,6 +597,9 @@ func ParseM3FinResponse(resp []byte) (pids []int, tags []string, timestamps []st
 					continue
 				}
 				pids = append(pids, pid)
+				if ss[0] == "jfr" {
+					jfrPids[pid] = true
+				}
 			}
 		}
 	}
@@ -1,16 +1,26 @@
 package m3
 
 import (
+	"context"
 	"strconv"
 	"strings"
 	"testing"
 
+	"yc-agent/internal/config"
+
 	"github.com/stretchr/testify/assert"
 )
 
 // https://tier1app.atlassian.net/browse/GCEA-1780
 func TestProcessResp(t *testing.T) {
-	err := processM3FinResponse([]byte(`{"actions":["capture 1"], "tags":["tag1", "tag2"]}`), map[int]string{1: "abc"})
+	// Sample the pressure over a second rather than SCRIPT_SPAN
+	old := config.GlobalConfig.Pipeline
+	t.Cleanup(func() { config.GlobalConfig.Pipeline = old })
+	config.GlobalConfig.Pipeline.Collectors = map[string]config.PipelineCollector{
+		"pressure": {Options: map[string]string{"duration": "1s"}},
+	}
+
+	err := processM3FinResponse(context.Background(), t.TempDir(), []byte(`{"actions":["capture 1"], "tags":["tag1", "tag2"]}`), map[int]string{1: "abc"})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -92,3 +102,11 @@ func TestParseJsonResp(t *testing.T) {
 	}
 	assert.Equal(t, []int{}, ids)
 }
+
+func TestParseM3FinResponse_JFR(t *testing.T) {
+	pids, jfrPids, _, ts, err := parseM3FinResponse([]byte(`{"actions":["capture 12321", "jfr 2341"], "timestamps": ["2023-05-05T20-23-23", "2023-05-05T20-23-24"]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{12321, 2341}, pids)
+	assert.Equal(t, map[int]bool{2341: true}, jfrPids)
+	assert.Equal(t, []string{"2023-05-05T20-23-23", "2023-05-05T20-23-24"}, ts)
+}
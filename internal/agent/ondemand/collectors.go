@@ -0,0 +1,434 @@
+package ondemand
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"yc-agent/internal/capture"
+	"yc-agent/internal/config"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/gentlemanautomaton/cmdline"
+)
+
+// The collectors of the capture, in their default order. The heap dump is
+// captured once the others completed, so it doesn't disturb them.
+func init() {
+	RegisterCollector(&Collector{
+		Name:  "gc",
+		Title: "GC LOG DATA",
+		New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+			return &capture.GC{
+				Pid:      env.Pid,
+				JavaHome: config.GlobalConfig.JavaHomePath,
+				DockerID: env.DockerID,
+				GCPath:   env.GCPath,
+			}
+		},
+		Log: func(env *CaptureEnv, result capture.Result) {
+			logResult(env, "GC LOG DATA", result)
+			if !result.Ok {
+				env.Log("WARNING: no -gcPath is passed and failed to capture gc log")
+			}
+		},
+	})
+	RegisterCollector(&Collector{
+		Name:     "netstat",
+		Title:    "NETSTAT DATA",
+		HostWide: true,
+		Output:   "netstat.out",
+		DataType: "ns",
+		New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+			if !env.PidPassed() {
+				return nil
+			}
+			return &capture.NetStat{}
+		},
+	})
+	RegisterCollector(&Collector{
+		Name:       "top",
+		Title:      "TOP DATA",
+		HostWide:   true,
+		Output:     "top.out",
+		DataType:   "top",
+		Background: true,
+		New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+			if !env.PidPassed() {
+				return nil
+			}
+			return &capture.Top{}
+		},
+	})
+	RegisterCollector(&Collector{
+		Name:       "vmstat",
+		Title:      "VMstat DATA",
+		HostWide:   true,
+		Output:     "vmstat.out",
+		DataType:   "vmstat",
+		Background: true,
+		New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+			if !env.PidPassed() {
+				return nil
+			}
+			return &capture.VMStat{}
+		},
+	})
+	RegisterCollector(&Collector{
+		Name:       "pressure",
+		Title:      "PRESSURE DATA",
+		Background: true,
+		New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+			if !env.PidPassed() {
+				return nil
+			}
+			return &capture.Pressure{
+				Pids:     []int{env.Pid},
+				Interval: opts.Duration("interval", 0),
+				Duration: opts.Duration("duration", 0),
+			}
+		},
+	})
+	RegisterCollector(&Collector{
+		Name:  "ps",
+		Title: "PROCESS STATUS DATA",
+		New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+			if !env.PidPassed() {
+				return nil
+			}
+			return capture.NewPS()
+		},
+	})
+	RegisterCollector(&Collector{
+		Name:      "dmesg",
+		Title:     "DMesg DATA",
+		HostWide:  true,
+		Output:    "dmesg.out",
+		DataType:  "dmesg",
+		DependsOn: []string{"vmstat"},
+		New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+			if !env.PidPassed() {
+				return nil
+			}
+			return &capture.DMesg{}
+		},
+	})
+	RegisterCollector(&Collector{
+		Name:     "disk",
+		Title:    "DISK USAGE DATA",
+		HostWide: true,
+		Output:   "disk.out",
+		DataType: "df",
+		New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+			if !env.PidPassed() {
+				return nil
+			}
+			return &capture.Disk{}
+		},
+	})
+	RegisterCollector(&Collector{
+		Name:       "diskio",
+		Title:      "DISK IO DATA",
+		Background: true,
+		New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+			if !env.PidPassed() {
+				return nil
+			}
+			return &capture.DiskIO{
+				Pid:      env.Pid,
+				Interval: opts.Duration("interval", 0),
+				Count:    opts.Int("count", 0),
+			}
+		},
+	})
+	RegisterCollector(&Collector{
+		Name:     "ping",
+		Title:    "PING DATA",
+		HostWide: true,
+		Output:   "ping.out",
+		DataType: "ping",
+		New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+			return &capture.Ping{Host: opts.String("host", config.GlobalConfig.PingHost)}
+		},
+	})
+	RegisterCollector(&Collector{
+		Name:     "kernel",
+		Title:    "KERNEL PARAMS DATA",
+		HostWide: true,
+		Output:   "kernel.out",
+		DataType: "kernel",
+		New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+			return &capture.Kernel{}
+		},
+	})
+	RegisterCollector(&Collector{
+		Name:  "cgroup",
+		Title: "CGROUP DATA",
+		New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+			if !env.PidPassed() {
+				return nil
+			}
+			return &capture.CGroup{
+				Pid:      env.Pid,
+				Interval: opts.Duration("interval", 0),
+				Count:    opts.Int("count", 0),
+			}
+		},
+	})
+	RegisterCollector(&Collector{
+		Name:  "threaddump",
+		Title: "THREAD DUMP DATA",
+		New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+			return &capture.ThreadDump{
+				Pid:               env.Pid,
+				TdPath:            env.TDPath,
+				JavaHome:          config.GlobalConfig.JavaHomePath,
+				TdCaptureDuration: opts.Duration("captureDuration", config.GlobalConfig.TDCaptureDuration),
+			}
+		},
+		Log: func(env *CaptureEnv, result capture.Result) {
+			absTDPath, err := filepath.Abs(env.TDPath)
+			if err != nil {
+				absTDPath = fmt.Sprintf("path %s: %s", env.TDPath, err.Error())
+			}
+			env.Log(
+				`THREAD DUMP DATA
+%s
+Is transmission completed: %s
+Resp: %s
+
+--------------------------------
+`, absTDPath, result.Transmission(), result.Msg)
+		},
+	})
+	RegisterCollector(&Collector{
+		Name:  "applog",
+		Title: "APPLOG DATA",
+		New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+			lineLimit := opts.Int("lineLimit", config.GlobalConfig.AppLogLineCount)
+			if len(config.GlobalConfig.AppLog) == 0 || lineLimit == 0 {
+				return nil
+			}
+			return &capture.AppLog{Paths: config.AppLogs{config.AppLog(config.GlobalConfig.AppLog)}, LineLimit: lineLimit}
+		},
+		Log: func(env *CaptureEnv, result capture.Result) {
+			env.Log(
+				`APPLOG DATA
+Is transmission completed: %s
+Resp:
+%s
+
+--------------------------------
+`, result.Transmission(), result.Msg)
+		},
+	})
+	RegisterCollector(&Collector{
+		Name:  "applogs",
+		Title: "APPLOGS DATA",
+		New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+			lineLimit := opts.Int("lineLimit", config.GlobalConfig.AppLogLineCount)
+			return &capture.AppLog{Paths: appLogPaths(env, lineLimit), LineLimit: lineLimit}
+		},
+		Log: func(env *CaptureEnv, result capture.Result) {
+			env.Log(
+				`APPLOGS DATA
+Ok (at least one transmitted): %t
+Resps:
+%s
+
+--------------------------------
+`, result.Ok, result.Msg)
+		},
+	})
+	RegisterCollector(&Collector{
+		Name:  "hdsub",
+		Title: "HDSUB DATA",
+		New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+			return &capture.HDSub{
+				Pid:      env.Pid,
+				JavaHome: config.GlobalConfig.JavaHomePath,
+			}
+		},
+	})
+	RegisterCollector(&Collector{
+		Name:  "jfr",
+		Title: "JFR DATA",
+		New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+			if !env.JFR || !env.PidPassed() {
+				return nil
+			}
+			return &capture.JFR{
+				Pid:      env.Pid,
+				JavaHome: config.GlobalConfig.JavaHomePath,
+				Duration: opts.Duration("duration", config.GlobalConfig.JFRDuration),
+				Settings: opts.String("settings", config.GlobalConfig.JFRSettings),
+				MaxSize:  opts.String("maxSize", config.GlobalConfig.JFRMaxSize),
+			}
+		},
+	})
+	RegisterCollector(&Collector{
+		Name:  "extendeddata",
+		Title: "EXTENDED DATA",
+		New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+			script := opts.String("script", config.GlobalConfig.EdScript)
+			dataFolder := opts.String("dataFolder", config.GlobalConfig.EdDataFolder)
+			if script == "" || dataFolder == "" {
+				return nil
+			}
+			return &capture.ExtendedData{Script: script, DataFolder: dataFolder}
+		},
+	})
+	RegisterCollector(&Collector{
+		Name:     "heapdump",
+		Title:    "HEAP DUMP DATA",
+		Receiver: "yc-receiver-heap",
+		DependsOn: []string{"gc", "netstat", "top", "vmstat", "pressure", "ps", "dmesg", "disk",
+			"diskio", "ping", "kernel", "cgroup", "threaddump", "applog", "applogs", "hdsub", "jfr"},
+		New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+			return capture.NewHeapDump(config.GlobalConfig.JavaHomePath, env.Pid, env.HDPath, env.HeapDump)
+		},
+	})
+	RegisterCollector(&Collector{
+		Name:      "custom",
+		Title:     "CUSTOM CMDS",
+		DependsOn: []string{"heapdump", "extendeddata"},
+		New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+			if len(config.GlobalConfig.Commands) == 0 {
+				return nil
+			}
+			return &customCommands{commands: config.GlobalConfig.Commands}
+		},
+		Log: func(env *CaptureEnv, result capture.Result) {
+			env.Log("Executed custom commands")
+		},
+	})
+}
+
+// appLogPaths returns the app logs of the config matching the app name,
+// or the log files opened by the process, but its gc logs.
+func appLogPaths(env *CaptureEnv, lineLimit int) config.AppLogs {
+	if len(config.GlobalConfig.AppLogs) > 0 && lineLimit != 0 {
+		appLogsContainDollarSign := false
+		for _, configAppLog := range config.GlobalConfig.AppLogs {
+			if strings.Contains(string(configAppLog), "$") {
+				appLogsContainDollarSign = true
+				break
+			}
+		}
+
+		if !appLogsContainDollarSign {
+			return config.GlobalConfig.AppLogs
+		}
+
+		// If any of the appLogs contain '$', choose only the matched appName
+		appLogsMatchingAppName := config.AppLogs{}
+		for _, configAppLog := range config.GlobalConfig.AppLogs {
+			searchToken := "$" + env.AppName
+
+			beforeSearchToken, found := strings.CutSuffix(string(configAppLog), searchToken)
+			if found {
+				appLogsMatchingAppName = append(appLogsMatchingAppName, config.AppLog(beforeSearchToken))
+			}
+		}
+		if len(appLogsMatchingAppName) > 0 {
+			return appLogsMatchingAppName
+		}
+	}
+
+	// Auto discover app logs
+	discoveredLogFiles, err := capture.DiscoverOpenedLogFilesByProcess(env.Pid)
+	if err != nil {
+		env.Log("Error on auto discovering app logs: %s", err.Error())
+	}
+
+	// To exclude GC log files from app logs discovery
+	globFiles := []string{}
+
+	// Need to check gcPath not empty. Otherwise, empty pattern will return an unexpected result: [".", "."]
+	if env.GCPath != "" {
+		pattern := capture.GetGlobPatternFromGCPath(env.GCPath, env.Pid)
+
+		var globErr error
+		globFiles, globErr = doublestar.FilepathGlob(pattern, doublestar.WithFilesOnly(), doublestar.WithNoFollow())
+		if globErr != nil {
+			env.Log("App logs Auto discovery: Error on creating Glob pattern %s", pattern)
+		}
+	}
+
+	paths := config.AppLogs{}
+	for _, f := range discoveredLogFiles {
+		isGCLog := false
+		for _, fileName := range globFiles {
+			// To exclude discovered gc log such f as /tmp/buggyapp-%p-%t.log
+			// also exclude discovered gc log with rotation where such f as /tmp/buggyapp-%p-%t.log.0
+			// Where the `pattern` = /tmp/buggyapp-*-*.log
+			if strings.Contains(f, filepath.FromSlash(fileName)) {
+				isGCLog = true
+				env.Log("App logs Auto discovery: Ignored %s because it is detected as a GC log", f)
+				break
+			}
+		}
+
+		if !isGCLog {
+			paths = append(paths, config.AppLog(f))
+		}
+	}
+	return paths
+}
+
+// customCommands executes the custom commands of the config one after another.
+type customCommands struct {
+	capture.Capture
+	commands []config.Command
+}
+
+func (c *customCommands) Run(ctx context.Context) (result capture.Result, err error) {
+	c.Log("Executing custom commands")
+	for i, command := range c.commands {
+		if ctx.Err() != nil {
+			break
+		}
+		customCmd := &capture.Custom{
+			Index:     i,
+			UrlParams: string(command.UrlParams),
+			Command:   cmdline.Split(string(command.Cmd)),
+		}
+		customCmd.SetEndpoint(c.Endpoint())
+		customCmd.SetDir(c.Dir())
+		c.ShareManifest(customCmd)
+		r, err := capture.RunWithTimeout(ctx, customCmd)
+		if err != nil {
+			c.Log("WARNING: Failed to execute custom command %d:%s, cause: %s", i, command.Cmd, err.Error())
+			continue
+		}
+		c.Log(
+			`CUSTOM CMD %d: %s
+Is transmission completed: %s
+Resp: %s
+
+--------------------------------
+`, i, command.Cmd, r.Transmission(), r.Msg)
+		result.Ok = result.Ok || r.Ok
+	}
+	return
+}
+
+// newCommandCollector creates the collector of a command from the pipeline config.
+func newCommandCollector(name string, index int, cc config.PipelineCollector) *Collector {
+	urlParams := cc.UrlParams
+	if urlParams == "" {
+		urlParams = "dt=" + name
+	}
+	return &Collector{
+		Name:  name,
+		Title: fmt.Sprintf("%s DATA", strings.ToUpper(name)),
+		New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+			return &capture.Custom{
+				Index:     index,
+				UrlParams: urlParams,
+				Command:   cmdline.Split(cc.Cmd),
+			}
+		},
+	}
+}
@@ -2,11 +2,11 @@ package ondemand
 
 import (
 	"bytes"
-	"crypto/tls"
-	"crypto/x509"
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"net/http"
 	"os"
@@ -23,19 +23,30 @@ import (
 	"yc-agent/internal/agent/common"
 	"yc-agent/internal/capture"
 	"yc-agent/internal/capture/executils"
+	"yc-agent/internal/capture/httpclient"
 	"yc-agent/internal/capture/java"
 	"yc-agent/internal/config"
 	"yc-agent/internal/logger"
 
-	"github.com/bmatcuk/doublestar/v4"
-	"github.com/gentlemanautomaton/cmdline"
 	"github.com/pterm/pterm"
+	"github.com/rs/zerolog"
 	ps "github.com/shirou/gopsutil/v3/process"
 )
 
 var Wg sync.WaitGroup
 
-func ProcessPids(pids []int, pid2Name map[int]string, hd bool, tags string, timestamps []string) (rUrls []string, err error) {
+// PidResult is the result of the capture of a process.
+type PidResult struct {
+	Pid int
+	// URL is the dashboard report URL, empty if the capture failed or was skipped.
+	URL string
+}
+
+// ProcessPids captures the pids, config.GlobalConfig.PidConcurrency of them at a
+// time, see FullCapture. The host-wide collectors run once and are shared by the
+// captures of the pids. The results are in the order of the deduplicated pids, and
+// hold only the pids started before ctx is done.
+func ProcessPids(ctx context.Context, captureDir string, pids []int, pid2Name map[int]string, hd bool, jfrPids map[int]bool, tags string, timestamps []string) (results []PidResult, err error) {
 	if len(pids) <= 0 {
 		logger.Log("Empty pids, no action needed.")
 		return
@@ -43,7 +54,34 @@ func ProcessPids(pids []int, pid2Name map[int]string, hd bool, tags string, time
 
 	pids = removeDuplicate(pids)
 
+	var session *Session
+	if len(pids) > 1 && len(config.GlobalConfig.CaptureCmd) == 0 {
+		session, err = NewSession(captureDir)
+		if err != nil {
+			return
+		}
+		defer session.Close()
+	}
+	var sem chan struct{}
+	if config.GlobalConfig.PidConcurrency > 0 {
+		sem = make(chan struct{}, config.GlobalConfig.PidConcurrency)
+	}
+	var wg sync.WaitGroup
+	results = make([]PidResult, len(pids))
 	for i, pid := range pids {
+		results[i].Pid = pid
+		if sem != nil {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			logger.Log("capture cancelled, skipping the remaining pids %v", pids[i:])
+			results = results[:i]
+			break
+		}
+
 		name := config.GlobalConfig.AppName
 		if len(pid2Name) > 0 {
 			if n, ok := pid2Name[pid]; ok {
@@ -51,38 +89,62 @@ func ProcessPids(pids []int, pid2Name map[int]string, hd bool, tags string, time
 			}
 		}
 
-		if len(config.GlobalConfig.CaptureCmd) > 0 {
-			_, err := executils.RunCaptureCmd(pid, config.GlobalConfig.CaptureCmd)
-			if err != nil {
-				logger.Log("WARNING: runCaptureCmd failed %s", err)
-				continue
+		// In case pids has more elements than timetamps,
+		// the extra elements will use "" timestamp
+		// which will be defaulted to now in FullCapture().
+		// The captures started together may share a timestamp, the server
+		// tells them apart by their capture ID.
+		var timestamp string
+		if i <= len(timestamps)-1 {
+			timestamp = timestamps[i]
+		}
+
+		wg.Add(1)
+		go func(i, pid int, name, timestamp string) {
+			defer wg.Done()
+			if sem != nil {
+				defer func() { <-sem }()
 			}
-		} else {
-			var timestamp string
-			// In case pids has more elements than timetamps,
-			// the extra elements will use "" timestamp
-			// which will be defaulted to now in fullProcess().
-			if i > len(timestamps)-1 {
-				timestamp = ""
-			} else {
-				timestamp = timestamps[i]
+
+			if len(config.GlobalConfig.CaptureCmd) > 0 {
+				_, err := executils.RunCaptureCmd(pid, config.GlobalConfig.CaptureCmd)
+				if err != nil {
+					logger.Log("WARNING: runCaptureCmd failed %s", err)
+				}
+				return
 			}
 
-			url := FullCapture(pid, name, hd, tags, timestamp)
-			if len(url) > 0 {
-				rUrls = append(rUrls, url)
+			dir := captureDir
+			if session != nil && captureDir != "" {
+				// The captures of the pids share captureDir, each one writes to its own sub dir
+				dir = filepath.Join(captureDir, strconv.Itoa(pid))
+				err := os.MkdirAll(dir, 0777)
+				if err != nil {
+					logger.Log("WARNING: failed to create the capture dir of %d: %s", pid, err)
+					return
+				}
 			}
-		}
+			results[i].URL = fullCapture(ctx, session, dir, pid, name, hd, jfrPids[pid], tags, timestamp)
+		}(i, pid, name, timestamp)
 	}
+	wg.Wait()
 
 	return
 }
 
-func FullCapture(pid int, appName string, hd bool, tags string, tsParam string) (rUrl string) {
+// FullCapture captures and transmits the artifacts of the process pid. They're
+// written to captureDir, a new yc-<timestamp> dir if empty, so that concurrent
+// captures don't share the current dir.
+func FullCapture(ctx context.Context, captureDir string, pid int, appName string, hd bool, jfr bool, tags string, tsParam string) (rUrl string) {
+	return fullCapture(ctx, nil, captureDir, pid, appName, hd, jfr, tags, tsParam)
+}
+
+// fullCapture is FullCapture sharing the host-wide collectors of the session, if not nil.
+func fullCapture(ctx context.Context, session *Session, captureDir string, pid int, appName string, hd bool, jfr bool, tags string, tsParam string) (rUrl string) {
 	var err error
 	defer func() {
 		if err != nil {
-			logger.Error().Err(err).Msg("unexpected error")
+			logger.Ctx(ctx).Error().Err(err).Msg("unexpected error")
 		}
 	}()
 
@@ -91,6 +153,8 @@ func FullCapture(pid int, appName string, hd bool, tags string, tsParam string)
 	// -------------------------------------------------------------------
 	var endpoint string
 	var parameters string
+	captureID := capture.NewCaptureID()
+	manifest := capture.NewManifest(captureID, pid, appName)
 
 	{
 		var timestamp string
@@ -105,114 +169,104 @@ func FullCapture(pid int, appName string, hd bool, tags string, tsParam string)
 			}
 			//parameters = fmt.Sprintf("de=%s&ts=%s", getOutboundIP().String(), tsParam)
 			timezoneBase64 := base64.StdEncoding.EncodeToString([]byte(timezone))
-			parameters = fmt.Sprintf("de=%s&ts=%s&timezoneId=%s", getOutboundIP().String(), tsParam, timezoneBase64)
+			parameters = fmt.Sprintf("de=%s&ts=%s&timezoneId=%s&%s=%s", getOutboundIP().String(), tsParam, timezoneBase64, capture.CaptureIDParam, captureID)
 			endpoint = fmt.Sprintf("%s/ycrash-receiver?%s", config.GlobalConfig.Server, parameters)
 		}
 
 		// A.2 Setup capture directory: yc-$timestamp
 		// TODO: This has a similar functionality with m3. It might be good to extract this to a common reusable function.
-		{
-			captureDir := "yc-" + timestamp
+		if len(captureDir) == 0 {
+			captureDir = "yc-" + timestamp
 			if len(config.GlobalConfig.StoragePath) > 0 {
 				captureDir = filepath.Join(config.GlobalConfig.StoragePath, captureDir)
 			}
 
-			{
-				if !config.GlobalConfig.M3 {
-					err = os.Mkdir(captureDir, 0777)
+			captureDir, err = mkCaptureDir(captureDir)
+			if err != nil {
+				return
+			}
+			// Cleanup capture dir
+			if config.GlobalConfig.DeferDelete {
+				Wg.Add(1)
+				defer func() {
+					defer Wg.Done()
+					err := os.RemoveAll(captureDir)
 					if err != nil {
+						logger.LogCtx(ctx, "WARNING: Can not remove the current directory: %s", err)
 						return
 					}
-				}
-				// Cleanup capture dir
-				if config.GlobalConfig.DeferDelete {
-					Wg.Add(1)
-					defer func() {
-						defer Wg.Done()
-						err := os.RemoveAll(captureDir)
-						if err != nil {
-							logger.Log("WARNING: Can not remove the current directory: %s", err)
-							return
-						}
-					}()
-				}
+				}()
 			}
 
-			{
-				// Store current dir for later
-				dir, err := os.Getwd()
-				if err != nil {
-					return
-				}
-
-				// Chdir to the capture dir (yc-$timestamp)
-				if !config.GlobalConfig.M3 {
-					err = os.Chdir(captureDir)
+			defer func() {
+				if config.GlobalConfig.OnlyCapture {
+					name, err := ZipFolder(captureDir)
 					if err != nil {
+						logger.LogCtx(ctx, "WARNING: Can not zip folder: %s", err)
 						return
 					}
+					logger.StdLog("All dumps can be found in %s", name)
+					if logger.Log2File {
+						logger.LogCtx(ctx, "All dumps can be found in %s", name)
+					}
 				}
+			}()
+		}
 
-				defer func() {
-					// Chdir to the original dir
-					err := os.Chdir(dir)
-					if err != nil {
-						logger.Log("WARNING: Can not chdir: %s", err)
-						return
-					}
-					if config.GlobalConfig.OnlyCapture {
-						name, err := ZipFolder(captureDir)
-						if err != nil {
-							logger.Log("WARNING: Can not zip folder: %s", err)
-							return
-						}
-						logger.StdLog("All dumps can be found in %s", name)
-						if logger.Log2File {
-							logger.Log("All dumps can be found in %s", name)
-						}
-					}
-				}()
-			}
+		// A.3 Manifest of the artifacts, written once they are all transmitted
+		if len(captureDir) > 0 {
+			defer func() {
+				err := manifest.Write(captureDir)
+				if err != nil {
+					logger.LogCtx(ctx, "WARNING: Can not write the manifest: %s", err)
+				}
+			}()
 		}
 	}
 
-	// A.3 Agent log file
+	// A.4 Agent log file
+	// The log of the capture is carried in ctx, so that concurrent captures don't mix their logs
 	var agentLogFile *os.File
 	if !config.GlobalConfig.M3 {
 		// As per Mahesh suggestion, the name of agentlog changed to yc360Logs
-		agentLogFile, err = logger.StartWritingToFile("yc360Logs.out")
+		var captureLog *zerolog.Logger
+		captureLog, agentLogFile, err = logger.StartWritingToFile(filepath.Join(captureDir, "yc360Logs.out"), captureID)
 		if err != nil {
-			logger.Info().Err(err).Msg("Failed to start writing to file")
+			logger.Ctx(ctx).Info().Err(err).Msg("Failed to start writing to file")
+		} else {
+			ctx = logger.WithContext(ctx, captureLog)
 		}
 
 		defer func() {
 			if agentLogFile == nil {
 				return
 			}
-			err := logger.StopWritingToFile()
+			err := logger.StopWritingToFile(agentLogFile)
 			if err != nil {
-				logger.Info().Err(err).Msg("Failed to stop writing to file")
+				logger.Ctx(ctx).Info().Err(err).Msg("Failed to stop writing to file")
 			}
 		}()
 	}
 
-	// A.4 MetaInfo
+	// A.5 MetaInfo
 	{
-		msg, ok, err := writeMetaInfo(pid, appName, endpoint, tags)
-		logger.Log(
+		metaStart := time.Now()
+		msg, ok, err := writeMetaInfo(captureDir, pid, appName, endpoint, tags)
+		manifest.Record("meta", "meta", "", metaStart, filepath.Join(captureDir, "meta-info.txt"), msg, ok)
+		logger.LogCtx(ctx,
 			`META INFO DATA
-Is transmission completed: %t
+Is transmission completed: %s
 Resp: %s
 Ignored errors: %v
 
 --------------------------------
-`, ok, msg, err)
+`, capture.TransmissionStatus(ok, msg), msg, err)
 	}
 
 	if pid > 0 && !capture.IsProcessExists(pid) {
 		defer func() {
-			logger.Log("WARNING: Process %d doesn't exist.", pid)
-			logger.Log("WARNING: You have entered non-existent processId. Please enter valid process id")
+			logger.LogCtx(ctx, "WARNING: Process %d doesn't exist.", pid)
+			logger.LogCtx(ctx, "WARNING: You have entered non-existent processId. Please enter valid process id")
 		}()
 	}
 
@@ -231,7 +285,7 @@ Ignored errors: %v
 	if pidPassed {
 		// find gc log path in from command line arguments of ps result
 		if len(gcPath) == 0 {
-			output, err := GetGCLogFile(pid)
+			output, err := GetGCLogFile(ctx, pid)
 			if err == nil && len(output) > 0 {
 				gcPath = output
 			}
@@ -242,25 +296,25 @@ Ignored errors: %v
 
 	// B.1 Log capture configs
 	{
-		logger.Log("PID is %d", pid)
-		logger.Log("YC_SERVER is %s", config.GlobalConfig.Server)
-		logger.Log("API_KEY is %s", config.GlobalConfig.ApiKey)
-		logger.Log("APP_NAME is %s", appName)
-		logger.Log("JAVA_HOME is %s", config.GlobalConfig.JavaHomePath)
-		logger.Log("GC_LOG is %s", gcPath)
+		logger.LogCtx(ctx, "PID is %d", pid)
+		logger.LogCtx(ctx, "YC_SERVER is %s", config.GlobalConfig.Server)
+		logger.LogCtx(ctx, "API_KEY is %s", config.GlobalConfig.ApiKey)
+		logger.LogCtx(ctx, "APP_NAME is %s", appName)
+		logger.LogCtx(ctx, "JAVA_HOME is %s", config.GlobalConfig.JavaHomePath)
+		logger.LogCtx(ctx, "GC_LOG is %s", gcPath)
 		if len(dockerID) > 0 {
-			logger.Log("DOCKER_ID is %s", dockerID)
+			logger.LogCtx(ctx, "DOCKER_ID is %s", dockerID)
 		}
 
 		// Display the PIDs which have been input to the script
-		logger.Log("PROBLEMATIC_PID is: %d", pid)
+		logger.LogCtx(ctx, "PROBLEMATIC_PID is: %d", pid)
 
 		// Display the being used in this script
-		logger.Log("SCRIPT_SPAN = %d", executils.SCRIPT_SPAN)
-		logger.Log("JAVACORE_INTERVAL = %d", executils.JAVACORE_INTERVAL)
-		logger.Log("TOP_INTERVAL = %d", executils.TOP_INTERVAL)
-		logger.Log("TOP_DASH_H_INTERVAL = %d", executils.TOP_DASH_H_INTERVAL)
-		logger.Log("VMSTAT_INTERVAL = %d", executils.VMSTAT_INTERVAL)
+		logger.LogCtx(ctx, "SCRIPT_SPAN = %d", executils.SCRIPT_SPAN)
+		logger.LogCtx(ctx, "JAVACORE_INTERVAL = %d", executils.JAVACORE_INTERVAL)
+		logger.LogCtx(ctx, "TOP_INTERVAL = %d", executils.TOP_INTERVAL)
+		logger.LogCtx(ctx, "TOP_DASH_H_INTERVAL = %d", executils.TOP_DASH_H_INTERVAL)
+		logger.LogCtx(ctx, "VMSTAT_INTERVAL = %d", executils.VMSTAT_INTERVAL)
 	}
 
 	{
@@ -269,475 +323,31 @@ Ignored errors: %v
 		if boomi {
 			now, _ := common.GetAgentCurrentTime()
 			timestamp := now.Format("2006-01-02T15-04-05")
-			logger.Log("CAPTURING BOOMI DETAILS..%s->", config.GlobalConfig.BoomiUrl)
-			capture.CaptureBoomiDetails(endpoint, timestamp, pid)
-		}
-	}
-
-	// ------------------------------------------------------------------------------
-	//   				Capture gc
-	// ------------------------------------------------------------------------------
-	gc := goCapture(endpoint, capture.WrapRun(&capture.GC{
-		Pid:      pid,
-		JavaHome: config.GlobalConfig.JavaHomePath,
-		DockerID: dockerID,
-		GCPath:   gcPath,
-	}))
-	var capNetStat *capture.NetStat
-	var netStat chan capture.Result
-	var capTop *capture.Top
-	var top chan capture.Result
-	var capVMStat *capture.VMStat
-	var vmstat chan capture.Result
-	var dmesg chan capture.Result
-	var threadDump chan capture.Result
-	var capPS *capture.PS
-	var ps chan capture.Result
-	var disk chan capture.Result
-	if pidPassed {
-		// ------------------------------------------------------------------------------
-		//                   Capture netstat x2
-		// ------------------------------------------------------------------------------
-		//  Collect the first netstat: date at the top, data, and then a blank line
-		capNetStat = &capture.NetStat{}
-		netStat = goCapture(endpoint, capture.WrapRun(capNetStat))
-
-		// ------------------------------------------------------------------------------
-		//                   Capture top
-		// ------------------------------------------------------------------------------
-		//  It runs in the background so that other tasks can be completed while this runs.
-		logger.Log("Starting collection of top data...")
-		capTop = &capture.Top{}
-		top = goCapture(endpoint, capture.WrapRun(capTop))
-		logger.Log("Collection of top data started.")
-
-		// ------------------------------------------------------------------------------
-		//                   Capture vmstat
-		// ------------------------------------------------------------------------------
-		//  It runs in the background so that other tasks can be completed while this runs.
-		logger.Log("Starting collection of vmstat data...")
-		capVMStat = &capture.VMStat{}
-		vmstat = goCapture(endpoint, capture.WrapRun(capVMStat))
-		logger.Log("Collection of vmstat data started.")
-
-		logger.Log("Collecting ps snapshot...")
-		capPS = capture.NewPS()
-		ps = goCapture(endpoint, capture.WrapRun(capPS))
-		logger.Log("Collected ps snapshot.")
-
-		// ------------------------------------------------------------------------------
-		//  				Capture dmesg
-		// ------------------------------------------------------------------------------
-		logger.Log("Collecting other data.  This may take a few moments...")
-		dmesg = goCapture(endpoint, capture.WrapRun(&capture.DMesg{}), capVMStat)
-		// ------------------------------------------------------------------------------
-		//  				Capture Disk Usage
-		// ------------------------------------------------------------------------------
-		disk = goCapture(endpoint, capture.WrapRun(&capture.Disk{}))
-
-		logger.Log("Collected other data.")
-	}
-
-	// ------------------------------------------------------------------------------
-	//   				Capture ping
-	// ------------------------------------------------------------------------------
-	ping := goCapture(endpoint, capture.WrapRun(&capture.Ping{Host: config.GlobalConfig.PingHost}))
-
-	// ------------------------------------------------------------------------------
-	//   				Capture kernel params
-	// ------------------------------------------------------------------------------
-	kernel := goCapture(endpoint, capture.WrapRun(&capture.Kernel{}))
-
-	// ------------------------------------------------------------------------------
-	//   				Capture thread dumps
-	// ------------------------------------------------------------------------------
-	capThreadDump := &capture.ThreadDump{
-		Pid:               pid,
-		TdPath:            tdPath,
-		JavaHome:          config.GlobalConfig.JavaHomePath,
-		TdCaptureDuration: config.GlobalConfig.TDCaptureDuration,
-	}
-	threadDump = goCapture(endpoint, capture.WrapRun(capThreadDump))
-
-	// ------------------------------------------------------------------------------
-	//   				Capture legacy app log
-	// ------------------------------------------------------------------------------
-	var appLog chan capture.Result
-	if len(config.GlobalConfig.AppLog) > 0 && config.GlobalConfig.AppLogLineCount != 0 {
-		configAppLogs := config.AppLogs{config.AppLog(config.GlobalConfig.AppLog)}
-		appLog = goCapture(endpoint, capture.WrapRun(&capture.AppLog{Paths: configAppLogs, LineLimit: config.GlobalConfig.AppLogLineCount}))
-	}
-
-	// ------------------------------------------------------------------------------
-	//   				Capture app logs
-	// ------------------------------------------------------------------------------
-	var appLogs chan capture.Result
-	useGlobalConfigAppLogs := false
-	if len(config.GlobalConfig.AppLogs) > 0 && config.GlobalConfig.AppLogLineCount != 0 {
-
-		appLogsContainDollarSign := false
-		for _, configAppLog := range config.GlobalConfig.AppLogs {
-			if strings.Contains(string(configAppLog), "$") {
-				appLogsContainDollarSign = true
-				break
-			}
-		}
-
-		if appLogsContainDollarSign {
-			// If any of the appLogs contain '$', choose only the matched appName
-			appLogsMatchingAppName := config.AppLogs{}
-
-			for _, configAppLog := range config.GlobalConfig.AppLogs {
-				searchToken := "$" + appName
-
-				beforeSearchToken, found := strings.CutSuffix(string(configAppLog), searchToken)
-				if found {
-					appLogsMatchingAppName = append(appLogsMatchingAppName, config.AppLog(beforeSearchToken))
-				}
-
-			}
-
-			if len(appLogsMatchingAppName) > 0 {
-				appLogs = goCapture(endpoint, capture.WrapRun(&capture.AppLog{Paths: appLogsMatchingAppName, LineLimit: config.GlobalConfig.AppLogLineCount}))
-				useGlobalConfigAppLogs = true
-			}
-		} else {
-			appLogs = goCapture(endpoint, capture.WrapRun(&capture.AppLog{Paths: config.GlobalConfig.AppLogs, LineLimit: config.GlobalConfig.AppLogLineCount}))
-			useGlobalConfigAppLogs = true
-		}
-	}
-
-	if !useGlobalConfigAppLogs {
-		// Auto discover app logs
-		discoveredLogFiles, err := capture.DiscoverOpenedLogFilesByProcess(pid)
-		if err != nil {
-			logger.Log("Error on auto discovering app logs: %s", err.Error())
-		}
-
-		// To exclude GC log files from app logs discovery
-		globFiles := []string{}
-
-		// Need to check gcPath not empty. Otherwise, empty pattern will return an unexpected result: [".", "."]
-		if gcPath != "" {
-			pattern := capture.GetGlobPatternFromGCPath(gcPath, pid)
-
-			var globErr error
-			globFiles, globErr = doublestar.FilepathGlob(pattern, doublestar.WithFilesOnly(), doublestar.WithNoFollow())
-			if globErr != nil {
-				logger.Log("App logs Auto discovery: Error on creating Glob pattern %s", pattern)
-			}
-		}
-
-		paths := config.AppLogs{}
-		for _, f := range discoveredLogFiles {
-			isGCLog := false
-			for _, fileName := range globFiles {
-				// To exclude discovered gc log such f as /tmp/buggyapp-%p-%t.log
-				// also exclude discovered gc log with rotation where such f as /tmp/buggyapp-%p-%t.log.0
-				// Where the `pattern` = /tmp/buggyapp-*-*.log
-				if strings.Contains(f, filepath.FromSlash(fileName)) {
-					isGCLog = true
-					logger.Log("App logs Auto discovery: Ignored %s because it is detected as a GC log", f)
-					break
-				}
-			}
-
-			if !isGCLog {
-				paths = append(paths, config.AppLog(f))
-			}
-		}
-
-		appLogs = goCapture(endpoint, capture.WrapRun(&capture.AppLog{Paths: paths, LineLimit: config.GlobalConfig.AppLogLineCount}))
-	}
-
-	// ------------------------------------------------------------------------------
-	//   				Capture hdsub log
-	// ------------------------------------------------------------------------------
-	hdsubLog := goCapture(endpoint, capture.WrapRun(&capture.HDSub{
-		Pid:      pid,
-		JavaHome: config.GlobalConfig.JavaHomePath,
-	}))
-
-	// ------------------------------------------------------------------------------
-	//   				Capture Extended Data
-	// ------------------------------------------------------------------------------
-	var extendedData chan capture.Result
-	if config.GlobalConfig.EdScript != "" && config.GlobalConfig.EdDataFolder != "" {
-		extendedData = goCapture(endpoint, capture.WrapRun(&capture.ExtendedData{Script: config.GlobalConfig.EdScript, DataFolder: config.GlobalConfig.EdDataFolder}))
-	}
-
-	// stop started tasks
-	if capTop != nil {
-		capTop.Kill()
-	}
-	if capVMStat != nil {
-		capVMStat.Kill()
-	}
-
-	// -------------------------------
-	//     Transmit Top data
-	// -------------------------------
-	if top != nil {
-		logger.Log("Reading result from top channel")
-		result := <-top
-		logger.Log(
-			`TOP DATA
-Is transmission completed: %t
-Resp: %s
-
---------------------------------
-`, result.Ok, result.Msg)
-	}
-
-	// -------------------------------
-	//     Transmit DF data
-	// -------------------------------
-	if disk != nil {
-		logger.Log("Reading result from disk channel")
-		result := <-disk
-		logger.Log(
-			`DISK USAGE DATA
-Is transmission completed: %t
-Resp: %s
-
---------------------------------
-`, result.Ok, result.Msg)
-	}
-
-	// -------------------------------
-	//     Transmit netstat data
-	// -------------------------------
-	if netStat != nil {
-		logger.Log("Reading result from netStat channel")
-		result := <-netStat
-		logger.Log(
-			`NETSTAT DATA
-Is transmission completed: %t
-Resp: %s
-
---------------------------------
-`, result.Ok, result.Msg)
-	}
-
-	// -------------------------------
-	//     Transmit ps data
-	// -------------------------------
-	if ps != nil {
-		logger.Log("Reading result from ps channel")
-		result := <-ps
-		logger.Log(
-			`PROCESS STATUS DATA
-Is transmission completed: %t
-Resp: %s
-
---------------------------------
-`, result.Ok, result.Msg)
-	}
-
-	// -------------------------------
-	//     Transmit VMstat data
-	// -------------------------------
-	if vmstat != nil {
-		logger.Log("Reading result from vmstat channel")
-		result := <-vmstat
-		logger.Log(
-			`VMstat DATA
-Is transmission completed: %t
-Resp: %s
-
---------------------------------
-`, result.Ok, result.Msg)
-	}
-
-	// -------------------------------
-	//     Transmit DMesg data
-	// -------------------------------
-	if dmesg != nil {
-		logger.Log("Reading result from dmesg channel")
-		result := <-dmesg
-		logger.Log(
-			`DMesg DATA
-Is transmission completed: %t
-Resp: %s
-
---------------------------------
-`, result.Ok, result.Msg)
-	}
-
-	// -------------------------------
-	//     Transmit GC Log
-	// -------------------------------
-	if gc != nil {
-		logger.Log("Reading result from gc channel")
-		result := <-gc
-		logger.Log(
-			`GC LOG DATA
-Is transmission completed: %t
-Resp: %s
-
---------------------------------
-`, result.Ok, result.Msg)
-		if !result.Ok {
-			defer logger.Log("WARNING: no -gcPath is passed and failed to capture gc log")
+			logger.LogCtx(ctx, "CAPTURING BOOMI DETAILS..%s->", config.GlobalConfig.BoomiUrl)
+			capture.CaptureBoomiDetails(ctx, endpoint, captureDir, timestamp, pid)
 		}
 	}
 
-	// -------------------------------
-	//     Transmit ping dump
-	// -------------------------------
-	if ping != nil {
-		logger.Log("Reading result from ping channel")
-		result := <-ping
-		logger.Log(
-			`PING DATA
-Is transmission completed: %t
-Resp: %s
-
---------------------------------
-`, result.Ok, result.Msg)
-	}
-
-	// -------------------------------
-	//     Transmit app log
-	// -------------------------------
-	if appLog != nil {
-		logger.Log("Reading result from appLog channel")
-		result := <-appLog
-		logger.Log(
-			`APPLOG DATA
-Is transmission completed: %t
-Resp:
-%s
-
---------------------------------
-`, result.Ok, result.Msg)
-	}
-
-	// -------------------------------
-	//     Transmit app logs
-	// -------------------------------
-	if appLogs != nil {
-		logger.Log("Reading result from appLogs channel")
-		result := <-appLogs
-		logger.Log(
-			`APPLOGS DATA
-Ok (at least one transmitted): %t
-Resps:
-%s
-
---------------------------------
-`, result.Ok, result.Msg)
-	}
-
-	// -------------------------------
-	//     Transmit hdsub log
-	// -------------------------------
-	if hdsubLog != nil {
-		logger.Log("Reading result from hdsubLog channel")
-		result := <-hdsubLog
-		logger.Log(
-			`HDSUB DATA
-Is transmission completed: %t
-Resp: %s
-
---------------------------------
-`, result.Ok, result.Msg)
-	}
-
-	// -------------------------------
-	//     Transmit kernel param dump
-	// -------------------------------
-	if kernel != nil {
-		logger.Log("Reading result from kernel channel")
-		result := <-kernel
-		logger.Log(
-			`KERNEL PARAMS DATA
-Is transmission completed: %t
-Resp: %s
-
---------------------------------
-`, result.Ok, result.Msg)
-	}
-
-	// -------------------------------
-	//     Transmit Thread dump
-	// -------------------------------
-	absTDPath, err := filepath.Abs(tdPath)
-	if err != nil {
-		absTDPath = fmt.Sprintf("path %s: %s", tdPath, err.Error())
-	}
-	if threadDump != nil {
-		logger.Log("Reading result from threadDump channel")
-		result := <-threadDump
-		logger.Log(
-			`THREAD DUMP DATA
-%s
-Is transmission completed: %t
-Resp: %s
-
---------------------------------
-`, absTDPath, result.Ok, result.Msg)
-	}
-
-	// -------------------------------
-	//     Transmit Heap dump result
-	// -------------------------------
-	ep := fmt.Sprintf("%s/yc-receiver-heap?%s", config.GlobalConfig.Server, parameters)
-	capHeapDump := capture.NewHeapDump(config.GlobalConfig.JavaHomePath, pid, hdPath, hd)
-	capHeapDump.SetEndpoint(ep)
-	hdResult, err := capHeapDump.Run()
+	plan, err := ResolvePlan(config.GlobalConfig.Pipeline, config.GlobalConfig.SkipCollectors)
 	if err != nil {
-		hdResult.Msg = fmt.Sprintf("capture heap dump failed: %s", err.Error())
-		err = nil
-	}
-	logger.Log(
-		`HEAP DUMP DATA
-Is transmission completed: %t
-Resp: %s
-
---------------------------------
-`, hdResult.Ok, hdResult.Msg)
-
-	// -------------------------------
-	//     Transmit Extended Data
-	// -------------------------------
-	if extendedData != nil {
-		logger.Log("Reading result from extended data channel")
-		result := <-extendedData
-		logger.Log(
-			`EXTENDED DATA
-Is transmission completed: %t
-Resp: %s
-
---------------------------------
-`, result.Ok, result.Msg)
-	}
-
-	// ------------------------------------------------------------------------------
-	//  				Execute custom commands
-	// ------------------------------------------------------------------------------
-	logger.Log("Executing custom commands")
-	for i, command := range config.GlobalConfig.Commands {
-		customCmd := capture.Custom{
-			Index:     i,
-			UrlParams: string(command.UrlParams),
-			Command:   cmdline.Split(string(command.Cmd)),
-		}
-		customCmd.SetEndpoint(endpoint)
-		result, err := customCmd.Run()
-		if err != nil {
-			logger.Log("WARNING: Failed to execute custom command %d:%s, cause: %s", i, command.Cmd, err.Error())
-			continue
-		}
-		logger.Log(
-			`CUSTOM CMD %d: %s
-Is transmission completed: %t
-Resp: %s
-
---------------------------------
-`, i, command.Cmd, result.Ok, result.Msg)
+		return
 	}
-	logger.Log("Executed custom commands")
+	plan.Run(ctx, &CaptureEnv{
+		Pid:        pid,
+		AppName:    appName,
+		HeapDump:   hd,
+		JFR:        jfr,
+		GCPath:     gcPath,
+		TDPath:     tdPath,
+		HDPath:     hdPath,
+		DockerID:   dockerID,
+		Server:     config.GlobalConfig.Server,
+		Parameters: parameters,
+		Dir:        captureDir,
+		Session:    session,
+		Manifest:   manifest,
+		Logger:     logger.Ctx(ctx),
+	})
 
 	if config.GlobalConfig.OnlyCapture {
 		return
@@ -750,9 +360,9 @@ Resp: %s
 	// C.1 /yc-fin
 	{
 		finEp := fmt.Sprintf("%s/yc-fin?%s", config.GlobalConfig.Server, parameters)
-		resp, err := RequestFin(finEp)
+		resp, err := RequestFin(ctx, finEp)
 		if err != nil {
-			logger.Log("post yc-fin err %s", err.Error())
+			logger.LogCtx(ctx, "post yc-fin err %s", err.Error())
 			err = nil
 		}
 
@@ -766,10 +376,10 @@ Resp: %s
 %s
 `, resp)
 
-		logger.Log(`
+		logger.LogCtx(ctx, `
 %s
 `, resp)
-		logger.Log(`
+		logger.LogCtx(ctx, `
 %s
 `, pterm.RemoveColorFromString(result))
 	}
@@ -777,18 +387,19 @@ Resp: %s
 	// C.2 Transmit agentlog
 	if agentLogFile != nil {
 		msg, ok := capture.PostData(endpoint, "agentlog", agentLogFile)
-		err := logger.StopWritingToFile()
+		err := logger.StopWritingToFile(agentLogFile)
 		if err != nil {
-			logger.Info().Err(err).Msg("Failed to stop writing to file")
+			logger.Ctx(ctx).Info().Err(err).Msg("Failed to stop writing to file")
 		}
+		manifest.Record("agentlog", "agentlog", "", startTime, agentLogFile.Name(), msg, ok)
 		agentLogFile = nil
-		logger.Log(
+		logger.LogCtx(ctx,
 			`AGENT LOG DATA
-Is transmission completed: %t
+Is transmission completed: %s
 Resp: %s
 
 --------------------------------
-`, ok, msg)
+`, capture.TransmissionStatus(ok, msg), msg)
 	}
 
 	return
@@ -797,7 +408,7 @@ Resp: %s
 var getOutboundIP = capture.GetOutboundIP
 var goCapture = capture.GoCapture
 
-func GetGCLogFile(pid int) (result string, err error) {
+func GetGCLogFile(ctx context.Context, pid int) (result string, err error) {
 	var cmdLine []byte
 	var command executils.Command
 	dynamicArg := strconv.Itoa(pid)
@@ -809,19 +420,19 @@ func GetGCLogFile(pid int) (result string, err error) {
 	cmdLine, err = executils.CommandCombinedOutput(command)
 
 	if err != nil {
-		logger.Log("GetGCLogFile: err in getting process cmdline: %s, output: %s", err.Error(), string(cmdLine))
-		logger.Log("GetGCLogFile: falling back to gopsutil")
+		logger.LogCtx(ctx, "GetGCLogFile: err in getting process cmdline: %s, output: %s", err.Error(), string(cmdLine))
+		logger.LogCtx(ctx, "GetGCLogFile: falling back to gopsutil")
 
 		// Try fallback with gopsutil library
 		p, errFallback := ps.NewProcess(int32(pid))
 		if errFallback != nil {
-			logger.Log("GetGCLogFile: fallback gopsutil err in getting process: %s", errFallback.Error())
+			logger.LogCtx(ctx, "GetGCLogFile: fallback gopsutil err in getting process: %s", errFallback.Error())
 			return
 		}
 
 		cmdLineStr, errFallbackCmdline := p.Cmdline()
 		if errFallbackCmdline != nil {
-			logger.Log("GetGCLogFile: fallback gopsutil err in getting process cmdline: %s", errFallbackCmdline.Error())
+			logger.LogCtx(ctx, "GetGCLogFile: fallback gopsutil err in getting process cmdline: %s", errFallbackCmdline.Error())
 			return
 		}
 
@@ -845,7 +456,7 @@ func GetGCLogFile(pid int) (result string, err error) {
 				}
 			}
 		} else {
-			logger.Warn().Str("gcpath", result).Msg("Please use absolute file path for '-Xloggc' and '-Xlog:gc'")
+			logger.Ctx(ctx).Warn().Str("gcpath", result).Msg("Please use absolute file path for '-Xloggc' and '-Xlog:gc'")
 		}
 	}
 
@@ -864,8 +475,21 @@ javaVersion=%s
 osVersion=%s
 tags=%s`
 
-func writeMetaInfo(processId int, appName, endpoint, tags string) (msg string, ok bool, err error) {
-	file, err := os.Create("meta-info.txt")
+// mkCaptureDir creates the capture dir name, suffixed with -1, -2... if it
+// exists since captures started in the same second run concurrently.
+func mkCaptureDir(name string) (dir string, err error) {
+	dir = name
+	for i := 1; ; i++ {
+		err = os.Mkdir(dir, 0777)
+		if !errors.Is(err, fs.ErrExist) {
+			return
+		}
+		dir = fmt.Sprintf("%s-%d", name, i)
+	}
+}
+
+func writeMetaInfo(dir string, processId int, appName, endpoint, tags string) (msg string, ok bool, err error) {
+	file, err := os.Create(filepath.Join(dir, "meta-info.txt"))
 	if err != nil {
 		return
 	}
@@ -973,28 +597,18 @@ func UpdatePaths(pid int, gcPath, tdPath, hdPath *string) {
 	}
 }
 
-func RequestFin(endpoint string) (resp []byte, err error) {
+func RequestFin(ctx context.Context, endpoint string) (resp []byte, err error) {
 	if config.GlobalConfig.OnlyCapture {
 		err = errors.New("in only capture mode")
 		return
 	}
-	transport := http.DefaultTransport.(*http.Transport)
-	transport.TLSClientConfig = &tls.Config{
-		InsecureSkipVerify: !config.GlobalConfig.VerifySSL,
-	}
-	path := config.GlobalConfig.CACertPath
-	if len(path) > 0 {
-		pool := x509.NewCertPool()
-		var ca []byte
-		ca, err = ioutil.ReadFile(path)
-		if err != nil {
-			return
-		}
-		pool.AppendCertsFromPEM(ca)
-		transport.TLSClientConfig.RootCAs = pool
+	if !capture.UploadsToServer() {
+		err = fmt.Errorf("not supported by the %s uploader", config.GlobalConfig.Uploader)
+		return
 	}
-	httpClient := &http.Client{
-		Transport: transport,
+	httpClient, err := httpclient.Shared()
+	if err != nil {
+		return
 	}
 	req, err := http.NewRequest("POST", endpoint, nil)
 	if err != nil {
@@ -1007,7 +621,7 @@ func RequestFin(endpoint string) (resp []byte, err error) {
 		defer post.Body.Close()
 		resp, err = ioutil.ReadAll(post.Body)
 		if err == nil {
-			logger.Log(
+			logger.LogCtx(ctx,
 				`yc-fin endpoint: %s
 Resp: %s
 
@@ -1,14 +1,18 @@
 package ondemand
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 	"yc-agent/internal/capture"
 	"yc-agent/internal/capture/executils"
+	"yc-agent/internal/config"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const (
@@ -48,7 +52,7 @@ func TestFindGCLog(t *testing.T) {
 	}
 	defer xloggc.KillAndWait()
 
-	f, err := GetGCLogFile(noGC.GetPid())
+	f, err := GetGCLogFile(context.Background(), noGC.GetPid())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -57,7 +61,7 @@ func TestFindGCLog(t *testing.T) {
 		t.Fatal("gc log file should be empty")
 	}
 
-	f, err = GetGCLogFile(xlog.GetPid())
+	f, err = GetGCLogFile(context.Background(), xlog.GetPid())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -66,7 +70,7 @@ func TestFindGCLog(t *testing.T) {
 		t.Fatal("gc log file should be gctrace.txt")
 	}
 
-	f, err = GetGCLogFile(xlog2.GetPid())
+	f, err = GetGCLogFile(context.Background(), xlog2.GetPid())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -75,7 +79,7 @@ func TestFindGCLog(t *testing.T) {
 		t.Fatal("gc log file should be gctrace.log")
 	}
 
-	f, err = GetGCLogFile(xloggc.GetPid())
+	f, err = GetGCLogFile(context.Background(), xloggc.GetPid())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -93,7 +97,7 @@ func TestPostData(t *testing.T) {
 
 	t.Run("requestFin", func(t *testing.T) {
 		finEp := fmt.Sprintf("%s/yc-fin?apiKey=%s&%s", host, api, parameters)
-		RequestFin(finEp)
+		RequestFin(context.Background(), finEp)
 	})
 
 	vmstat, err := os.Open("testdata/vmstat.out")
@@ -166,7 +170,7 @@ func TestWriteMetaInfo(t *testing.T) {
 	timestamp := time.Now().Format("2006-01-02T15-04-05")
 	parameters := fmt.Sprintf("de=%s&ts=%s", capture.GetOutboundIP().String(), timestamp)
 	endpoint := fmt.Sprintf("%s/ycrash-receiver?apiKey=%s&%s", host, api, parameters)
-	msg, ok, err := writeMetaInfo(11111, "test", endpoint, "tag1")
+	msg, ok, err := writeMetaInfo(t.TempDir(), 11111, "test", endpoint, "tag1")
 	if err != nil || !ok {
 		t.Fatal(err, msg)
 	}
@@ -279,3 +283,32 @@ func TestAllPossibleGCPath(t *testing.T) {
 	logFile = ExtractGCLogPathFromCmdline(cmdLine)
 	assert.Equal(t, expected, logFile)
 }
+
+func TestMkCaptureDir(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "yc-2024-01-02T03-04-05")
+
+	dir, err := mkCaptureDir(name)
+	require.NoError(t, err)
+	assert.Equal(t, name, dir)
+
+	dir, err = mkCaptureDir(name)
+	require.NoError(t, err)
+	assert.Equal(t, name+"-1", dir, "a concurrent capture gets its own dir")
+	assert.DirExists(t, dir)
+}
+
+func TestProcessPids_Cancelled(t *testing.T) {
+	old := config.GlobalConfig
+	t.Cleanup(func() { config.GlobalConfig = old })
+	config.GlobalConfig.PidConcurrency = 1
+	config.GlobalConfig.CaptureCmd = "sleep 1"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The job is cancelled while the first pid is captured
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	results, err := ProcessPids(ctx, t.TempDir(), []int{101, 102, 103}, nil, false, nil, "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []PidResult{{Pid: 101}}, results, "the skipped pids have no result")
+}
@@ -0,0 +1,453 @@
+package ondemand
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"yc-agent/internal/capture"
+	"yc-agent/internal/config"
+	"yc-agent/internal/logger"
+	"yc-agent/internal/metrics"
+
+	"github.com/rs/zerolog"
+)
+
+// CaptureEnv is what the collectors of a capture know about the target.
+type CaptureEnv struct {
+	Pid      int
+	AppName  string
+	HeapDump bool
+	// JFR requests a Java Flight Recording of the process.
+	JFR      bool
+	GCPath   string
+	TDPath   string
+	HDPath   string
+	DockerID string
+	Server   string
+	// Parameters are the url params of the capture, such as de and ts.
+	Parameters string
+	// Dir is the directory the tasks write their output files to.
+	Dir string
+	// Session shares the host-wide collectors with the captures of other processes, if not nil.
+	Session *Session
+	// Manifest records the artifacts uploaded by the collectors, if not nil.
+	Manifest *capture.Manifest
+	// Logger is the log of the capture, the agent log if nil.
+	Logger *zerolog.Logger
+}
+
+// PidPassed reports whether the capture targets a process.
+func (env *CaptureEnv) PidPassed() bool {
+	return env.Pid > 0
+}
+
+// Log logs to the log of the capture.
+func (env *CaptureEnv) Log(format string, values ...interface{}) {
+	if env.Logger == nil {
+		logger.Log(format, values...)
+		return
+	}
+	env.Logger.Info().Msgf(format, values...)
+}
+
+// Endpoint returns the endpoint of the receiver on the server.
+func (env *CaptureEnv) Endpoint(receiver string) string {
+	return fmt.Sprintf("%s/%s?%s", env.Server, receiver, env.Parameters)
+}
+
+// Collector is a named step of the capture pipeline.
+type Collector struct {
+	Name string
+	// Title heads the log of the result.
+	Title string
+	// Receiver is the receiver of the result on the server, ycrash-receiver if empty.
+	Receiver string
+	// DependsOn names the collectors completed before this one starts.
+	DependsOn []string
+	// Background collectors keep capturing while the others run. They are stopped once
+	// all the collectors have been scheduled, and don't count toward the concurrency limit.
+	Background bool
+	// Disabled collectors run only if enabled by the pipeline config.
+	Disabled bool
+	// HostWide collectors capture the host rather than the process. In a Session
+	// they run once, and their Output file is uploaded as DataType by the others.
+	HostWide bool
+	Output   string
+	DataType string
+	// New creates the task of the capture, nil skips the collector for it.
+	New func(env *CaptureEnv, opts CollectorOptions) capture.Task
+	// Log logs the result, logResult with the Title if nil.
+	Log func(env *CaptureEnv, result capture.Result)
+}
+
+// CollectorOptions are the options of a collector from the pipeline config.
+type CollectorOptions map[string]string
+
+func (o CollectorOptions) String(name, def string) string {
+	if v, ok := o[name]; ok {
+		return v
+	}
+	return def
+}
+
+func (o CollectorOptions) Int(name string, def int) int {
+	v, ok := o[name]
+	if !ok {
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		logger.Log("WARNING: invalid option %s=%s, using %d: %s", name, v, def, err)
+		return def
+	}
+	return i
+}
+
+func (o CollectorOptions) Duration(name string, def time.Duration) time.Duration {
+	v, ok := o[name]
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		logger.Log("WARNING: invalid option %s=%s, using %s: %s", name, v, def, err)
+		return def
+	}
+	return d
+}
+
+var (
+	collectorsMtx sync.Mutex
+	collectors    []*Collector
+)
+
+// RegisterCollector adds the collector to the pipeline, after the registered ones.
+// A collector of the same name is replaced.
+func RegisterCollector(c *Collector) {
+	collectorsMtx.Lock()
+	defer collectorsMtx.Unlock()
+
+	for i, registered := range collectors {
+		if registered.Name == c.Name {
+			collectors[i] = c
+			return
+		}
+	}
+	collectors = append(collectors, c)
+}
+
+func registeredCollectors() []*Collector {
+	collectorsMtx.Lock()
+	defer collectorsMtx.Unlock()
+
+	return append([]*Collector(nil), collectors...)
+}
+
+// PlanStep is a collector resolved with the pipeline config.
+type PlanStep struct {
+	*Collector
+	DependsOn []string
+	Options   CollectorOptions
+}
+
+// Plan is the resolved pipeline: the enabled collectors ordered so that every
+// collector comes after the ones it depends on.
+type Plan struct {
+	Concurrency int
+	Steps       []*PlanStep
+	Disabled    []string
+}
+
+// ResolvePlan resolves the registered collectors with the pipeline config and the
+// comma delimited collectors to skip. Dependencies on disabled collectors are dropped.
+func ResolvePlan(pipeline config.Pipeline, skip string) (*Plan, error) {
+	all := registeredCollectors()
+	byName := make(map[string]*Collector, len(all))
+	for _, c := range all {
+		byName[c.Name] = c
+	}
+
+	// Collectors of custom commands from the config, in the order of their names
+	var customNames []string
+	for name, cc := range pipeline.Collectors {
+		if _, ok := byName[name]; ok {
+			continue
+		}
+		if cc.Cmd == "" {
+			return nil, fmt.Errorf("unknown collector %s in the pipeline, a new collector requires a cmd", name)
+		}
+		customNames = append(customNames, name)
+	}
+	sort.Strings(customNames)
+	for i, name := range customNames {
+		c := newCommandCollector(name, len(config.GlobalConfig.Commands)+i, pipeline.Collectors[name])
+		all = append(all, c)
+		byName[name] = c
+	}
+
+	skipped := map[string]bool{}
+	for _, name := range strings.Split(skip, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := byName[name]; !ok {
+			return nil, fmt.Errorf("unknown collector %s to skip", name)
+		}
+		skipped[name] = true
+	}
+
+	plan := &Plan{Concurrency: pipeline.Concurrency}
+	enabled := map[string]*PlanStep{}
+	for _, c := range all {
+		cc := pipeline.Collectors[c.Name]
+		on := !c.Disabled
+		if cc.Enabled != nil {
+			on = *cc.Enabled
+		}
+		if !on || skipped[c.Name] {
+			plan.Disabled = append(plan.Disabled, c.Name)
+			continue
+		}
+
+		deps := c.DependsOn
+		if cc.DependsOn != nil {
+			deps = cc.DependsOn
+		}
+		for _, dep := range deps {
+			if _, ok := byName[dep]; !ok {
+				return nil, fmt.Errorf("collector %s depends on unknown collector %s", c.Name, dep)
+			}
+		}
+		enabled[c.Name] = &PlanStep{Collector: c, DependsOn: deps, Options: CollectorOptions(cc.Options)}
+	}
+	for _, step := range enabled {
+		var deps []string
+		for _, dep := range step.DependsOn {
+			if _, ok := enabled[dep]; ok {
+				deps = append(deps, dep)
+			}
+		}
+		step.DependsOn = deps
+	}
+
+	// Order the steps topologically, keeping the registration order otherwise
+	done := map[string]bool{}
+	for len(plan.Steps) < len(enabled) {
+		progressed := false
+		for _, c := range all {
+			step, ok := enabled[c.Name]
+			if !ok || done[c.Name] {
+				continue
+			}
+			ready := true
+			for _, dep := range step.DependsOn {
+				if !done[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				plan.Steps = append(plan.Steps, step)
+				done[c.Name] = true
+				progressed = true
+			}
+		}
+		if !progressed {
+			var cycle []string
+			for _, c := range all {
+				if _, ok := enabled[c.Name]; ok && !done[c.Name] {
+					cycle = append(cycle, c.Name)
+				}
+			}
+			return nil, fmt.Errorf("collectors %s can't be ordered, their dependencies make a cycle", strings.Join(cycle, ", "))
+		}
+	}
+
+	return plan, nil
+}
+
+// Print writes the plan for -dryRun.
+func (p *Plan) Print(w io.Writer) {
+	concurrency := "unlimited"
+	if p.Concurrency > 0 {
+		concurrency = strconv.Itoa(p.Concurrency)
+	}
+	fmt.Fprintf(w, "Capture pipeline (concurrency: %s):\n", concurrency)
+	for i, step := range p.Steps {
+		fmt.Fprintf(w, "%3d. %s", i+1, step.Name)
+		if step.Background {
+			fmt.Fprint(w, " (background)")
+		}
+		if len(step.DependsOn) > 0 {
+			fmt.Fprintf(w, " after %s", strings.Join(step.DependsOn, ", "))
+		}
+		if len(step.Options) > 0 {
+			keys := make([]string, 0, len(step.Options))
+			for k := range step.Options {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			pairs := make([]string, len(keys))
+			for j, k := range keys {
+				pairs[j] = k + "=" + step.Options[k]
+			}
+			fmt.Fprintf(w, " [%s]", strings.Join(pairs, " "))
+		}
+		fmt.Fprintln(w)
+	}
+	if len(p.Disabled) > 0 {
+		fmt.Fprintf(w, "Disabled: %s\n", strings.Join(p.Disabled, ", "))
+	}
+}
+
+// The states of a collector reported to a ProgressFunc.
+const (
+	CollectorRunning   = "running"
+	CollectorSucceeded = "succeeded"
+	CollectorFailed    = "failed"
+	CollectorSkipped   = "skipped"
+)
+
+// ProgressFunc is notified of the progress of the collectors of the capture of pid:
+// running, then succeeded, failed or skipped with the result.
+type ProgressFunc func(pid int, collector string, state string, result capture.Result)
+
+type progressKey struct{}
+
+// WithProgress returns ctx notifying fn of the progress of the captures run with it.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+func reportProgress(ctx context.Context, pid int, collector string, state string, result capture.Result) {
+	if fn, ok := ctx.Value(progressKey{}).(ProgressFunc); ok {
+		fn(pid, collector, state, result)
+	}
+}
+
+// EventFunc is notified of the events of the collectors of the capture of pid
+// while they run, such as their fallbacks and the progress of their uploads.
+type EventFunc func(pid int, collector string, event capture.Event)
+
+type eventsKey struct{}
+
+// WithEvents returns ctx notifying fn of the events of the collectors of the captures run with it.
+func WithEvents(ctx context.Context, fn EventFunc) context.Context {
+	return context.WithValue(ctx, eventsKey{}, fn)
+}
+
+// Run runs the collectors of the plan for env, each one once the collectors it
+// depends on have completed, and logs their results in the order of the plan.
+func (p *Plan) Run(ctx context.Context, env *CaptureEnv) {
+	var sem chan struct{}
+	if p.Concurrency > 0 {
+		sem = make(chan struct{}, p.Concurrency)
+	}
+
+	done := make(map[string]chan struct{}, len(p.Steps))
+	for _, step := range p.Steps {
+		done[step.Name] = make(chan struct{})
+	}
+
+	results := make([]chan capture.Result, len(p.Steps))
+	var background []capture.Task
+	for i, step := range p.Steps {
+		task := step.New(env, step.Options)
+		if task == nil {
+			close(done[step.Name])
+			continue
+		}
+		task.SetDir(env.Dir)
+		complete := func() {}
+		if step.HostWide && env.Session != nil {
+			task, complete = env.Session.shareHostWide(step, env, task)
+		}
+		if step.Background {
+			background = append(background, task)
+		}
+
+		receiver := step.Receiver
+		if receiver == "" {
+			receiver = "ycrash-receiver"
+		}
+
+		results[i] = make(chan capture.Result, 1)
+		go func(step *PlanStep, task capture.Task, complete func(), result chan capture.Result) {
+			defer close(done[step.Name])
+			defer complete()
+
+			for _, dep := range step.DependsOn {
+				<-done[dep]
+			}
+			if sem != nil && !step.Background {
+				sem <- struct{}{}
+				defer func() { <-sem }()
+			}
+			if ctx.Err() != nil {
+				r := capture.Result{Msg: fmt.Sprintf("skipped capturing %s: %s", step.Name, ctx.Err())}
+				reportProgress(ctx, env.Pid, step.Name, CollectorSkipped, r)
+				result <- r
+				return
+			}
+			if env.Manifest != nil {
+				task.SetManifest(env.Manifest, step.Name)
+			}
+			if fn, ok := ctx.Value(eventsKey{}).(EventFunc); ok {
+				task.SetEvents(func(e capture.Event) {
+					fn(env.Pid, step.Name, e)
+				})
+			}
+			metrics.CapturesStarted.WithLabelValues(step.Name).Inc()
+			reportProgress(ctx, env.Pid, step.Name, CollectorRunning, capture.Result{})
+			r := <-goCapture(ctx, env.Endpoint(receiver), capture.WrapRun(task))
+			if r.Ok {
+				metrics.CapturesSucceeded.WithLabelValues(step.Name).Inc()
+				reportProgress(ctx, env.Pid, step.Name, CollectorSucceeded, r)
+			} else {
+				metrics.CapturesFailed.WithLabelValues(step.Name).Inc()
+				reportProgress(ctx, env.Pid, step.Name, CollectorFailed, r)
+			}
+			result <- r
+		}(step, task, complete, results[i])
+	}
+
+	// stop the background tasks once the others are done, they keep what they captured so far
+	for _, step := range p.Steps {
+		if !step.Background {
+			<-done[step.Name]
+		}
+	}
+	for _, task := range background {
+		task.Kill()
+	}
+
+	for i, step := range p.Steps {
+		if results[i] == nil {
+			continue
+		}
+		env.Log("Reading result from %s collector", step.Name)
+		result := <-results[i]
+		if step.Log != nil {
+			step.Log(env, result)
+			continue
+		}
+		logResult(env, step.Title, result)
+	}
+}
+
+func logResult(env *CaptureEnv, title string, result capture.Result) {
+	env.Log(
+		`%s
+Is transmission completed: %s
+Resp: %s
+
+--------------------------------
+`, title, result.Transmission(), result.Msg)
+}
@@ -0,0 +1,269 @@
+package ondemand
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"yc-agent/internal/capture"
+	"yc-agent/internal/config"
+	"yc-agent/internal/logger"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stepNames(plan *Plan) []string {
+	names := make([]string, len(plan.Steps))
+	for i, step := range plan.Steps {
+		names[i] = step.Name
+	}
+	return names
+}
+
+func TestResolvePlan_Default(t *testing.T) {
+	plan, err := ResolvePlan(config.Pipeline{}, "")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"gc", "netstat", "top", "vmstat", "pressure", "ps", "dmesg", "disk", "diskio", "ping", "kernel",
+		"cgroup", "threaddump", "applog", "applogs", "hdsub", "jfr", "extendeddata", "heapdump", "custom"}, stepNames(plan))
+	assert.Empty(t, plan.Disabled)
+
+	var out bytes.Buffer
+	plan.Print(&out)
+	assert.Contains(t, out.String(), "concurrency: unlimited")
+	assert.Contains(t, out.String(), "7. dmesg after vmstat")
+	assert.Contains(t, out.String(), "3. top (background)")
+}
+
+func TestResolvePlan_Configured(t *testing.T) {
+	disabled := false
+	plan, err := ResolvePlan(config.Pipeline{
+		Concurrency: 2,
+		Collectors: map[string]config.PipelineCollector{
+			"kernel":  {Enabled: &disabled},
+			"ping":    {Options: map[string]string{"host": "example.com"}},
+			"gc":      {DependsOn: []string{"threaddump"}},
+			"iostat":  {Cmd: "iostat -x 1 3", DependsOn: []string{"heapdump"}},
+			"netstat": {DependsOn: []string{}},
+		},
+	}, "disk, dmesg")
+	require.NoError(t, err)
+
+	names := stepNames(plan)
+	assert.NotContains(t, names, "kernel")
+	assert.NotContains(t, names, "disk")
+	assert.ElementsMatch(t, []string{"kernel", "disk", "dmesg"}, plan.Disabled)
+	assert.Less(t, indexOf(names, "threaddump"), indexOf(names, "gc"))
+	assert.Equal(t, "iostat", names[len(names)-1])
+
+	var out bytes.Buffer
+	plan.Print(&out)
+	assert.Contains(t, out.String(), "concurrency: 2")
+	assert.Contains(t, out.String(), "ping [host=example.com]")
+	assert.NotContains(t, out.String(), "kernel,", "dependencies on disabled collectors are dropped")
+}
+
+func TestResolvePlan_Invalid(t *testing.T) {
+	_, err := ResolvePlan(config.Pipeline{Collectors: map[string]config.PipelineCollector{"unknown": {}}}, "")
+	assert.ErrorContains(t, err, "unknown collector unknown")
+
+	_, err = ResolvePlan(config.Pipeline{}, "nope")
+	assert.ErrorContains(t, err, "unknown collector nope")
+
+	_, err = ResolvePlan(config.Pipeline{Collectors: map[string]config.PipelineCollector{
+		"gc":   {DependsOn: []string{"ping"}},
+		"ping": {DependsOn: []string{"gc"}},
+	}}, "")
+	assert.ErrorContains(t, err, "collectors gc, ping, heapdump, custom can't be ordered")
+}
+
+// recordingTask records how many tasks run at once and when it completes.
+type recordingTask struct {
+	capture.Capture
+	name    string
+	running *int32
+	max     *int32
+	mu      *sync.Mutex
+	order   *[]string
+}
+
+func (r *recordingTask) Run(ctx context.Context) (capture.Result, error) {
+	n := atomic.AddInt32(r.running, 1)
+	for {
+		m := atomic.LoadInt32(r.max)
+		if n <= m || atomic.CompareAndSwapInt32(r.max, m, n) {
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	atomic.AddInt32(r.running, -1)
+
+	r.mu.Lock()
+	*r.order = append(*r.order, r.name)
+	r.mu.Unlock()
+	return capture.Result{Msg: r.name, Ok: true}, nil
+}
+
+func TestPlanRun(t *testing.T) {
+	var running, max int32
+	var mu sync.Mutex
+	var order []string
+	newStep := func(name string, deps ...string) *PlanStep {
+		return &PlanStep{
+			Collector: &Collector{
+				Name:  name,
+				Title: name,
+				New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+					return &recordingTask{name: name, running: &running, max: &max, mu: &mu, order: &order}
+				},
+			},
+			DependsOn: deps,
+		}
+	}
+	skipped := newStep("skipped")
+	skipped.New = func(env *CaptureEnv, opts CollectorOptions) capture.Task { return nil }
+
+	plan := &Plan{
+		Concurrency: 2,
+		Steps: []*PlanStep{
+			newStep("a"), newStep("b"), newStep("c"), skipped, newStep("d"),
+			newStep("last", "a", "b", "c", "skipped", "d"),
+		},
+	}
+	plan.Run(context.Background(), &CaptureEnv{Server: "http://localhost", Parameters: "de=localhost"})
+
+	assert.LessOrEqual(t, max, int32(2), "the concurrency limit should be respected")
+	require.Len(t, order, 5)
+	assert.Equal(t, "last", order[4])
+}
+
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+type loggingTask struct {
+	capture.Capture
+	name string
+}
+
+func (l *loggingTask) Run(ctx context.Context) (capture.Result, error) {
+	l.Log("collecting %s", l.name)
+	return capture.Result{Msg: l.name, Ok: true}, nil
+}
+
+// lockedBuffer is a bytes.Buffer safe for concurrent writes.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func TestPlanRun_CaptureLogs(t *testing.T) {
+	plan := &Plan{Steps: []*PlanStep{{Collector: &Collector{
+		Name:  "logging",
+		Title: "LOGGING DATA",
+		New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+			return &loggingTask{name: env.AppName}
+		},
+	}}}}
+
+	logs := map[string]*lockedBuffer{"a": {}, "b": {}}
+	var wg sync.WaitGroup
+	for name, buf := range logs {
+		wg.Add(1)
+		go func(name string, buf *lockedBuffer) {
+			defer wg.Done()
+			l := zerolog.New(buf).With().Str(logger.CaptureIDField, name).Logger()
+			ctx := logger.WithContext(context.Background(), &l)
+			plan.Run(ctx, &CaptureEnv{AppName: name, Server: "http://localhost", Parameters: "de=localhost", Logger: &l})
+		}(name, buf)
+	}
+	wg.Wait()
+
+	for name, buf := range logs {
+		other := "b"
+		if name == "b" {
+			other = "a"
+		}
+		lines := strings.Split(strings.TrimSpace(buf.buf.String()), "\n")
+		assert.Contains(t, buf.buf.String(), "collecting "+name)
+		assert.NotContains(t, buf.buf.String(), "collecting "+other)
+		assert.Contains(t, buf.buf.String(), "LOGGING DATA")
+		for _, line := range lines {
+			assert.Contains(t, line, `"`+logger.CaptureIDField+`":"`+name+`"`)
+		}
+	}
+}
+
+// backgroundTask runs until it's killed, recording whether the foreground step was done by then.
+type backgroundTask struct {
+	capture.Capture
+	foregroundDone *int32
+	killedEarly    int32
+	killed         chan struct{}
+	once           sync.Once
+}
+
+func (b *backgroundTask) Run(ctx context.Context) (capture.Result, error) {
+	<-b.killed
+	return capture.Result{Msg: "background", Ok: true}, nil
+}
+
+func (b *backgroundTask) Kill() error {
+	if atomic.LoadInt32(b.foregroundDone) == 0 {
+		atomic.StoreInt32(&b.killedEarly, 1)
+	}
+	b.once.Do(func() { close(b.killed) })
+	return nil
+}
+
+// foregroundTask records when it's done.
+type foregroundTask struct {
+	capture.Capture
+	done *int32
+}
+
+func (f *foregroundTask) Run(ctx context.Context) (capture.Result, error) {
+	time.Sleep(20 * time.Millisecond)
+	atomic.StoreInt32(f.done, 1)
+	return capture.Result{Msg: "foreground", Ok: true}, nil
+}
+
+func TestPlanRun_KillsBackgroundLast(t *testing.T) {
+	var foregroundDone int32
+	background := &backgroundTask{foregroundDone: &foregroundDone, killed: make(chan struct{})}
+	plan := &Plan{Steps: []*PlanStep{
+		{Collector: &Collector{
+			Name:       "background",
+			Title:      "BACKGROUND DATA",
+			Background: true,
+			New:        func(env *CaptureEnv, opts CollectorOptions) capture.Task { return background },
+		}},
+		{Collector: &Collector{
+			Name:  "foreground",
+			Title: "FOREGROUND DATA",
+			New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+				return &foregroundTask{done: &foregroundDone}
+			},
+		}},
+	}}
+	plan.Run(context.Background(), &CaptureEnv{Server: "http://localhost", Parameters: "de=localhost"})
+
+	assert.Zero(t, atomic.LoadInt32(&background.killedEarly), "the background task is killed once the others are done")
+}
@@ -0,0 +1,156 @@
+package ondemand
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"yc-agent/internal/capture"
+	"yc-agent/internal/config"
+)
+
+// Session is the capture of several processes at once. The host-wide collectors
+// run once in the session, for the first process captured, and the captures of
+// the other processes upload a copy of their output.
+type Session struct {
+	// Dir is the directory the host-wide collectors write their output to.
+	Dir string
+	// temp reports whether Dir was created for the session, and is removed with it.
+	temp bool
+
+	mtx    sync.Mutex
+	shared map[string]*sharedCapture
+}
+
+// NewSession creates a session writing the host-wide output to dir, a new
+// temporary dir under the storage path if empty.
+func NewSession(dir string) (*Session, error) {
+	s := &Session{Dir: dir, shared: make(map[string]*sharedCapture)}
+	if dir != "" {
+		return s, nil
+	}
+
+	dir, err := os.MkdirTemp(config.GlobalConfig.StoragePath, "yc-host-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the session dir: %w", err)
+	}
+	s.Dir = dir
+	s.temp = true
+	return s, nil
+}
+
+// Close removes the dir of the session if it was created for it.
+func (s *Session) Close() error {
+	if !s.temp {
+		return nil
+	}
+	return os.RemoveAll(s.Dir)
+}
+
+// sharedCapture is the output of a host-wide collector in a session.
+type sharedCapture struct {
+	// done is closed once the output is captured.
+	done chan struct{}
+	path string
+}
+
+// share returns the shared capture of the collector, and whether the caller owns
+// it: the owner runs the task and completes it, the others upload its output.
+func (s *Session) share(step *PlanStep) (c *sharedCapture, owner bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	c, ok := s.shared[step.Name]
+	if ok {
+		return c, false
+	}
+	c = &sharedCapture{done: make(chan struct{}), path: filepath.Join(s.Dir, step.Output)}
+	s.shared[step.Name] = c
+	return c, true
+}
+
+func (c *sharedCapture) complete() {
+	close(c.done)
+}
+
+// sharedUpload uploads a copy of the output of a host-wide collector captured
+// for another process of the session.
+type sharedUpload struct {
+	capture.Capture
+	shared   *sharedCapture
+	output   string
+	dataType string
+}
+
+func (t *sharedUpload) Run(ctx context.Context) (result capture.Result, err error) {
+	select {
+	case <-t.shared.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
+	}
+
+	file, err := copyOutput(t.shared.path, t.OutputPath(t.output))
+	if err != nil {
+		result.Msg = fmt.Sprintf("failed to copy the shared %s: %s", t.output, err.Error())
+		err = nil
+		return
+	}
+	defer file.Close()
+
+	result.Msg, result.Ok = t.PostData(t.dataType, file)
+	return
+}
+
+// copyOutput copies the file src to dst, and returns dst opened at its start.
+func copyOutput(src, dst string) (*os.File, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		_, err = out.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = out.Close()
+		return nil, err
+	}
+	return out, nil
+}
+
+// shareHostWide returns the task of the host-wide step in the session: task
+// writing to the session dir if the caller owns the capture, or the upload of
+// the output captured by the owner. complete is called once the task completed.
+func (s *Session) shareHostWide(step *PlanStep, env *CaptureEnv, task capture.Task) (shared capture.Task, complete func()) {
+	c, owner := s.share(step)
+	if !owner {
+		upload := &sharedUpload{shared: c, output: step.Output, dataType: step.DataType}
+		upload.SetDir(env.Dir)
+		return upload, func() {}
+	}
+
+	task.SetDir(s.Dir)
+	return task, func() {
+		c.complete()
+		if env.Dir == s.Dir {
+			return
+		}
+		// Keep a copy in the capture dir of the process, like the other processes
+		file, err := copyOutput(c.path, filepath.Join(env.Dir, step.Output))
+		if err != nil {
+			env.Log("WARNING: failed to copy the shared %s: %s", step.Output, err)
+			return
+		}
+		_ = file.Close()
+	}
+}
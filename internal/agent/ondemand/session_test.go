@@ -0,0 +1,84 @@
+package ondemand
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"yc-agent/internal/capture"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hostTask writes host.out, counting how many times it runs.
+type hostTask struct {
+	capture.Capture
+	runs *int32
+}
+
+func (h *hostTask) Run(ctx context.Context) (capture.Result, error) {
+	atomic.AddInt32(h.runs, 1)
+	err := os.WriteFile(h.OutputPath("host.out"), []byte("host data"), 0644)
+	return capture.Result{Msg: "host", Ok: err == nil}, err
+}
+
+func TestSession(t *testing.T) {
+	var mu sync.Mutex
+	uploads := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		uploads[r.URL.Query().Get("ts")] = string(body)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	var runs int32
+	plan := &Plan{Steps: []*PlanStep{{Collector: &Collector{
+		Name:     "host",
+		Title:    "HOST DATA",
+		HostWide: true,
+		Output:   "host.out",
+		DataType: "host",
+		New: func(env *CaptureEnv, opts CollectorOptions) capture.Task {
+			return &hostTask{runs: &runs}
+		},
+	}}}}
+
+	session, err := NewSession("")
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	dirs := []string{t.TempDir(), t.TempDir(), t.TempDir()}
+	for i, dir := range dirs {
+		wg.Add(1)
+		go func(ts, dir string) {
+			defer wg.Done()
+			plan.Run(context.Background(), &CaptureEnv{
+				Server:     server.URL,
+				Parameters: "de=localhost&ts=" + ts,
+				Dir:        dir,
+				Session:    session,
+			})
+		}(string(rune('a'+i)), dir)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), runs, "the host-wide collector should run once")
+	assert.Len(t, uploads, 2, "the other captures should upload the shared output")
+	for _, dir := range dirs {
+		data, err := os.ReadFile(filepath.Join(dir, "host.out"))
+		require.NoError(t, err)
+		assert.Equal(t, "host data", string(data))
+	}
+
+	require.NoError(t, session.Close())
+	assert.NoDirExists(t, session.Dir)
+}
@@ -1,10 +1,9 @@
 package capture
 
 import (
+	"context"
 	"io"
 	"os"
-
-	"yc-agent/internal/logger"
 )
 
 const accessLogOut = "accesslog.out"
@@ -20,7 +19,7 @@ type AccessLog struct {
 
 // Run captures the new content from the access log specified in the Path field to a file,
 // then uploads them to the server.
-func (al *AccessLog) Run() (Result, error) {
+func (al *AccessLog) Run(ctx context.Context) (Result, error) {
 	if al.SourcePath == "" {
 		return Result{}, nil
 	}
@@ -39,11 +38,11 @@ func (al *AccessLog) Run() (Result, error) {
 // and writes them to a new capture file. It updates Position to track the last read
 // location for subsequent calls.
 //
-// The capture file is created at accessLogOut path. The caller is responsible for
+// The capture file is created at accessLogOut in the task dir unless CapturePath is set. The caller is responsible for
 // closing the returned file.
 func (al *AccessLog) CaptureToFile() (*os.File, error) {
 	if al.CapturePath == "" {
-		al.CapturePath = accessLogOut
+		al.CapturePath = al.OutputPath(accessLogOut)
 	}
 
 	// Open the access log path as the source
@@ -73,7 +72,7 @@ func (al *AccessLog) CaptureToFile() (*os.File, error) {
 
 	// Ensure all data is persisted to disk before proceeding with upload
 	if syncErr := dst.Sync(); syncErr != nil {
-		logger.Log("failed to sync destination file: %v", syncErr)
+		al.Log("failed to sync destination file: %v", syncErr)
 	}
 
 	// Update the position field to track the current position
@@ -86,6 +85,6 @@ func (al *AccessLog) CaptureToFile() (*os.File, error) {
 // UploadCapturedFile sends the captured log file to the configured endpoint
 // with data type "accessLog".
 func (al *AccessLog) UploadCapturedFile(f *os.File) Result {
-	msg, ok := PostData(al.Endpoint(), "accessLog", f)
+	msg, ok := al.PostData("accessLog", f)
 	return Result{Msg: msg, Ok: ok}
 }
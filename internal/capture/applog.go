@@ -1,6 +1,7 @@
 package capture
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -29,7 +30,7 @@ type AppLog struct {
 // Run executes the log capture process for all configured paths.
 // It processes each path as a glob pattern, capturing logs from all matching files.
 // Returns a summary Result of all operations and any errors encountered.
-func (al *AppLog) Run() (Result, error) {
+func (al *AppLog) Run(ctx context.Context) (Result, error) {
 	var results []Result
 	var errs []error
 
@@ -76,7 +77,7 @@ func (al *AppLog) CaptureSingleAppLog(filePath string) (Result, error) {
 
 	// Create a new file with a unique name to store the processed log content
 	// Example: 1.appLogs.abc.log
-	dstPath := generateUniqueLogPath(fileBaseName)
+	dstPath := generateUniqueLogPath(al.Dir(), fileBaseName)
 	dst, err := os.Create(dstPath)
 
 	if err != nil {
@@ -93,9 +94,15 @@ func (al *AppLog) CaptureSingleAppLog(filePath string) (Result, error) {
 		return Result{}, fmt.Errorf("applog failed to sync destination file: %w", err)
 	}
 
-	// Send the log data to the configured endpoint
+	// Send the log data to the configured endpoint, compressing it on the fly unless it's compressed already
 	data := buildPostData(fileBaseName, fileExt, isCompressed)
-	msg, ok := PostData(al.Endpoint(), data, dst)
+	var msg string
+	var ok bool
+	if isCompressed {
+		msg, ok = al.PostData(data, dst)
+	} else {
+		msg, ok = al.PostCompressedData("dt="+data, "content-encoding", dst)
+	}
 
 	return Result{Msg: msg, Ok: ok}, nil
 }
@@ -103,12 +110,12 @@ func (al *AppLog) CaptureSingleAppLog(filePath string) (Result, error) {
 // generateUniqueLogPath creates a unique file path for storing the log content.
 // It appends a sequential number to the base filename until it finds an unused path.
 // Returns the generated unique path as a string.
-func generateUniqueLogPath(baseFileName string) string {
+func generateUniqueLogPath(dir string, baseFileName string) string {
 	counter := 1
 	for {
 		// Generate a unique filename by appending the sequential number
 		// Example: 1.appLogs.abc.log
-		path := fmt.Sprintf("%d.appLogs.%s", counter, baseFileName)
+		path := filepath.Join(dir, fmt.Sprintf("%d.appLogs.%s", counter, baseFileName))
 		if !fileExists(path) {
 			return path
 		}
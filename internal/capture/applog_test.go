@@ -1,8 +1,10 @@
 package capture
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"yc-agent/internal/config"
@@ -26,25 +28,18 @@ func TestBuildPostData(t *testing.T) {
 
 // TestGenerateUniqueLogPath verifies that generateUniqueLogPath returns a filename that does not exist.
 func TestGenerateUniqueLogPath(t *testing.T) {
-	// Create a temporary directory and change working directory into it.
 	tmpDir := t.TempDir()
-	originalWd, err := os.Getwd()
-	require.NoError(t, err)
-	defer os.Chdir(originalWd)
-
-	err = os.Chdir(tmpDir)
-	require.NoError(t, err)
 
 	// Create initial file to force unique name generation
-	existingFile := "1.appLogs.test.log"
-	err = os.WriteFile(existingFile, []byte("dummy"), 0644)
+	existingFile := filepath.Join(tmpDir, "1.appLogs.test.log")
+	err := os.WriteFile(existingFile, []byte("dummy"), 0644)
 	require.NoError(t, err)
 
-	uniquePath := generateUniqueLogPath("test.log")
+	uniquePath := generateUniqueLogPath(tmpDir, "test.log")
 
 	// For this simple algorithm, we expect the next unique name to be "2.appLogs.test.log".
 	assert.NotEqual(t, existingFile, uniquePath, "should not return existing file path")
-	assert.Equal(t, "2.appLogs.test.log", uniquePath, "should generate expected unique name")
+	assert.Equal(t, filepath.Join(tmpDir, "2.appLogs.test.log"), uniquePath, "should generate expected unique name")
 }
 
 // TestSummarizeResults verifies that summarizeResults aggregates the result messages and errors.
@@ -160,7 +155,7 @@ func TestRun(t *testing.T) {
 		}
 
 		// Run
-		result, err := appLog.Run()
+		result, err := appLog.Run(context.Background())
 
 		// Verify
 		assert.NoError(t, err)
@@ -175,7 +170,7 @@ func TestRun(t *testing.T) {
 		}
 
 		// Run
-		result, err := appLog.Run()
+		result, err := appLog.Run(context.Background())
 
 		// Verify
 		assert.Error(t, err, "should return error for invalid glob pattern")
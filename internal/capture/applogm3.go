@@ -1,13 +1,13 @@
 package capture
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 
 	"yc-agent/internal/config"
-	"yc-agent/internal/logger"
 
 	"github.com/mattn/go-zglob"
 )
@@ -55,7 +55,7 @@ func (a *AppLogM3) SetPaths(p map[int]config.AppLogs) {
 // which is essential for handling rotating log files (e.g., app.log.1, app.log.2).
 // Errors are collected but don't stop processing - this ensures one bad file
 // doesn't prevent capture from other valid logs.
-func (a *AppLogM3) Run() (Result, error) {
+func (a *AppLogM3) Run(ctx context.Context) (Result, error) {
 	results := []Result{}
 	errs := []error{}
 
@@ -122,14 +122,14 @@ func (a *AppLogM3) CaptureSingleAppLog(filePath string, pid int) (Result, error)
 	// This avoids missing logs after rotation while preventing
 	// duplicate processing of log entries
 	if fileInfo.Size() < readStat.fileSize {
-		logger.Log("applogm3: file %q truncated, resetting read position", filePath)
+		a.Log("applogm3: file %q truncated, resetting read position", filePath)
 		readStat.readPosition = 0
 	} else {
 		// Seek to last read position for incremental processing
 		if _, err := src.Seek(readStat.readPosition, io.SeekStart); err != nil {
 			// If seek fails, fall back to processing from start to ensure
 			// no log entries are missed, even if some may be duplicated
-			logger.Log("applogm3: failed to seek %q to pos %d: %v, resetting to start",
+			a.Log("applogm3: failed to seek %q to pos %d: %v, resetting to start",
 				filePath, readStat.readPosition, err)
 			if _, err = src.Seek(0, io.SeekStart); err != nil {
 				return Result{}, fmt.Errorf("failed to seek applog %q: %w", filePath, err)
@@ -138,10 +138,10 @@ func (a *AppLogM3) CaptureSingleAppLog(filePath string, pid int) (Result, error)
 		}
 	}
 
-	logger.Log("applogm3: reading %q from pos %d", filePath, readStat.readPosition)
+	a.Log("applogm3: reading %q from pos %d", filePath, readStat.readPosition)
 
 	// Generate a unique destination filename to prevent conflicting file names.
-	dstPath := generateUniqueLogPath(filepath.Base(filePath))
+	dstPath := generateUniqueLogPath(a.Dir(), filepath.Base(filePath))
 	dst, err := os.Create(dstPath)
 	if err != nil {
 		return Result{}, fmt.Errorf("failed to create destination file %q: %w", dstPath, err)
@@ -166,7 +166,7 @@ func (a *AppLogM3) CaptureSingleAppLog(filePath string, pid int) (Result, error)
 
 	// Build the data string for posting.
 	dt := fmt.Sprintf("applog&logName=%s&pid=%d", filepath.Base(filePath), pid)
-	msg, ok := PostData(a.Endpoint(), dt, dst)
+	msg, ok := a.PostData(dt, dst)
 
 	return Result{Msg: msg, Ok: ok}, nil
 }
@@ -1,6 +1,7 @@
 package capture
 
 import (
+	"context"
 	"os"
 	"testing"
 
@@ -218,7 +219,7 @@ func TestAppLogM3_Run(t *testing.T) {
 	}
 
 	// Run the capture.
-	result, err := appLog.Run()
+	result, err := appLog.Run(context.Background())
 	require.NoError(t, err)
 
 	// Since these files are encountered for the first time, they should be initialized.
@@ -242,7 +243,7 @@ func TestRun_InvalidGlob(t *testing.T) {
 		1000: {"["},
 	}
 
-	result, err := appLog.Run()
+	result, err := appLog.Run(context.Background())
 	require.Error(t, err, "should return error for invalid glob pattern")
 	assert.False(t, result.Ok, "result should indicate failure")
 }
@@ -2,10 +2,12 @@ package capture
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"text/template"
 	"time"
@@ -98,75 +100,75 @@ type AtomConnector struct {
 	Name     string `json:"name"`
 }
 
-func CaptureBoomiDetails(endpoint string, timestamp string, pid int) {
+func CaptureBoomiDetails(ctx context.Context, endpoint string, dir string, timestamp string, pid int) {
 	// get Boomi details from the config
 	boomiURL := BoomiURL //config.GlobalConfig.BoomiUrl
 	if boomiURL == "" {
-		logger.Log("Boomi server URL is missing. It is mandatory.")
+		logger.LogCtx(ctx, "Boomi server URL is missing. It is mandatory.")
 		return
 	}
 
 	accountID := config.GlobalConfig.BoomiAcctId
 	if accountID == "" {
-		logger.Log("Boomi account ID is missing. It is mandatory.")
+		logger.LogCtx(ctx, "Boomi account ID is missing. It is mandatory.")
 		return
 	}
 
 	boomiUserName := config.GlobalConfig.BoomiUser
 	boomiPassword := config.GlobalConfig.BoomiPassword
 	if boomiUserName == "" || boomiPassword == "" {
-		logger.Log("Boomi username or password is missing.. It is mandatory..")
+		logger.LogCtx(ctx, "Boomi username or password is missing.. It is mandatory..")
 		return
 	}
 
 	boomiURL = strings.Replace(boomiURL, "{accountId}", accountID, 1)
 
-	logger.Log("boomiURL: %s", boomiURL)
-	logger.Log("accountId: %s", accountID)
-	logger.Log("boomiUserName: %s", boomiUserName)
+	logger.LogCtx(ctx, "boomiURL: %s", boomiURL)
+	logger.LogCtx(ctx, "accountId: %s", accountID)
+	logger.LogCtx(ctx, "boomiUserName: %s", boomiUserName)
 
-	output := BoomiExecutionOutput{pid: pid}
+	output := BoomiExecutionOutput{pid: pid, dir: dir}
 	outputFile, err := output.CreateFile()
 	if err != nil {
-		logger.Log(err.Error())
+		logger.LogCtx(ctx, err.Error())
 		return
 	}
 	defer output.CloseFile()
 
-	executionRecords, err := fetchBoomiExecutionRecords(boomiUserName, boomiPassword, boomiURL)
+	executionRecords, err := fetchBoomiExecutionRecords(ctx, boomiUserName, boomiPassword, boomiURL)
 	if err != nil {
-		logger.Log(err.Error())
+		logger.LogCtx(ctx, err.Error())
 		return
 	}
 
 	if len(executionRecords) == 0 {
-		logger.Log("No Boomi records to match the given criteria...")
+		logger.LogCtx(ctx, "No Boomi records to match the given criteria...")
 		return
 	}
 
 	output.WriteHeader()
-	output.WriteRecords(executionRecords)
+	output.WriteRecords(ctx, executionRecords)
 
 	stats := NewExecutionRecordStats()
-	stats.CalculateStats(executionRecords)
-	stats.LogSummary()
+	stats.CalculateStats(ctx, executionRecords)
+	stats.LogSummary(ctx)
 
-	logger.Log("Finished capturing Boomi details, uploading to server")
+	logger.LogCtx(ctx, "Finished capturing Boomi details, uploading to server")
 
 	//// gets atom details
-	atomQueryResult := getAtomQueryDetails(accountID, boomiUserName, boomiPassword)
+	atomQueryResult := getAtomQueryDetails(ctx, accountID, boomiUserName, boomiPassword)
 
 	//// write atom details header
 	output.WriteAtomDetailsHeader()
 	/// write atom query details
-	output.WriteAtomQueryDetails(atomQueryResult)
+	output.WriteAtomQueryDetails(ctx, atomQueryResult)
 
 	///// get atom connector details
 	atomConnectorURL := "https://api.boomi.com/api/rest/v1/" + accountID + "/Connector/query"
-	atomConnectorRecord, err := fetchAtomConnectorDetails(boomiUserName, boomiPassword, atomConnectorURL)
+	atomConnectorRecord, err := fetchAtomConnectorDetails(ctx, boomiUserName, boomiPassword, atomConnectorURL)
 
 	//// download atom log
-	downloadAtomLog(boomiUserName, boomiPassword, accountID)
+	downloadAtomLog(ctx, boomiUserName, boomiPassword, accountID)
 
 	//// write atom details header
 	output.WriteAtomConnectorDetailsHeader()
@@ -175,23 +177,23 @@ func CaptureBoomiDetails(endpoint string, timestamp string, pid int) {
 	uploadBoomiDetailsToServer(endpoint, outputFile, "boomi")
 }
 
-func fetchBoomiExecutionRecords(boomiUserName, boomiPassword, boomiURL string) ([]ExecutionRecord, error) {
+func fetchBoomiExecutionRecords(ctx context.Context, boomiUserName, boomiPassword, boomiURL string) ([]ExecutionRecord, error) {
 	totalRecordCount := 0
 	stopped := false
 	records := []ExecutionRecord{}
 
 	queryToken := ""
 	for {
-		resp, err := makeBoomiRequest(queryToken, boomiUserName, boomiPassword, boomiURL)
+		resp, err := makeBoomiRequest(ctx, queryToken, boomiUserName, boomiPassword, boomiURL)
 
 		if err != nil {
 			return records, fmt.Errorf("Failed to make Boomi request: %w", err)
 		}
-		logger.Log("Response Status Code: %d", resp.StatusCode())
+		logger.LogCtx(ctx, "Response Status Code: %d", resp.StatusCode())
 
 		// return if status code is not 200
 		if resp.StatusCode() != 200 {
-			logger.Log("Boomi API responded with non 200, aborting...")
+			logger.LogCtx(ctx, "Boomi API responded with non 200, aborting...")
 			return records, nil
 		}
 
@@ -202,7 +204,7 @@ func fetchBoomiExecutionRecords(boomiUserName, boomiPassword, boomiURL string) (
 			return records, fmt.Errorf("Error unmarshalling Boomi response as JSON: %w", jsonErr)
 		}
 
-		logger.Log("Length of Boomi queryResult.Result->%d", len(queryResult.Result))
+		logger.LogCtx(ctx, "Length of Boomi queryResult.Result->%d", len(queryResult.Result))
 
 		if len(queryResult.Result) <= 0 {
 			return records, nil
@@ -221,7 +223,7 @@ func fetchBoomiExecutionRecords(boomiUserName, boomiPassword, boomiURL string) (
 		}
 
 		if stopped {
-			logger.Log("Processed %d Boomi records", totalRecordCount-1)
+			logger.LogCtx(ctx, "Processed %d Boomi records", totalRecordCount-1)
 			break
 		}
 
@@ -232,7 +234,7 @@ func fetchBoomiExecutionRecords(boomiUserName, boomiPassword, boomiURL string) (
 	return records, nil
 }
 
-func fetchAtomConnectorDetails(boomiUserName, boomiPassword, boomiURL string) ([]AtomConnector, error) {
+func fetchAtomConnectorDetails(ctx context.Context, boomiUserName, boomiPassword, boomiURL string) ([]AtomConnector, error) {
 	records := []AtomConnector{}
 	totalRecordCount := 0
 	stopped := false
@@ -243,11 +245,11 @@ func fetchAtomConnectorDetails(boomiUserName, boomiPassword, boomiURL string) ([
 		if err != nil {
 			//return fmt.Errorf("Failed to make Boomi request: %w", err)
 		}
-		logger.Log("Response Status Code: %d", resp.StatusCode())
+		logger.LogCtx(ctx, "Response Status Code: %d", resp.StatusCode())
 
 		// return if status code is not 200
 		if resp.StatusCode() != 200 {
-			logger.Log("Boomi API responded with non 200, aborting...")
+			logger.LogCtx(ctx, "Boomi API responded with non 200, aborting...")
 			return records, nil
 		}
 
@@ -257,7 +259,7 @@ func fetchAtomConnectorDetails(boomiUserName, boomiPassword, boomiURL string) ([
 		if jsonErr != nil {
 			return records, fmt.Errorf("Error unmarshalling Boomi response as JSON: %w", jsonErr)
 		}
-		logger.Log("Length of Boomi queryResult.Result->%d", len(queryResult.Result))
+		logger.LogCtx(ctx, "Length of Boomi queryResult.Result->%d", len(queryResult.Result))
 		if len(queryResult.Result) <= 0 {
 			return records, nil
 		}
@@ -275,7 +277,7 @@ func fetchAtomConnectorDetails(boomiUserName, boomiPassword, boomiURL string) ([
 		}
 
 		if stopped {
-			logger.Log("Processed %d Atom connector records", totalRecordCount-1)
+			logger.LogCtx(ctx, "Processed %d Atom connector records", totalRecordCount-1)
 			break
 		}
 
@@ -298,11 +300,11 @@ func NewExecutionRecordStats() *ExecutionRecordStats {
 	return &ExecutionRecordStats{CountByStatus: make(map[string]int)}
 }
 
-func (ers *ExecutionRecordStats) CalculateStats(records []ExecutionRecord) {
+func (ers *ExecutionRecordStats) CalculateStats(ctx context.Context, records []ExecutionRecord) {
 	for _, executionRecord := range records {
 		ers.CountByStatus[executionRecord.Status]++
 
-		executionDuration := convertExecutionDurationToInt(executionRecord, executionRecord.Status)
+		executionDuration := convertExecutionDurationToInt(ctx, executionRecord, executionRecord.Status)
 		ers.ExecutionTimeTotal += executionDuration
 	}
 
@@ -310,33 +312,35 @@ func (ers *ExecutionRecordStats) CalculateStats(records []ExecutionRecord) {
 	ers.ExecutionTimeAvg = ers.ExecutionTimeTotal / ers.RecordCount
 }
 
-func (ers *ExecutionRecordStats) LogSummary() {
-	logger.Log("===================== BOOMI execution summary =====================")
-	logger.Log("number of records: %d", ers.RecordCount)
+func (ers *ExecutionRecordStats) LogSummary(ctx context.Context) {
+	logger.LogCtx(ctx, "===================== BOOMI execution summary =====================")
+	logger.LogCtx(ctx, "number of records: %d", ers.RecordCount)
 
 	successJobCount, exist := ers.CountByStatus["COMPLETE"]
 	if exist {
-		logger.Log("number of SUCCESS: %d", successJobCount)
+		logger.LogCtx(ctx, "number of SUCCESS: %d", successJobCount)
 	}
 
 	failedJobCount, exist := ers.CountByStatus["ERROR"]
 	if exist {
-		logger.Log("number of FAILURE: %d", failedJobCount)
+		logger.LogCtx(ctx, "number of FAILURE: %d", failedJobCount)
 	}
 
 	executionTimeTotalMin := ers.ExecutionTimeTotal / 60000
-	logger.Log("execution time total: %d min\n", executionTimeTotalMin)
+	logger.LogCtx(ctx, "execution time total: %d min\n", executionTimeTotalMin)
 
-	logger.Log("Avg execution time: %d ms\n", ers.ExecutionTimeAvg)
+	logger.LogCtx(ctx, "Avg execution time: %d ms\n", ers.ExecutionTimeAvg)
 }
 
 type BoomiExecutionOutput struct {
-	pid  int
+	pid int
+	// dir is the directory boomi.out is written to, the current directory if empty.
+	dir  string
 	file *os.File
 }
 
 func (b *BoomiExecutionOutput) CreateFile() (*os.File, error) {
-	file, err := os.Create("boomi.out")
+	file, err := os.Create(filepath.Join(b.dir, "boomi.out"))
 	if err != nil {
 		return nil, fmt.Errorf("Error while creating Boomi output file: %w", err)
 	}
@@ -402,13 +406,13 @@ func (b *BoomiExecutionOutput) WriteAtomConnectorDetailsHeader() error {
 	return err2
 }
 
-func (b *BoomiExecutionOutput) WriteRecords(records []ExecutionRecord) error {
+func (b *BoomiExecutionOutput) WriteRecords(ctx context.Context, records []ExecutionRecord) error {
 	if b.file == nil {
 		return nil
 	}
 
 	for _, executionRecord := range records {
-		executionDuration := convertExecutionDurationToInt(executionRecord, executionRecord.Status)
+		executionDuration := convertExecutionDurationToInt(ctx, executionRecord, executionRecord.Status)
 
 		boomiData := fmt.Sprintf("%s,%d,%s,%s,%s,%d,%s,%s\n", executionRecord.ExecutionTime, executionDuration, executionRecord.Status, executionRecord.AtomName, executionRecord.AtomID, b.pid, executionRecord.ProcessName, executionRecord.ExecutionID)
 		_, err := b.file.WriteString(boomiData)
@@ -426,14 +430,14 @@ func (b *BoomiExecutionOutput) WriteRecords(records []ExecutionRecord) error {
 	return nil
 }
 
-func (b *BoomiExecutionOutput) WriteAtomQueryDetails(atomQueryResult AtomQueryResult) error {
+func (b *BoomiExecutionOutput) WriteAtomQueryDetails(ctx context.Context, atomQueryResult AtomQueryResult) error {
 	if b.file == nil {
 		return nil
 	}
 
 	// get configured atom id
 	atomId := config.GlobalConfig.AtomId
-	logger.Log("configured atom id %s->", atomId)
+	logger.LogCtx(ctx, "configured atom id %s->", atomId)
 
 	for _, atomQuery := range atomQueryResult.Result {
 		if atomId == atomQuery.ID {
@@ -486,16 +490,16 @@ func (b *BoomiExecutionOutput) WriteAtomConnectorDetails(atomConnectors []AtomCo
 }
 
 // convert execution duration to integer
-func convertExecutionDurationToInt(record ExecutionRecord, jobStatus string) int {
+func convertExecutionDurationToInt(ctx context.Context, record ExecutionRecord, jobStatus string) int {
 	if (jobStatus == "COMPLETE" || jobStatus == "ERROR") && len(record.ExecutionDuration) == 2 {
 		if value, ok := record.ExecutionDuration[1].(float64); ok {
 			executionDuration := int(value)
 			return executionDuration
 		}
 
-		logger.Log("ExecutionDuration value is not a float64")
+		logger.LogCtx(ctx, "ExecutionDuration value is not a float64")
 	} else {
-		logger.Log("Unexpected format for ExecutionDuration")
+		logger.LogCtx(ctx, "Unexpected format for ExecutionDuration")
 	}
 
 	return 0
@@ -504,7 +508,7 @@ func convertExecutionDurationToInt(record ExecutionRecord, jobStatus string) int
 // This method perform a BOOMI API POST request based on the query token value
 // If the query token is NOT empty, it will hit queryMore URL with the query token
 // received from the previous request and finally return the response
-func makeBoomiRequest(queryToken string, username string, password string, boomiURL string) (*resty.Response, error) {
+func makeBoomiRequest(ctx context.Context, queryToken string, username string, password string, boomiURL string) (*resty.Response, error) {
 
 	// Create a new Resty client
 	client := resty.New()
@@ -544,12 +548,12 @@ func makeBoomiRequest(queryToken string, username string, password string, boomi
 		period = 3
 	}
 
-	logger.Log("current time frame %d hours", period)
+	logger.LogCtx(ctx, "current time frame %d hours", period)
 
 	startTimeStr, endTimeStr := getStartAndEndTime(period)
 
-	logger.Log("start time%s", startTimeStr)
-	logger.Log("end time%s", endTimeStr)
+	logger.LogCtx(ctx, "start time%s", startTimeStr)
+	logger.LogCtx(ctx, "end time%s", endTimeStr)
 
 	type FilterData struct {
 		StartTimeStr string
@@ -588,12 +592,12 @@ func makeBoomiRequest(queryToken string, username string, password string, boomi
 
 		t, err := template.New("filter").Parse(p)
 		if err != nil {
-			logger.Log("error while parsing the boomi request template string %s", err.Error())
+			logger.LogCtx(ctx, "error while parsing the boomi request template string %s", err.Error())
 		}
 
 		err = t.Execute(&result, data)
 		if err != nil {
-			logger.Log("error while applying template with value %s", err.Error())
+			logger.LogCtx(ctx, "error while applying template with value %s", err.Error())
 		}
 	}
 
@@ -695,7 +699,7 @@ func getStartAndEndTime(period uint) (string, string) {
 	return startTimeStr, endTimeStr
 }
 
-func getAtomQueryDetails(accountID string, username string, password string) AtomQueryResult {
+func getAtomQueryDetails(ctx context.Context, accountID string, username string, password string) AtomQueryResult {
 	atomURL := "https://api.boomi.com/api/rest/v1/" + accountID + "/Atom/query"
 
 	// Create a new Resty client
@@ -707,31 +711,31 @@ func getAtomQueryDetails(accountID string, username string, password string) Ato
 		Post(atomURL)
 
 	if err != nil {
-		logger.Log("error while calling atom query details rest endpoint %s", err.Error())
+		logger.LogCtx(ctx, "error while calling atom query details rest endpoint %s", err.Error())
 	}
 
-	logger.Log("atom query result status code %d", resp.StatusCode())
+	logger.LogCtx(ctx, "atom query result status code %d", resp.StatusCode())
 
 	var atomQueryResult AtomQueryResult
 
 	// return if status code is not 200
 	if resp.StatusCode() != 200 {
-		logger.Log("Boomi Atom details api responded with non 200, aborting...")
+		logger.LogCtx(ctx, "Boomi Atom details api responded with non 200, aborting...")
 		return atomQueryResult
 	}
 
 	jsonErr := json.Unmarshal(resp.Body(), &atomQueryResult)
 	if jsonErr != nil {
-		logger.Log("error while unmarshalling response %s", jsonErr.Error())
+		logger.LogCtx(ctx, "error while unmarshalling response %s", jsonErr.Error())
 		return atomQueryResult
 	}
 
-	//logger.Log("Atom Query details Result %v", atomQueryResult)
+	//logger.LogCtx(ctx, "Atom Query details Result %v", atomQueryResult)
 
 	return atomQueryResult
 }
 
-func getAtomConnectorDetails(accountID string, username string, password string) {
+func getAtomConnectorDetails(ctx context.Context, accountID string, username string, password string) {
 	// Create a new Resty client
 	client := resty.New()
 	connectorURL := "https://api.boomi.com/api/rest/v1/" + accountID + "/Connector/query"
@@ -741,10 +745,10 @@ func getAtomConnectorDetails(accountID string, username string, password string)
 		Post(connectorURL)
 
 	if err != nil {
-		logger.Log("error while calling atom connector details rest endpoint %s", err.Error())
+		logger.LogCtx(ctx, "error while calling atom connector details rest endpoint %s", err.Error())
 	}
 
-	logger.Log("atom connector result status code %d", resp.StatusCode())
+	logger.LogCtx(ctx, "atom connector result status code %d", resp.StatusCode())
 
 }
 
@@ -754,8 +758,8 @@ func getAtomConnectorDetails(accountID string, username string, password string)
 // <mdm:Clouds>
 // <mdm:Cloud cloudId="47ff4c06-8bef-431e-a30f-2f4dec0ffca8" containerId="acd927c3-a249-4a47-b217-ef9cbf99d187" name="Singapore Hub Cloud"/>
 // </mdm:Clouds>
-func downloadAtomLog(username, password, boomiAcctId string) {
-	logger.Log("now downloading atom log..")
+func downloadAtomLog(ctx context.Context, username, password, boomiAcctId string) {
+	logger.LogCtx(ctx, "now downloading atom log..")
 	var period = config.GlobalConfig.Period
 	if period == 0 {
 		period = 3
@@ -782,16 +786,16 @@ func downloadAtomLog(username, password, boomiAcctId string) {
 
 	t, err := template.New("filter").Parse(req)
 	if err != nil {
-		logger.Log("error while parsing the boomi download atom request template string %s", err.Error())
+		logger.LogCtx(ctx, "error while parsing the boomi download atom request template string %s", err.Error())
 	}
 	err = t.Execute(&result, data)
 	if err != nil {
-		logger.Log("error while applying template with value %s", err.Error())
+		logger.LogCtx(ctx, "error while applying template with value %s", err.Error())
 	}
 
 	client := resty.New()
 	boomiURL := "https://api.boomi.com/api/rest/v1/" + boomiAcctId + "/AtomLog"
-	logger.Log("boomi atom log req string %s", result.String())
+	logger.LogCtx(ctx, "boomi atom log req string %s", result.String())
 
 	resp, err := client.R().
 		SetBasicAuth(username, password).
@@ -799,9 +803,9 @@ func downloadAtomLog(username, password, boomiAcctId string) {
 		SetBody(result.String()).
 		Post(boomiURL)
 
-	logger.Log("boomi atom download log response code %d", resp.StatusCode())
+	logger.LogCtx(ctx, "boomi atom download log response code %d", resp.StatusCode())
 	if resp.StatusCode() != 202 {
-		logger.Log("Boomi API responded with non 202, aborting...")
+		logger.LogCtx(ctx, "Boomi API responded with non 202, aborting...")
 		return
 	}
 
@@ -816,9 +820,9 @@ func downloadAtomLog(username, password, boomiAcctId string) {
 	var queryResult BoomiAtomLogQueryResult
 	jsonErr := json.Unmarshal(resp.Body(), &queryResult)
 	if jsonErr != nil {
-		logger.Log("Error unmarshalling Boomi atom download log response as JSON: %v", jsonErr)
+		logger.LogCtx(ctx, "Error unmarshalling Boomi atom download log response as JSON: %v", jsonErr)
 	}
-	logger.Log("got atom log download url->%s", queryResult.Url)
+	logger.LogCtx(ctx, "got atom log download url->%s", queryResult.Url)
 
 	// client2 := resty.New()
 	// client2.SetDebug(true)
@@ -844,13 +848,13 @@ func downloadAtomLog(username, password, boomiAcctId string) {
 			Get(queryResult.Url)
 
 		if err != nil {
-			logger.Log("error while get operation in boomi atom log download.. aborting")
+			logger.LogCtx(ctx, "error while get operation in boomi atom log download.. aborting")
 			return
 		}
 
-		// 	logger.Log("boomi atom download url response code-> %d", resp.StatusCode())
+		// 	logger.LogCtx(ctx, "boomi atom download url response code-> %d", resp.StatusCode())
 		// 	if resp.StatusCode() != 202 {
-		// 		logger.Log("error while downloading boomi atom log.. aborting")
+		// 		logger.LogCtx(ctx, "error while downloading boomi atom log.. aborting")
 		// 		return
 		// 	}
 
@@ -886,7 +890,7 @@ func downloadAtomLog(username, password, boomiAcctId string) {
 }
 
 // downloads the atom diskspace details from the Boomi server
-func getAtomDiskSize(accountID string, atomId string, username string, password string, records []ExecutionRecord) {
+func getAtomDiskSize(ctx context.Context, accountID string, atomId string, username string, password string, records []ExecutionRecord) {
 
 	uniqueData := make(map[string]struct{})
 	// Create a slice to store unique values
@@ -900,7 +904,7 @@ func getAtomDiskSize(accountID string, atomId string, username string, password
 		}
 	}
 
-	logger.Log("atomIDResult->%s", atomIDResult)
+	logger.LogCtx(ctx, "atomIDResult->%s", atomIDResult)
 	// Create a new Resty client
 	client := resty.New()
 
@@ -915,13 +919,13 @@ func getAtomDiskSize(accountID string, atomId string, username string, password
 			Post(atomURL)
 
 		if err != nil {
-			logger.Log("error while calling atom asycn rest endpoint %s", err.Error())
+			logger.LogCtx(ctx, "error while calling atom asycn rest endpoint %s", err.Error())
 		}
 
-		logger.Log("atom disk space status code %d", resp.StatusCode())
+		logger.LogCtx(ctx, "atom disk space status code %d", resp.StatusCode())
 		// return if status code is not 200
 		if resp.StatusCode() != 202 {
-			logger.Log("Boomi API responded with non 202, aborting...")
+			logger.LogCtx(ctx, "Boomi API responded with non 202, aborting...")
 			return
 		}
 
@@ -931,7 +935,7 @@ func getAtomDiskSize(accountID string, atomId string, username string, password
 		if jsonErr != nil {
 			return
 		}
-		logger.Log("atom async response->%s", jsonErr)
+		logger.LogCtx(ctx, "atom async response->%s", jsonErr)
 
 		/// now call the atom disk space rest endpoint
 		if asyncToken.Token != "" {
@@ -943,22 +947,22 @@ func getAtomDiskSize(accountID string, atomId string, username string, password
 				Get(atomURL)
 
 			if err != nil {
-				logger.Log("error while applying template with value %s", err.Error())
+				logger.LogCtx(ctx, "error while applying template with value %s", err.Error())
 			}
 
 			// return if status code is not 200
 			if resp.StatusCode() != 200 {
-				logger.Log("Boomi API responded with non 200, aborting...")
+				logger.LogCtx(ctx, "Boomi API responded with non 200, aborting...")
 				return
 			}
 
 			var asyncAtomDiskspaceTokenResult AsyncAtomDiskspaceTokenResult
 			jsonErr := json.Unmarshal(resp.Body(), &asyncAtomDiskspaceTokenResult)
 			if jsonErr != nil {
-				logger.Log("error while unmarshalling response %s", jsonErr.Error())
+				logger.LogCtx(ctx, "error while unmarshalling response %s", jsonErr.Error())
 				return
 			}
-			logger.Log("asyncAtomDiskspaceTokenResult %v", asyncAtomDiskspaceTokenResult)
+			logger.LogCtx(ctx, "asyncAtomDiskspaceTokenResult %v", asyncAtomDiskspaceTokenResult)
 		}
 
 	}
@@ -1,14 +1,26 @@
 package capture
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
+	"time"
 
 	"yc-agent/internal/capture/executils"
+	"yc-agent/internal/config"
 	"yc-agent/internal/logger"
+
+	"github.com/rs/zerolog"
 )
 
+// PartialResultTimeout is how long a task stopped on timeout or cancellation is
+// given to transmit what it captured so far, before it's abandoned.
+var PartialResultTimeout = 30 * time.Second
+
 type Result struct {
 	Msg string
 	Ok  bool
@@ -17,8 +29,19 @@ type Result struct {
 type Capture struct {
 	Cmd               executils.CmdManager
 	endpoint          string
+	dir               string
 	wg                sync.WaitGroup
 	mapEndpointParams map[string]string
+	manifest          *Manifest
+	collector         string
+	method            string
+	start             time.Time
+	events            EventFunc
+	log               *zerolog.Logger
+
+	killMtx sync.Mutex
+	killed  bool
+	cancel  context.CancelFunc
 }
 
 func (cap *Capture) DoneWaitGroup() {
@@ -43,13 +66,54 @@ func (cap *Capture) Interrupt() error {
 	return cap.Cmd.Interrupt()
 }
 
+// Kill stops the task: the context returned by killable is cancelled, and the command is killed.
 func (cap *Capture) Kill() error {
+	cap.killMtx.Lock()
+	cap.killed = true
+	if cap.cancel != nil {
+		cap.cancel()
+	}
+	cap.killMtx.Unlock()
+
 	if cap.Cmd == nil {
 		return nil
 	}
 	return cap.Cmd.Kill()
 }
 
+// killable returns a context of ctx which is also cancelled by Kill, even if Kill
+// was called before. The tasks run their commands and samplers with it, so that
+// Kill stops them whatever they are doing.
+func (cap *Capture) killable(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	cap.killMtx.Lock()
+	defer cap.killMtx.Unlock()
+	if cap.killed {
+		cancel()
+	}
+	cap.cancel = cancel
+	return ctx, cancel
+}
+
+// SetLogger sets the log of the capture the task is part of.
+func (cap *Capture) SetLogger(l *zerolog.Logger) {
+	cap.log = l
+}
+
+// Logger returns the log of the capture the task is part of, the agent log if none.
+func (cap *Capture) Logger() *zerolog.Logger {
+	if cap.log == nil {
+		return logger.GetLogger()
+	}
+	return cap.log
+}
+
+// Log logs to the log of the capture the task is part of.
+func (cap *Capture) Log(format string, values ...interface{}) {
+	cap.Logger().Info().Msgf(format, values...)
+}
+
 func (cap *Capture) Endpoint() string {
 	if len(cap.mapEndpointParams) == 0 {
 		return cap.endpoint
@@ -75,6 +139,24 @@ func (cap *Capture) SetEndpoint(endpoint string) {
 	cap.endpoint = endpoint
 }
 
+// SetDir sets the directory the task writes its output files to, the current
+// directory if empty.
+func (cap *Capture) SetDir(dir string) {
+	cap.dir = dir
+}
+
+func (cap *Capture) Dir() string {
+	return cap.dir
+}
+
+// OutputPath returns the path of the output file name in the directory of the task.
+func (cap *Capture) OutputPath(name string) string {
+	if cap.dir == "" {
+		return name
+	}
+	return filepath.Join(cap.dir, name)
+}
+
 func (cap *Capture) SetEndpointParam(name, value string) {
 	if cap.mapEndpointParams == nil {
 		cap.mapEndpointParams = make(map[string]string)
@@ -91,22 +173,26 @@ func (cap *Capture) RemoveEndpointParam(name string) {
 
 type Task interface {
 	SetEndpoint(endpoint string)
+	SetDir(dir string)
+	SetManifest(m *Manifest, collector string)
+	SetEvents(fn EventFunc)
+	SetLogger(l *zerolog.Logger)
 	SetEndpointParam(name, value string)
 	RemoveEndpointParam(name string)
-	Run() (result Result, err error)
+	Run(ctx context.Context) (result Result, err error)
 	Kill() error
 	InitWaitGroup()
 	DoneWaitGroup()
 	WaitWaitGroup()
 }
 
-func WrapRun(task Task) func(endpoint string, c chan Result) {
-	return func(endpoint string, c chan Result) {
+func WrapRun(task Task) func(ctx context.Context, endpoint string, c chan Result) {
+	return func(ctx context.Context, endpoint string, c chan Result) {
 		var err error
 		var result Result
 		defer func() {
 			if err != nil {
-				logger.Log("capture %#v failed: %+v", task, err)
+				logger.LogCtx(ctx, "capture %#v failed: %+v", task, err)
 				result.Msg = fmt.Sprintf("capture failed: %s", err.Error())
 			}
 			c <- result
@@ -114,22 +200,103 @@ func WrapRun(task Task) func(endpoint string, c chan Result) {
 			task.DoneWaitGroup()
 		}()
 		task.SetEndpoint(endpoint)
+		task.SetLogger(logger.Ctx(ctx))
 		task.InitWaitGroup()
-		result, err = task.Run()
+		result, err = RunWithTimeout(ctx, task)
 	}
 }
 
-func (cap *Capture) Run() (result Result, err error) {
+func (cap *Capture) Run(ctx context.Context) (result Result, err error) {
 	return
 }
 
-func GoCapture(endpoint string, fn func(endpoint string, c chan Result), wait ...Task) (c chan Result) {
+// TaskName returns the name of the task used by the taskTimeouts config,
+// the lowercased name of its type, for example: jstack, threaddump, heapdump.
+func TaskName(task Task) string {
+	t := reflect.TypeOf(task)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return strings.ToLower(t.Name())
+}
+
+// TaskTimeout returns the timeout of the task, 0 for none.
+func TaskTimeout(task Task) time.Duration {
+	if timeout, ok := config.GlobalConfig.TaskTimeouts[TaskName(task)]; ok {
+		return timeout
+	}
+	if _, ok := task.(*HeapDump); ok {
+		// Dumping and uploading a big heap legitimately takes long
+		return 0
+	}
+	if jfr, ok := task.(*JFR); ok && config.GlobalConfig.CaptureTimeout > 0 {
+		// The recording lasts its duration before it's dumped and uploaded
+		return jfr.Duration + config.GlobalConfig.CaptureTimeout
+	}
+	if td, ok := task.(*ThreadDump); ok && config.GlobalConfig.CaptureTimeout > 0 {
+		// The thread dumps are sampled over the capture duration
+		return td.TdCaptureDuration + config.GlobalConfig.CaptureTimeout
+	}
+	return config.GlobalConfig.CaptureTimeout
+}
+
+// RunWithTimeout runs the task until it completes, its timeout expires or ctx is done.
+// A task still running then is killed, and its partial result is returned if it
+// completes within PartialResultTimeout. Otherwise it's abandoned, so a stuck
+// task can't hang the whole capture.
+func RunWithTimeout(ctx context.Context, task Task) (result Result, err error) {
+	timeout := TaskTimeout(task)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	type outcome struct {
+		result Result
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := task.Run(ctx)
+		done <- outcome{result, err}
+	}()
+
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-ctx.Done():
+	}
+
+	name := TaskName(task)
+	reason := "cancelled"
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		reason = fmt.Sprintf("timed out after %s", timeout)
+	}
+	logger.LogCtx(ctx, "capture %s %s, stopping it", name, reason)
+	if e := task.Kill(); e != nil {
+		logger.LogCtx(ctx, "failed to kill capture %s: %s", name, e)
+	}
+
+	select {
+	case o := <-done:
+		if o.err != nil {
+			return o.result, fmt.Errorf("%s %s: %w", name, reason, o.err)
+		}
+		o.result.Msg = fmt.Sprintf("%s %s, partial result:\n%s", name, reason, o.result.Msg)
+		return o.result, nil
+	case <-time.After(PartialResultTimeout):
+		return Result{}, fmt.Errorf("%s %s and didn't stop in %s", name, reason, PartialResultTimeout)
+	}
+}
+
+func GoCapture(ctx context.Context, endpoint string, fn func(ctx context.Context, endpoint string, c chan Result), wait ...Task) (c chan Result) {
 	c = make(chan Result)
 	go func() {
 		for _, task := range wait {
 			task.WaitWaitGroup()
 		}
-		fn(endpoint, c)
+		fn(ctx, endpoint, c)
 	}()
 	return
 }
@@ -0,0 +1,97 @@
+package capture
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"yc-agent/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingTask blocks until it's killed, or forever if stuck.
+type blockingTask struct {
+	Capture
+	stuck  bool
+	killed chan struct{}
+}
+
+func newBlockingTask(stuck bool) *blockingTask {
+	return &blockingTask{stuck: stuck, killed: make(chan struct{})}
+}
+
+func (b *blockingTask) Run(ctx context.Context) (Result, error) {
+	if b.stuck {
+		select {}
+	}
+	<-b.killed
+	return Result{Msg: "captured so far", Ok: true}, nil
+}
+
+func (b *blockingTask) Kill() error {
+	close(b.killed)
+	return nil
+}
+
+func withTaskTimeouts(t *testing.T, captureTimeout time.Duration, taskTimeouts config.TaskTimeouts) {
+	previous := config.GlobalConfig.Options
+	previousPartial := PartialResultTimeout
+	t.Cleanup(func() {
+		config.GlobalConfig.Options = previous
+		PartialResultTimeout = previousPartial
+	})
+	config.GlobalConfig.CaptureTimeout = captureTimeout
+	config.GlobalConfig.TaskTimeouts = taskTimeouts
+	PartialResultTimeout = 100 * time.Millisecond
+}
+
+func TestRunWithTimeout_PartialResult(t *testing.T) {
+	withTaskTimeouts(t, 50*time.Millisecond, nil)
+
+	result, err := RunWithTimeout(context.Background(), newBlockingTask(false))
+	require.NoError(t, err)
+	assert.True(t, result.Ok)
+	assert.Contains(t, result.Msg, "blockingtask timed out after 50ms")
+	assert.Contains(t, result.Msg, "captured so far")
+}
+
+func TestRunWithTimeout_AbandonsStuckTask(t *testing.T) {
+	withTaskTimeouts(t, 0, config.TaskTimeouts{"blockingtask": 50 * time.Millisecond})
+
+	start := time.Now()
+	c := GoCapture(context.Background(), "http://localhost/ycrash-receiver?de=localhost", WrapRun(newBlockingTask(true)))
+	result := <-c
+	assert.False(t, result.Ok)
+	assert.Contains(t, result.Msg, "didn't stop")
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestRunWithTimeout_Cancelled(t *testing.T) {
+	withTaskTimeouts(t, 0, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	result, err := RunWithTimeout(ctx, newBlockingTask(false))
+	require.NoError(t, err)
+	assert.Contains(t, result.Msg, "blockingtask cancelled")
+}
+
+func TestTaskTimeout(t *testing.T) {
+	withTaskTimeouts(t, time.Minute, config.TaskTimeouts{"ping": time.Second})
+
+	assert.Equal(t, time.Second, TaskTimeout(&Ping{}))
+	assert.Equal(t, time.Minute, TaskTimeout(&JStack{}))
+	assert.Equal(t, time.Duration(0), TaskTimeout(&HeapDump{}), "heap dump has no timeout by default")
+	assert.Equal(t, time.Minute+5*time.Minute, TaskTimeout(&JFR{Duration: 5 * time.Minute}), "the recording lasts its duration")
+	assert.Equal(t, time.Minute+15*time.Minute, TaskTimeout(&ThreadDump{TdCaptureDuration: 15 * time.Minute}), "the thread dumps are sampled over the capture duration")
+	assert.Equal(t, time.Minute, TaskTimeout(&ThreadDump{}))
+
+	config.GlobalConfig.TaskTimeouts["heapdump"] = time.Hour
+	assert.Equal(t, time.Hour, TaskTimeout(&HeapDump{}))
+}
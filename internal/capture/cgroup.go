@@ -0,0 +1,193 @@
+package capture
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"yc-agent/internal/capture/executils"
+	"yc-agent/internal/capture/procfs"
+)
+
+const (
+	cgroupOutputPath = "cgroup.json"
+	cgroupCount      = 5 // Default count, like vmstat
+)
+
+// CGroup handles the capture of the resource usage and the limits of the
+// cgroup of the process, sampled over the capture window: memory, OOM events,
+// CPU quota and throttling, pids and IO.
+type CGroup struct {
+	Capture
+	Pid int
+	// Interval is the time between the samples, VMSTAT_INTERVAL if 0.
+	Interval time.Duration
+	// Count is the number of samples, cgroupCount if 0.
+	Count int
+}
+
+// CGroupReport is the captured cgroup of the process.
+type CGroupReport struct {
+	Pid     int    `json:"pid"`
+	Version int    `json:"version"`
+	Path    string `json:"path"`
+	// Samples are taken every interval, the first at the start of the capture.
+	Samples []*procfs.CGroupStats `json:"samples"`
+	Summary CGroupSummary         `json:"summary"`
+}
+
+// CGroupSummary is the usage of the cgroup between the first and the last samples.
+type CGroupSummary struct {
+	Seconds float64 `json:"seconds"`
+	// CPUs is the average number of CPUs used, CPULimit the CPUs the quota
+	// allows, -1 if unlimited.
+	CPUs     float64 `json:"cpus"`
+	CPULimit float64 `json:"cpuLimit"`
+	// Throttled are the enforcement periods, out of Periods, in which the
+	// cgroup exhausted its quota.
+	Periods          uint64  `json:"nrPeriods"`
+	Throttled        uint64  `json:"nrThrottled"`
+	ThrottledRatio   float64 `json:"throttledRatio"`
+	ThrottledSeconds float64 `json:"throttledSeconds"`
+	OOMKills         uint64  `json:"oomKills"`
+	// MaxMemory is the highest memory usage sampled.
+	MaxMemory uint64 `json:"maxMemory"`
+}
+
+// Run executes the cgroup capture process and uploads the captured file
+// to the specified endpoint.
+func (c *CGroup) Run(ctx context.Context) (Result, error) {
+	if runtime.GOOS != "linux" {
+		return Result{Msg: "skipped capturing cgroup, only supported on linux", Ok: false}, nil
+	}
+
+	capturedFile, err := c.captureToFile(ctx)
+	if err != nil {
+		return Result{Msg: err.Error(), Ok: false}, err
+	}
+	defer capturedFile.Close()
+
+	result := c.UploadCapturedFile(capturedFile)
+	return result, nil
+}
+
+// CaptureToFile captures the cgroup of the process to a file.
+// It returns the file handle for the captured data.
+func (c *CGroup) CaptureToFile() (*os.File, error) {
+	return c.captureToFile(context.Background())
+}
+
+func (c *CGroup) captureToFile(ctx context.Context) (*os.File, error) {
+	report, err := c.sample(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(c.OutputPath(cgroupOutputPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write cgroup report: %w", err)
+	}
+
+	if err := file.Sync(); err != nil {
+		c.Log("warning: failed to sync file: %v", err)
+	}
+
+	return file, nil
+}
+
+// sample reads the cgroup of the process every interval. Once ctx is done, the
+// samples taken so far are reported.
+func (c *CGroup) sample(ctx context.Context) (*CGroupReport, error) {
+	interval, count := c.Interval, c.Count
+	if interval <= 0 {
+		interval = time.Duration(executils.VMSTAT_INTERVAL) * time.Second
+	}
+	if count <= 0 {
+		count = cgroupCount
+	}
+
+	cg, err := procFS().CGroup(c.Pid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve the cgroup of process %d: %w", c.Pid, err)
+	}
+	report := &CGroupReport{Pid: c.Pid, Version: cg.Version, Path: cg.Path}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+sampling:
+	for len(report.Samples) < count {
+		if len(report.Samples) > 0 {
+			select {
+			case <-ctx.Done():
+				c.Log("cgroup capture stopped after %d samples: %s", len(report.Samples), ctx.Err())
+				break sampling
+			case <-ticker.C:
+			}
+		}
+		stats, err := cg.Stats()
+		if err != nil {
+			if len(report.Samples) == 0 {
+				return nil, fmt.Errorf("failed to read the cgroup %s: %w", cg.Path, err)
+			}
+			c.Log("failed to read the cgroup %s: %s", cg.Path, err.Error())
+			break
+		}
+		report.Samples = append(report.Samples, stats)
+	}
+	report.Summary = summarizeCGroup(report.Samples)
+	return report, nil
+}
+
+// summarizeCGroup returns the usage of the cgroup between the first and the last samples.
+func summarizeCGroup(samples []*procfs.CGroupStats) CGroupSummary {
+	summary := CGroupSummary{CPULimit: -1}
+	if len(samples) == 0 {
+		return summary
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	if last.CPU.Quota >= 0 && last.CPU.Period > 0 {
+		summary.CPULimit = float64(last.CPU.Quota) / float64(last.CPU.Period)
+	}
+	for _, s := range samples {
+		summary.MaxMemory = max(summary.MaxMemory, s.Memory.Usage)
+	}
+
+	summary.Seconds = last.Time.Sub(first.Time).Seconds()
+	if summary.Seconds > 0 {
+		summary.CPUs = float64(counterDelta(last.CPU.Usage, first.CPU.Usage)) / 1e6 / summary.Seconds
+	}
+	summary.Periods = counterDelta(last.CPU.Periods, first.CPU.Periods)
+	summary.Throttled = counterDelta(last.CPU.Throttled, first.CPU.Throttled)
+	if summary.Periods > 0 {
+		summary.ThrottledRatio = float64(summary.Throttled) / float64(summary.Periods)
+	}
+	summary.ThrottledSeconds = float64(counterDelta(last.CPU.ThrottledTime, first.CPU.ThrottledTime)) / 1e6
+	summary.OOMKills = counterDelta(last.Memory.Events["oom_kill"], first.Memory.Events["oom_kill"])
+	return summary
+}
+
+// counterDelta returns the increase of a counter, 0 if it was reset.
+func counterDelta(cur, prev uint64) uint64 {
+	if cur < prev {
+		return 0
+	}
+	return cur - prev
+}
+
+// UploadCapturedFile uploads the captured file to the configured endpoint.
+func (c *CGroup) UploadCapturedFile(file *os.File) Result {
+	msg, ok := c.PostData("cgroup", file)
+	return Result{
+		Msg: msg,
+		Ok:  ok,
+	}
+}
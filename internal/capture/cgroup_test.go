@@ -0,0 +1,81 @@
+package capture
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"yc-agent/internal/capture/procfs"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCGroup_CaptureToFile(t *testing.T) {
+	withFixtureProcFS(t)
+
+	c := &CGroup{Pid: 42, Interval: time.Millisecond, Count: 3}
+	c.SetDir(t.TempDir())
+	file, err := c.CaptureToFile()
+	require.NoError(t, err)
+	defer file.Close()
+
+	data, err := os.ReadFile(file.Name())
+	require.NoError(t, err)
+	var report CGroupReport
+	require.NoError(t, json.Unmarshal(data, &report))
+	assert.Equal(t, 2, report.Version)
+	assert.Equal(t, "/kubepods/pod1/app", report.Path)
+	require.Len(t, report.Samples, 3)
+	assert.EqualValues(t, 250, report.Samples[0].CPU.Throttled)
+	assert.EqualValues(t, 2, report.Summary.CPULimit)
+	assert.EqualValues(t, 536870912, report.Summary.MaxMemory)
+
+	// The samples taken before ctx is done are kept
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c = &CGroup{Pid: 1, Interval: time.Hour, Count: 3}
+	c.SetDir(t.TempDir())
+	file, err = c.captureToFile(ctx)
+	require.NoError(t, err)
+	file.Close()
+	data, err = os.ReadFile(file.Name())
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &report))
+	assert.Equal(t, 1, report.Version)
+	assert.Len(t, report.Samples, 1)
+
+	c = &CGroup{Pid: 2}
+	c.SetDir(t.TempDir())
+	_, err = c.CaptureToFile()
+	assert.Error(t, err)
+}
+
+func TestSummarizeCGroup(t *testing.T) {
+	start := time.Unix(1699960000, 0)
+	first := &procfs.CGroupStats{
+		Time:   start,
+		Memory: procfs.CGroupMemory{Usage: 100, Events: map[string]uint64{"oom_kill": 1}},
+		CPU:    procfs.CGroupCPU{Quota: 50000, Period: 100000, Usage: 1000000, Periods: 100, Throttled: 10, ThrottledTime: 500000},
+	}
+	last := &procfs.CGroupStats{
+		Time:   start.Add(10 * time.Second),
+		Memory: procfs.CGroupMemory{Usage: 80, Events: map[string]uint64{"oom_kill": 3}},
+		CPU:    procfs.CGroupCPU{Quota: 50000, Period: 100000, Usage: 6000000, Periods: 200, Throttled: 60, ThrottledTime: 2500000},
+	}
+	assert.Equal(t, CGroupSummary{
+		Seconds:          10,
+		CPUs:             0.5,
+		CPULimit:         0.5,
+		Periods:          100,
+		Throttled:        50,
+		ThrottledRatio:   0.5,
+		ThrottledSeconds: 2,
+		OOMKills:         2,
+		MaxMemory:        100,
+	}, summarizeCGroup([]*procfs.CGroupStats{first, last}))
+
+	assert.Equal(t, CGroupSummary{CPULimit: -1}, summarizeCGroup(nil))
+}
@@ -0,0 +1,413 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"yc-agent/internal/capture/httpclient"
+	"yc-agent/internal/capture/spool"
+	"yc-agent/internal/config"
+	"yc-agent/internal/logger"
+
+	"github.com/rs/zerolog"
+)
+
+// The chunked upload protocol sends a file to a receiver endpoint in fixed-size parts,
+// all requests are POSTed to the endpoint with the chunked parameter:
+//
+//	chunked=init&sessionId=<id>&size=<file size>&partSize=<part size>&parts=<part count>
+//	chunked=status&sessionId=<id>      responds {"parts":[<acknowledged part numbers>]}, 404 if unknown
+//	chunked=part&sessionId=<id>&part=<n>&sha256=<hex of the body>
+//	chunked=complete&sessionId=<id>&parts=<part count>
+//
+// Part n holds the bytes [n*partSize, (n+1)*partSize) of the file. When compressed,
+// every part is compressed on its own as a gzip member or zstd frame, and the
+// receiver concatenates the parts into a valid compressed stream. So a part can be
+// resent without compressing the parts before it again.
+const (
+	chunkedInit     = "init"
+	chunkedStatus   = "status"
+	chunkedPart     = "part"
+	chunkedComplete = "complete"
+
+	chunkedSessionsDir = "yc-upload-sessions"
+
+	chunkedRetryBaseDelay = time.Second
+	chunkedRetryMaxDelay  = 30 * time.Second
+)
+
+var errChunkedSessionNotFound = errors.New("chunked upload session not found")
+
+// ChunkedUpload uploads a file in parts and resumes from the last acknowledged
+// part after a failure or an agent restart.
+type ChunkedUpload struct {
+	PartSize int64
+	// Retries is the number of times a failed request is retried before giving up.
+	Retries int
+	// Compression compresses every part on its own, empty for none.
+	Compression string
+	// StateDir keeps the sessions of unfinished uploads, to resume them when the same file is uploaded again.
+	StateDir string
+	// Progress is notified of the bytes of the file uploaded after every part, if not nil.
+	Progress func(uploaded, total int64)
+	// Log is the log of the capture the file is part of, the agent log if nil.
+	Log *zerolog.Logger
+
+	client *http.Client
+}
+
+// log returns the log of the upload, see Log.
+func (c *ChunkedUpload) log() *zerolog.Logger {
+	if c.Log == nil {
+		return logger.GetLogger()
+	}
+	return c.Log
+}
+
+// chunkedSession is the local state of an unfinished upload.
+type chunkedSession struct {
+	SessionID   string    `json:"sessionId"`
+	URL         string    `json:"url"`
+	File        string    `json:"file"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"modTime"`
+	PartSize    int64     `json:"partSize"`
+	Compression string    `json:"compression,omitempty"`
+	Acked       []int     `json:"acked"`
+}
+
+// NewChunkedUpload creates a ChunkedUpload with the chunked upload options of the config.
+func NewChunkedUpload(compression string) *ChunkedUpload {
+	return &ChunkedUpload{
+		PartSize:    int64(config.GlobalConfig.HDChunkSize) * 1024 * 1024,
+		Retries:     config.GlobalConfig.HDChunkRetries,
+		Compression: compression,
+		StateDir:    uploadStatePath(chunkedSessionsDir),
+	}
+}
+
+// Upload uploads file to url in parts, resuming the session of a previous upload
+// of the same file to the same receiver if any. It gives up when ctx is done.
+func (c *ChunkedUpload) Upload(ctx context.Context, url string, file *os.File) (msg string, ok bool) {
+	if c.PartSize <= 0 {
+		return "invalid chunked upload part size", false
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Sprintf("file stat err %s", err.Error()), false
+	}
+
+	if c.client == nil {
+		c.client, err = httpclient.Shared()
+		if err != nil {
+			return err.Error(), false
+		}
+	}
+
+	session, err := c.session(ctx, url, file, stat)
+	if err != nil {
+		return fmt.Sprintf("chunked upload session err %s", err.Error()), false
+	}
+
+	parts := int((session.Size + session.PartSize - 1) / session.PartSize)
+	acked := make(map[int]bool, len(session.Acked))
+	for _, n := range session.Acked {
+		acked[n] = true
+	}
+	if len(acked) > 0 {
+		c.log().Info().Msgf("resuming chunked upload %s of %s from %d/%d acknowledged parts", session.SessionID, session.File, len(acked), parts)
+	}
+
+	for n := 0; n < parts; n++ {
+		if acked[n] {
+			continue
+		}
+		if ctx.Err() != nil {
+			return fmt.Sprintf("chunked upload %s stopped at part %d/%d: %s",
+				session.SessionID, n, parts, ctx.Err().Error()), false
+		}
+		err = c.sendPart(ctx, session, file, n)
+		if err != nil {
+			return fmt.Sprintf("chunked upload %s failed at part %d/%d: %s",
+				session.SessionID, n, parts, err.Error()), false
+		}
+		session.Acked = append(session.Acked, n)
+		c.saveSession(session)
+		if c.Progress != nil {
+			c.Progress(min(int64(len(session.Acked))*session.PartSize, session.Size), session.Size)
+		}
+	}
+
+	var statusCode int
+	var body []byte
+	err = c.retry(ctx, func() error {
+		statusCode, body, err = c.post(session.URL, chunkedParams(chunkedComplete, session.SessionID, "parts", strconv.Itoa(parts)), nil)
+		return err
+	})
+	if err != nil {
+		return fmt.Sprintf("chunked upload %s failed to complete: %s", session.SessionID, err.Error()), false
+	}
+
+	c.removeSession(session)
+	return fmt.Sprintf("%s\nchunked upload %s of %d parts\nstatus code %d\n%s", session.URL, session.SessionID, parts, statusCode, body), true
+}
+
+// session resumes the session of a previous upload of the same file, or starts a new one.
+func (c *ChunkedUpload) session(ctx context.Context, url string, file *os.File, stat os.FileInfo) (*chunkedSession, error) {
+	name, err := filepath.Abs(file.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := c.loadSession(url, name, stat.Size(), stat.ModTime())
+	if err == nil {
+		acked, err := c.status(ctx, s)
+		if err == nil {
+			s.Acked = acked
+			return s, nil
+		}
+		if !errors.Is(err, errChunkedSessionNotFound) {
+			c.log().Info().Msgf("failed to get the status of chunked upload %s, resuming from the local state: %s", s.SessionID, err)
+			return s, nil
+		}
+		c.log().Info().Msgf("chunked upload %s is unknown to the receiver, starting over", s.SessionID)
+		c.removeSession(s)
+	}
+
+	id, err := newChunkedSessionID()
+	if err != nil {
+		return nil, err
+	}
+	s = &chunkedSession{
+		SessionID:   id,
+		URL:         url,
+		File:        name,
+		Size:        stat.Size(),
+		ModTime:     stat.ModTime(),
+		PartSize:    c.PartSize,
+		Compression: c.Compression,
+	}
+	parts := (s.Size + s.PartSize - 1) / s.PartSize
+
+	err = c.retry(ctx, func() error {
+		_, _, err := c.post(s.URL, chunkedParams(chunkedInit, s.SessionID,
+			"size", strconv.FormatInt(s.Size, 10),
+			"partSize", strconv.FormatInt(s.PartSize, 10),
+			"parts", strconv.FormatInt(parts, 10)), nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.saveSession(s)
+	return s, nil
+}
+
+// status returns the parts of the session acknowledged by the receiver.
+func (c *ChunkedUpload) status(ctx context.Context, s *chunkedSession) ([]int, error) {
+	var body []byte
+	err := c.retry(ctx, func() error {
+		statusCode, b, err := c.post(s.URL, chunkedParams(chunkedStatus, s.SessionID), nil)
+		if statusCode == http.StatusNotFound {
+			return errChunkedSessionNotFound
+		}
+		body = b
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	status := struct {
+		Parts []int `json:"parts"`
+	}{}
+	err = json.Unmarshal(body, &status)
+	if err != nil {
+		return nil, fmt.Errorf("invalid status response: %w", err)
+	}
+	return status.Parts, nil
+}
+
+func (c *ChunkedUpload) sendPart(ctx context.Context, s *chunkedSession, file *os.File, n int) error {
+	offset := int64(n) * s.PartSize
+	size := s.PartSize
+	if offset+size > s.Size {
+		size = s.Size - offset
+	}
+
+	var buf bytes.Buffer
+	var err error
+	src := io.NewSectionReader(file, offset, size)
+	if s.Compression != "" {
+		var enc io.WriteCloser
+		enc, err = newCompressWriter(&buf, s.Compression)
+		if err == nil {
+			_, err = io.Copy(enc, src)
+			if e := enc.Close(); err == nil {
+				err = e
+			}
+		}
+	} else {
+		_, err = io.Copy(&buf, src)
+	}
+	if err != nil {
+		return fmt.Errorf("read part err %w", err)
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	params := chunkedParams(chunkedPart, s.SessionID, "part", strconv.Itoa(n), "sha256", hex.EncodeToString(sum[:]))
+
+	return c.retry(ctx, func() error {
+		_, _, err := c.post(s.URL, params, buf.Bytes())
+		return err
+	})
+}
+
+// post posts body to the url with params, and returns an error unless the receiver responds 200.
+func (c *ChunkedUpload) post(url, params string, body []byte) (statusCode int, respBody []byte, err error) {
+	statusCode, respBody, err = postBody(c.client, url+"&"+params, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	if statusCode != http.StatusOK {
+		err = fmt.Errorf("status code %d\n%s", statusCode, respBody)
+	}
+	return
+}
+
+// retry calls f until it succeeds, it failed Retries+1 times, or ctx is done.
+func (c *ChunkedUpload) retry(ctx context.Context, f func() error) (err error) {
+	for attempt := 0; ; attempt++ {
+		err = f()
+		if err == nil || errors.Is(err, errChunkedSessionNotFound) || attempt >= c.Retries {
+			return
+		}
+		delay := spool.Backoff(attempt, chunkedRetryBaseDelay, chunkedRetryMaxDelay)
+		c.log().Info().Msgf("chunked upload request failed, retrying in %s: %s", delay, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %v", ctx.Err(), err)
+		case <-time.After(delay):
+		}
+	}
+}
+
+func chunkedParams(step, sessionID string, kvs ...string) string {
+	params := fmt.Sprintf("chunked=%s&sessionId=%s", step, sessionID)
+	for i := 0; i+1 < len(kvs); i += 2 {
+		params += "&" + kvs[i] + "=" + kvs[i+1]
+	}
+	return params
+}
+
+// sessionPath returns the path of the local state of the upload of file to url.
+// A changed file, part size or compression makes a new session.
+func (c *ChunkedUpload) sessionPath(url, file string, size int64, modTime time.Time) string {
+	key := fmt.Sprintf("%s\n%s\n%d\n%d\n%d\n%s", receiverURL(url), file, size, modTime.UnixNano(), c.PartSize, c.Compression)
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.StateDir, hex.EncodeToString(sum[:16])+".json")
+}
+
+func (c *ChunkedUpload) loadSession(url, file string, size int64, modTime time.Time) (*chunkedSession, error) {
+	b, err := os.ReadFile(c.sessionPath(url, file, size, modTime))
+	if err != nil {
+		return nil, err
+	}
+	s := &chunkedSession{}
+	err = json.Unmarshal(b, s)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (c *ChunkedUpload) saveSession(s *chunkedSession) {
+	err := os.MkdirAll(c.StateDir, 0700)
+	if err != nil {
+		c.log().Warn().Err(err).Msg("failed to create chunked upload state dir")
+		return
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return
+	}
+	path := c.sessionPath(s.URL, s.File, s.Size, s.ModTime)
+	err = os.WriteFile(path+".tmp", b, 0600)
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		c.log().Warn().Err(err).Msg("failed to save chunked upload state")
+	}
+}
+
+// moveSession moves the session of the upload of file to url, if any, to the file at
+// path, a link or a copy of file, so that an upload of path resumes it.
+func (c *ChunkedUpload) moveSession(url string, file *os.File, path string) error {
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	name, err := filepath.Abs(file.Name())
+	if err != nil {
+		return err
+	}
+	s, err := c.loadSession(url, name, stat.Size(), stat.ModTime())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	moved, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	c.removeSession(s)
+	s.File, err = filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	s.ModTime = moved.ModTime()
+	c.saveSession(s)
+	return nil
+}
+
+func (c *ChunkedUpload) removeSession(s *chunkedSession) {
+	err := os.Remove(c.sessionPath(s.URL, s.File, s.Size, s.ModTime))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		c.log().Warn().Err(err).Msg("failed to remove chunked upload state")
+	}
+}
+
+// receiverURL strips the query of url, which holds the timestamp of the capture,
+// so an upload of the same file by a later run resumes the session.
+func receiverURL(url string) string {
+	u, _, _ := strings.Cut(url, "?")
+	return u
+}
+
+func newChunkedSessionID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
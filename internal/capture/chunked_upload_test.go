@@ -0,0 +1,278 @@
+package capture
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"yc-agent/internal/capture/spool"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chunkedReceiver is a minimal receiver of the chunked upload protocol.
+type chunkedReceiver struct {
+	mu       sync.Mutex
+	sessions map[string]map[int][]byte
+	// failPart makes the receiver reject the part with this number while failures > 0.
+	failPart int
+	failures int
+	sent     []int
+	complete []byte
+}
+
+func newChunkedReceiver() *chunkedReceiver {
+	return &chunkedReceiver{sessions: map[string]map[int][]byte{}, failPart: -1}
+}
+
+func (rc *chunkedReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	q := r.URL.Query()
+	id := q.Get("sessionId")
+	switch q.Get("chunked") {
+	case "init":
+		rc.sessions[id] = map[int][]byte{}
+	case "status":
+		parts, ok := rc.sessions[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		acked := []int{}
+		for n := range parts {
+			acked = append(acked, n)
+		}
+		_ = json.NewEncoder(w).Encode(map[string][]int{"parts": acked})
+	case "part":
+		n, _ := strconv.Atoi(q.Get("part"))
+		rc.sent = append(rc.sent, n)
+		if n == rc.failPart && rc.failures > 0 {
+			rc.failures--
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(b)
+		if hex.EncodeToString(sum[:]) != q.Get("sha256") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		rc.sessions[id][n] = b
+	case "complete":
+		parts := rc.sessions[id]
+		count, _ := strconv.Atoi(q.Get("parts"))
+		var buf bytes.Buffer
+		for n := 0; n < count; n++ {
+			b, ok := parts[n]
+			if !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, "missing part %d", n)
+				return
+			}
+			buf.Write(b)
+		}
+		rc.complete = buf.Bytes()
+		delete(rc.sessions, id)
+		fmt.Fprint(w, "received")
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func createHeapFile(t *testing.T, size int) (*os.File, string) {
+	content := strings.Repeat("JAVA PROFILE 1.0.2\x00", size/19+1)[:size]
+	file := createUploadFile(t, content)
+	return file, content
+}
+
+func TestChunkedUpload(t *testing.T) {
+	rc := newChunkedReceiver()
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	file, content := createHeapFile(t, 10*1024+7)
+
+	c := &ChunkedUpload{PartSize: 1024, StateDir: t.TempDir()}
+	msg, ok := c.Upload(context.Background(), server.URL+"/yc-receiver-heap?de=localhost&dt=hd", file)
+	require.True(t, ok, msg)
+
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, rc.sent)
+	assert.Equal(t, content, string(rc.complete))
+
+	files, err := os.ReadDir(c.StateDir)
+	require.NoError(t, err)
+	assert.Empty(t, files, "session state should be removed once completed")
+}
+
+func TestChunkedUpload_CompressesEveryPart(t *testing.T) {
+	rc := newChunkedReceiver()
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	file, content := createHeapFile(t, 5000)
+
+	c := &ChunkedUpload{PartSize: 1000, Compression: CompressionGzip, StateDir: t.TempDir()}
+	msg, ok := c.Upload(context.Background(), server.URL+"/yc-receiver-heap?de=localhost&dt=hd", file)
+	require.True(t, ok, msg)
+
+	// The concatenated gzip members make a valid gzip stream
+	gr, err := gzip.NewReader(bytes.NewReader(rc.complete))
+	require.NoError(t, err)
+	b, err := io.ReadAll(gr)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(b))
+}
+
+func TestChunkedUpload_RetriesFailedPart(t *testing.T) {
+	rc := newChunkedReceiver()
+	rc.failPart = 1
+	rc.failures = 1
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	file, content := createHeapFile(t, 3000)
+
+	c := &ChunkedUpload{PartSize: 1000, Retries: 1, StateDir: t.TempDir()}
+	msg, ok := c.Upload(context.Background(), server.URL+"/yc-receiver-heap?de=localhost&dt=hd", file)
+	require.True(t, ok, msg)
+
+	assert.Equal(t, []int{0, 1, 1, 2}, rc.sent)
+	assert.Equal(t, content, string(rc.complete))
+}
+
+func TestChunkedUpload_ResumesAfterFailure(t *testing.T) {
+	rc := newChunkedReceiver()
+	rc.failPart = 2
+	rc.failures = 1
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	stateDir := t.TempDir()
+	file, content := createHeapFile(t, 4000)
+
+	c := &ChunkedUpload{PartSize: 1000, StateDir: stateDir}
+	msg, ok := c.Upload(context.Background(), server.URL+"/yc-receiver-heap?de=localhost&ts=run1&dt=hd", file)
+	require.False(t, ok)
+	assert.Contains(t, msg, "failed at part 2/4")
+
+	files, err := os.ReadDir(stateDir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1, "session state should be kept to resume")
+
+	// A later run uploads the same file again, resuming after the acknowledged parts
+	rc.sent = nil
+	restarted := &ChunkedUpload{PartSize: 1000, StateDir: stateDir}
+	msg, ok = restarted.Upload(context.Background(), server.URL+"/yc-receiver-heap?de=localhost&ts=run2&dt=hd", file)
+	require.True(t, ok, msg)
+
+	assert.Equal(t, []int{2, 3}, rc.sent)
+	assert.Equal(t, content, string(rc.complete))
+}
+
+func TestChunkedUpload_StopsRetryingWhenCancelled(t *testing.T) {
+	rc := newChunkedReceiver()
+	rc.failPart = 1
+	rc.failures = 10
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The job is cancelled while part 1 fails
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc.ServeHTTP(w, r)
+		if r.URL.Query().Get("part") == "1" {
+			cancel()
+		}
+	}))
+	defer server.Close()
+
+	file, _ := createHeapFile(t, 3000)
+
+	c := &ChunkedUpload{PartSize: 1000, Retries: 10, StateDir: t.TempDir()}
+	start := time.Now()
+	msg, ok := c.Upload(ctx, server.URL+"/yc-receiver-heap?de=localhost&dt=hd", file)
+	require.False(t, ok)
+	assert.Contains(t, msg, context.Canceled.Error())
+	assert.Less(t, time.Since(start), chunkedRetryBaseDelay/2, "the backoff should not be waited out")
+	assert.Equal(t, []int{0, 1}, rc.sent)
+}
+
+func TestChunkedUpload_StartsOverUnknownSession(t *testing.T) {
+	rc := newChunkedReceiver()
+	rc.failPart = 1
+	rc.failures = 1
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	stateDir := t.TempDir()
+	file, content := createHeapFile(t, 2000)
+
+	c := &ChunkedUpload{PartSize: 1000, StateDir: stateDir}
+	_, ok := c.Upload(context.Background(), server.URL+"/yc-receiver-heap?de=localhost&dt=hd", file)
+	require.False(t, ok)
+
+	// The receiver lost the session, e.g. it was restarted
+	rc.sessions = map[string]map[int][]byte{}
+	rc.sent = nil
+
+	msg, ok := (&ChunkedUpload{PartSize: 1000, StateDir: stateDir}).Upload(context.Background(), server.URL+"/yc-receiver-heap?de=localhost&dt=hd", file)
+	require.True(t, ok, msg)
+	assert.Equal(t, []int{0, 1}, rc.sent)
+	assert.Equal(t, content, string(rc.complete))
+}
+
+func TestChunkedUpload_SpooledUploadResumes(t *testing.T) {
+	rc := newChunkedReceiver()
+	rc.failPart = 2
+	rc.failures = 1
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	uploadStateMtx.Lock()
+	uploadStateDir = t.TempDir()
+	uploadStateMtx.Unlock()
+	defer func() {
+		uploadStateMtx.Lock()
+		uploadStateDir = ""
+		uploadStateMtx.Unlock()
+	}()
+
+	s, err := spool.New(uploadStatePath(uploadSpoolDir), sendSpooledUpload)
+	require.NoError(t, err)
+	s.BaseDelay = 0
+	SetUploadSpool(s)
+	defer SetUploadSpool(nil)
+
+	file, content := createHeapFile(t, 4000)
+	url := server.URL + "/yc-receiver-heap?de=localhost&dt=hd"
+
+	c := &ChunkedUpload{PartSize: 1000, StateDir: uploadStatePath(chunkedSessionsDir)}
+	msg, ok := c.Upload(context.Background(), url, file)
+	require.False(t, ok)
+	msg = spoolChunkedUpload(c, url, file, msg)
+	assert.Equal(t, "queued", TransmissionStatus(ok, msg), msg)
+
+	// The capture removes the dump once it's done with it
+	require.NoError(t, os.Remove(file.Name()))
+
+	rc.sent = nil
+	s.Flush()
+
+	assert.Equal(t, []int{2, 3}, rc.sent, "the spooled upload should resume after the acknowledged parts")
+	assert.Equal(t, content, string(rc.complete))
+	assert.Equal(t, 0, s.Len())
+}
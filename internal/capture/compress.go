@@ -0,0 +1,93 @@
+package capture
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// ValidateCompression returns an error if compression is not one of the supported upload compressions.
+func ValidateCompression(compression string) error {
+	switch compression {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		return nil
+	}
+	return fmt.Errorf("unknown compression %q, should be one of %s, %s, %s", compression, CompressionNone, CompressionGzip, CompressionZstd)
+}
+
+// compressionExt returns the file extension of compression, which is how
+// the yCrash server expects the encoding in the content-encoding parameters.
+func compressionExt(compression string) string {
+	switch compression {
+	case CompressionGzip:
+		return "gz"
+	case CompressionZstd:
+		return "zstd"
+	}
+	return compression
+}
+
+func newCompressWriter(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	}
+	return nil, ValidateCompression(compression)
+}
+
+// newCompressingReader returns a reader of r compressed with compression.
+// The compression runs in a goroutine feeding an io.Pipe, so the compressed
+// data never touches the disk. Closing the reader stops the goroutine.
+func newCompressingReader(r io.Reader, compression string) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+	enc, err := newCompressWriter(pw, compression)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		_, err := io.Copy(enc, r)
+		if e := enc.Close(); err == nil {
+			err = e
+		}
+		_ = pw.CloseWithError(err)
+	}()
+
+	return pr, nil
+}
+
+// compressToTempFile writes the content of file from its current offset, compressed
+// with compression, to a temp file for the uploaders that can't stream.
+// The caller is responsible for closing and removing the returned file.
+func compressToTempFile(file *os.File, compression string) (*os.File, error) {
+	tmp, err := os.CreateTemp("", "yc-upload-*."+compressionExt(compression))
+	if err != nil {
+		return nil, err
+	}
+
+	enc, err := newCompressWriter(tmp, compression)
+	if err == nil {
+		_, err = io.Copy(enc, file)
+		if e := enc.Close(); err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	return tmp, nil
+}
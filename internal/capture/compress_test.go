@@ -0,0 +1,198 @@
+package capture
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"yc-agent/internal/capture/spool"
+	"yc-agent/internal/config"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decompress(t *testing.T, r io.Reader, compression string) string {
+	var dec io.Reader
+	switch compression {
+	case CompressionGzip:
+		gr, err := gzip.NewReader(r)
+		require.NoError(t, err)
+		dec = gr
+	case CompressionZstd:
+		zr, err := zstd.NewReader(r)
+		require.NoError(t, err)
+		defer zr.Close()
+		dec = zr
+	}
+	b, err := io.ReadAll(dec)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func setUploadCompression(t *testing.T, compression string) {
+	previous := config.GlobalConfig.UploadCompression
+	config.GlobalConfig.UploadCompression = compression
+	t.Cleanup(func() { config.GlobalConfig.UploadCompression = previous })
+}
+
+func TestNewCompressingReader(t *testing.T) {
+	content := strings.Repeat("0x00007f3a2c01e000 java.lang.Thread.run(Thread.java:750)\n", 10000)
+
+	for _, compression := range []string{CompressionGzip, CompressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			r, err := newCompressingReader(strings.NewReader(content), compression)
+			require.NoError(t, err)
+			defer r.Close()
+
+			assert.Equal(t, content, decompress(t, r, compression))
+		})
+	}
+
+	_, err := newCompressingReader(strings.NewReader(content), "lz4")
+	assert.Error(t, err)
+}
+
+func TestPostCompressedData(t *testing.T) {
+	content := strings.Repeat("2024-01-02T15:04:05.000+0000: [GC (Allocation Failure)]\n", 1000)
+
+	for _, compression := range []string{CompressionGzip, CompressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			setUploadCompression(t, compression)
+
+			var gotEncoding, gotParam, gotBody string
+			var gotLength int64
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotEncoding = r.Header.Get("Content-Encoding")
+				gotParam = r.URL.Query().Get("content-encoding")
+				gotLength = r.ContentLength
+				gotBody = decompress(t, r.Body, compression)
+			}))
+			defer server.Close()
+
+			file := createUploadFile(t, content)
+
+			msg, ok := PostCompressedData(server.URL+"/ycrash-receiver?de=localhost", "dt=gc", "content-encoding", file)
+			require.True(t, ok, msg)
+			assert.Empty(t, gotEncoding, "the compression is announced by the parameter alone")
+			assert.Equal(t, compressionExt(compression), gotParam)
+			assert.Equal(t, int64(-1), gotLength, "body should be streamed")
+			assert.Equal(t, content, gotBody)
+		})
+	}
+}
+
+func TestPostCompressedDataWithoutCompression(t *testing.T) {
+	setUploadCompression(t, CompressionNone)
+
+	var gotEncoding, gotQuery, gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotEncoding = r.Header.Get("Content-Encoding")
+		gotQuery = r.URL.RawQuery
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+	}))
+	defer server.Close()
+
+	file := createUploadFile(t, "gc log")
+
+	msg, ok := PostCompressedData(server.URL+"/ycrash-receiver?de=localhost", "dt=gc", "content-encoding", file)
+	require.True(t, ok, msg)
+	assert.Empty(t, gotEncoding)
+	assert.Equal(t, "de=localhost&dt=gc", gotQuery)
+	assert.Equal(t, "gc log", gotBody)
+}
+
+func TestPostCompressedDataQueuesCompressedData(t *testing.T) {
+	setUploadCompression(t, CompressionGzip)
+
+	var gotEncoding, gotBody string
+	available := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		gotEncoding = r.URL.Query().Get("Content-Encoding")
+		gotBody = decompress(t, r.Body, CompressionGzip)
+	}))
+	defer server.Close()
+
+	s, err := spool.New(t.TempDir(), sendSpooledUpload)
+	require.NoError(t, err)
+	s.BaseDelay = 0
+	SetUploadSpool(s)
+	defer SetUploadSpool(nil)
+
+	file := createUploadFile(t, "heap dump")
+
+	msg, ok := PostCompressedData(server.URL+"/yc-receiver-heap?de=localhost", "dt=hd", "Content-Encoding", file)
+	assert.Equal(t, "queued", TransmissionStatus(ok, msg), msg)
+
+	available = true
+	s.Flush()
+
+	assert.Equal(t, 0, s.Len())
+	assert.Equal(t, "gz", gotEncoding)
+	assert.Equal(t, "heap dump", gotBody)
+}
+
+// fileUploader records the upload of an uploader that can't stream compressed data.
+type fileUploader struct {
+	params string
+	data   []byte
+}
+
+func (f *fileUploader) Upload(endpoint, params string, file *os.File, position func(file *os.File) error) (string, bool) {
+	if err := position(file); err != nil {
+		return err.Error(), false
+	}
+	f.params = params
+	f.data, _ = io.ReadAll(file)
+	return "ok", true
+}
+
+func TestPostCompressedDataWithoutStreaming(t *testing.T) {
+	setUploadCompression(t, CompressionZstd)
+
+	u := &fileUploader{}
+	SetUploader(u)
+	defer SetUploader(&HTTPUploader{})
+
+	file := createUploadFile(t, "app log")
+
+	msg, ok := PostCompressedData("/ycrash-receiver?de=localhost", "dt=applog&logName=app.log", "content-encoding", file)
+	require.True(t, ok, msg)
+	assert.Equal(t, "dt=applog&logName=app.log&content-encoding=zstd", u.params)
+	assert.Equal(t, "app log", decompress(t, strings.NewReader(string(u.data)), CompressionZstd))
+}
+
+func TestPostCompressedDataToDir(t *testing.T) {
+	dir := t.TempDir()
+	u, err := NewDirUploader(dir)
+	require.NoError(t, err)
+	SetUploader(u)
+	defer SetUploader(&HTTPUploader{})
+
+	for compression, name := range map[string]string{CompressionGzip: "hd.gz", CompressionZstd: "hd.zst"} {
+		t.Run(compression, func(t *testing.T) {
+			setUploadCompression(t, compression)
+			file := createUploadFile(t, "heap dump")
+
+			msg, ok := PostCompressedData("/yc-receiver-heap?de=localhost&ts=ts1", "dt=hd", "Content-Encoding", file)
+			require.True(t, ok, msg)
+
+			// The compressed data is named after its encoding
+			data, err := os.Open(filepath.Join(dir, "localhost", "ts1", "yc-receiver-heap", name))
+			require.NoError(t, err)
+			defer data.Close()
+			assert.Equal(t, "heap dump", decompress(t, data, compression))
+		})
+	}
+}
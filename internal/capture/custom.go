@@ -1,6 +1,7 @@
 package capture
 
 import (
+	"context"
 	"fmt"
 	"os"
 
@@ -18,13 +19,13 @@ type Custom struct {
 
 // Run runs the capture by calling the specified command
 // Deprecated. No longer supported.
-func (c *Custom) Run() (result Result, err error) {
-	custom, err := os.Create(fmt.Sprintf("custom%d.out", c.Index))
+func (c *Custom) Run(ctx context.Context) (result Result, err error) {
+	custom, err := os.Create(c.OutputPath(fmt.Sprintf("custom%d.out", c.Index)))
 	if err != nil {
 		return
 	}
 	defer custom.Close()
-	c.Cmd, err = executils.CommandStartInBackgroundToWriter(custom, c.Command)
+	c.Cmd, err = executils.CommandStartInBackgroundToWriter(custom, c.Command, executils.ContextHooker{Ctx: ctx})
 	if err != nil {
 		return
 	}
@@ -34,6 +35,6 @@ func (c *Custom) Run() (result Result, err error) {
 		return
 	}
 	c.Cmd.Wait()
-	result.Msg, result.Ok = PostCustomData(c.Endpoint(), c.UrlParams, custom)
+	result.Msg, result.Ok = c.PostCustomData(c.UrlParams, custom)
 	return
 }
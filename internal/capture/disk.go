@@ -1,8 +1,11 @@
 package capture
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+
 	"yc-agent/internal/capture/executils"
 )
 
@@ -15,8 +18,8 @@ type Disk struct {
 }
 
 // Run collects and uploads the disk metrics collection.
-func (d *Disk) Run() (Result, error) {
-	file, err := d.CaptureToFile()
+func (d *Disk) Run(ctx context.Context) (Result, error) {
+	file, err := d.captureToFile(ctx)
 	if err != nil {
 		return Result{}, fmt.Errorf("failed to capture disk metrics: %w", err)
 	}
@@ -27,8 +30,22 @@ func (d *Disk) Run() (Result, error) {
 
 // CaptureToFile executes the disk metrics collection command and saves output to a file.
 func (d *Disk) CaptureToFile() (*os.File, error) {
-	file, err := executils.CommandCombinedOutputToFile(outputFile, executils.Disk)
+	return d.captureToFile(context.Background())
+}
+
+func (d *Disk) captureToFile(ctx context.Context) (*os.File, error) {
+	file, err := os.Create(d.OutputPath(outputFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+
+	err = d.captureWithProc(ctx, file, "df", func(f *os.File) error {
+		return executils.CommandCombinedOutputToWriter(f, executils.Disk)
+	}, func(w io.Writer) error {
+		return procFS().WriteDF(ctx, w)
+	})
 	if err != nil {
+		file.Close()
 		return nil, fmt.Errorf("failed to execute disk command: %w", err)
 	}
 
@@ -37,7 +54,7 @@ func (d *Disk) CaptureToFile() (*os.File, error) {
 
 // UploadCapturedFile sends the collected disk metrics to the configured endpoint.
 func (d *Disk) UploadCapturedFile(file *os.File) (Result, error) {
-	msg, ok := PostData(d.endpoint, "df", file)
+	msg, ok := d.PostData("df", file)
 
 	return Result{
 		Msg: msg,
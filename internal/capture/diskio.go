@@ -0,0 +1,194 @@
+package capture
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"yc-agent/internal/capture/executils"
+	"yc-agent/internal/capture/procfs"
+)
+
+const (
+	diskIOOutputPath = "diskio.json"
+	diskIOCount      = 5 // Default count, like vmstat
+)
+
+// DiskIO handles the capture of the IO of the block devices, like iostat -x,
+// and of the process, sampled over the capture window.
+type DiskIO struct {
+	Capture
+	// Pid is the process whose IO is sampled, none if 0.
+	Pid int
+	// Interval is the time between the samples, VMSTAT_INTERVAL if 0.
+	Interval time.Duration
+	// Count is the number of intervals sampled, diskIOCount if 0.
+	Count int
+}
+
+// DiskIOReport is the captured IO.
+type DiskIOReport struct {
+	Pid int `json:"pid,omitempty"`
+	// Samples are the IO over each interval.
+	Samples []*DiskIOSample `json:"samples"`
+	// Summary is the IO over the whole capture window.
+	Summary *DiskIOSample `json:"summary"`
+}
+
+// DiskIOSample is the IO over an interval.
+type DiskIOSample struct {
+	// Time is the end of the interval.
+	Time    time.Time       `json:"time"`
+	Seconds float64         `json:"seconds"`
+	Devices []procfs.DiskIO `json:"devices"`
+	// Process is nil if the IO of the process can't be read.
+	Process *procfs.ProcIORate `json:"process,omitempty"`
+}
+
+// diskIOReading are the IO statistics read at a time.
+type diskIOReading struct {
+	time  time.Time
+	disks []procfs.DiskStats
+	proc  *procfs.ProcIO
+}
+
+// Run executes the disk IO capture process and uploads the captured file
+// to the specified endpoint.
+func (d *DiskIO) Run(ctx context.Context) (Result, error) {
+	if runtime.GOOS != "linux" {
+		return Result{Msg: "skipped capturing disk IO, only supported on linux", Ok: false}, nil
+	}
+
+	capturedFile, err := d.captureToFile(ctx)
+	if err != nil {
+		return Result{Msg: err.Error(), Ok: false}, err
+	}
+	defer capturedFile.Close()
+
+	result := d.UploadCapturedFile(capturedFile)
+	return result, nil
+}
+
+// CaptureToFile captures the disk IO to a file.
+// It returns the file handle for the captured data.
+func (d *DiskIO) CaptureToFile() (*os.File, error) {
+	return d.captureToFile(context.Background())
+}
+
+func (d *DiskIO) captureToFile(ctx context.Context) (*os.File, error) {
+	report, err := d.sample(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(d.OutputPath(diskIOOutputPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write disk IO report: %w", err)
+	}
+
+	if err := file.Sync(); err != nil {
+		d.Log("warning: failed to sync file: %v", err)
+	}
+
+	return file, nil
+}
+
+// sample reads the IO statistics every interval. Once ctx is done, the
+// intervals sampled so far are reported.
+func (d *DiskIO) sample(ctx context.Context) (*DiskIOReport, error) {
+	interval, count := d.Interval, d.Count
+	if interval <= 0 {
+		interval = time.Duration(executils.VMSTAT_INTERVAL) * time.Second
+	}
+	if count <= 0 {
+		count = diskIOCount
+	}
+
+	fs := procFS()
+	first, err := d.read(fs, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the disk stats: %w", err)
+	}
+	report := &DiskIOReport{Pid: d.Pid}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	prev := first
+sampling:
+	for len(report.Samples) < count {
+		select {
+		case <-ctx.Done():
+			d.Log("disk IO capture stopped after %d samples: %s", len(report.Samples), ctx.Err())
+			break sampling
+		case <-ticker.C:
+		}
+		cur, err := d.read(fs, false)
+		if err != nil {
+			d.Log("failed to read the disk stats: %s", err.Error())
+			break
+		}
+		report.Samples = append(report.Samples, diskIORate(cur, prev))
+		prev = cur
+	}
+	if len(report.Samples) == 0 {
+		return nil, fmt.Errorf("disk IO capture stopped before the first interval: %w", ctx.Err())
+	}
+	report.Summary = diskIORate(prev, first)
+	return report, nil
+}
+
+// read reads the IO statistics of the devices and of the process. The failure
+// to read those of the process is logged the first time only.
+func (d *DiskIO) read(fs *procfs.FS, first bool) (*diskIOReading, error) {
+	disks, err := fs.DiskStats()
+	if err != nil {
+		return nil, err
+	}
+	reading := &diskIOReading{time: time.Now(), disks: disks}
+	if d.Pid > 0 {
+		reading.proc, err = fs.ProcIO(d.Pid)
+		if err != nil && first {
+			d.Log("failed to read the IO of process %d, capturing the disks only: %s", d.Pid, err.Error())
+		}
+	}
+	return reading, nil
+}
+
+// diskIORate returns the IO between the readings prev and cur. The devices
+// which appeared meanwhile are skipped.
+func diskIORate(cur, prev *diskIOReading) *DiskIOSample {
+	elapsed := cur.time.Sub(prev.time)
+	sample := &DiskIOSample{Time: cur.time, Seconds: elapsed.Seconds(), Devices: []procfs.DiskIO{}}
+	prevDisks := make(map[string]procfs.DiskStats, len(prev.disks))
+	for _, disk := range prev.disks {
+		prevDisks[disk.Device] = disk
+	}
+	for _, disk := range cur.disks {
+		if p, ok := prevDisks[disk.Device]; ok {
+			sample.Devices = append(sample.Devices, disk.Rate(p, elapsed))
+		}
+	}
+	if cur.proc != nil && prev.proc != nil {
+		rate := cur.proc.Rate(prev.proc, elapsed)
+		sample.Process = &rate
+	}
+	return sample
+}
+
+// UploadCapturedFile uploads the captured file to the configured endpoint.
+func (d *DiskIO) UploadCapturedFile(file *os.File) Result {
+	msg, ok := d.PostData("diskio", file)
+	return Result{
+		Msg: msg,
+		Ok:  ok,
+	}
+}
@@ -0,0 +1,82 @@
+package capture
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"yc-agent/internal/capture/procfs"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskIO_CaptureToFile(t *testing.T) {
+	withFixtureProcFS(t)
+
+	d := &DiskIO{Pid: 42, Interval: time.Millisecond, Count: 2}
+	d.SetDir(t.TempDir())
+	file, err := d.CaptureToFile()
+	require.NoError(t, err)
+	defer file.Close()
+
+	data, err := os.ReadFile(file.Name())
+	require.NoError(t, err)
+	var report DiskIOReport
+	require.NoError(t, json.Unmarshal(data, &report))
+	assert.Equal(t, 42, report.Pid)
+	require.Len(t, report.Samples, 2)
+	require.Len(t, report.Samples[0].Devices, 3)
+	assert.Equal(t, "sda", report.Samples[0].Devices[0].Device)
+	assert.NotNil(t, report.Samples[0].Process)
+	require.NotNil(t, report.Summary)
+	assert.Len(t, report.Summary.Devices, 3)
+
+	// The IO of pid 1 can't be read, the disks are captured alone
+	d = &DiskIO{Pid: 1, Interval: time.Millisecond, Count: 1}
+	d.SetDir(t.TempDir())
+	file, err = d.CaptureToFile()
+	require.NoError(t, err)
+	file.Close()
+	data, err = os.ReadFile(file.Name())
+	require.NoError(t, err)
+	report = DiskIOReport{}
+	require.NoError(t, json.Unmarshal(data, &report))
+	require.Len(t, report.Samples, 1)
+	assert.Nil(t, report.Samples[0].Process)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	d = &DiskIO{Interval: time.Hour}
+	d.SetDir(t.TempDir())
+	_, err = d.captureToFile(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestDiskIORate(t *testing.T) {
+	start := time.Unix(1699960000, 0)
+	prev := &diskIOReading{
+		time:  start,
+		disks: []procfs.DiskStats{{Device: "sda", ReadIOs: 100, ReadSectors: 2000, ReadTime: 50, IOTime: 1000}},
+		proc:  &procfs.ProcIO{ReadBytes: 1000, SyscR: 10},
+	}
+	cur := &diskIOReading{
+		time: start.Add(2 * time.Second),
+		disks: []procfs.DiskStats{
+			{Device: "sda", ReadIOs: 300, ReadSectors: 6000, ReadTime: 450, IOTime: 1500},
+			{Device: "sdb", ReadIOs: 1},
+		},
+		proc: &procfs.ProcIO{ReadBytes: 5000, SyscR: 30},
+	}
+	assert.Equal(t, &DiskIOSample{
+		Time:    cur.time,
+		Seconds: 2,
+		Devices: []procfs.DiskIO{{Device: "sda", ReadIOPS: 100, ReadKB: 1000, ReadAwait: 2, Util: 25}},
+		Process: &procfs.ProcIORate{ReadBytes: 2000, ReadSyscalls: 10},
+	}, diskIORate(cur, prev))
+
+	cur.proc = nil
+	assert.Nil(t, diskIORate(cur, prev).Process)
+}
@@ -1,13 +1,13 @@
 package capture
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 
 	"yc-agent/internal/capture/executils"
-	"yc-agent/internal/logger"
 )
 
 const dmesgOutputPath = "dmesg.out"
@@ -22,7 +22,7 @@ type DMesg struct {
 
 // Run executes the dmesg capture process and uploads the captured file
 // to the specified endpoint.
-func (d *DMesg) Run() (Result, error) {
+func (d *DMesg) Run(ctx context.Context) (Result, error) {
 	if executils.DMesg == nil && executils.DMesg2 == nil {
 		return Result{
 			Msg: "skipped capturing DMesg",
@@ -43,7 +43,7 @@ func (d *DMesg) Run() (Result, error) {
 // CaptureToFile captures dmesg output to a file, handling both primary and fallback commands.
 // It returns the file handle for the captured data.
 func (d *DMesg) CaptureToFile() (*os.File, error) {
-	file, err := os.Create(dmesgOutputPath)
+	file, err := os.Create(d.OutputPath(dmesgOutputPath))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create output file: %w", err)
 	}
@@ -54,7 +54,7 @@ func (d *DMesg) CaptureToFile() (*os.File, error) {
 	}
 
 	if err := d.syncFile(file); err != nil {
-		logger.Log("warning: failed to sync file: %v", err)
+		d.Log("warning: failed to sync file: %v", err)
 	}
 
 	return file, nil
@@ -91,7 +91,7 @@ func (d *DMesg) captureOutput(file *os.File) error {
 
 // UploadCapturedFile uploads the captured file to the configured endpoint.
 func (d *DMesg) UploadCapturedFile(file *os.File) Result {
-	msg, ok := PostData(d.Endpoint(), "dmesg", file)
+	msg, ok := d.PostData("dmesg", file)
 
 	return Result{
 		Msg: msg,
@@ -112,7 +112,7 @@ func (d *DMesg) captureWithPrimaryCommand(w io.Writer) error {
 	}
 
 	if err := cmd.Wait(); err != nil {
-		logger.Log("primary command failed: %v", err)
+		d.Log("primary command failed: %v", err)
 		return err
 	}
 
@@ -137,7 +137,7 @@ func (d *DMesg) captureWithFallbackCommand(file *os.File) error {
 	}
 
 	if err := cmd.Wait(); err != nil {
-		logger.Log("fallback command failed: %v", err)
+		d.Log("fallback command failed: %v", err)
 		return fmt.Errorf("fallback command failed: %w", err)
 	}
 
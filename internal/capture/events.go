@@ -0,0 +1,92 @@
+package capture
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// The events of a task reported to its EventFunc.
+const (
+	// EventFallback is reported when the task falls back to another method to capture its data.
+	EventFallback = "fallback"
+	// EventUpload is reported while the task uploads a file, and once it's uploaded.
+	EventUpload = "upload"
+)
+
+// UploadProgressInterval is how often the progress of an upload is reported.
+var UploadProgressInterval = time.Second
+
+// Event is a step of a task in progress.
+type Event struct {
+	Type string
+	// Method is the method the task falls back to, From the one that failed.
+	Method string `json:",omitempty"`
+	From   string `json:",omitempty"`
+	// DataType is the dt of the upload, Bytes of its Total are uploaded.
+	DataType string `json:",omitempty"`
+	Bytes    int64  `json:",omitempty"`
+	Total    int64  `json:",omitempty"`
+	// Done reports whether the upload completed, Ok whether it succeeded.
+	Done bool `json:",omitempty"`
+	Ok   bool `json:",omitempty"`
+}
+
+// EventFunc is notified of the events of a task.
+type EventFunc func(e Event)
+
+// SetEvents sets the func notified of the events of the task.
+func (cap *Capture) SetEvents(fn EventFunc) {
+	cap.events = fn
+}
+
+func (cap *Capture) emit(e Event) {
+	if cap.events != nil {
+		cap.events(e)
+	}
+}
+
+// trackUpload reports the progress of the upload of file as dt, the offset the
+// uploader read it up to, until done is called with the result of the upload.
+func (cap *Capture) trackUpload(dt string, file *os.File) (done func(ok bool)) {
+	if _, skip := skipUpload(file); skip || cap.events == nil {
+		return func(bool) {}
+	}
+	var total int64
+	if stat, err := file.Stat(); err == nil {
+		total = stat.Size()
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(UploadProgressInterval)
+		defer ticker.Stop()
+
+		var reported int64 = -1
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				offset, err := file.Seek(0, io.SeekCurrent)
+				if err != nil || offset == reported {
+					continue
+				}
+				reported = offset
+				cap.emit(Event{Type: EventUpload, DataType: dt, Bytes: min(offset, total), Total: total})
+			}
+		}
+	}()
+
+	return func(ok bool) {
+		close(stop)
+		<-stopped
+		e := Event{Type: EventUpload, DataType: dt, Total: total, Done: true, Ok: ok}
+		if ok {
+			e.Bytes = total
+		}
+		cap.emit(e)
+	}
+}
@@ -0,0 +1,72 @@
+package capture
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvents(t *testing.T) {
+	old := UploadProgressInterval
+	UploadProgressInterval = 5 * time.Millisecond
+	t.Cleanup(func() { UploadProgressInterval = old })
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Read part of the body, then wait for the progress to be reported
+		buf := make([]byte, 4)
+		_, _ = r.Body.Read(buf)
+		<-release
+		_, _ = w.Write([]byte("received"))
+	}))
+	defer server.Close()
+
+	var mtx sync.Mutex
+	var events []Event
+	var task Capture
+	task.SetEndpoint(server.URL + "/ycrash-receiver")
+	task.SetEvents(func(e Event) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		events = append(events, e)
+	})
+
+	task.SetMethod("jstack")
+	task.SetMethod("jattach")
+	task.SetMethod("jattach")
+
+	name := filepath.Join(t.TempDir(), "td.out")
+	require.NoError(t, os.WriteFile(name, make([]byte, 1024*1024), 0644))
+	file, err := os.Open(name)
+	require.NoError(t, err)
+	defer file.Close()
+
+	go func() {
+		assert.Eventually(t, func() bool {
+			mtx.Lock()
+			defer mtx.Unlock()
+			return len(events) > 1
+		}, time.Second, time.Millisecond)
+		close(release)
+	}()
+	msg, ok := task.PostData("td", file)
+	require.True(t, ok, msg)
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	require.GreaterOrEqual(t, len(events), 3)
+	assert.Equal(t, Event{Type: EventFallback, Method: "jattach", From: "jstack"}, events[0])
+	progress := events[1]
+	assert.Equal(t, EventUpload, progress.Type)
+	assert.Equal(t, "td", progress.DataType)
+	assert.Equal(t, int64(1024*1024), progress.Total)
+	assert.False(t, progress.Done)
+	assert.Equal(t, Event{Type: EventUpload, DataType: "td", Bytes: 1024 * 1024, Total: 1024 * 1024, Done: true, Ok: true}, events[len(events)-1])
+}
@@ -91,7 +91,7 @@ func (c *Cmd) KillAndWait() (err error) {
 	if c.Cmd == nil || c.Cmd.Process == nil {
 		return
 	}
-	err = c.Cmd.Process.Kill()
+	err = killProcess(c.Cmd)
 	if err != nil {
 		return
 	}
@@ -111,6 +111,6 @@ func (c *Cmd) Kill() (err error) {
 	if c.Cmd == nil || c.Cmd.Process == nil {
 		return
 	}
-	err = c.Cmd.Process.Kill()
+	err = killProcess(c.Cmd)
 	return
 }
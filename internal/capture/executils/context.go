@@ -0,0 +1,30 @@
+package executils
+
+import (
+	"context"
+	"os/exec"
+)
+
+// ContextHooker binds the command to Ctx: the process and the processes it started
+// are stopped once Ctx is done, see bindContext, so a cancelled or timed out capture
+// doesn't leave its child processes behind.
+type ContextHooker struct {
+	Ctx context.Context
+}
+
+func (h ContextHooker) After(command *exec.Cmd) {
+}
+
+func (h ContextHooker) Before(command Command) (result Command) {
+	return command
+}
+
+// hookersContext returns the context of the last ContextHooker in hookers, nil if none.
+func hookersContext(hookers []Hooker) (ctx context.Context) {
+	for _, hooker := range hookers {
+		if h, ok := hooker.(ContextHooker); ok && h.Ctx != nil {
+			ctx = h.Ctx
+		}
+	}
+	return
+}
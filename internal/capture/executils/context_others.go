@@ -0,0 +1,35 @@
+//go:build !windows
+// +build !windows
+
+package executils
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// cancelGracePeriod is how long a command is given to exit once its context is done,
+// before it's killed.
+const cancelGracePeriod = 5 * time.Second
+
+// bindContext runs the command in its own process group, which is terminated once
+// the context of the command is done. That way the commands started by a wrapper,
+// like sudo, are stopped too. sudo relays the signal to its command even when it
+// runs it in a new session. The command is killed if it's still running after
+// cancelGracePeriod.
+func bindContext(command *exec.Cmd) {
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	command.Cancel = func() error {
+		return syscall.Kill(-command.Process.Pid, syscall.SIGTERM)
+	}
+	command.WaitDelay = cancelGracePeriod
+}
+
+// killProcess kills the command, with its process group if it has its own.
+func killProcess(command *exec.Cmd) error {
+	if command.SysProcAttr != nil && command.SysProcAttr.Setpgid {
+		return syscall.Kill(-command.Process.Pid, syscall.SIGKILL)
+	}
+	return command.Process.Kill()
+}
@@ -0,0 +1,50 @@
+//go:build !windows
+// +build !windows
+
+package executils
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContextHookerKillsChildProcesses(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Like sudo, the shell waits for the command it started
+	out, err := os.Create(t.TempDir() + "/out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	c, err := CommandStartInBackgroundToWriter(out, append(SHELL, "sleep 30 & echo $!; wait"), ContextHooker{Ctx: ctx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pid int
+	for i := 0; i < 100 && pid == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		b, _ := os.ReadFile(out.Name())
+		pid, _ = strconv.Atoi(strings.TrimSpace(string(b)))
+	}
+	if pid == 0 {
+		t.Fatal("the child process didn't start")
+	}
+
+	cancel()
+	_ = c.Wait()
+
+	for i := 0; i < 100; i++ {
+		stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+		if err != nil || strings.Contains(string(stat), ") Z ") {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("the child process %d should be stopped with the command", pid)
+}
@@ -0,0 +1,16 @@
+//go:build windows
+// +build windows
+
+package executils
+
+import (
+	"os/exec"
+)
+
+// bindContext leaves the command to be killed by exec once its context is done.
+func bindContext(command *exec.Cmd) {
+}
+
+func killProcess(command *exec.Cmd) error {
+	return command.Process.Kill()
+}
@@ -0,0 +1,17 @@
+package executils
+
+import (
+	"os/exec"
+)
+
+// DirHooker runs the command in the directory Dir, the current directory if empty,
+// so commands writing relative files write them to the dir of their capture.
+type DirHooker string
+
+func (h DirHooker) After(command *exec.Cmd) {
+	command.Dir = string(h)
+}
+
+func (h DirHooker) Before(command Command) (result Command) {
+	return command
+}
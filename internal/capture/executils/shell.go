@@ -121,11 +121,20 @@ func NewCommand(cmd Command, hookers ...Hooker) CmdManager {
 	if wait {
 		cmd = cmd[1:]
 	}
+	ctx := hookersContext(hookers)
 	for _, hooker := range hookers {
+		n := len(cmd)
 		cmd = hooker.Before(cmd)
+		if _, ok := hooker.(SudoHooker); ok && len(cmd) != n {
+			// logged here, with the logger of the capture the command is bound to
+			logger.Ctx(ctx).Info().Str("cmd", strings.Join(cmd, " ")).Msg("sudo hooker result")
+		}
 	}
 	var command *exec.Cmd
-	if len(cmd) == 1 {
+	if ctx != nil {
+		command = exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+		bindContext(command)
+	} else if len(cmd) == 1 {
 		command = exec.Command(cmd[0])
 	} else {
 		command = exec.Command(cmd[0], cmd[1:]...)
@@ -200,10 +209,11 @@ func CommandCombinedOutputToWriter(writer io.Writer, cmd Command, hookers ...Hoo
 	timer := time.NewTimer(timerDuration)
 	select {
 	case <-timer.C:
-		logger.Log("Timeout happened during the command execution (%ds) [%s]", int(timerDuration/time.Second), c.String())
+		log := logger.Ctx(hookersContext(hookers))
+		log.Info().Msgf("Timeout happened during the command execution (%ds) [%s]", int(timerDuration/time.Second), c.String())
 		err = c.KillAndWait()
 		if err != nil {
-			logger.Log("Error doing cmd.KillAndWait() invocation: [%s]", err.Error())
+			log.Info().Msgf("Error doing cmd.KillAndWait() invocation: [%s]", err.Error())
 		}
 	case <-channelDone:
 		timer.Stop()
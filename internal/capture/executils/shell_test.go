@@ -1,7 +1,9 @@
 package executils
 
 import (
+	"context"
 	"testing"
+	"time"
 )
 
 func TestNilCmdHolder(t *testing.T) {
@@ -20,3 +22,17 @@ func TestCaptureCmd(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestContextHookerKillsCommand(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := CommandRun(append(SHELL, "sleep 10"), ContextHooker{Ctx: ctx})
+	if err == nil {
+		t.Fatal("the command should be killed")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("the command should be killed once the context is done, took %s", elapsed)
+	}
+}
@@ -10,8 +10,6 @@ import (
 	"os/exec"
 	"strconv"
 	"strings"
-
-	"yc-agent/internal/logger"
 )
 
 type SudoHooker struct {
@@ -38,7 +36,6 @@ func (s SudoHooker) Before(command Command) (result Command) {
 		return command
 	}
 	result = append(Command{"sudo", "-E", "-u", fmt.Sprintf("#%s", uid)}, command...)
-	logger.Info().Str("cmd", strings.Join(result, " ")).Msg("sudo hooker result")
 	return
 }
 
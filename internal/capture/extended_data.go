@@ -1,6 +1,7 @@
 package capture
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -9,7 +10,6 @@ import (
 	"time"
 
 	"yc-agent/internal/capture/executils"
-	"yc-agent/internal/logger"
 )
 
 // DefaultScriptTimeout is the default timeout for script execution
@@ -25,30 +25,30 @@ type ExtendedData struct {
 
 // Run executes the custom script and uploads all files from the data folder
 // to the specified endpoint.
-func (ed *ExtendedData) Run() (Result, error) {
+func (ed *ExtendedData) Run(ctx context.Context) (Result, error) {
 	// Ensure the data folder exists
 	if err := os.MkdirAll(ed.DataFolder, 0755); err != nil {
 		errMsg := fmt.Sprintf("ExtendedData: failed to create data folder %s: %v", ed.DataFolder, err)
-		logger.Log(errMsg)
+		ed.Log(errMsg)
 		return Result{Msg: errMsg, Ok: false}, err
 	}
 
 	// Clear existing files in the data folder
 	if err := ed.clearDataFolder(); err != nil {
-		logger.Log("ExtendedData: failed to clear data folder: %v", err)
+		ed.Log("ExtendedData: failed to clear data folder: %v", err)
 	}
 
 	// Execute the custom script with timeout
-	if err := ed.executeScript(); err != nil {
+	if err := ed.executeScript(ctx); err != nil {
 		// We log the error but continue to upload any files that might have been generated
-		logger.Log("ExtendedData: error while executing custom script: %v", err)
+		ed.Log("ExtendedData: error while executing custom script: %v", err)
 	}
 
 	// Copy files from data folder to current directory with "ed-" prefix
 	err := ed.captureEdFiles()
 	if err != nil {
 		errMsg := fmt.Sprintf("ExtendedData: failed to capture files: %v", err)
-		logger.Log(errMsg)
+		ed.Log(errMsg)
 		return Result{Msg: errMsg, Ok: false}, err
 	}
 
@@ -74,8 +74,8 @@ func (ed *ExtendedData) clearDataFolder() error {
 }
 
 // executeScript runs the custom script with a timeout
-func (ed *ExtendedData) executeScript() error {
-	logger.Log("ExtendedData: executing custom script: %s", ed.Script)
+func (ed *ExtendedData) executeScript(ctx context.Context) error {
+	ed.Log("ExtendedData: executing custom script: %s", ed.Script)
 
 	// Create a temporary file for script output
 	logFile, err := os.Create(filepath.Join(ed.DataFolder, "script_execution.log"))
@@ -85,14 +85,14 @@ func (ed *ExtendedData) executeScript() error {
 	defer logFile.Close()
 
 	// Start the script
-	cmd, err := executils.CommandStartInBackgroundToWriter(logFile, []string{ed.Script})
+	cmd, err := executils.CommandStartInBackgroundToWriter(logFile, []string{ed.Script}, executils.ContextHooker{Ctx: ctx})
 	if err != nil {
 		return fmt.Errorf("ExtendedData: failed to start custom script: %w", err)
 	}
 	ed.Cmd = cmd
 
 	if cmd.IsSkipped() {
-		logger.Log("ExtendedData: custom script execution was skipped")
+		ed.Log("ExtendedData: custom script execution was skipped")
 		return nil
 	}
 
@@ -119,11 +119,11 @@ func (ed *ExtendedData) executeScript() error {
 		if cmd.ExitCode() != 0 {
 			return fmt.Errorf("ExtendedData: custom script exited with non-zero code: %d", cmd.ExitCode())
 		}
-		logger.Log("ExtendedData: custom script completed successfully")
+		ed.Log("ExtendedData: custom script completed successfully")
 	case <-timeout:
-		logger.Log("ExtendedData: custom script timed out after %v, terminating", ed.Timeout)
+		ed.Log("ExtendedData: custom script timed out after %v, terminating", ed.Timeout)
 		if err := cmd.Kill(); err != nil {
-			logger.Log("ExtendedData: failed to kill timed out script: %v", err)
+			ed.Log("ExtendedData: failed to kill timed out script: %v", err)
 		}
 		return fmt.Errorf("ExtendedData: custom script execution timed out after %v", ed.Timeout)
 	}
@@ -139,7 +139,7 @@ func (ed *ExtendedData) captureEdFiles() error {
 	}
 
 	if len(entries) == 0 {
-		logger.Log("ExtendedData: no files found in data folder %s", ed.DataFolder)
+		ed.Log("ExtendedData: no files found in data folder %s", ed.DataFolder)
 		return nil
 	}
 
@@ -157,7 +157,7 @@ func (ed *ExtendedData) captureEdFiles() error {
 		// Copy the file to current directory
 		err := ed.copyFile(filePath, newFileName)
 		if err != nil {
-			logger.Log("ExtendedData: failed to copy file %s to %s: %v", filePath, newFileName, err)
+			ed.Log("ExtendedData: failed to copy file %s to %s: %v", filePath, newFileName, err)
 		}
 	}
 
@@ -216,7 +216,7 @@ func (ed *ExtendedData) uploadCapturedFiles() (Result, error) {
 
 		file, err := os.Open(fileName)
 		if err != nil {
-			logger.Log("ExtendedData: failed to open file %s: %v", fileName, err)
+			ed.Log("ExtendedData: failed to open file %s: %v", fileName, err)
 			failCount++
 			lastError = err
 			continue
@@ -233,7 +233,7 @@ func (ed *ExtendedData) uploadCapturedFiles() (Result, error) {
 			data += "&content-encoding=" + fileExt
 		}
 
-		msg, ok := PostData(ed.Endpoint(), data, file)
+		msg, ok := ed.PostData(data, file)
 		file.Close()
 
 		if ok {
@@ -3,6 +3,7 @@ package capture
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -13,8 +14,10 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"yc-agent/internal/capture/executils"
+	"yc-agent/internal/capture/hsperf"
 	"yc-agent/internal/config"
 	"yc-agent/internal/logger"
 
@@ -29,53 +32,66 @@ type GC struct {
 	GCPath   string
 }
 
-func (t *GC) Run() (result Result, err error) {
-	fileName := "gc.log"
+func (t *GC) Run(ctx context.Context) (result Result, err error) {
+	fileName := t.OutputPath("gc.log")
 	var gcFile *os.File
 
-	gcFile, err = ProcessGCLogFile(t.GCPath, fileName, t.DockerID, t.Pid)
+	t.SetMethod("gc log file")
+	gcFile, err = ProcessGCLogFile(ctx, t.GCPath, fileName, t.DockerID, t.Pid)
 	if err != nil {
-		logger.Log("process log file failed %s, err: %s", t.GCPath, err.Error())
+		t.Log("process log file failed %s, err: %s", t.GCPath, err.Error())
 	}
 
 	if gcFile == nil && t.Pid > 0 {
 
 		if gcFile == nil {
 			// Garbage collection log: Attempt 5: jstat
-			logger.Log("Trying to capture gc log using jstat...")
+			t.Log("Trying to capture gc log using jstat...")
+			t.SetMethod("jstat")
 			gcFile, err = executils.CommandCombinedOutputToFile(fileName,
-				executils.Command{path.Join(config.GlobalConfig.JavaHomePath, "/bin/jstat"), "-gc", "-t", strconv.Itoa(t.Pid), "2000", "30"}, executils.SudoHooker{PID: t.Pid})
+				executils.Command{path.Join(config.GlobalConfig.JavaHomePath, "/bin/jstat"), "-gc", "-t", strconv.Itoa(t.Pid), "2000", "30"}, executils.SudoHooker{PID: t.Pid}, executils.ContextHooker{Ctx: ctx})
 			if err != nil {
-				logger.Log("jstat failed cause %s", err.Error())
+				t.Log("jstat failed cause %s", err.Error())
+			}
+		}
+		if gcFile == nil {
+			// Garbage collection log: Attempt 5b: jstat from the hsperfdata file, for JREs without jstat
+			t.Log("Trying to capture gc log from hsperfdata...")
+			t.SetMethod("hsperfdata")
+			gcFile, err = captureHsperfGC(ctx, fileName, t.Pid)
+			if err != nil {
+				t.Log("hsperfdata failed cause %s", err.Error())
 			}
 		}
 		if gcFile == nil {
 			// Garbage collection log: Attempt 6a: jattach
-			logger.Log("Trying to capture gc log using jattach...")
+			t.Log("Trying to capture gc log using jattach...")
+			t.SetMethod("jattach")
 			gcFile, err = executils.CommandCombinedOutputToFile(fileName,
-				executils.Command{executils.Executable(), "-p", strconv.Itoa(t.Pid), "-gcCaptureMode"}, executils.EnvHooker{"pid": strconv.Itoa(t.Pid)}, executils.SudoHooker{PID: t.Pid})
+				executils.Command{executils.Executable(), "-p", strconv.Itoa(t.Pid), "-gcCaptureMode"}, executils.EnvHooker{"pid": strconv.Itoa(t.Pid)}, executils.SudoHooker{PID: t.Pid}, executils.ContextHooker{Ctx: ctx})
 			if err != nil {
-				logger.Log("jattach failed cause %s", err.Error())
+				t.Log("jattach failed cause %s", err.Error())
 			}
 		}
 		if gcFile == nil {
 			// Garbage collection log: Attempt 6b: tmp jattach
-			logger.Log("Trying to capture gc log using tmp jattach...")
+			t.Log("Trying to capture gc log using tmp jattach...")
+			t.SetMethod("tmp jattach")
 			var tempPath string
 			tempPath, err = executils.Copy2TempPath()
 			if err != nil {
 				return
 			}
 			gcFile, err = executils.CommandCombinedOutputToFile(fileName,
-				executils.Command{tempPath, "-p", strconv.Itoa(t.Pid), "-gcCaptureMode"}, executils.EnvHooker{"pid": strconv.Itoa(t.Pid)}, executils.SudoHooker{PID: t.Pid})
+				executils.Command{tempPath, "-p", strconv.Itoa(t.Pid), "-gcCaptureMode"}, executils.EnvHooker{"pid": strconv.Itoa(t.Pid)}, executils.SudoHooker{PID: t.Pid}, executils.ContextHooker{Ctx: ctx})
 			if err != nil {
-				logger.Log("tmp jattach failed cause %s", err.Error())
+				t.Log("tmp jattach failed cause %s", err.Error())
 			}
 		}
 
 		if gcFile != nil {
 			t.GCPath = fileName
-			logger.Log("gc log set to %s", t.GCPath)
+			t.Log("gc log set to %s", t.GCPath)
 		}
 	}
 
@@ -85,7 +101,7 @@ func (t *GC) Run() (result Result, err error) {
 		}()
 	}
 
-	result.Msg, result.Ok = PostData(t.Endpoint(), "gc", gcFile)
+	result.Msg, result.Ok = t.PostCompressedData("dt=gc", "content-encoding", gcFile)
 	absGCPath, err := filepath.Abs(t.GCPath)
 	if err != nil {
 		absGCPath = fmt.Sprintf("path %s: %s", t.GCPath, err.Error())
@@ -139,11 +155,11 @@ func GetGlobPatternFromGCPath(gcPath string, pid int) string {
 	return pattern
 }
 
-func GetLatestFileFromGlobPattern(globPattern string) (string, error) {
+func GetLatestFileFromGlobPattern(ctx context.Context, globPattern string) (string, error) {
 	globFiles, err := doublestar.FilepathGlob(globPattern, doublestar.WithFilesOnly(), doublestar.WithNoFollow())
 
 	if err != nil {
-		logger.Log("GetLatestFileFromGlobPattern: error on expanding %%t, pattern:%s, err:%s", globPattern, err)
+		logger.LogCtx(ctx, "GetLatestFileFromGlobPattern: error on expanding %%t, pattern:%s, err:%s", globPattern, err)
 		return "", err
 	}
 
@@ -155,14 +171,14 @@ func GetLatestFileFromGlobPattern(globPattern string) (string, error) {
 	})
 
 	if len(globFiles) == 0 {
-		logger.Log("No file found from glob %s", globPattern)
+		logger.LogCtx(ctx, "No file found from glob %s", globPattern)
 		return "", fmt.Errorf("no file found from glob %s", globPattern)
 	}
 
 	return filepath.FromSlash(globFiles[0]), nil
 }
 
-func ProcessGCLogFile(gcPath string, out string, dockerID string, pid int) (gc *os.File, err error) {
+func ProcessGCLogFile(ctx context.Context, gcPath string, out string, dockerID string, pid int) (gc *os.File, err error) {
 	if len(gcPath) <= 0 {
 		return
 	}
@@ -172,12 +188,12 @@ func ProcessGCLogFile(gcPath string, out string, dockerID string, pid int) (gc *
 	// /tmp/buggyapp-%p-%t.log -> /tmp/buggyapp-*-*.log
 	if strings.Contains(gcPath, "%") {
 		globPattern := GetGlobPatternFromGCPath(gcPath, pid)
-		logger.Log("Finding GC log gcPath=%s glob=%s", gcPath, globPattern)
+		logger.LogCtx(ctx, "Finding GC log gcPath=%s glob=%s", gcPath, globPattern)
 
-		latestFile, err := GetLatestFileFromGlobPattern(globPattern)
+		latestFile, err := GetLatestFileFromGlobPattern(ctx, globPattern)
 		if err == nil && gcPath != latestFile {
 			gcPath = latestFile
-			logger.Log("gcPath is updated from %s to %s", originalGcPath, latestFile)
+			logger.LogCtx(ctx, "gcPath is updated from %s to %s", originalGcPath, latestFile)
 		}
 
 		// Handle a condition in some JVM versions such as OpenJ9,
@@ -185,15 +201,15 @@ func ProcessGCLogFile(gcPath string, out string, dockerID string, pid int) (gc *
 		if latestFile == "" {
 			// To find one of the rotation file
 			globPattern += ".*"
-			logger.Log("Retry finding GC log gcPath=%s glob=%s", gcPath, globPattern)
+			logger.LogCtx(ctx, "Retry finding GC log gcPath=%s glob=%s", gcPath, globPattern)
 
-			latestFile, err = GetLatestFileFromGlobPattern(globPattern)
+			latestFile, err = GetLatestFileFromGlobPattern(ctx, globPattern)
 			if err == nil && gcPath != latestFile {
 				// Trim extension so that the behavior is the same as the above logic (the initial attempt):
 				// returns /tmp/buggyapp-*-*.log excluding the .001
 				// The .001 will be handled by the same code in the following lines
 				gcPath = strings.TrimSuffix(latestFile, filepath.Ext(latestFile))
-				logger.Log("gcPath is updated from %s to %s", originalGcPath, gcPath)
+				logger.LogCtx(ctx, "gcPath is updated from %s to %s", originalGcPath, gcPath)
 			}
 		}
 	}
@@ -201,10 +217,10 @@ func ProcessGCLogFile(gcPath string, out string, dockerID string, pid int) (gc *
 	// Attempt to find the latest file in rotating GC log
 	// i.e: find /tmp/jvm-2023-10-28_09-07-59.log.9 from tmp/jvm-2023-10-28_09-07-59.log
 	gcPathBefore := gcPath
-	gcPath = findLatestFileInRotatingLogFiles(gcPathBefore)
+	gcPath = findLatestFileInRotatingLogFiles(ctx, gcPathBefore)
 
 	if gcPathBefore != gcPath {
-		logger.Log("Found rotating logs: gcPath is updated from %s to %s", gcPathBefore, gcPath)
+		logger.LogCtx(ctx, "Found rotating logs: gcPath is updated from %s to %s", gcPathBefore, gcPath)
 	}
 
 	if len(dockerID) > 0 {
@@ -218,14 +234,14 @@ func ProcessGCLogFile(gcPath string, out string, dockerID string, pid int) (gc *
 		if err != nil {
 			return
 		}
-		err = copyFile(gc, gcPath, pid)
+		err = copyFile(ctx, gc, gcPath, pid)
 		if err == nil {
 			return
 		}
 	}
 
 	// Attempt 2 to find the latest file in rotating gc logs
-	logger.Log("collecting rotation gc logs, because file open failed %s", err.Error())
+	logger.LogCtx(ctx, "collecting rotation gc logs, because file open failed %s", err.Error())
 
 	// err is other than not exists
 	if !os.IsNotExist(err) {
@@ -272,7 +288,7 @@ func ProcessGCLogFile(gcPath string, out string, dockerID string, pid int) (gc *
 		if len(r) > 1 {
 			p, err := strconv.Atoi(r[1])
 			if err != nil {
-				logger.Log("skipped file %s because can not parse its index", f)
+				logger.LogCtx(ctx, "skipped file %s because can not parse its index", f)
 				continue
 			}
 			files = append(files, p)
@@ -315,47 +331,47 @@ func ProcessGCLogFile(gcPath string, out string, dockerID string, pid int) (gc *
 		}
 	}
 	if len(preLog) > 0 {
-		logger.Log("collecting previous gc log %s", preLog)
+		logger.LogCtx(ctx, "collecting previous gc log %s", preLog)
 		if len(dockerID) > 0 {
 			tmp := filepath.Join(os.TempDir(), out+".pre")
 			err = DockerCopy(tmp, dockerID+":"+preLog)
 			if err == nil {
-				err = copyFile(gc, tmp, pid)
+				err = copyFile(ctx, gc, tmp, pid)
 			}
 		} else {
-			err = copyFile(gc, preLog, pid)
+			err = copyFile(ctx, gc, preLog, pid)
 		}
 		if err != nil {
-			logger.Log("failed to collect previous gc log %s", err.Error())
+			logger.LogCtx(ctx, "failed to collect previous gc log %s", err.Error())
 		} else {
-			logger.Log("collected previous gc log %s", preLog)
+			logger.LogCtx(ctx, "collected previous gc log %s", preLog)
 		}
 	}
 
 	curLog := filepath.Join(d, rf[0])
-	logger.Log("collecting previous gc log %s", curLog)
+	logger.LogCtx(ctx, "collecting previous gc log %s", curLog)
 	if len(dockerID) > 0 {
 		tmp := filepath.Join(os.TempDir(), out+".cur")
 		err = DockerCopy(tmp, dockerID+":"+curLog)
 		if err == nil {
-			err = copyFile(gc, tmp, pid)
+			err = copyFile(ctx, gc, tmp, pid)
 		}
 	} else {
-		err = copyFile(gc, curLog, pid)
+		err = copyFile(ctx, gc, curLog, pid)
 	}
 	if err != nil {
-		logger.Log("failed to collect previous gc log %s", err.Error())
+		logger.LogCtx(ctx, "failed to collect previous gc log %s", err.Error())
 	} else {
-		logger.Log("collected previous gc log %s", curLog)
+		logger.LogCtx(ctx, "collected previous gc log %s", curLog)
 	}
 	return
 }
 
 // combine previous gc log to new gc log
-func copyFile(gc *os.File, file string, pid int) (err error) {
+func copyFile(ctx context.Context, gc *os.File, file string, pid int) (err error) {
 	log, err := os.Open(file)
 	if err != nil && runtime.GOOS == "linux" {
-		logger.Log("Failed to %s. Trying to open in the Docker container...", err)
+		logger.LogCtx(ctx, "Failed to %s. Trying to open in the Docker container...", err)
 		log, err = os.Open(filepath.Join("/proc", strconv.Itoa(pid), "root", file))
 	}
 	if err != nil {
@@ -366,6 +382,31 @@ func copyFile(gc *os.File, file string, pid int) (err error) {
 	return
 }
 
+// captureHsperfGC writes the `jstat -gc -t <pid> 2000 30` output sampled from
+// the hsperfdata file of the JVM to the file name. The samples taken so far are
+// kept when ctx is done or the JVM exits.
+func captureHsperfGC(ctx context.Context, name string, pid int) (gc *os.File, err error) {
+	perfPath, err := hsperf.Find(pid)
+	if err != nil {
+		return
+	}
+	logger.LogCtx(ctx, "sampling gc counters from %s", perfPath)
+	gc, err = os.Create(name)
+	if err != nil {
+		return
+	}
+	samples, err := hsperf.SampleGC(ctx, perfPath, gc, 2*time.Second, 30)
+	if samples == 0 {
+		_ = gc.Close()
+		gc = nil
+		return
+	}
+	if err != nil {
+		logger.LogCtx(ctx, "stopped sampling gc counters after %d samples: %s", samples, err.Error())
+	}
+	return gc, nil
+}
+
 func fileExists(filename string) bool {
 	info, err := os.Stat(filename)
 	if os.IsNotExist(err) {
@@ -379,7 +420,7 @@ func fileExists(filename string) bool {
 	return !info.IsDir()
 }
 
-func findLatestFileInRotatingLogFiles(gcPath string) string {
+func findLatestFileInRotatingLogFiles(ctx context.Context, gcPath string) string {
 	pattern := gcPath + ".*"
 	matches, err := doublestar.FilepathGlob(pattern, doublestar.WithFilesOnly(), doublestar.WithNoFollow())
 
@@ -388,14 +429,14 @@ func findLatestFileInRotatingLogFiles(gcPath string) string {
 	}
 
 	if err != nil {
-		logger.Log(err.Error())
+		logger.LogCtx(ctx, err.Error())
 	} else {
 		fileInfos := []os.FileInfo{}
 
 		for _, match := range matches {
 			fileInfo, err := os.Lstat(match)
 			if err != nil {
-				logger.Log(err.Error())
+				logger.LogCtx(ctx, err.Error())
 			}
 			fileInfos = append(fileInfos, fileInfo)
 		}
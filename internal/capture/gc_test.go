@@ -1,6 +1,7 @@
 package capture
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -8,6 +9,8 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"yc-agent/internal/logger"
 )
 
 func TestProcessLogFile(t *testing.T) {
@@ -25,7 +28,7 @@ func TestProcessLogFile(t *testing.T) {
 		if len(out) < 1 {
 			out = fname
 		}
-		gc, err := ProcessGCLogFile(filepath.Join(dir, fname), filepath.Join(dir, out), "", 0)
+		gc, err := ProcessGCLogFile(context.Background(), filepath.Join(dir, fname), filepath.Join(dir, out), "", 0)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -66,7 +69,7 @@ func TestProcessLogFile(t *testing.T) {
 		test(t, dir, "gc.log.0.current", "gc.log")
 	})
 	t.Run("gcPath-not-exists", func(t *testing.T) {
-		_, err := ProcessGCLogFile("gc-rotation-logs/0-current/1/gc.log.current", "gc-rotation-logs/0-current/1/gc.log", "", 0)
+		_, err := ProcessGCLogFile(context.Background(), "gc-rotation-logs/0-current/1/gc.log.current", "gc-rotation-logs/0-current/1/gc.log", "", 0)
 		if err != nil && errors.Is(err, os.ErrNotExist) && strings.Contains(err.Error(), "can not find the current log file,") {
 		} else {
 			t.Fatal(err)
@@ -79,3 +82,30 @@ func TestProcessLogFile(t *testing.T) {
 		test(t, dir, "gc%t.log", "gctt.log")
 	})
 }
+
+func TestProcessLogFile_CaptureLog(t *testing.T) {
+	dir := t.TempDir()
+	log, f, err := logger.StartWritingToFile(filepath.Join(dir, "yc360Logs.out"), "capture-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := logger.WithContext(context.Background(), log)
+	_, _ = ProcessGCLogFile(ctx, filepath.Join(dir, "gc-%t.log"), filepath.Join(dir, "gc.log"), "", 0)
+	if err := logger.StopWritingToFile(f); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(all)), "\n")
+	if len(lines) == 0 || !strings.Contains(lines[0], "Finding GC log") {
+		t.Fatal(string(all))
+	}
+	for _, line := range lines {
+		if !strings.Contains(line, logger.CaptureIDField+"=capture-1") {
+			t.Fatalf("line not tagged with the capture ID: %s", line)
+		}
+	}
+}
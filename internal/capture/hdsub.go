@@ -1,6 +1,7 @@
 package capture
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -9,7 +10,8 @@ import (
 	"strconv"
 
 	"yc-agent/internal/capture/executils"
-	"yc-agent/internal/logger"
+
+	"github.com/rs/zerolog"
 )
 
 const hdsubOutputPath = "hdsub.out"
@@ -23,7 +25,7 @@ type HDSub struct {
 
 // Run executes the heap dump capture process and uploads the captured file
 // to the specified endpoint.
-func (t *HDSub) Run() (Result, error) {
+func (t *HDSub) Run(ctx context.Context) (Result, error) {
 	capturedFile, err := t.CaptureToFile()
 	if err != nil {
 		return Result{Msg: err.Error(), Ok: false}, err
@@ -37,30 +39,30 @@ func (t *HDSub) Run() (Result, error) {
 // CaptureToFile captures Java heap and VM data to a file.
 // It returns the file handle for the captured data.
 func (t *HDSub) CaptureToFile() (*os.File, error) {
-	file, err := os.Create(hdsubOutputPath)
+	file, err := os.Create(t.OutputPath(hdsubOutputPath))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create output file: %w", err)
 	}
 
 	// Capture each section of data
 	if err := t.captureClassHistogram(file); err != nil {
-		logger.Log("Failed to capture class histogram: %v", err)
+		t.Log("Failed to capture class histogram: %v", err)
 	}
 
 	if err := t.captureSystemProperties(file); err != nil {
-		logger.Log("Failed to capture system properties: %v", err)
+		t.Log("Failed to capture system properties: %v", err)
 	}
 
 	if err := t.captureHeapInfo(file); err != nil {
-		logger.Log("Failed to capture heap info: %v", err)
+		t.Log("Failed to capture heap info: %v", err)
 	}
 
 	if err := t.captureVMFlags(file); err != nil {
-		logger.Log("Failed to capture VM flags: %v", err)
+		t.Log("Failed to capture VM flags: %v", err)
 	}
 
 	if err := t.syncFile(file); err != nil {
-		logger.Log("warning: failed to sync file: %v", err)
+		t.Log("warning: failed to sync file: %v", err)
 	}
 
 	return file, nil
@@ -105,28 +107,34 @@ func (t *HDSub) captureVMFlags(w io.Writer) error {
 // executeJcmd executes the jcmd command with the given parameters, falling back to
 // jattach if needed.
 func (t *HDSub) executeJcmd(w io.Writer, command string) error {
+	return executeJcmd(t.Logger(), w, t.JavaHome, t.Pid, command)
+}
+
+// executeJcmd executes the jcmd command on the process pid, falling back to
+// jattach if needed, and logs the fallbacks to log.
+func executeJcmd(log *zerolog.Logger, w io.Writer, javaHome string, pid int, command string) error {
 	// Try using jcmd first
 	err := executils.CommandCombinedOutputToWriter(w,
-		executils.Command{path.Join(t.JavaHome, "bin/jcmd"), strconv.Itoa(t.Pid), command},
-		executils.SudoHooker{PID: t.Pid})
+		executils.Command{path.Join(javaHome, "bin/jcmd"), strconv.Itoa(pid), command},
+		executils.SudoHooker{PID: pid})
 
 	if err == nil {
 		return nil
 	}
 
-	logger.Log("Failed to run jcmd with err %v. Trying to capture using jattach...", err)
+	log.Info().Msgf("Failed to run jcmd with err %v. Trying to capture using jattach...", err)
 
 	// Try using jattach as fallback
 	err = executils.CommandCombinedOutputToWriter(w,
-		executils.Command{executils.Executable(), "-p", strconv.Itoa(t.Pid), "-jCmdCaptureMode", command},
-		executils.EnvHooker{"pid": strconv.Itoa(t.Pid)},
-		executils.SudoHooker{PID: t.Pid})
+		executils.Command{executils.Executable(), "-p", strconv.Itoa(pid), "-jCmdCaptureMode", command},
+		executils.EnvHooker{"pid": strconv.Itoa(pid)},
+		executils.SudoHooker{PID: pid})
 
 	if err == nil {
 		return nil
 	}
 
-	logger.Log("Failed to capture %s with err %v. Trying to capture using tmp jattach...", command, err)
+	log.Info().Msgf("Failed to capture %s with err %v. Trying to capture using tmp jattach...", command, err)
 
 	// Try using temp jattach as last resort
 	tempPath, err := executils.Copy2TempPath()
@@ -135,9 +143,9 @@ func (t *HDSub) executeJcmd(w io.Writer, command string) error {
 	}
 
 	err = executils.CommandCombinedOutputToWriter(w,
-		executils.Command{tempPath, "-p", strconv.Itoa(t.Pid), "-jCmdCaptureMode", command},
-		executils.EnvHooker{"pid": strconv.Itoa(t.Pid)},
-		executils.SudoHooker{PID: t.Pid})
+		executils.Command{tempPath, "-p", strconv.Itoa(pid), "-jCmdCaptureMode", command},
+		executils.EnvHooker{"pid": strconv.Itoa(pid)},
+		executils.SudoHooker{PID: pid})
 
 	if err != nil {
 		return fmt.Errorf("failed to capture %s: %w", command, err)
@@ -148,7 +156,7 @@ func (t *HDSub) executeJcmd(w io.Writer, command string) error {
 
 // UploadCapturedFile uploads the captured file to the configured endpoint.
 func (t *HDSub) UploadCapturedFile(file *os.File) Result {
-	msg, ok := PostData(t.Endpoint(), "hdsub", file)
+	msg, ok := t.PostData("hdsub", file)
 
 	return Result{
 		Msg: msg,
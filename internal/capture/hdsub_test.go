@@ -1,6 +1,7 @@
 package capture
 
 import (
+	"context"
 	"testing"
 	"yc-agent/internal/capture/executils"
 )
@@ -12,7 +13,7 @@ func TestHDSub(t *testing.T) {
 	}
 	defer noGC.KillAndWait()
 	cap := &HDSub{JavaHome: javaHome, Pid: noGC.GetPid()}
-	_, err = cap.Run()
+	_, err = cap.Run(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -11,8 +11,9 @@ import (
 	"strings"
 	"time"
 	"yc-agent/internal/capture/executils"
+	"yc-agent/internal/capture/httpclient"
 	"yc-agent/internal/config"
-	"yc-agent/internal/logger"
+	"yc-agent/internal/metrics"
 )
 
 const DefaultTimeoutSeconds = 10
@@ -28,17 +29,17 @@ type HealthCheck struct {
 // Run executes the health check operation against the configured endpoint
 // and writes the results to a file. It returns a Result containing the operation
 // status and any relevant messages.
-func (h *HealthCheck) Run() (Result, error) {
-	logger.Log("Running Healthcheck")
-	logger.Log("AppName: %s", h.AppName)
-	logger.Log("Endpoint: %s", h.Cfg.Endpoint)
-	logger.Log("HTTP Body: %s", h.Cfg.HTTPBody)
-	logger.Log("Timeout: %d secs", h.Cfg.TimeoutSecs)
+func (h *HealthCheck) Run(ctx context.Context) (Result, error) {
+	h.Log("Running Healthcheck")
+	h.Log("AppName: %s", h.AppName)
+	h.Log("Endpoint: %s", h.Cfg.Endpoint)
+	h.Log("HTTP Body: %s", h.Cfg.HTTPBody)
+	h.Log("Timeout: %d secs", h.Cfg.TimeoutSecs)
 
 	// Create output file
 	appName := sanitizeAppNameForFileName(h.AppName)
 	fileName := fmt.Sprintf("healthCheckEndpoint.%s.out", appName)
-	outFile, err := os.Create(fileName)
+	outFile, err := os.Create(h.OutputPath(fileName))
 	if err != nil {
 		return Result{}, fmt.Errorf("failed to create output file: %w", err)
 	}
@@ -46,12 +47,12 @@ func (h *HealthCheck) Run() (Result, error) {
 
 	// Perform health check and write results
 	if err := h.executeAndRecordHealthCheck(outFile); err != nil {
-		logToFileAndLogger(outFile, "Health check failed: %v", err)
+		h.logToFileAndLogger(outFile, "Health check failed: %v", err)
 	}
 
 	// Upload results
 	dt := fmt.Sprintf("healthCheckEndpoint&fileName=%s&appName=%s", fileName, appName)
-	msg, ok := PostData(h.Endpoint(), dt, outFile)
+	msg, ok := h.PostData(dt, outFile)
 
 	return Result{Msg: msg, Ok: ok}, nil
 }
@@ -69,6 +70,9 @@ func (h *HealthCheck) executeAndRecordHealthCheck(outFile *os.File) error {
 
 	// Execute HTTP health check
 	resp, rtt, err := h.runHTTPHealthCheck(ctx)
+	up := err == nil && resp != nil && resp.StatusCode < http.StatusBadRequest
+	metrics.HealthCheckLatency.WithLabelValues(h.AppName).Set(rtt.Seconds())
+	metrics.HealthCheckUp.WithLabelValues(h.AppName).Set(boolToFloat(up))
 	if err != nil {
 		return fmt.Errorf("HTTP health check failed: %w", err)
 	}
@@ -80,13 +84,20 @@ func (h *HealthCheck) executeAndRecordHealthCheck(outFile *os.File) error {
 	defer resp.Body.Close()
 
 	// Log response time
-	logToFileAndLogger(outFile, "Round Trip Time: %v", rtt)
+	h.logToFileAndLogger(outFile, "Round Trip Time: %v", rtt)
 
 	// Dump response to file
 	err = h.dumpResponse(resp, outFile)
 	return err
 }
 
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 // validateEndpoint checks if the endpoint configuration is valid.
 func (h *HealthCheck) validateEndpoint() error {
 	if h.Cfg.Endpoint == "" {
@@ -134,29 +145,30 @@ func sanitizeAppNameForFileName(name string) string {
 	return name
 }
 
-// logToFileAndLogger writes the formatted message to both the logger and the specified file
+// logToFileAndLogger writes the formatted message to both the capture log and the specified file
 // This ensures consistent logging across multiple outputs.
-func logToFileAndLogger(file *os.File, format string, values ...interface{}) {
-	logger.Log(format, values...)
+func (h *HealthCheck) logToFileAndLogger(file *os.File, format string, values ...interface{}) {
+	h.Log(format, values...)
 	file.WriteString(fmt.Sprintf(format+"\n", values...))
 }
 
-func newHTTPClient() *http.Client {
-	return &http.Client{
-		Transport: &http.Transport{
-			MaxIdleConns:       10,
-			IdleConnTimeout:    90 * time.Second,
-			DisableCompression: true,
-			DisableKeepAlives:  true,
-			ForceAttemptHTTP2:  true,
-		},
-	}
+// newHTTPClient returns a client for the health check endpoints of the application.
+// Unlike the client of the yCrash server, it doesn't go through the proxy and
+// doesn't reuse connections, so every check measures a fresh round trip.
+func newHTTPClient() (*http.Client, error) {
+	return httpclient.New(httpclient.Options{
+		DisableCompression: true,
+		DisableKeepAlives:  true,
+	})
 }
 
 // runHTTPHealthCheck performs the actual HTTP health check request and measures
 // the round-trip time. It returns the response, duration, and any errors encountered.
 func (h *HealthCheck) runHTTPHealthCheck(ctx context.Context) (*http.Response, time.Duration, error) {
-	httpClient := newHTTPClient()
+	httpClient, err := newHTTPClient()
+	if err != nil {
+		return nil, 0, err
+	}
 
 	req, err := h.buildHealthCheckHTTPRequest(ctx)
 	if err != nil {
@@ -184,7 +196,7 @@ func (h *HealthCheck) runHTTPHealthCheck(ctx context.Context) (*http.Response, t
 func (h *HealthCheck) getTimeoutDuration() time.Duration {
 	timeoutSecs := DefaultTimeoutSeconds
 	if h.Cfg.TimeoutSecs < 0 {
-		logger.Log("Warning: Negative timeout value provided, using default")
+		h.Log("Warning: Negative timeout value provided, using default")
 	} else if h.Cfg.TimeoutSecs > 0 {
 		timeoutSecs = h.Cfg.TimeoutSecs
 	}
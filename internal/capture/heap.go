@@ -4,6 +4,7 @@ import (
 	"archive/zip"
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -18,7 +19,7 @@ import (
 	"time"
 
 	"yc-agent/internal/capture/executils"
-	"yc-agent/internal/logger"
+	"yc-agent/internal/config"
 )
 
 // Taken from yc-server
@@ -63,7 +64,7 @@ func NewHeapDump(javaHome string, pid int, hdPath string, dump bool) *HeapDump {
 
 // Run executes the heap dump capture process and uploads the captured file
 // to the specified endpoint.
-func (t *HeapDump) Run() (Result, error) {
+func (t *HeapDump) Run(ctx context.Context) (Result, error) {
 	var hd *os.File
 	var err error
 	var isCompressed bool
@@ -73,7 +74,7 @@ func (t *HeapDump) Run() (Result, error) {
 	if len(t.hdPath) > 0 {
 		isCompressed, contentEncoding = isCompressedHeapFile(t.hdPath)
 		if isCompressed {
-			logger.Log("detected pre-compressed heap dump file: %s with encoding: %s", t.hdPath, contentEncoding)
+			t.Log("detected pre-compressed heap dump file: %s with encoding: %s", t.hdPath, contentEncoding)
 		}
 
 		hd, err = t.getPreCapturedDumpFile()
@@ -86,7 +87,7 @@ func (t *HeapDump) Run() (Result, error) {
 	} else if t.Pid > 0 && t.dump {
 		var actualDumpPath string
 		// Then try capturing a new heap dump
-		hd, actualDumpPath, err = t.captureDumpFile()
+		hd, actualDumpPath, err = t.captureDumpFile(ctx)
 		if err != nil {
 			return Result{
 				Msg: fmt.Sprintf("capture heap dump failed: %s", err.Error()),
@@ -99,7 +100,7 @@ func (t *HeapDump) Run() (Result, error) {
 			defer func() {
 				err := os.Remove(actualDumpPath)
 				if err != nil {
-					logger.Trace().Err(err).Str("file", actualDumpPath).Msg("failed to rm hd file")
+					t.Logger().Trace().Err(err).Str("file", actualDumpPath).Msg("failed to rm hd file")
 				}
 			}()
 		}
@@ -112,21 +113,31 @@ func (t *HeapDump) Run() (Result, error) {
 	defer func() {
 		err := hd.Close()
 		if err != nil && !errors.Is(err, os.ErrClosed) {
-			logger.Log("failed to close hd file %s cause err: %s", hdOut, err.Error())
+			t.Log("failed to close hd file %s cause err: %s", hdOut, err.Error())
 		}
 	}()
 
 	var fileToUpload *os.File
 	var uploadContentEncoding string
 
+	if chunkedHeapUpload() {
+		result := t.UploadChunked(ctx, hd, isCompressed, contentEncoding)
+		return result, nil
+	}
+
 	if isCompressed {
 		// If the file is already compressed, use it directly without re-compressing
-		logger.Log("file is already compressed, skipping compression step")
+		t.Log("file is already compressed, skipping compression step")
 		fileToUpload = hd
 		uploadContentEncoding = contentEncoding
+	} else if streamHeapDump() {
+		// Compress while uploading, so no second full-size copy is written to disk
+		t.Log("captured heap dump data, uploading it with %s compression...", config.GlobalConfig.UploadCompression)
+		msg, ok := t.PostCompressedData("dt=hd", "Content-Encoding", hd)
+		return Result{Msg: msg, Ok: ok}, nil
 	} else {
 		// For uncompressed files, compress them
-		logger.Log("captured heap dump data, zipping...")
+		t.Log("captured heap dump data, zipping...")
 
 		zipfile, err := t.CreateZipFile(hd)
 		if err != nil {
@@ -138,14 +149,14 @@ func (t *HeapDump) Run() (Result, error) {
 
 		defer func() {
 			if err := zipfile.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
-				logger.Debug().Err(err).Msg("failed to close zip file")
+				t.Logger().Debug().Err(err).Msg("failed to close zip file")
 			}
 		}()
 
 		defer func() {
-			err = os.Remove(hdOut)
+			err = os.Remove(t.OutputPath(hdOut))
 			if err != nil {
-				logger.Log("failed to rm hd file %s cause err: %s", hdOut, err.Error())
+				t.Log("failed to rm hd file %s cause err: %s", hdOut, err.Error())
 			}
 		}()
 
@@ -157,31 +168,54 @@ func (t *HeapDump) Run() (Result, error) {
 	return result, nil
 }
 
-// getPreCapturedDumpFile handles the case when a heap dump is pre-captured (using the hdPath field)
+// streamHeapDump reports whether the heap dump is uploaded compressed on the fly. Otherwise,
+// it's zipped to hdZip first, which is kept in the capture dir in the only capture mode.
+func streamHeapDump() bool {
+	return !config.GlobalConfig.OnlyCapture &&
+		config.GlobalConfig.UploadCompression != "" &&
+		config.GlobalConfig.UploadCompression != CompressionNone
+}
+
+// chunkedHeapUpload reports whether the heap dump is uploaded in parts, see ChunkedUpload.
+// Only the yCrash server receives chunked uploads.
+func chunkedHeapUpload() bool {
+	return !config.GlobalConfig.OnlyCapture &&
+		config.GlobalConfig.HDChunkSize > 0 &&
+		UploadsToServer()
+}
+
+// getPreCapturedDumpFile handles the case when a heap dump is pre-captured (using the hdPath field).
+// The file is copied to hdOut unless it's streamed to the upload, see streamHeapDump.
 func (t *HeapDump) getPreCapturedDumpFile() (*os.File, error) {
 	hdf, err := os.Open(t.hdPath)
 
 	// Fallback, try to open the file in the Docker container
 	if err != nil && runtime.GOOS == "linux" {
-		logger.Log("failed to open hdPath(%s) err: %s. Trying to open in the Docker container...", t.hdPath, err.Error())
+		t.Log("failed to open hdPath(%s) err: %s. Trying to open in the Docker container...", t.hdPath, err.Error())
 		hdf, err = os.Open(filepath.Join("/proc", strconv.Itoa(t.Pid), "root", t.hdPath))
 	}
 
 	if err != nil {
-		logger.Log("failed to open hdPath(%s) err: %s", t.hdPath, err.Error())
+		t.Log("failed to open hdPath(%s) err: %s", t.hdPath, err.Error())
 		return nil, err
 	}
 
-	logger.Log("copying heap dump data %s", t.hdPath)
+	// Upload straight from the original file, unless it has to be kept in the capture dir
+	isCompressed, _ := isCompressedHeapFile(t.hdPath)
+	if !config.GlobalConfig.OnlyCapture && (streamHeapDump() || chunkedHeapUpload() || isCompressed) {
+		return hdf, nil
+	}
+
+	t.Log("copying heap dump data %s", t.hdPath)
 
 	defer func() {
 		err := hdf.Close()
 		if err != nil {
-			logger.Log("failed to close hd file %s cause err: %s", t.hdPath, err.Error())
+			t.Log("failed to close hd file %s cause err: %s", t.hdPath, err.Error())
 		}
 	}()
 
-	hd, err := os.Create(hdOut)
+	hd, err := os.Create(t.OutputPath(hdOut))
 	if err != nil {
 		return nil, err
 	}
@@ -196,27 +230,35 @@ func (t *HeapDump) getPreCapturedDumpFile() (*os.File, error) {
 		return nil, err
 	}
 
-	logger.Log("copied heap dump data %s", t.hdPath)
+	t.Log("copied heap dump data %s", t.hdPath)
 	return hd, nil
 }
 
 // captureDumpFile handles the case when a heap dump needs to be captured (using the Pid field)
-// and returns both the file handle and the actual dump path
-func (t *HeapDump) captureDumpFile() (*os.File, string, error) {
-	logger.Log("capturing heap dump data")
+// and returns both the file handle and the actual dump path. The dump is stopped when ctx is
+// done or the task is killed.
+func (t *HeapDump) captureDumpFile(ctx context.Context) (*os.File, string, error) {
+	ctx, cancel := t.killable(ctx)
+	defer cancel()
+
+	t.Log("capturing heap dump data")
 
-	dir, err := os.Getwd()
+	// The JVM writes the dump, relative to its own working dir
+	dir, err := filepath.Abs(t.Dir())
 	if err != nil {
 		return nil, "", err
 	}
 
 	fp := filepath.Join(dir, fmt.Sprintf("%s.%d.%d", hdOut, t.Pid, time.Now().Unix()))
-	actualDumpPath, err := t.heapDump(fp)
+	actualDumpPath, err := t.heapDump(ctx, fp)
+	if err != nil && ctx.Err() != nil {
+		return nil, "", fmt.Errorf("%w: %v", ctx.Err(), err)
+	}
 	if err != nil {
 		// Fallback if the heap dump failed
 		// Retry with a temp file, hopefully writeable
 		fp = filepath.Join(os.TempDir(), fmt.Sprintf("%s.%d.%d", hdOut, t.Pid, time.Now().Unix()))
-		actualDumpPath, err = t.heapDump(fp)
+		actualDumpPath, err = t.heapDump(ctx, fp)
 
 		if err != nil {
 			return nil, "", err
@@ -226,7 +268,7 @@ func (t *HeapDump) captureDumpFile() (*os.File, string, error) {
 	hd, err := os.Open(actualDumpPath)
 	if err != nil && runtime.GOOS == "linux" {
 		// Fallback, try to open the file in the Docker container
-		logger.Log("Failed to %s. Trying to open in the Docker container...", err.Error())
+		t.Log("Failed to %s. Trying to open in the Docker container...", err.Error())
 		actualDumpPath = filepath.Join("/proc", strconv.Itoa(t.Pid), "root", actualDumpPath)
 		hd, err = os.Open(actualDumpPath)
 	}
@@ -239,7 +281,7 @@ func (t *HeapDump) captureDumpFile() (*os.File, string, error) {
 }
 
 func (t *HeapDump) CreateZipFile(hd *os.File) (*os.File, error) {
-	zipfile, err := os.Create(hdZip)
+	zipfile, err := os.Create(t.OutputPath(hdZip))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create zip file: %w", err)
 	}
@@ -262,14 +304,54 @@ func (t *HeapDump) CreateZipFile(hd *os.File) (*os.File, error) {
 
 	e := zipfile.Sync()
 	if e != nil && !errors.Is(e, os.ErrClosed) {
-		logger.Log("failed to sync file %s", e)
+		t.Log("failed to sync file %s", e)
 	}
 
 	return zipfile, nil
 }
 
 func (t *HeapDump) UploadCapturedFile(file *os.File, contentEncoding string) Result {
-	msg, ok := PostData(t.Endpoint(), fmt.Sprintf("hd&Content-Encoding=%s", contentEncoding), file)
+	msg, ok := t.PostData(fmt.Sprintf("hd&Content-Encoding=%s", contentEncoding), file)
+
+	return Result{
+		Msg: msg,
+		Ok:  ok,
+	}
+}
+
+// UploadChunked uploads the heap dump in parts. Uncompressed dumps are compressed part
+// by part with the upload compression of the config.
+func (t *HeapDump) UploadChunked(ctx context.Context, hd *os.File, isCompressed bool, contentEncoding string) Result {
+	var compression string
+	if !isCompressed && config.GlobalConfig.UploadCompression != CompressionNone {
+		compression = config.GlobalConfig.UploadCompression
+		contentEncoding = compressionExt(compression)
+	}
+
+	url := t.Endpoint() + "&dt=hd"
+	if contentEncoding != "" {
+		url += "&Content-Encoding=" + contentEncoding
+	}
+
+	t.Log("uploading heap dump in parts of %d MB...", config.GlobalConfig.HDChunkSize)
+	upload := NewChunkedUpload(compression)
+	upload.Log = t.Logger()
+	upload.Progress = func(uploaded, total int64) {
+		t.emit(Event{Type: EventUpload, DataType: "hd", Bytes: uploaded, Total: total})
+	}
+	msg, ok := upload.Upload(ctx, url, hd)
+	if !ok {
+		msg = spoolChunkedUpload(upload, url, hd, msg)
+	}
+	e := Event{Type: EventUpload, DataType: "hd", Done: true, Ok: ok}
+	if stat, err := hd.Stat(); err == nil {
+		e.Total = stat.Size()
+		if ok {
+			e.Bytes = e.Total
+		}
+	}
+	t.emit(e)
+	t.RecordUpload("hd", hd, msg, ok)
 
 	return Result{
 		Msg: msg,
@@ -297,26 +379,33 @@ func isCompressedHeapFile(filePath string) (bool, string) {
 // - Replacement (%) tokens were specified in the file name. These will have been expanded.
 // - The full path is returned, if only a file name with no directory was specified the full path with the directory the dump was written to will be returned.
 // - The JVM couldn't write to the specified location. In this case it will attempt to write the dump to another location, unless -Xdump:nofailover was specified on the command line.
-func (t *HeapDump) heapDump(requestedFilePath string) (actualDumpPath string, err error) {
+// The tools are stopped when ctx is done, and no further attempt is made then.
+func (t *HeapDump) heapDump(ctx context.Context, requestedFilePath string) (actualDumpPath string, err error) {
 	// The default value of writtenDumpPath is the same as the requested file path
 	actualDumpPath = requestedFilePath
 	var output []byte
 
 	// Heap dump: Attempt 1: jcmd
-	output, err = executils.CommandCombinedOutput(executils.Command{path.Join(t.JavaHome, "/bin/jcmd"), strconv.Itoa(t.Pid), "GC.heap_dump", requestedFilePath}, executils.SudoHooker{PID: t.Pid})
-	logger.Log("heap dump output from jcmd: %s, %v", output, err)
+	t.SetMethod("jcmd")
+	output, err = executils.CommandCombinedOutput(executils.Command{path.Join(t.JavaHome, "/bin/jcmd"), strconv.Itoa(t.Pid), "GC.heap_dump", requestedFilePath}, executils.SudoHooker{PID: t.Pid}, executils.ContextHooker{Ctx: ctx})
+	t.Log("heap dump output from jcmd: %s, %v", output, err)
 	if err != nil ||
 		bytes.Index(output, []byte("No such file")) >= 0 ||
 		bytes.Index(output, []byte("Permission denied")) >= 0 {
 		if len(output) > 1 {
 			err = fmt.Errorf("%w because %s", err, output)
 		}
+		if ctx.Err() != nil {
+			return
+		}
 		var e2 error
 		// Heap dump: Attempt 2a: jattach
+		t.SetMethod("jattach")
 		output, e2 = executils.CommandCombinedOutput(executils.Command{executils.Executable(), "-p", strconv.Itoa(t.Pid), "-hdPath", requestedFilePath, "-hdCaptureMode"},
 			executils.EnvHooker{"pid": strconv.Itoa(t.Pid)},
-			executils.SudoHooker{PID: t.Pid})
-		logger.Log("heap dump output from jattach: %s, %v", output, e2)
+			executils.SudoHooker{PID: t.Pid},
+			executils.ContextHooker{Ctx: ctx})
+		t.Log("heap dump output from jattach: %s, %v", output, e2)
 		if e2 != nil ||
 			bytes.Index(output, []byte("No such file")) >= 0 ||
 			bytes.Index(output, []byte("Permission denied")) >= 0 {
@@ -324,7 +413,11 @@ func (t *HeapDump) heapDump(requestedFilePath string) (actualDumpPath string, er
 				e2 = fmt.Errorf("%w because %s", e2, output)
 			}
 			err = fmt.Errorf("%v: %v", e2, err)
+			if ctx.Err() != nil {
+				return
+			}
 			// Heap dump: Attempt 2b: tmp jattach
+			t.SetMethod("tmp jattach")
 			tempPath, e := executils.Copy2TempPath()
 			if e != nil {
 				err = fmt.Errorf("%v: %v", e, err)
@@ -333,8 +426,9 @@ func (t *HeapDump) heapDump(requestedFilePath string) (actualDumpPath string, er
 			var e3 error
 			output, e3 = executils.CommandCombinedOutput(executils.Command{tempPath, "-p", strconv.Itoa(t.Pid), "-hdPath", requestedFilePath, "-hdCaptureMode"},
 				executils.EnvHooker{"pid": strconv.Itoa(t.Pid)},
-				executils.SudoHooker{PID: t.Pid})
-			logger.Log("heap dump output from tmp jattach: %s, %v", output, e3)
+				executils.SudoHooker{PID: t.Pid},
+				executils.ContextHooker{Ctx: ctx})
+			t.Log("heap dump output from tmp jattach: %s, %v", output, e3)
 			if e3 != nil ||
 				bytes.Index(output, []byte("No such file")) >= 0 ||
 				bytes.Index(output, []byte("Permission denied")) >= 0 {
@@ -350,8 +444,8 @@ func (t *HeapDump) heapDump(requestedFilePath string) (actualDumpPath string, er
 				return
 			}
 			command := executils.Command{"sudo", "chown", fmt.Sprintf("%s:%s", u.Username, u.Username), requestedFilePath}
-			e = executils.CommandRun(command)
-			logger.Info().Str("cmd", strings.Join(command, " ")).Msgf("chown: %s, %v", requestedFilePath, e)
+			e = executils.CommandRun(command, executils.ContextHooker{Ctx: ctx})
+			t.Logger().Info().Str("cmd", strings.Join(command, " ")).Msgf("chown: %s, %v", requestedFilePath, e)
 			if e != nil {
 				err = fmt.Errorf("%v: %v", e, err)
 				return
@@ -1,8 +1,11 @@
 package capture
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
@@ -58,7 +61,7 @@ func testHeapDump(hdPath string, dump bool) func(t *testing.T) {
 		defer noGC.KillAndWait()
 		capHeapDump := NewHeapDump(javaHome, noGC.GetPid(), hdPath, dump)
 		capHeapDump.SetEndpoint(heapEndpoint)
-		r, err := capHeapDump.Run()
+		r, err := capHeapDump.Run(context.Background())
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -74,8 +77,41 @@ func testHeapDumpWithInvalidPid(t *testing.T) {
 	var err error
 	capHeapDump := NewHeapDump(javaHome, 65535, "", true)
 	capHeapDump.SetEndpoint(heapEndpoint)
-	r, err := capHeapDump.Run()
+	r, err := capHeapDump.Run(context.Background())
 	if err == nil || r.Ok {
 		t.Fatal(r)
 	}
 }
+
+func TestHeapDump_Kill(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("jcmd stub is a shell script")
+	}
+	// jcmd hangs, as on a JVM which doesn't reach a safepoint
+	jh := t.TempDir()
+	if err := os.Mkdir(filepath.Join(jh, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(jh, "bin", "jcmd"), []byte("#!/bin/sh\nsleep 60\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	capHeapDump := NewHeapDump(jh, os.Getpid(), "", true)
+	capHeapDump.SetDir(t.TempDir())
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		capHeapDump.Kill()
+	}()
+
+	start := time.Now()
+	r, err := capHeapDump.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("heap dump took %s after Kill", elapsed)
+	}
+	if r.Ok || !strings.Contains(r.Msg, context.Canceled.Error()) {
+		t.Fatal(r)
+	}
+}
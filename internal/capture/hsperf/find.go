@@ -0,0 +1,83 @@
+package hsperf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrNotFound is returned when no hsperfdata file exists for the process, for
+// example when it isn't a JVM or runs with -XX:-UsePerfData.
+var ErrNotFound = errors.New("hsperfdata file not found")
+
+// Find returns the path of the hsperfdata file of the JVM pid.
+//
+// A containerized JVM writes the file in the /tmp of its mount namespace,
+// named after its pid in the namespace, so the file is looked up through
+// /proc/<pid>/root/tmp first, then in the temp dir of the agent.
+func Find(pid int) (string, error) {
+	return FindIn("/proc", pid)
+}
+
+// FindIn is Find with the proc file system mounted at procRoot.
+func FindIn(procRoot string, pid int) (string, error) {
+	var patterns []string
+	procDir := filepath.Join(procRoot, strconv.Itoa(pid))
+	if _, err := os.Stat(procDir); err == nil {
+		nsPid := namespacePid(procRoot, pid)
+		patterns = append(patterns, filepath.Join(procDir, "root", "tmp", "hsperfdata_*", strconv.Itoa(nsPid)))
+		if nsPid != pid {
+			patterns = append(patterns, filepath.Join(procDir, "root", "tmp", "hsperfdata_*", strconv.Itoa(pid)))
+		}
+	}
+	patterns = append(patterns, filepath.Join(os.TempDir(), "hsperfdata_*", strconv.Itoa(pid)))
+
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			continue
+		}
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.Mode().IsRegular() {
+				return match, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w for pid %d", ErrNotFound, pid)
+}
+
+// NamespacePid returns the pid of the process in its innermost pid namespace,
+// the last NSpid of /proc/<pid>/status, or pid when unknown.
+func NamespacePid(pid int) int {
+	return namespacePid("/proc", pid)
+}
+
+func namespacePid(procRoot string, pid int) int {
+	file, err := os.Open(filepath.Join(procRoot, strconv.Itoa(pid), "status"))
+	if err != nil {
+		return pid
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "NSpid:") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "NSpid:"))
+		if len(fields) == 0 {
+			break
+		}
+		nsPid, err := strconv.Atoi(fields[len(fields)-1])
+		if err != nil {
+			break
+		}
+		return nsPid
+	}
+	return pid
+}
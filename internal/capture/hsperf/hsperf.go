@@ -0,0 +1,202 @@
+// Package hsperf reads the HotSpot performance data files, the
+// hsperfdata_<user>/<pid> files a JVM maps into memory to publish its counters
+// unless it runs with -XX:-UsePerfData. It lets the agent sample the JVM
+// counters, like jstat does, without any JDK tools.
+//
+// A file starts with a 32 bytes prologue, followed by the entries:
+//
+//	magic          uint32, always 0xcafec0c0 big endian
+//	byte order     byte, 0 big endian, 1 little endian
+//	major, minor   byte, the version of the format, 2.0 since JDK 1.5
+//	accessible     byte, 0 until the JVM initialized the file
+//	used, overflow int32
+//	mod time stamp int64
+//	entry offset   int32, offset of the first entry
+//	num entries    int32
+//
+// Every entry has a 20 bytes header, in the byte order of the prologue:
+//
+//	entry length   int32
+//	name offset    int32, from the start of the entry, nul terminated
+//	vector length  int32, 0 for scalars
+//	data type      byte, a java basic type signature such as 'J' for long
+//	flags          byte
+//	data units     byte, see Units
+//	variability    byte, see Variability
+//	data offset    int32, from the start of the entry
+package hsperf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	Magic = 0xcafec0c0
+
+	prologueSize    = 32
+	entryHeaderSize = 20
+	supportedMajor  = 2
+)
+
+// Units of the data of an entry.
+type Units byte
+
+const (
+	UnitsNone   Units = 1
+	UnitsBytes  Units = 2
+	UnitsTicks  Units = 3
+	UnitsEvents Units = 4
+	UnitsString Units = 5
+	UnitsHertz  Units = 6
+)
+
+// Variability of the data of an entry.
+type Variability byte
+
+const (
+	VariabilityConstant  Variability = 1
+	VariabilityMonotonic Variability = 2
+	VariabilityVariable  Variability = 3
+)
+
+// ErrNotAccessible is returned for a file the JVM didn't initialize yet.
+var ErrNotAccessible = errors.New("hsperfdata is not accessible yet")
+
+// Entry is a counter of the JVM.
+type Entry struct {
+	Name        string
+	Units       Units
+	Variability Variability
+	// Long holds the value of a long scalar.
+	Long int64
+	// String holds the value of a byte vector, such as a string constant.
+	String string
+	// IsLong reports whether the entry is a long scalar.
+	IsLong bool
+}
+
+// PerfData is a snapshot of the counters of a JVM.
+type PerfData struct {
+	Major, Minor int
+	// ModTimeStamp is the last time the JVM modified the entries, in hrt ticks.
+	ModTimeStamp int64
+	Entries      map[string]Entry
+}
+
+// ReadFile reads the counters of the hsperfdata file at path.
+func ReadFile(path string) (*PerfData, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse parses the content of a hsperfdata file.
+func Parse(data []byte) (*PerfData, error) {
+	if len(data) < prologueSize {
+		return nil, fmt.Errorf("hsperfdata too short: %d bytes", len(data))
+	}
+	if magic := binary.BigEndian.Uint32(data); magic != Magic {
+		return nil, fmt.Errorf("invalid hsperfdata magic %#x", magic)
+	}
+
+	var order binary.ByteOrder = binary.BigEndian
+	if data[4] == 1 {
+		order = binary.LittleEndian
+	}
+	pd := &PerfData{
+		Major: int(data[5]),
+		Minor: int(data[6]),
+	}
+	if pd.Major != supportedMajor {
+		return nil, fmt.Errorf("unsupported hsperfdata version %d.%d", pd.Major, pd.Minor)
+	}
+	if data[7] == 0 {
+		return nil, ErrNotAccessible
+	}
+	pd.ModTimeStamp = int64(order.Uint64(data[16:]))
+
+	offset := int(int32(order.Uint32(data[24:])))
+	numEntries := int(int32(order.Uint32(data[28:])))
+	if numEntries < 0 {
+		return nil, fmt.Errorf("invalid hsperfdata entry count %d", numEntries)
+	}
+
+	pd.Entries = make(map[string]Entry, numEntries)
+	for i := 0; i < numEntries; i++ {
+		if offset < prologueSize || offset+entryHeaderSize > len(data) {
+			return nil, fmt.Errorf("hsperfdata entry %d out of bounds at %d", i, offset)
+		}
+		header := data[offset : offset+entryHeaderSize]
+		length := int(int32(order.Uint32(header)))
+		if length < entryHeaderSize || offset+length > len(data) {
+			return nil, fmt.Errorf("hsperfdata entry %d has invalid length %d", i, length)
+		}
+		entryData := data[offset : offset+length]
+		offset += length
+
+		nameOffset := int(int32(order.Uint32(header[4:])))
+		vectorLength := int(int32(order.Uint32(header[8:])))
+		dataType := header[12]
+		dataOffset := int(int32(order.Uint32(header[16:])))
+		if nameOffset < entryHeaderSize || nameOffset >= length || dataOffset < entryHeaderSize || dataOffset > length {
+			return nil, fmt.Errorf("hsperfdata entry %d has invalid offsets", i)
+		}
+
+		name := entryData[nameOffset:]
+		if end := bytes.IndexByte(name, 0); end >= 0 {
+			name = name[:end]
+		}
+		e := Entry{
+			Name:        string(name),
+			Units:       Units(header[14]),
+			Variability: Variability(header[15]),
+		}
+
+		value := entryData[dataOffset:]
+		switch {
+		case dataType == 'J' && vectorLength == 0:
+			if len(value) < 8 {
+				return nil, fmt.Errorf("hsperfdata entry %s has truncated data", e.Name)
+			}
+			e.Long = int64(order.Uint64(value))
+			e.IsLong = true
+		case dataType == 'B' && vectorLength > 0:
+			if vectorLength < len(value) {
+				value = value[:vectorLength]
+			}
+			if end := bytes.IndexByte(value, 0); end >= 0 {
+				value = value[:end]
+			}
+			e.String = string(value)
+		default:
+			// other types aren't published by HotSpot, keep the entry without a value
+		}
+		pd.Entries[e.Name] = e
+	}
+
+	return pd, nil
+}
+
+// Long returns the value of the long counter name.
+func (pd *PerfData) Long(name string) (int64, bool) {
+	e, ok := pd.Entries[name]
+	if !ok || !e.IsLong {
+		return 0, false
+	}
+	return e.Long, true
+}
+
+// String returns the value of the string counter name.
+func (pd *PerfData) String(name string) (string, bool) {
+	e, ok := pd.Entries[name]
+	if !ok || e.IsLong {
+		return "", false
+	}
+	return e.String, true
+}
@@ -0,0 +1,224 @@
+package hsperf
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "regenerate the hsperfdata fixtures in testdata")
+
+type fixtureEntry struct {
+	name  string
+	units Units
+	long  int64
+	str   string
+}
+
+// encode writes entries in the hsperfdata format, like HotSpot's PerfMemory does.
+func encode(order binary.ByteOrder, entries []fixtureEntry) []byte {
+	var body bytes.Buffer
+	for _, e := range entries {
+		name := append([]byte(e.name), 0)
+		nameOffset := entryHeaderSize
+		dataOffset := nameOffset + len(name)
+		// longs are 8 bytes aligned
+		for dataOffset%8 != 0 {
+			name = append(name, 0)
+			dataOffset++
+		}
+
+		var data []byte
+		var dataType byte = 'J'
+		vectorLength := 0
+		if e.units == UnitsString {
+			dataType = 'B'
+			data = append([]byte(e.str), 0)
+			vectorLength = len(data)
+		} else {
+			data = make([]byte, 8)
+			order.PutUint64(data, uint64(e.long))
+		}
+		length := dataOffset + len(data)
+		for length%8 != 0 {
+			data = append(data, 0)
+			length++
+		}
+
+		header := make([]byte, entryHeaderSize)
+		order.PutUint32(header, uint32(length))
+		order.PutUint32(header[4:], uint32(nameOffset))
+		order.PutUint32(header[8:], uint32(vectorLength))
+		header[12] = dataType
+		header[14] = byte(e.units)
+		header[15] = byte(VariabilityVariable)
+		order.PutUint32(header[16:], uint32(dataOffset))
+		body.Write(header)
+		body.Write(name)
+		body.Write(data)
+	}
+
+	prologue := make([]byte, prologueSize)
+	binary.BigEndian.PutUint32(prologue, Magic)
+	if order == binary.LittleEndian {
+		prologue[4] = 1
+	}
+	prologue[5] = 2
+	prologue[7] = 1
+	order.PutUint32(prologue[8:], uint32(prologueSize+body.Len()))
+	order.PutUint64(prologue[16:], 1234)
+	order.PutUint32(prologue[24:], prologueSize)
+	order.PutUint32(prologue[28:], uint32(len(entries)))
+	return append(prologue, body.Bytes()...)
+}
+
+func gcEntries(concurrent bool) []fixtureEntry {
+	mb := int64(1024 * 1024)
+	entries := []fixtureEntry{
+		{name: "sun.rt.javaCommand", units: UnitsString, str: "com.example.BuggyApp"},
+		{name: "sun.os.hrt.frequency", units: UnitsHertz, long: 1000000000},
+		{name: "sun.os.hrt.ticks", units: UnitsTicks, long: 12345600000},
+		{name: "sun.gc.generation.0.space.0.capacity", units: UnitsBytes, long: 64 * mb},
+		{name: "sun.gc.generation.0.space.0.used", units: UnitsBytes, long: 16 * mb},
+		{name: "sun.gc.generation.0.space.1.capacity", units: UnitsBytes, long: 8 * mb},
+		{name: "sun.gc.generation.0.space.1.used", units: UnitsBytes, long: 2 * mb},
+		{name: "sun.gc.generation.0.space.2.capacity", units: UnitsBytes, long: 8 * mb},
+		{name: "sun.gc.generation.0.space.2.used", units: UnitsBytes, long: 0},
+		{name: "sun.gc.generation.1.space.0.capacity", units: UnitsBytes, long: 256 * mb},
+		{name: "sun.gc.generation.1.space.0.used", units: UnitsBytes, long: 100 * mb},
+		{name: "sun.gc.metaspace.capacity", units: UnitsBytes, long: 40 * mb},
+		{name: "sun.gc.metaspace.used", units: UnitsBytes, long: 38 * mb},
+		{name: "sun.gc.compressedclassspace.capacity", units: UnitsBytes, long: 5 * mb},
+		{name: "sun.gc.compressedclassspace.used", units: UnitsBytes, long: 4 * mb},
+		{name: "sun.gc.collector.0.invocations", units: UnitsEvents, long: 42},
+		{name: "sun.gc.collector.0.time", units: UnitsTicks, long: 250000000},
+		{name: "sun.gc.collector.1.invocations", units: UnitsEvents, long: 2},
+		{name: "sun.gc.collector.1.time", units: UnitsTicks, long: 1500000000},
+	}
+	if concurrent {
+		entries = append(entries,
+			fixtureEntry{name: "sun.gc.collector.2.invocations", units: UnitsEvents, long: 6},
+			fixtureEntry{name: "sun.gc.collector.2.time", units: UnitsTicks, long: 30000000},
+		)
+	}
+	return entries
+}
+
+// The fixtures are a parallel GC JVM on a big endian host, and a G1 JVM on a
+// little endian host, which has a concurrent collector.
+var fixtures = map[string][]byte{
+	"parallel-be.hsperfdata": encode(binary.BigEndian, gcEntries(false)),
+	"g1-le.hsperfdata":       encode(binary.LittleEndian, gcEntries(true)),
+}
+
+func TestFixtures(t *testing.T) {
+	for name, data := range fixtures {
+		path := filepath.Join("testdata", name)
+		if *update {
+			require.NoError(t, os.MkdirAll("testdata", 0755))
+			require.NoError(t, os.WriteFile(path, data, 0644))
+		}
+		recorded, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, data, recorded, "%s is outdated, run the tests with -update", name)
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	pd, err := ReadFile(filepath.Join("testdata", "g1-le.hsperfdata"))
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, pd.Major)
+	assert.Equal(t, int64(1234), pd.ModTimeStamp)
+	command, ok := pd.String("sun.rt.javaCommand")
+	assert.True(t, ok)
+	assert.Equal(t, "com.example.BuggyApp", command)
+	invocations, ok := pd.Long("sun.gc.collector.0.invocations")
+	assert.True(t, ok)
+	assert.Equal(t, int64(42), invocations)
+	assert.Equal(t, UnitsEvents, pd.Entries["sun.gc.collector.0.invocations"].Units)
+
+	_, ok = pd.Long("sun.rt.javaCommand")
+	assert.False(t, ok, "a string is not a long")
+}
+
+func TestParse_Invalid(t *testing.T) {
+	_, err := Parse([]byte{0xca, 0xfe})
+	assert.ErrorContains(t, err, "too short")
+
+	data := encode(binary.LittleEndian, gcEntries(false))
+	bad := append([]byte(nil), data...)
+	bad[0] = 0
+	_, err = Parse(bad)
+	assert.ErrorContains(t, err, "invalid hsperfdata magic")
+
+	notReady := append([]byte(nil), data...)
+	notReady[7] = 0
+	_, err = Parse(notReady)
+	assert.ErrorIs(t, err, ErrNotAccessible)
+
+	_, err = Parse(data[:len(data)-10])
+	assert.ErrorContains(t, err, "invalid length")
+}
+
+func TestGCRow(t *testing.T) {
+	pd, err := ReadFile(filepath.Join("testdata", "g1-le.hsperfdata"))
+	require.NoError(t, err)
+	row, err := GCRow(pd)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"12.3", "8192.0", "8192.0", "2048.0", "0.0", "65536.0", "16384.0", "262144.0",
+		"102400.0", "40960.0", "38912.0", "5120.0", "4096.0", "42", "0.250", "2", "1.500", "6", "0.030", "1.780"}, row)
+
+	pd, err = ReadFile(filepath.Join("testdata", "parallel-be.hsperfdata"))
+	require.NoError(t, err)
+	row, err = GCRow(pd)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-", "-", "1.750"}, row[17:], "no concurrent collector")
+}
+
+func TestSampleGC(t *testing.T) {
+	var out bytes.Buffer
+	samples, err := SampleGC(context.Background(), filepath.Join("testdata", "g1-le.hsperfdata"), &out, time.Millisecond, 3)
+	require.NoError(t, err)
+	assert.Equal(t, 3, samples)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 4)
+	assert.Equal(t, GCColumns, strings.Fields(lines[0]))
+	assert.Len(t, strings.Fields(lines[1]), len(GCColumns))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	out.Reset()
+	samples, err = SampleGC(ctx, filepath.Join("testdata", "g1-le.hsperfdata"), &out, time.Hour, 3)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, samples, "the samples taken so far are kept")
+}
+
+func TestFind(t *testing.T) {
+	dir := t.TempDir()
+	procRoot := filepath.Join(dir, "proc")
+
+	// a containerized JVM, pid 4321 on the host and 7 in its namespace
+	require.NoError(t, os.MkdirAll(filepath.Join(procRoot, "4321", "root", "tmp", "hsperfdata_app"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(procRoot, "4321", "status"), []byte("Name:\tjava\nNSpid:\t4321\t7\n"), 0644))
+	hsperfdata := filepath.Join(procRoot, "4321", "root", "tmp", "hsperfdata_app", "7")
+	require.NoError(t, os.WriteFile(hsperfdata, fixtures["g1-le.hsperfdata"], 0644))
+
+	assert.Equal(t, 7, namespacePid(procRoot, 4321))
+	path, err := FindIn(procRoot, 4321)
+	require.NoError(t, err)
+	assert.Equal(t, hsperfdata, path)
+
+	_, err = FindIn(procRoot, 999999)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
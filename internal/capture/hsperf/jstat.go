@@ -0,0 +1,140 @@
+package hsperf
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GCColumns are the columns of jstat -gc -t, in order.
+var GCColumns = []string{"Timestamp", "S0C", "S1C", "S0U", "S1U", "EC", "EU", "OC", "OU", "MC", "MU",
+	"CCSC", "CCSU", "YGC", "YGCT", "FGC", "FGCT", "CGC", "CGCT", "GCT"}
+
+// the counter of every capacity and usage column, reported in KB
+var gcSizeCounters = map[string]string{
+	"S0C":  "sun.gc.generation.0.space.1.capacity",
+	"S1C":  "sun.gc.generation.0.space.2.capacity",
+	"S0U":  "sun.gc.generation.0.space.1.used",
+	"S1U":  "sun.gc.generation.0.space.2.used",
+	"EC":   "sun.gc.generation.0.space.0.capacity",
+	"EU":   "sun.gc.generation.0.space.0.used",
+	"OC":   "sun.gc.generation.1.space.0.capacity",
+	"OU":   "sun.gc.generation.1.space.0.used",
+	"MC":   "sun.gc.metaspace.capacity",
+	"MU":   "sun.gc.metaspace.used",
+	"CCSC": "sun.gc.compressedclassspace.capacity",
+	"CCSU": "sun.gc.compressedclassspace.used",
+}
+
+// the collector of every count and time column: young, full and concurrent
+var gcCollectors = map[string]int{
+	"YGC": 0, "YGCT": 0,
+	"FGC": 1, "FGCT": 1,
+	"CGC": 2, "CGCT": 2,
+}
+
+const (
+	hrtFrequency = "sun.os.hrt.frequency"
+	hrtTicks     = "sun.os.hrt.ticks"
+)
+
+// GCRow formats the jstat -gc -t columns of pd, "-" for the counters the JVM
+// doesn't publish, such as the concurrent collector of the parallel GC.
+func GCRow(pd *PerfData) ([]string, error) {
+	frequency, ok := pd.Long(hrtFrequency)
+	if !ok || frequency <= 0 {
+		return nil, fmt.Errorf("hsperfdata has no %s", hrtFrequency)
+	}
+	seconds := func(ticks int64) float64 {
+		return float64(ticks) / float64(frequency)
+	}
+
+	row := make([]string, 0, len(GCColumns))
+	var gct float64
+	for _, column := range GCColumns {
+		switch column {
+		case "Timestamp":
+			ticks, _ := pd.Long(hrtTicks)
+			row = append(row, strconv.FormatFloat(seconds(ticks), 'f', 1, 64))
+		case "GCT":
+			row = append(row, strconv.FormatFloat(gct, 'f', 3, 64))
+		case "YGC", "FGC", "CGC":
+			count, ok := pd.Long(fmt.Sprintf("sun.gc.collector.%d.invocations", gcCollectors[column]))
+			if !ok {
+				row = append(row, "-")
+				continue
+			}
+			row = append(row, strconv.FormatInt(count, 10))
+		case "YGCT", "FGCT", "CGCT":
+			ticks, ok := pd.Long(fmt.Sprintf("sun.gc.collector.%d.time", gcCollectors[column]))
+			if !ok {
+				row = append(row, "-")
+				continue
+			}
+			gct += seconds(ticks)
+			row = append(row, strconv.FormatFloat(seconds(ticks), 'f', 3, 64))
+		default:
+			size, ok := pd.Long(gcSizeCounters[column])
+			if !ok {
+				row = append(row, "-")
+				continue
+			}
+			row = append(row, strconv.FormatFloat(float64(size)/1024, 'f', 1, 64))
+		}
+	}
+	return row, nil
+}
+
+// writeGCLine writes the columns right aligned like jstat does.
+func writeGCLine(w io.Writer, columns []string) error {
+	var line strings.Builder
+	for i, column := range columns {
+		width := 12
+		if i == 0 {
+			width = 14
+		}
+		fmt.Fprintf(&line, "%*s", width, column)
+	}
+	line.WriteByte('\n')
+	_, err := io.WriteString(w, line.String())
+	return err
+}
+
+// SampleGC writes the jstat -gc -t output of the hsperfdata file at path to w,
+// count samples, one per interval, the way `jstat -gc -t <pid> <interval> <count>`
+// does. It returns the number of samples written, which are kept when ctx is
+// done or the JVM exits before the last one.
+func SampleGC(ctx context.Context, path string, w io.Writer, interval time.Duration, count int) (int, error) {
+	samples := 0
+	for samples < count {
+		if samples > 0 {
+			select {
+			case <-ctx.Done():
+				return samples, ctx.Err()
+			case <-time.After(interval):
+			}
+		}
+
+		pd, err := ReadFile(path)
+		if err != nil {
+			return samples, err
+		}
+		row, err := GCRow(pd)
+		if err != nil {
+			return samples, err
+		}
+		if samples == 0 {
+			if err := writeGCLine(w, GCColumns); err != nil {
+				return samples, err
+			}
+		}
+		if err := writeGCLine(w, row); err != nil {
+			return samples, err
+		}
+		samples++
+	}
+	return samples, nil
+}
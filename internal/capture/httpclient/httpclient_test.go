@@ -0,0 +1,150 @@
+package httpclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"yc-agent/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyWithAuth(t *testing.T) {
+	var gotURL, gotAuth string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURL = r.URL.String()
+		gotAuth = r.Header.Get("Proxy-Authorization")
+	}))
+	defer proxy.Close()
+
+	proxyURL := "http://user:secret@" + proxy.Listener.Addr().String()
+	client, err := New(Options{Proxy: proxyURL})
+	require.NoError(t, err)
+
+	resp, err := client.Get("http://yc.example.com/yc-attendance")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, "http://yc.example.com/yc-attendance", gotURL)
+	assert.Equal(t, "Basic dXNlcjpzZWNyZXQ=", gotAuth)
+}
+
+func TestInvalidProxyHidesCredentials(t *testing.T) {
+	_, err := New(Options{Proxy: "user:secret@proxy"})
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "secret")
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	clientCert, certPath, keyPath := writeClientCert(t, dir)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	caPath := filepath.Join(dir, "ca.pem")
+	writePEM(t, caPath, "CERTIFICATE", server.Certificate().Raw)
+
+	t.Run("without client certificate", func(t *testing.T) {
+		client, err := New(Options{CACertPath: caPath})
+		require.NoError(t, err)
+		_, err = client.Get(server.URL)
+		assert.Error(t, err)
+	})
+
+	t.Run("with client certificate", func(t *testing.T) {
+		client, err := New(Options{CACertPath: caPath, ClientCertPath: certPath, ClientKeyPath: keyPath})
+		require.NoError(t, err)
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "yc-agent", string(b))
+	})
+
+	t.Run("untrusted server", func(t *testing.T) {
+		client, err := New(Options{ClientCertPath: certPath, ClientKeyPath: keyPath})
+		require.NoError(t, err)
+		_, err = client.Get(server.URL)
+		assert.Error(t, err)
+	})
+}
+
+func TestClientCertRequiresKey(t *testing.T) {
+	_, err := New(Options{ClientCertPath: "client.pem"})
+	assert.Error(t, err)
+}
+
+func TestShared(t *testing.T) {
+	previous := config.GlobalConfig.Options
+	defer func() { config.GlobalConfig.Options = previous }()
+
+	c1, err := Shared()
+	require.NoError(t, err)
+	c2, err := Shared()
+	require.NoError(t, err)
+	assert.Same(t, c1, c2, "the client should be reused")
+
+	config.GlobalConfig.Proxy = "http://proxy:3128"
+	c3, err := Shared()
+	require.NoError(t, err)
+	assert.NotSame(t, c1, c3, "the client should be recreated when the config changes")
+}
+
+func writeClientCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "yc-agent"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPath := filepath.Join(dir, "client.pem")
+	keyPath := filepath.Join(dir, "client-key.pem")
+	writePEM(t, certPath, "CERTIFICATE", der)
+	writePEM(t, keyPath, "EC PRIVATE KEY", keyDer)
+
+	return cert, certPath, keyPath
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600)
+	require.NoError(t, err)
+}
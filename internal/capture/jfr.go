@@ -0,0 +1,142 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+const (
+	jfrOutputPath    = "jfr.jfr"
+	jfrRecordingName = "ycrash"
+	// jfrStopMargin is added to the duration the recording is started with, so
+	// the JVM stops it by itself if the agent dies before stopping it.
+	jfrStopMargin = 5 * time.Minute
+)
+
+// JFR records a Java Flight Recording of the process, through jcmd or jattach.
+// The recording is dumped and stopped once Duration elapsed, or as soon as the
+// capture is cancelled, then it's uploaded compressed as dt=jfr.
+type JFR struct {
+	Capture
+	JavaHome string
+	Pid      int
+	Duration time.Duration
+	// Settings is the settings profile of the recording, default or profile,
+	// or the path of a .jfc file in the JVM.
+	Settings string
+	// MaxSize is the maximum size of the recording, such as 250M, unlimited if empty.
+	MaxSize string
+
+	// jcmd runs a diagnostic command on the process, executeJcmd if nil.
+	jcmd func(w io.Writer, command string) error
+}
+
+func (t *JFR) Run(ctx context.Context) (result Result, err error) {
+	if t.jcmd == nil {
+		t.jcmd = func(w io.Writer, command string) error {
+			return executeJcmd(t.Logger(), w, t.JavaHome, t.Pid, command)
+		}
+	}
+
+	// The recording is written by the JVM, in its temp dir
+	recordingPath := filepath.Join(os.TempDir(), fmt.Sprintf("yc-%d-%d.jfr", t.Pid, time.Now().UnixNano()))
+	err = t.start()
+	if err != nil {
+		result.Msg = err.Error()
+		return
+	}
+
+	t.Log("recording JFR of %d for %s", t.Pid, t.Duration)
+	select {
+	case <-ctx.Done():
+		t.Log("JFR recording interrupted: %s, dumping what was recorded so far", ctx.Err())
+	case <-time.After(t.Duration):
+	}
+
+	dumpErr := t.command(fmt.Sprintf("JFR.dump name=%s filename=%s", jfrRecordingName, recordingPath))
+	stopErr := t.command("JFR.stop name=" + jfrRecordingName)
+	if stopErr != nil {
+		t.Log("failed to stop the JFR recording: %s", stopErr.Error())
+	}
+	if dumpErr != nil {
+		err = dumpErr
+		result.Msg = err.Error()
+		return
+	}
+
+	file, err := t.collect(recordingPath)
+	if err != nil {
+		result.Msg = fmt.Sprintf("failed to collect the JFR recording %s: %s", recordingPath, err.Error())
+		return
+	}
+	defer file.Close()
+
+	result.Msg, result.Ok = t.PostCompressedData("dt=jfr", "content-encoding", file)
+	return
+}
+
+// start starts the recording, with a duration the JVM stops it after if it's not stopped.
+func (t *JFR) start() error {
+	command := fmt.Sprintf("JFR.start name=%s duration=%ds", jfrRecordingName, int((t.Duration + jfrStopMargin).Seconds()))
+	if t.Settings != "" {
+		command += " settings=" + t.Settings
+	}
+	if t.MaxSize != "" {
+		command += " maxsize=" + t.MaxSize
+	}
+	return t.command(command)
+}
+
+// command runs the jcmd command, and fails on the errors jcmd reports in its
+// output, since it exits successfully on them.
+func (t *JFR) command(command string) error {
+	var output bytes.Buffer
+	err := t.jcmd(&output, command)
+	t.Log("%s: %s", command, output.String())
+	if err != nil {
+		return fmt.Errorf("failed to run %s: %w", command, err)
+	}
+	for _, failure := range [][]byte{[]byte("Could not"), []byte("Exception"), []byte("Unknown diagnostic command")} {
+		if bytes.Contains(output.Bytes(), failure) {
+			return fmt.Errorf("failed to run %s: %s", command, bytes.TrimSpace(output.Bytes()))
+		}
+	}
+	return nil
+}
+
+// collect moves the recording written by the JVM to the capture dir. The
+// recording of a containerized JVM is read through its root in /proc.
+func (t *JFR) collect(recordingPath string) (*os.File, error) {
+	src, err := os.Open(recordingPath)
+	if err != nil && runtime.GOOS == "linux" {
+		recordingPath = filepath.Join("/proc", strconv.Itoa(t.Pid), "root", recordingPath)
+		src, err = os.Open(recordingPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = src.Close()
+		if err := os.Remove(recordingPath); err != nil {
+			t.Log("failed to remove the JFR recording %s: %s", recordingPath, err.Error())
+		}
+	}()
+
+	dst, err := os.Create(t.OutputPath(jfrOutputPath))
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		_ = dst.Close()
+		return nil, err
+	}
+	return dst, nil
+}
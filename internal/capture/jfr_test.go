@@ -0,0 +1,99 @@
+package capture
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeJcmd records the commands, and writes the recording on JFR.dump like the JVM does.
+type fakeJcmd struct {
+	mu       sync.Mutex
+	commands []string
+}
+
+func (f *fakeJcmd) run(w io.Writer, command string) error {
+	f.mu.Lock()
+	f.commands = append(f.commands, command)
+	f.mu.Unlock()
+
+	if strings.HasPrefix(command, "JFR.dump") {
+		filename := command[strings.Index(command, "filename=")+len("filename="):]
+		if err := os.WriteFile(filename, []byte("FLR\x00recording"), 0644); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "OK")
+	return err
+}
+
+func TestJFR(t *testing.T) {
+	dir := t.TempDir()
+
+	var dataType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dataType = r.URL.Query().Get("dt")
+	}))
+	defer server.Close()
+
+	jcmd := &fakeJcmd{}
+	task := &JFR{Pid: 1234, Duration: 10 * time.Millisecond, Settings: "profile", MaxSize: "100M", jcmd: jcmd.run}
+	task.SetDir(dir)
+	task.SetEndpoint(server.URL + "/ycrash-receiver?de=localhost")
+	result, err := task.Run(context.Background())
+	require.NoError(t, err)
+	assert.True(t, result.Ok, result.Msg)
+	assert.Equal(t, "jfr", dataType)
+
+	require.Len(t, jcmd.commands, 3)
+	assert.Equal(t, "JFR.start name=ycrash duration=300s settings=profile maxsize=100M", jcmd.commands[0])
+	assert.True(t, strings.HasPrefix(jcmd.commands[1], "JFR.dump name=ycrash filename="))
+	assert.Equal(t, "JFR.stop name=ycrash", jcmd.commands[2])
+
+	recording, err := os.ReadFile(filepath.Join(dir, jfrOutputPath))
+	require.NoError(t, err)
+	assert.Equal(t, "FLR\x00recording", string(recording))
+	recordingPath := strings.TrimPrefix(jcmd.commands[1], "JFR.dump name=ycrash filename=")
+	assert.NoFileExists(t, recordingPath, "the recording of the JVM is removed")
+}
+
+func TestJFR_Cancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	jcmd := &fakeJcmd{}
+	task := &JFR{Pid: 1234, Duration: time.Hour, jcmd: jcmd.run}
+	task.SetDir(t.TempDir())
+	task.SetEndpoint(server.URL + "/ycrash-receiver?de=localhost")
+	start := time.Now()
+	result, err := task.Run(ctx)
+	require.NoError(t, err)
+	assert.True(t, result.Ok, "what was recorded so far is uploaded")
+	assert.Less(t, time.Since(start), time.Minute)
+	assert.Len(t, jcmd.commands, 3)
+}
+
+func TestJFR_StartFails(t *testing.T) {
+	task := &JFR{Pid: 1234, Duration: time.Hour, jcmd: func(w io.Writer, command string) error {
+		_, err := fmt.Fprintln(w, "Could not start recording, not able to write to disk")
+		return err
+	}}
+	result, err := task.Run(context.Background())
+	assert.Error(t, err)
+	assert.False(t, result.Ok)
+	assert.Contains(t, result.Msg, "Could not start recording")
+}
@@ -2,6 +2,7 @@ package capture
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"path"
@@ -9,7 +10,7 @@ import (
 	"time"
 
 	"yc-agent/internal/capture/executils"
-	"yc-agent/internal/logger"
+	"yc-agent/internal/capture/procfs"
 )
 
 const defaultCount = 3
@@ -42,7 +43,7 @@ func NewJStackWithCaptureDuration(javaHome string, pid int, duration time.Durati
 	return j
 }
 
-func (t *JStack) Run() (result Result, err error) {
+func (t *JStack) Run(ctx context.Context) (result Result, err error) {
 	b1 := make(chan int, t.count)
 	b2 := make(chan int, t.count)
 	e1 := make(chan error, t.count)
@@ -60,70 +61,76 @@ func (t *JStack) Run() (result Result, err error) {
 			if !ok {
 				return
 			}
-			outputFileName := fmt.Sprintf("javacore.%d.out", n)
+			outputFileName := t.OutputPath(fmt.Sprintf("javacore.%d.out", n))
 			var jstackFile *os.File = nil
 
 			// Thread dump: Attempt 1: jstack
 			if jstackFile == nil {
-				logger.Log("Trying to capture thread dump using jstack ...")
+				t.Log("Trying to capture thread dump using jstack ...")
+				t.SetMethod("jstack")
 				jstackFile, err = executils.CommandCombinedOutputToFile(
 					outputFileName,
 					executils.Command{path.Join(t.javaHome, "bin/jstack"), "-l", strconv.Itoa(t.pid)},
-					executils.SudoHooker{PID: t.pid},
+					executils.SudoHooker{PID: t.pid}, executils.ContextHooker{Ctx: ctx},
 				)
 				if err != nil {
-					logger.Log("Failed to run jstack with err %v", err)
+					t.Log("Failed to run jstack with err %v", err)
 				}
 			}
 			//  Thread dump: Attempt 2a: jattach via self execution with -tdCaptureMode
 			if jstackFile == nil {
-				logger.Log("Trying to capture thread dump using jattach...")
+				t.Log("Trying to capture thread dump using jattach...")
+				t.SetMethod("jattach")
 				jstackFile, err = executils.CommandCombinedOutputToFile(outputFileName,
-					executils.Command{executils.Executable(), "-p", strconv.Itoa(t.pid), "-tdCaptureMode"}, executils.EnvHooker{"pid": strconv.Itoa(t.pid)}, executils.SudoHooker{PID: t.pid})
+					executils.Command{executils.Executable(), "-p", strconv.Itoa(t.pid), "-tdCaptureMode"}, executils.EnvHooker{"pid": strconv.Itoa(t.pid)}, executils.SudoHooker{PID: t.pid}, executils.ContextHooker{Ctx: ctx})
 				if err != nil {
-					logger.Log("Failed to run jattach with err %v", err)
+					t.Log("Failed to run jattach with err %v", err)
 				}
 			}
 
 			// Thread dump: Attempt 2b: jattach via self execution from tmp path with -tdCaptureMode
 			if jstackFile == nil {
-				logger.Log("Trying to capture thread dump using jattach in temp path...")
+				t.Log("Trying to capture thread dump using jattach in temp path...")
+				t.SetMethod("tmp jattach")
 				tempPath, err := executils.Copy2TempPath()
 				if err == nil {
 					jstackFile, err = executils.CommandCombinedOutputToFile(outputFileName,
-						executils.Command{tempPath, "-p", strconv.Itoa(t.pid), "-tdCaptureMode"}, executils.EnvHooker{"pid": strconv.Itoa(t.pid)}, executils.SudoHooker{PID: t.pid})
+						executils.Command{tempPath, "-p", strconv.Itoa(t.pid), "-tdCaptureMode"}, executils.EnvHooker{"pid": strconv.Itoa(t.pid)}, executils.SudoHooker{PID: t.pid}, executils.ContextHooker{Ctx: ctx})
 					if err != nil {
-						logger.Log("Failed to run jattach with err %v", err)
+						t.Log("Failed to run jattach with err %v", err)
 					}
 				} else {
-					logger.Log("Failed to Copy2TempPath with err %v", err)
+					t.Log("Failed to Copy2TempPath with err %v", err)
 				}
 			}
 
 			// Thread dump: Attempt 5: jstack -F
 			if jstackFile == nil {
-				logger.Log("Trying to capture thread dump using jstack -F ...")
+				t.Log("Trying to capture thread dump using jstack -F ...")
+				t.SetMethod("jstack -F")
 				jstackFile, err = os.Create(outputFileName)
 				if err != nil {
-					logger.Log("Failed to create output file %v", err)
+					t.Log("Failed to create output file %v", err)
 					e1 <- err
 					return
 				}
 
 				_, e := jstackFile.WriteString("\nFull thread dump\n")
 				if e != nil {
-					logger.Log("failed to write file %s", e)
+					t.Log("failed to write file %s", e)
 					e1 <- e
 					_ = jstackFile.Close()
 					return
 				}
-				_, err = (&JStackF{
+				jstackF := &JStackF{
 					jstack:   jstackFile,
 					javaHome: t.javaHome,
 					pid:      t.pid,
-				}).Run()
+				}
+				jstackF.SetLogger(t.Logger())
+				_, err = jstackF.Run(ctx)
 				if err != nil {
-					logger.Log("failed to collect dump using jstack -F : %v", err)
+					t.Log("failed to collect dump using jstack -F : %v", err)
 					e1 <- err
 					_ = jstackFile.Close()
 					return
@@ -135,18 +142,19 @@ func (t *JStack) Run() (result Result, err error) {
 			// java.lang.RuntimeException: Unable to deduce type of thread from address 0x00007fab10001000 (expected type JavaThread, CompilerThread, ServiceThread, JvmtiAgentThread or CodeCacheSweeperThread)
 			// It requires the debug information. In ubuntu, you can install it with: apt install openjdk-11-dbg
 			if jstackFile == nil {
-				logger.Log("Trying to capture thread dump using jhsdb jstack ...")
+				t.Log("Trying to capture thread dump using jhsdb jstack ...")
+				t.SetMethod("jhsdb jstack")
 
 				jstackFile, err = os.Create(outputFileName)
 				if err != nil {
-					logger.Log("Failed to create output file %v", err)
+					t.Log("Failed to create output file %v", err)
 					e1 <- err
 					return
 				}
 
 				_, e := jstackFile.WriteString("\nFull thread dump\n")
 				if e != nil {
-					logger.Log("failed to write file %s", e)
+					t.Log("failed to write file %s", e)
 					e1 <- e
 					_ = jstackFile.Close()
 					return
@@ -154,11 +162,11 @@ func (t *JStack) Run() (result Result, err error) {
 
 				err = executils.CommandCombinedOutputToWriter(jstackFile,
 					executils.Command{path.Join(t.javaHome, "bin/jhsdb"), "jstack", "--pid", strconv.Itoa(t.pid)},
-					executils.SudoHooker{PID: t.pid},
+					executils.SudoHooker{PID: t.pid}, executils.ContextHooker{Ctx: ctx},
 				)
 
 				if err != nil {
-					logger.Log("Failed to run jhsdb jstack with err %v", err)
+					t.Log("Failed to run jhsdb jstack with err %v", err)
 				}
 			}
 
@@ -166,7 +174,7 @@ func (t *JStack) Run() (result Result, err error) {
 			if jstackFile != nil {
 				e := jstackFile.Sync()
 				if e != nil {
-					logger.Log("failed to sync file %v", e)
+					t.Log("failed to sync file %v", e)
 				}
 				_ = jstackFile.Close()
 			}
@@ -180,13 +188,18 @@ func (t *JStack) Run() (result Result, err error) {
 		defer func() {
 			close(e2)
 		}()
+		// The CPU usage of the threads is sampled from a thread dump to the next
+		var sampler *procfs.ThreadSampler
 		for {
 			n, ok := <-b2
 			if !ok {
 				return
 			}
-			topH := TopH{Pid: t.pid, N: n}
-			_, err = topH.Run()
+			topH := TopH{Pid: t.pid, N: n, Sampler: sampler}
+			topH.SetDir(t.Dir())
+			topH.SetLogger(t.Logger())
+			_, err = topH.Run(ctx)
+			sampler = topH.Sampler
 			e2 <- err
 		}
 	}()
@@ -196,16 +209,22 @@ func (t *JStack) Run() (result Result, err error) {
 		b1 <- n
 		err = <-e1
 		if err != nil {
-			logger.Warn().Err(err).Msg("Failed to gather thread dump with err")
+			t.Logger().Warn().Err(err).Msg("Failed to gather thread dump with err")
 		}
 		err = <-e2
 		if err != nil {
-			logger.Warn().Err(err).Msg("Failed to run top h with err")
+			t.Logger().Warn().Err(err).Msg("Failed to run top h with err")
 		}
 
 		if n < t.count {
-			logger.Log("sleeping for %v for next capture of thread dump ...", defaultTimeToSleep)
-			time.Sleep(defaultTimeToSleep)
+			t.Log("sleeping for %v for next capture of thread dump ...", defaultTimeToSleep)
+			select {
+			case <-time.After(defaultTimeToSleep):
+			case <-ctx.Done():
+				// Keep the thread dumps captured so far
+				t.Log("stopped capturing thread dumps after %d/%d: %s", n, t.count, ctx.Err())
+				return
+			}
 		}
 	}
 
@@ -219,7 +238,7 @@ type JStackF struct {
 	pid      int
 }
 
-func (t *JStackF) Run() (result Result, err error) {
+func (t *JStackF) Run(ctx context.Context) (result Result, err error) {
 	_, err = t.jstack.Seek(0, 0)
 	if err != nil {
 		return
@@ -236,10 +255,10 @@ func (t *JStackF) Run() (result Result, err error) {
 			return
 		}
 		err = executils.CommandCombinedOutputToWriter(t.jstack,
-			executils.Command{path.Join(t.javaHome, "bin/jstack"), "-F", strconv.Itoa(t.pid)}, executils.SudoHooker{PID: t.pid})
+			executils.Command{path.Join(t.javaHome, "bin/jstack"), "-F", strconv.Itoa(t.pid)}, executils.SudoHooker{PID: t.pid}, executils.ContextHooker{Ctx: ctx})
 		if err != nil {
 			err = executils.CommandCombinedOutputToWriter(t.jstack,
-				executils.Command{executils.Executable(), "-p", strconv.Itoa(t.pid), "-tdCaptureMode"}, executils.EnvHooker{"pid": strconv.Itoa(t.pid)}, executils.SudoHooker{PID: t.pid})
+				executils.Command{executils.Executable(), "-p", strconv.Itoa(t.pid), "-tdCaptureMode"}, executils.EnvHooker{"pid": strconv.Itoa(t.pid)}, executils.SudoHooker{PID: t.pid}, executils.ContextHooker{Ctx: ctx})
 		}
 	}
 	return
@@ -1,6 +1,7 @@
 package capture
 
 import (
+	"context"
 	"os"
 	"testing"
 
@@ -14,7 +15,7 @@ func TestJStack(t *testing.T) {
 	}
 	defer noGC.KillAndWait()
 	capJStack := NewJStack(javaHome, noGC.GetPid())
-	_, err = capJStack.Run()
+	_, err = capJStack.Run(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -40,7 +41,7 @@ func TestJStackF_Run(t *testing.T) {
 		javaHome: javaHome,
 		pid:      noGC.GetPid(),
 	}
-	_, err = j.Run()
+	_, err = j.Run(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
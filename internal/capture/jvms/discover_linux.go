@@ -0,0 +1,143 @@
+//go:build linux
+// +build linux
+
+package jvms
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"yc-agent/internal/capture/hsperf"
+)
+
+// Discover returns the JVMs running on the host, in its containers too, but
+// the agent. A process is a JVM if it's launched by java, or if it has a
+// hsperfdata file like the JVMs started by a custom launcher.
+func Discover() ([]*JVM, error) {
+	return discover("/proc")
+}
+
+func discover(procRoot string) ([]*JVM, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	self := os.Getpid()
+	var jvms []*JVM
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == self || !entry.IsDir() {
+			continue
+		}
+		jvm := discoverProcess(procRoot, pid)
+		if jvm != nil {
+			jvms = append(jvms, jvm)
+		}
+	}
+	return jvms, nil
+}
+
+// discoverProcess returns the JVM of pid, nil if it's not a JVM or it exited.
+func discoverProcess(procRoot string, pid int) *JVM {
+	procDir := filepath.Join(procRoot, strconv.Itoa(pid))
+	cmdline := readCmdline(filepath.Join(procDir, "cmdline"))
+	perfPath, err := hsperf.FindIn(procRoot, pid)
+	if err != nil {
+		perfPath = ""
+	}
+	if perfPath == "" && (len(cmdline) == 0 || !IsJavaLauncher(cmdline[0])) {
+		return nil
+	}
+	if len(cmdline) == 0 {
+		cmdline = hsperfCmdline(perfPath)
+	}
+
+	jvm := ParseCmdline(cmdline)
+	jvm.Pid = pid
+	jvm.HsperfPath = perfPath
+	jvm.User = processUser(procDir, perfPath)
+	jvm.ContainerID = containerID(filepath.Join(procDir, "cgroup"))
+	return jvm
+}
+
+func readCmdline(path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	data = bytes.TrimRight(data, "\x00")
+	if len(data) == 0 {
+		return nil
+	}
+	return strings.Split(string(data), "\x00")
+}
+
+// hsperfCmdline rebuilds the command line from the hsperfdata counters, for
+// the processes whose cmdline can't be read.
+func hsperfCmdline(perfPath string) []string {
+	pd, err := hsperf.ReadFile(perfPath)
+	if err != nil {
+		return nil
+	}
+	cmdline := []string{"java"}
+	if vmArgs, ok := pd.String("java.rt.vmArgs"); ok {
+		cmdline = append(cmdline, strings.Fields(vmArgs)...)
+	}
+	if command, ok := pd.String("sun.rt.javaCommand"); ok {
+		cmdline = append(cmdline, strings.Fields(command)...)
+	}
+	return cmdline
+}
+
+// processUser returns the user of the hsperfdata directory, which is the
+// user in the container of a containerized JVM, or the owner of the process.
+func processUser(procDir, perfPath string) string {
+	if perfPath != "" {
+		dir := filepath.Base(filepath.Dir(perfPath))
+		if name := strings.TrimPrefix(dir, "hsperfdata_"); name != dir && name != "" {
+			return name
+		}
+	}
+
+	file, err := os.Open(filepath.Join(procDir, "status"))
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "Uid:" {
+			continue
+		}
+		if u, err := user.LookupId(fields[1]); err == nil {
+			return u.Username
+		}
+		return fields[1]
+	}
+	return ""
+}
+
+var containerIDPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// containerID returns the docker, containerd or cri-o container id found in
+// the cgroups of the process.
+func containerID(cgroupPath string) string {
+	data, err := os.ReadFile(cgroupPath)
+	if err != nil {
+		return ""
+	}
+	ids := containerIDPattern.FindAll(data, -1)
+	if len(ids) == 0 {
+		return ""
+	}
+	return string(ids[len(ids)-1])
+}
@@ -0,0 +1,59 @@
+//go:build linux
+// +build linux
+
+package jvms
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeProcess(t *testing.T, procRoot string, pid int, cmdline []string, files map[string]string) {
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cmdline"), []byte(strings.Join(cmdline, "\x00")+"\x00"), 0644))
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	procRoot := filepath.Join(t.TempDir(), "proc")
+	containerID := "3f4e8a1b2c3d4e5f60718293a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b3"
+
+	writeProcess(t, procRoot, 100, []string{"/usr/bin/java", "-Dapp.name=orders", "com.example.Main"}, map[string]string{
+		"status": "Name:\tjava\nUid:\t0\t0\t0\t0\n",
+	})
+	// a containerized JVM started by a custom launcher, found by its hsperfdata file
+	writeProcess(t, procRoot, 200, []string{"/opt/app/launcher", "-jar", "app.jar"}, map[string]string{
+		"status":                    "Name:\tlauncher\nNSpid:\t200\t1\n",
+		"cgroup":                    "0::/system.slice/docker-" + containerID + ".scope\n",
+		"root/tmp/hsperfdata_app/1": "",
+	})
+	writeProcess(t, procRoot, 300, []string{"/usr/bin/sudo", "java", "-version"}, nil)
+	writeProcess(t, procRoot, os.Getpid(), []string{"java", "agent"}, nil)
+
+	jvms, err := discover(procRoot)
+	require.NoError(t, err)
+	require.Len(t, jvms, 2)
+
+	assert.Equal(t, 100, jvms[0].Pid)
+	assert.Equal(t, "com.example.Main", jvms[0].MainClass)
+	assert.Equal(t, "orders", jvms[0].Properties["app.name"])
+	assert.Equal(t, "root", jvms[0].User)
+	assert.Empty(t, jvms[0].ContainerID)
+
+	assert.Equal(t, 200, jvms[1].Pid)
+	assert.Equal(t, "app.jar", jvms[1].Jar)
+	assert.Equal(t, "app", jvms[1].User)
+	assert.Equal(t, containerID, jvms[1].ContainerID)
+	assert.Equal(t, filepath.Join(procRoot, "200", "root", "tmp", "hsperfdata_app", "1"), jvms[1].HsperfPath)
+}
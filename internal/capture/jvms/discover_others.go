@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package jvms
+
+// Discover returns ErrUnsupported, the processes are looked up with ps instead.
+func Discover() ([]*JVM, error) {
+	return nil, ErrUnsupported
+}
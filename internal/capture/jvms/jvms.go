@@ -0,0 +1,138 @@
+// Package jvms discovers the running JVMs the way jps does, from the
+// hsperfdata files and the command lines of the processes, so that they can be
+// matched on their main class, jar, system properties, user or container
+// rather than on a line of ps output.
+package jvms
+
+import (
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupported is returned by Discover on the platforms it doesn't support.
+var ErrUnsupported = errors.New("jvm discovery is not supported on this platform")
+
+// JVM is a discovered java process.
+type JVM struct {
+	Pid int
+	// MainClass is the main class, or the main module/class of a module launch.
+	MainClass string
+	// Jar is the jar launched with -jar.
+	Jar string
+	// JVMArgs are the options given to the JVM before the main class or jar.
+	JVMArgs []string
+	// Args are the arguments given to the application.
+	Args []string
+	// Properties are the -D system properties of JVMArgs.
+	Properties map[string]string
+	User       string
+	// ContainerID is the id of the container running the JVM, empty on the host.
+	ContainerID string
+	// Cmdline is the command line of the process.
+	Cmdline []string
+	// HsperfPath is the hsperfdata file of the JVM, empty if it runs with -XX:-UsePerfData.
+	HsperfPath string
+}
+
+// options of the java launcher taking their value in the next argument
+var launcherOptionsWithValue = map[string]bool{
+	"-cp": true, "-classpath": true, "--class-path": true,
+	"-p": true, "--module-path": true, "--upgrade-module-path": true,
+	"--add-modules": true, "--limit-modules": true, "--add-reads": true,
+	"--add-exports": true, "--add-opens": true, "--patch-module": true,
+	"--enable-native-access": true,
+}
+
+// IsJavaLauncher reports whether the executable is a java launcher.
+func IsJavaLauncher(executable string) bool {
+	name := strings.ToLower(executable)
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSuffix(name, ".exe")
+	return name == "java" || name == "javaw"
+}
+
+// ParseCmdline splits the command line of a java launcher into the JVM
+// options, the main class or jar, and the application arguments.
+func ParseCmdline(cmdline []string) *JVM {
+	jvm := &JVM{Cmdline: cmdline, Properties: map[string]string{}}
+	if len(cmdline) == 0 {
+		return jvm
+	}
+
+	args := cmdline[1:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-jar" && i+1 < len(args):
+			jvm.Jar = args[i+1]
+			jvm.Args = args[i+2:]
+			return jvm
+		case (arg == "-m" || arg == "--module") && i+1 < len(args):
+			jvm.MainClass = args[i+1]
+			jvm.Args = args[i+2:]
+			return jvm
+		case strings.HasPrefix(arg, "--module="):
+			jvm.MainClass = strings.TrimPrefix(arg, "--module=")
+			jvm.Args = args[i+1:]
+			return jvm
+		case launcherOptionsWithValue[arg] && i+1 < len(args):
+			jvm.JVMArgs = append(jvm.JVMArgs, arg, args[i+1])
+			i++
+		case strings.HasPrefix(arg, "-"):
+			jvm.JVMArgs = append(jvm.JVMArgs, arg)
+			if strings.HasPrefix(arg, "-D") {
+				name, value, _ := strings.Cut(strings.TrimPrefix(arg, "-D"), "=")
+				jvm.Properties[name] = value
+			}
+		default:
+			jvm.MainClass = arg
+			jvm.Args = args[i+1:]
+			return jvm
+		}
+	}
+	return jvm
+}
+
+// Matches reports whether the JVM matches the process token. A token is one of:
+//
+//	1234                     the pid
+//	mainClass=<name>         the main class, exactly
+//	jar=<name>               the jar, by its path or file name
+//	user=<name>              the user running the JVM
+//	container=<id>           the container id, or its 12 characters short form
+//	regex=<expression>       a regular expression on the command line
+//	-D<name>=<value>         a system property, exactly
+//	<anything else>          a substring of the command line
+func (jvm *JVM) Matches(token string) bool {
+	if pid, err := strconv.Atoi(token); err == nil {
+		return jvm.Pid == pid
+	}
+
+	field, value, ok := strings.Cut(token, "=")
+	if ok {
+		switch field {
+		case "mainClass":
+			return jvm.MainClass == value
+		case "jar":
+			return jvm.Jar != "" && (jvm.Jar == value || filepath.Base(jvm.Jar) == value)
+		case "user":
+			return jvm.User == value
+		case "container":
+			return jvm.ContainerID != "" && (jvm.ContainerID == value || len(value) >= 12 && strings.HasPrefix(jvm.ContainerID, value))
+		case "regex":
+			re, err := regexp.Compile(value)
+			return err == nil && re.MatchString(strings.Join(jvm.Cmdline, " "))
+		}
+		if strings.HasPrefix(field, "-D") {
+			v, ok := jvm.Properties[strings.TrimPrefix(field, "-D")]
+			return ok && v == value
+		}
+	}
+
+	return strings.Contains(strings.Join(jvm.Cmdline, " "), token)
+}
@@ -0,0 +1,64 @@
+package jvms
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCmdline(t *testing.T) {
+	jvm := ParseCmdline([]string{"/usr/bin/java", "-Xmx1g", "-cp", "lib/*", "-Dapp.name=orders", "com.example.Main", "--port", "8080"})
+	assert.Equal(t, "com.example.Main", jvm.MainClass)
+	assert.Empty(t, jvm.Jar)
+	assert.Equal(t, []string{"-Xmx1g", "-cp", "lib/*", "-Dapp.name=orders"}, jvm.JVMArgs)
+	assert.Equal(t, []string{"--port", "8080"}, jvm.Args)
+	assert.Equal(t, "orders", jvm.Properties["app.name"])
+
+	jvm = ParseCmdline([]string{"java", "-Dflag", "-jar", "/opt/app/buggyapp.jar", "run"})
+	assert.Equal(t, "/opt/app/buggyapp.jar", jvm.Jar)
+	assert.Empty(t, jvm.MainClass)
+	assert.Equal(t, []string{"run"}, jvm.Args)
+	assert.Contains(t, jvm.Properties, "flag")
+
+	jvm = ParseCmdline([]string{"java", "--module-path", "mods", "-m", "com.example/com.example.Main"})
+	assert.Equal(t, "com.example/com.example.Main", jvm.MainClass)
+	assert.Empty(t, jvm.Args)
+
+	assert.True(t, IsJavaLauncher("/usr/lib/jvm/bin/java"))
+	assert.True(t, IsJavaLauncher(`C:\jdk\bin\javaw.exe`))
+	assert.False(t, IsJavaLauncher("/usr/bin/sudo"))
+}
+
+func TestMatches(t *testing.T) {
+	jvm := ParseCmdline([]string{"java", "-Dapp.name=orders", "-jar", "/opt/app/buggyapp.jar"})
+	jvm.Pid = 1234
+	jvm.User = "app"
+	jvm.ContainerID = "3f4e8a1b2c3d4e5f60718293a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b3"
+
+	for _, token := range []string{
+		"1234",
+		"jar=buggyapp.jar",
+		"jar=/opt/app/buggyapp.jar",
+		"user=app",
+		"container=3f4e8a1b2c3d",
+		"-Dapp.name=orders",
+		"regex=buggy.*\\.jar$",
+		"buggyapp",
+	} {
+		assert.True(t, jvm.Matches(token), token)
+	}
+
+	for _, token := range []string{
+		"123",
+		"jar=app.jar",
+		"mainClass=buggyapp",
+		"user=root",
+		"container=3f4e",
+		"-Dapp.name=order",
+		"-Dother=orders",
+		"regex=[",
+		"sudo",
+	} {
+		assert.False(t, jvm.Matches(token), token)
+	}
+}
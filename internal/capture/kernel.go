@@ -1,13 +1,13 @@
 package capture
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 
 	"yc-agent/internal/capture/executils"
-	"yc-agent/internal/logger"
 )
 
 const kernelOutputPath = "kernel.out"
@@ -19,7 +19,7 @@ type Kernel struct {
 
 // Run executes the kernel capture process and uploads the captured file
 // to the specified endpoint.
-func (k *Kernel) Run() (Result, error) {
+func (k *Kernel) Run(ctx context.Context) (Result, error) {
 	if executils.KernelParam == nil {
 		return Result{
 			Msg: "skipped capturing Kernel",
@@ -43,7 +43,7 @@ func (k *Kernel) Run() (Result, error) {
 // CaptureToFile creates a new file and captures kernel information into it.
 // The function handles file creation and ensures proper cleanup in case of errors.
 func (k *Kernel) CaptureToFile() (*os.File, error) {
-	file, err := os.Create(kernelOutputPath)
+	file, err := os.Create(k.OutputPath(kernelOutputPath))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create output file: %w", err)
 	}
@@ -54,7 +54,7 @@ func (k *Kernel) CaptureToFile() (*os.File, error) {
 	}
 
 	if err := k.syncFile(file); err != nil {
-		logger.Log("warning: failed to sync kernel output file: %v", err)
+		k.Log("warning: failed to sync kernel output file: %v", err)
 	}
 
 	return file, nil
@@ -74,7 +74,7 @@ func (k *Kernel) captureOutput(w io.Writer) error {
 	}
 
 	if err := cmd.Wait(); err != nil {
-		logger.Log("kernel capture command failed: %v", err)
+		k.Log("kernel capture command failed: %v", err)
 		return fmt.Errorf("kernel capture command failed: %w", err)
 	}
 
@@ -88,7 +88,7 @@ func (k *Kernel) captureOutput(w io.Writer) error {
 // UploadCapturedFile uploads the captured kernel data file to the configured endpoint.
 // It handles the POST operation and returns a Result indicating success or failure.
 func (k *Kernel) UploadCapturedFile(file *os.File) Result {
-	msg, ok := PostData(k.Endpoint(), "kernel", file)
+	msg, ok := k.PostData("kernel", file)
 	return Result{
 		Msg: msg,
 		Ok:  ok,
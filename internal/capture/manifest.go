@@ -0,0 +1,218 @@
+package capture
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// ManifestFile is the name of the manifest in the capture dir.
+	ManifestFile = "manifest.json"
+	// CaptureIDParam is the endpoint parameter of the capture ID, sent as the
+	// CaptureIDHeader of the uploads too.
+	CaptureIDParam  = "captureId"
+	CaptureIDHeader = "X-Capture-Id"
+)
+
+// NewCaptureID returns a new unique ID of a capture.
+func NewCaptureID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// captureIDOf returns the capture ID parameter of the url, empty if none.
+func captureIDOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get(CaptureIDParam)
+}
+
+// Manifest is the machine-readable record of a capture: every artifact transmitted
+// by its collectors.
+type Manifest struct {
+	CaptureID string      `json:"captureId"`
+	Pid       int         `json:"pid,omitempty"`
+	AppName   string      `json:"appName,omitempty"`
+	Start     time.Time   `json:"start"`
+	End       time.Time   `json:"end"`
+	Artifacts []*Artifact `json:"artifacts"`
+
+	mtx sync.Mutex
+}
+
+// Artifact is a file captured and transmitted by a collector.
+type Artifact struct {
+	Collector string `json:"collector"`
+	DataType  string `json:"dt"`
+	// File is the name of the file, relative to the capture dir if it's in it.
+	File   string `json:"file"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Method is how the data was captured when there are fallbacks, for example: jstack or jattach.
+	Method   string    `json:"method,omitempty"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Uploaded bool      `json:"uploaded"`
+	// Upload is the response of the upload, or why it failed or was skipped.
+	Upload string `json:"upload"`
+}
+
+func NewManifest(captureID string, pid int, appName string) *Manifest {
+	return &Manifest{CaptureID: captureID, Pid: pid, AppName: appName, Start: time.Now(), Artifacts: []*Artifact{}}
+}
+
+// Record adds the artifact of the file at path, transmitted with the upload result msg and ok.
+func (m *Manifest) Record(collector, dt, method string, start time.Time, path string, msg string, ok bool) {
+	a := &Artifact{
+		Collector: collector,
+		DataType:  dt,
+		File:      path,
+		Method:    method,
+		Start:     start,
+		Uploaded:  ok,
+		Upload:    msg,
+	}
+	if path != "" {
+		if stat, err := os.Stat(path); err == nil {
+			a.Size = stat.Size()
+		}
+		if sum, err := fileSHA256(path); err == nil {
+			a.SHA256 = sum
+		}
+	}
+	a.End = time.Now()
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.Artifacts = append(m.Artifacts, a)
+}
+
+func fileSHA256(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Write ends the manifest and writes it to dir, with the files relative to dir.
+func (m *Manifest) Write(dir string) error {
+	m.mtx.Lock()
+	m.End = time.Now()
+	absDir, _ := filepath.Abs(dir)
+	for _, a := range m.Artifacts {
+		abs, err := filepath.Abs(a.File)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(absDir, abs); err == nil && filepath.IsLocal(rel) {
+			a.File = filepath.ToSlash(rel)
+		}
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	m.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, ManifestFile), data, 0644)
+}
+
+// dataTypeOf returns the dt of the upload params, for example: gc of dt=gc&pid=1.
+func dataTypeOf(params string) string {
+	values, err := url.ParseQuery(params)
+	if err != nil || !values.Has("dt") {
+		return params
+	}
+	return values.Get("dt")
+}
+
+// SetManifest sets the manifest the uploads of the task are recorded in, as
+// artifacts of the collector. The collector starts capturing now.
+func (cap *Capture) SetManifest(m *Manifest, collector string) {
+	cap.manifest = m
+	cap.collector = collector
+	cap.start = time.Now()
+}
+
+// ShareManifest records the uploads of the sub-task in the manifest of the task,
+// and reports its events as the task's.
+func (cap *Capture) ShareManifest(sub Task) {
+	if cap.manifest != nil {
+		sub.SetManifest(cap.manifest, cap.collector)
+	}
+	sub.SetEvents(cap.events)
+}
+
+// SetMethod sets how the data was captured, recorded with the next uploads.
+// Setting another method than the current one reports an EventFallback.
+func (cap *Capture) SetMethod(method string) {
+	if cap.method != "" && cap.method != method {
+		cap.emit(Event{Type: EventFallback, Method: method, From: cap.method})
+	}
+	cap.method = method
+}
+
+// RecordUpload records the upload of file as dt in the manifest of the task, if any.
+func (cap *Capture) RecordUpload(dt string, file *os.File, msg string, ok bool) {
+	if cap.manifest == nil {
+		return
+	}
+	var path string
+	if file != nil {
+		path = file.Name()
+	}
+	cap.manifest.Record(cap.collector, dt, cap.method, cap.start, path, msg, ok)
+}
+
+// PostData is PostData to the endpoint of the task, recorded in its manifest.
+func (cap *Capture) PostData(dt string, file *os.File) (msg string, ok bool) {
+	done := cap.trackUpload(dataTypeOf("dt="+dt), file)
+	msg, ok = PostData(cap.Endpoint(), dt, file)
+	done(ok)
+	cap.RecordUpload(dataTypeOf("dt="+dt), file, msg, ok)
+	return
+}
+
+// PostCustomData is PostCustomData to the endpoint of the task, recorded in its manifest.
+func (cap *Capture) PostCustomData(params string, file *os.File) (msg string, ok bool) {
+	return cap.PostCustomDataWithPositionFunc(params, file, PositionZero)
+}
+
+// PostCustomDataWithPositionFunc is PostCustomDataWithPositionFunc to the endpoint of
+// the task, recorded in its manifest.
+func (cap *Capture) PostCustomDataWithPositionFunc(params string, file *os.File, position func(file *os.File) error) (msg string, ok bool) {
+	done := cap.trackUpload(dataTypeOf(params), file)
+	msg, ok = PostCustomDataWithPositionFunc(cap.Endpoint(), params, file, position)
+	done(ok)
+	cap.RecordUpload(dataTypeOf(params), file, msg, ok)
+	return
+}
+
+// PostCompressedData is PostCompressedData to the endpoint of the task, recorded in its manifest.
+func (cap *Capture) PostCompressedData(params, encodingParam string, file *os.File) (msg string, ok bool) {
+	done := cap.trackUpload(dataTypeOf(params), file)
+	msg, ok = PostCompressedData(cap.Endpoint(), params, encodingParam, file)
+	done(ok)
+	cap.RecordUpload(dataTypeOf(params), file, msg, ok)
+	return
+}
@@ -0,0 +1,68 @@
+package capture
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifest(t *testing.T) {
+	var header string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get(CaptureIDHeader)
+		_, _ = w.Write([]byte("received"))
+	}))
+	defer server.Close()
+
+	id := NewCaptureID()
+	assert.Len(t, id, 16)
+	assert.NotEqual(t, id, NewCaptureID())
+
+	dir := t.TempDir()
+	m := NewManifest(id, 1234, "buggyApp")
+
+	var task Capture
+	task.SetEndpoint(server.URL + "/ycrash-receiver?de=localhost&ts=1&" + CaptureIDParam + "=" + id)
+	task.SetDir(dir)
+	task.SetManifest(m, "gc")
+	task.SetMethod("jstat")
+
+	data := []byte("gc data")
+	require.NoError(t, os.WriteFile(task.OutputPath("gc.log"), data, 0644))
+	file, err := os.Open(task.OutputPath("gc.log"))
+	require.NoError(t, err)
+	defer file.Close()
+
+	msg, ok := task.PostCustomData("dt=gc&pid=1234", file)
+	assert.True(t, ok, msg)
+	assert.Equal(t, id, header)
+
+	require.NoError(t, m.Write(dir))
+	b, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	require.NoError(t, err)
+	var written Manifest
+	require.NoError(t, json.Unmarshal(b, &written))
+
+	assert.Equal(t, id, written.CaptureID)
+	assert.Equal(t, 1234, written.Pid)
+	require.Len(t, written.Artifacts, 1)
+	a := written.Artifacts[0]
+	sum := sha256.Sum256(data)
+	assert.Equal(t, "gc", a.Collector)
+	assert.Equal(t, "gc", a.DataType)
+	assert.Equal(t, "gc.log", a.File)
+	assert.Equal(t, int64(len(data)), a.Size)
+	assert.Equal(t, hex.EncodeToString(sum[:]), a.SHA256)
+	assert.Equal(t, "jstat", a.Method)
+	assert.True(t, a.Uploaded)
+	assert.Contains(t, a.Upload, "received")
+	assert.False(t, a.End.Before(a.Start))
+}
@@ -1,13 +1,13 @@
 package capture
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"time"
 
 	"yc-agent/internal/capture/executils"
-	"yc-agent/internal/logger"
 )
 
 const netStatOutputPath = "netstat.out"
@@ -22,13 +22,13 @@ type NetStat struct {
 }
 
 // Run captures netstat data twice with a delay between captures, then upload it to the specified endpoint.
-func (ns *NetStat) Run() (Result, error) {
+func (ns *NetStat) Run(ctx context.Context) (Result, error) {
 	if ns.sleepBetweenCaptures == 0 {
 		ns.sleepBetweenCaptures = defaultSleepBetweenCaptures
 	}
 
 	// Create the output file.
-	file, err := os.Create(netStatOutputPath)
+	file, err := os.Create(ns.OutputPath(netStatOutputPath))
 	if err != nil {
 		return Result{}, fmt.Errorf("failed to create output file: %w", err)
 	}
@@ -37,16 +37,20 @@ func (ns *NetStat) Run() (Result, error) {
 	defer ns.close()
 
 	// First capture.
-	logger.Log("Collecting the first netstat snapshot...")
+	ns.Log("Collecting the first netstat snapshot...")
 	if err := ns.CaptureToFile(); err != nil {
 		// Continue execution even if first capture fails.
-		logger.Log("warning: failed run first netstat capture: %v", err)
+		ns.Log("warning: failed run first netstat capture: %v", err)
 	} else {
-		logger.Log("First netstat snapshot complete.")
+		ns.Log("First netstat snapshot complete.")
 	}
 
-	// Wait between captures
-	time.Sleep(ns.sleepBetweenCaptures)
+	// Wait between captures, unless cancelled, then the first capture is uploaded alone
+	select {
+	case <-time.After(ns.sleepBetweenCaptures):
+	case <-ctx.Done():
+		return ns.UploadCapturedFile(ns.file), nil
+	}
 
 	// New line separator between captures
 	if _, err := ns.file.WriteString("\n"); err != nil {
@@ -54,16 +58,16 @@ func (ns *NetStat) Run() (Result, error) {
 	}
 
 	// Second capture to detect any changes from the first one.
-	logger.Log("Collecting the final netstat snapshot...")
+	ns.Log("Collecting the final netstat snapshot...")
 	if err := ns.CaptureToFile(); err != nil {
-		logger.Log("warning: failed run second netstat capture: %v", err)
+		ns.Log("warning: failed run second netstat capture: %v", err)
 	} else {
-		logger.Log("Final netstat snapshot complete.")
+		ns.Log("Final netstat snapshot complete.")
 	}
 
 	// Ensure data is flushed / written to the disk before upload
 	if err := ns.syncFile(ns.file); err != nil {
-		logger.Log("warning: failed to sync netstat output file: %v", err)
+		ns.Log("warning: failed to sync netstat output file: %v", err)
 	}
 
 	result := ns.UploadCapturedFile(ns.file)
@@ -99,7 +103,7 @@ func (ns *NetStat) CaptureToFile() error {
 
 // UploadCapturedFile sends the captured netstat data to a remote endpoint.
 func (ns *NetStat) UploadCapturedFile(file *os.File) Result {
-	msg, ok := PostData(ns.Endpoint(), "ns", file)
+	msg, ok := ns.PostData("ns", file)
 
 	return Result{
 		Msg: msg,
@@ -1,6 +1,7 @@
 package capture
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"strings"
@@ -55,7 +56,7 @@ func TestNetStat_Run(t *testing.T) {
 	ns := &NetStat{sleepBetweenCaptures: 1 * time.Millisecond}
 
 	// Run the capture
-	result, err := ns.Run()
+	result, err := ns.Run(context.Background())
 	require.NoError(t, err, "Run() should not return error")
 
 	// Verify output file existence and content
@@ -1,13 +1,13 @@
 package capture
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 
 	"yc-agent/internal/capture/executils"
-	"yc-agent/internal/logger"
 )
 
 const pingOutputPath = "ping.out"
@@ -20,7 +20,7 @@ type Ping struct {
 
 // Run executes the ping capture process and uploads the captured file
 // to the specified endpoint.
-func (p *Ping) Run() (Result, error) {
+func (p *Ping) Run(ctx context.Context) (Result, error) {
 	if executils.Ping == nil {
 		return Result{
 			Msg: "skipped capturing Ping",
@@ -28,7 +28,7 @@ func (p *Ping) Run() (Result, error) {
 		}, nil
 	}
 
-	capturedFile, err := p.CaptureToFile()
+	capturedFile, err := p.captureToFile(ctx)
 	if err != nil {
 		return Result{Msg: err.Error(), Ok: false}, err
 	}
@@ -41,27 +41,40 @@ func (p *Ping) Run() (Result, error) {
 // CaptureToFile captures ping output to a file.
 // It returns the file handle for the captured data.
 func (p *Ping) CaptureToFile() (*os.File, error) {
-	file, err := os.Create(pingOutputPath)
+	return p.captureToFile(context.Background())
+}
+
+// captureToFile runs ping until it completes, ctx is done or the task is killed.
+// The output written until then is kept.
+func (p *Ping) captureToFile(ctx context.Context) (*os.File, error) {
+	ctx, cancel := p.killable(ctx)
+	defer cancel()
+
+	file, err := os.Create(p.OutputPath(pingOutputPath))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create output file: %w", err)
 	}
 
-	if err := p.captureOutput(file); err != nil {
+	if err := p.captureOutput(ctx, file); err != nil {
 		file.Close()
 		return nil, err
 	}
 
 	if err := p.syncFile(file); err != nil {
-		logger.Log("warning: failed to sync file: %v", err)
+		p.Log("warning: failed to sync file: %v", err)
 	}
 
 	return file, nil
 }
 
 // captureOutput handles the actual ping capture process.
-func (p *Ping) captureOutput(w io.Writer) error {
-	cmd, err := executils.CommandStartInBackgroundToWriter(w, executils.Append(executils.Ping, p.Host))
+func (p *Ping) captureOutput(ctx context.Context, w io.Writer) error {
+	cmd, err := executils.CommandStartInBackgroundToWriter(w, executils.Append(executils.Ping, p.Host), executils.ContextHooker{Ctx: ctx})
 	if err != nil {
+		if ctx.Err() != nil {
+			p.Log("ping not started: %v", context.Cause(ctx))
+			return nil
+		}
 		return fmt.Errorf("failed to start ping command: %w", err)
 	}
 	p.Cmd = cmd
@@ -70,8 +83,13 @@ func (p *Ping) captureOutput(w io.Writer) error {
 		return nil
 	}
 
-	if err := cmd.Wait(); err != nil {
-		logger.Log("ping command failed: %v", err)
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		p.Log("ping stopped: %v, keeping the partial output", context.Cause(ctx))
+		return nil
+	}
+	if err != nil {
+		p.Log("ping command failed: %v", err)
 		return err
 	}
 
@@ -84,7 +102,7 @@ func (p *Ping) captureOutput(w io.Writer) error {
 
 // UploadCapturedFile uploads the captured file to the configured endpoint.
 func (p *Ping) UploadCapturedFile(file *os.File) Result {
-	msg, ok := PostData(p.Endpoint(), "ping", file)
+	msg, ok := p.PostData("ping", file)
 
 	return Result{
 		Msg: msg,
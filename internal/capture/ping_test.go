@@ -1,9 +1,13 @@
 package capture
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"yc-agent/internal/capture/executils"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -54,7 +58,7 @@ func TestPing_Run(t *testing.T) {
 	}
 
 	// Run the capture
-	result, err := p.Run()
+	result, err := p.Run(context.Background())
 	require.NoError(t, err, "Run() should not return error")
 
 	// Verify output file existence and content
@@ -69,3 +73,34 @@ func TestPing_Run(t *testing.T) {
 	assert.Contains(t, content, "ping", "capture file should contain ping command output")
 	assert.NotEmpty(t, result.Msg, "Run() should return non-empty result message")
 }
+
+func TestPing_KeepsPartialOutput(t *testing.T) {
+	ping := executils.Ping
+	executils.Ping = append(executils.Command{executils.WaitCommand}, append(executils.SHELL, "echo 64 bytes from $0; sleep 30")...)
+	defer func() { executils.Ping = ping }()
+
+	p := &Ping{Host: "localhost"}
+	p.SetDir(t.TempDir())
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	file, err := p.captureToFile(ctx)
+	require.NoError(t, err, "the output written before the timeout should be kept")
+	defer file.Close()
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	data, err := os.ReadFile(file.Name())
+	require.NoError(t, err)
+	assert.Equal(t, "64 bytes from localhost\n", string(data))
+
+	// Killed before it starts, ping doesn't run
+	p = &Ping{Host: "localhost"}
+	p.SetDir(t.TempDir())
+	require.NoError(t, p.Kill())
+	start = time.Now()
+	file, err = p.captureToFile(context.Background())
+	require.NoError(t, err)
+	file.Close()
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
@@ -1,16 +1,18 @@
 package capture
 
 import (
-	"crypto/tls"
-	"crypto/x509"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"time"
 
+	"yc-agent/internal/capture/httpclient"
 	"yc-agent/internal/config"
+	"yc-agent/internal/metrics"
 )
 
 func GetOutboundIP() net.IP {
@@ -77,73 +79,102 @@ func PostCustomData(endpoint, params string, file *os.File) (msg string, ok bool
 }
 
 func PostCustomDataWithPositionFunc(endpoint, params string, file *os.File, position func(file *os.File) error) (msg string, ok bool) {
-	if config.GlobalConfig.OnlyCapture {
-		msg = "in only capture mode"
+	msg, skip := skipUpload(file)
+	if skip {
 		return
 	}
-	if file == nil {
-		msg = "file is not captured"
+
+	return GetUploader().Upload(endpoint, params, file, position)
+}
+
+// PostCompressedData transmits file compressed with the upload compression of the config.
+// The compression is reported to the receiver with the encodingParam parameter, for example:
+// content-encoding=gz. The data is streamed through the compressor straight into the upload
+// when the uploader supports it, so no compressed copy of large files is written to disk.
+func PostCompressedData(endpoint, params, encodingParam string, file *os.File) (msg string, ok bool) {
+	compression := config.GlobalConfig.UploadCompression
+	if compression == "" || compression == CompressionNone {
+		return PostCustomData(endpoint, params, file)
+	}
+
+	msg, skip := skipUpload(file)
+	if skip {
 		return
 	}
-	stat, err := file.Stat()
+
+	params = fmt.Sprintf("%s&%s=%s", params, encodingParam, compressionExt(compression))
+
+	u := GetUploader()
+	if cu, ok := u.(CompressingUploader); ok {
+		return cu.UploadCompressed(endpoint, params, file, PositionZero, compression)
+	}
+
+	err := PositionZero(file)
 	if err != nil {
-		msg = fmt.Sprintf("file stat err %s", err.Error())
+		msg = fmt.Sprintf("PostData position err %s", err.Error())
 		return
 	}
-	fileName := stat.Name()
-	if stat.Size() < 1 {
-		msg = fmt.Sprintf("skipped empty file %s", fileName)
+	compressed, err := compressToTempFile(file, compression)
+	if err != nil {
+		msg = fmt.Sprintf("PostData compress err %s", err.Error())
 		return
 	}
+	defer func() {
+		_ = compressed.Close()
+		_ = os.Remove(compressed.Name())
+	}()
 
-	url := fmt.Sprintf("%s&%s", endpoint, params)
-	transport := http.DefaultTransport.(*http.Transport)
-	transport.TLSClientConfig = &tls.Config{
-		InsecureSkipVerify: !config.GlobalConfig.VerifySSL,
-	}
-	path := config.GlobalConfig.CACertPath
-	if len(path) > 0 {
-		pool := x509.NewCertPool()
-		ca, err := ioutil.ReadFile(path)
-		if err != nil {
-			msg = err.Error()
-			return
-		}
-		pool.AppendCertsFromPEM(ca)
-		transport.TLSClientConfig.RootCAs = pool
+	return u.Upload(endpoint, params, compressed, PositionZero)
+}
+
+// skipUpload reports whether file should not be uploaded, and why.
+func skipUpload(file *os.File) (msg string, skip bool) {
+	if config.GlobalConfig.OnlyCapture {
+		return "in only capture mode", true
 	}
-	httpClient := &http.Client{
-		Transport: transport,
+	if file == nil {
+		return "file is not captured", true
 	}
-	err = position(file)
+	stat, err := file.Stat()
 	if err != nil {
-		msg = fmt.Sprintf("PostData position err %s", err.Error())
-		return
+		return fmt.Sprintf("file stat err %s", err.Error()), true
+	}
+	if stat.Size() < 1 {
+		return fmt.Sprintf("skipped empty file %s", stat.Name()), true
 	}
-	req, err := http.NewRequest("POST", url, file)
+	return "", false
+}
+
+// postBody posts body to url and returns the response status code and body.
+// Compressed bodies are announced by the content encoding parameter of url alone, never
+// with a Content-Encoding header too, so the receiver can't decompress them twice. The dir
+// and s3 uploaders name the data after that parameter, see uploadObjectName.
+// The capture ID parameter of url is sent as the capture ID header.
+func postBody(httpClient *http.Client, url string, body io.Reader) (statusCode int, respBody []byte, err error) {
+	counted := &countingReader{r: body}
+	req, err := http.NewRequest("POST", url, counted)
 	if err != nil {
-		msg = fmt.Sprintf("PostData new req err %s", err.Error())
+		err = fmt.Errorf("new req err %w", err)
 		return
 	}
+	defer observeUpload(url, time.Now(), counted, &statusCode, &err)
 	req.Header.Set("Content-Type", "text")
 	req.Header.Set("ApiKey", config.GlobalConfig.ApiKey)
+	if id := captureIDOf(url); id != "" {
+		req.Header.Set(CaptureIDHeader, id)
+	}
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		msg = fmt.Sprintf("PostData post err %s", err.Error())
 		return
 	}
 
 	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	respBody, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
-		msg = fmt.Sprintf("PostData get resp err %s", err.Error())
+		err = fmt.Errorf("get resp err %w", err)
 		return
 	}
-	msg = fmt.Sprintf("%s\nstatus code %d\n%s", url, resp.StatusCode, body)
-
-	if resp.StatusCode == http.StatusOK {
-		ok = true
-	}
+	statusCode = resp.StatusCode
 	return
 }
 
@@ -152,23 +183,14 @@ func GetData(endpoint string) (msg string, ok bool) {
 		msg = "in only capture mode"
 		return
 	}
-	transport := http.DefaultTransport.(*http.Transport)
-	transport.TLSClientConfig = &tls.Config{
-		InsecureSkipVerify: !config.GlobalConfig.VerifySSL,
-	}
-	path := config.GlobalConfig.CACertPath
-	if len(path) > 0 {
-		pool := x509.NewCertPool()
-		ca, err := ioutil.ReadFile(path)
-		if err != nil {
-			msg = err.Error()
-			return
-		}
-		pool.AppendCertsFromPEM(ca)
-		transport.TLSClientConfig.RootCAs = pool
+	if !UploadsToServer() {
+		msg = fmt.Sprintf("not supported by the %s uploader", config.GlobalConfig.Uploader)
+		return
 	}
-	httpClient := &http.Client{
-		Transport: transport,
+	httpClient, err := httpclient.Shared()
+	if err != nil {
+		msg = err.Error()
+		return
 	}
 	req, err := http.NewRequest("GET", endpoint, nil)
 	if err != nil {
@@ -195,3 +217,28 @@ func GetData(endpoint string) (msg string, ok bool) {
 	}
 	return
 }
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// observeUpload updates the upload metrics with the post of body to rawURL, started at start.
+func observeUpload(rawURL string, start time.Time, body *countingReader, statusCode *int, err *error) {
+	dt := "unknown"
+	if u, e := url.Parse(rawURL); e == nil && u.Query().Get("dt") != "" {
+		dt = u.Query().Get("dt")
+	}
+	metrics.UploadDuration.WithLabelValues(dt).Observe(time.Since(start).Seconds())
+	metrics.UploadBytes.WithLabelValues(dt).Add(float64(body.n))
+	if *err != nil || *statusCode != http.StatusOK {
+		metrics.UploadFailures.WithLabelValues(dt).Inc()
+	}
+}
@@ -1,9 +1,20 @@
 package capture
 
 import (
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+
+	"yc-agent/internal/capture/spool"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestLastNLines(t *testing.T) {
@@ -29,3 +40,62 @@ func TestLastNLines(t *testing.T) {
 		t.Fatalf("invalid result '%x' != '%x'", bytes, result)
 	}
 }
+
+func TestPostDataQueuesFailedUpload(t *testing.T) {
+	var available atomic.Bool
+	var received atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		received.Store(r.URL.Query().Get("dt") + " " + string(b))
+	}))
+	defer server.Close()
+
+	s, err := spool.New(t.TempDir(), sendSpooledUpload)
+	require.NoError(t, err)
+	s.BaseDelay = 0
+	SetUploadSpool(s)
+	defer SetUploadSpool(nil)
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "vmstat.out"))
+	require.NoError(t, err)
+	defer file.Close()
+	_, err = file.WriteString("vmstat data")
+	require.NoError(t, err)
+
+	msg, ok := PostData(server.URL+"/ycrash-receiver?de=localhost", "vmstat", file)
+	assert.False(t, ok)
+	assert.True(t, strings.HasPrefix(msg, queuedMsgPrefix), msg)
+	assert.Equal(t, "queued", TransmissionStatus(ok, msg))
+	assert.Equal(t, 1, s.Len())
+
+	available.Store(true)
+	s.Flush()
+
+	assert.Equal(t, 0, s.Len())
+	assert.Equal(t, "vmstat vmstat data", received.Load())
+}
+
+func TestPostDataDoesNotQueueRejectedUpload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	s, err := spool.New(t.TempDir(), sendSpooledUpload)
+	require.NoError(t, err)
+	SetUploadSpool(s)
+	defer SetUploadSpool(nil)
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "vmstat.out"))
+	require.NoError(t, err)
+	defer file.Close()
+
+	msg, ok := PostData(server.URL+"/ycrash-receiver?de=localhost", "vmstat", file)
+	assert.False(t, ok)
+	assert.Equal(t, "false", TransmissionStatus(ok, msg))
+	assert.Equal(t, 0, s.Len())
+}
@@ -0,0 +1,220 @@
+package capture
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"yc-agent/internal/capture/executils"
+	"yc-agent/internal/capture/procfs"
+)
+
+const pressureOutputPath = "pressure.json"
+
+// The resources of the pressure stall information.
+var pressureResources = []string{"cpu", "memory", "io"}
+
+// Pressure handles the capture of the saturation of the host, and of the cgroups
+// of the processes, sampled over the capture window: the pressure stall
+// information of the CPU, memory and IO, the load average, the run queue and
+// the rates of context switches and interrupts.
+type Pressure struct {
+	Capture
+	// Pids are the processes whose cgroups are sampled, none for the host only.
+	Pids []int
+	// Interval is the time between the samples, VMSTAT_INTERVAL if 0.
+	Interval time.Duration
+	// Duration is the capture window, SCRIPT_SPAN if 0.
+	Duration time.Duration
+}
+
+// PressureReport is the time series of the captured saturation.
+type PressureReport struct {
+	// CGroups are the cgroups of the processes sampled, by pid.
+	CGroups map[int]string    `json:"cgroups,omitempty"`
+	Samples []*PressureSample `json:"samples"`
+}
+
+// PressureSample is a sample of the saturation.
+type PressureSample struct {
+	Time time.Time `json:"time"`
+	// Pressure is the pressure of the host by resource, empty if the kernel has no PSI.
+	Pressure map[string]*procfs.PSI `json:"pressure,omitempty"`
+	// CGroupPressure is the pressure of the cgroups of the processes by pid and resource.
+	CGroupPressure map[int]map[string]*procfs.PSI `json:"cgroupPressure,omitempty"`
+	LoadAvg        procfs.LoadAvg                 `json:"loadavg"`
+	// ProcsRunning is the run queue, the runnable threads, ProcsBlocked the
+	// threads blocked on IO.
+	ProcsRunning uint64 `json:"procsRunning"`
+	ProcsBlocked uint64 `json:"procsBlocked"`
+	// ContextSwitches and Interrupts are per second since the previous sample,
+	// 0 for the first one.
+	ContextSwitches float64 `json:"contextSwitches"`
+	Interrupts      float64 `json:"interrupts"`
+}
+
+// Run executes the pressure capture process and uploads the captured file
+// to the specified endpoint.
+func (p *Pressure) Run(ctx context.Context) (Result, error) {
+	if runtime.GOOS != "linux" {
+		return Result{Msg: "skipped capturing pressure, only supported on linux", Ok: false}, nil
+	}
+
+	capturedFile, err := p.captureToFile(ctx)
+	if err != nil {
+		return Result{Msg: err.Error(), Ok: false}, err
+	}
+	defer capturedFile.Close()
+
+	result := p.UploadCapturedFile(capturedFile)
+	return result, nil
+}
+
+// CaptureToFile captures the pressure time series to a file.
+// It returns the file handle for the captured data.
+func (p *Pressure) CaptureToFile() (*os.File, error) {
+	return p.captureToFile(context.Background())
+}
+
+func (p *Pressure) captureToFile(ctx context.Context) (*os.File, error) {
+	report, err := p.sample(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(p.OutputPath(pressureOutputPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write pressure report: %w", err)
+	}
+
+	if err := file.Sync(); err != nil {
+		p.Log("warning: failed to sync file: %v", err)
+	}
+
+	return file, nil
+}
+
+// sample reads the saturation every interval over the window. Once ctx is
+// done, the samples taken so far are reported.
+func (p *Pressure) sample(ctx context.Context) (*PressureReport, error) {
+	interval, duration := p.Interval, p.Duration
+	if interval <= 0 {
+		interval = time.Duration(executils.VMSTAT_INTERVAL) * time.Second
+	}
+	if duration <= 0 {
+		duration = time.Duration(executils.SCRIPT_SPAN) * time.Second
+	}
+	count := int(duration/interval) + 1
+
+	fs := procFS()
+	report := &PressureReport{}
+	cgroups := map[int]*procfs.CGroup{}
+	for _, pid := range p.Pids {
+		cg, err := fs.CGroup(pid)
+		if err != nil {
+			p.Log("failed to resolve the cgroup of process %d, sampling the pressure of the host only: %s", pid, err.Error())
+			continue
+		}
+		cgroups[pid] = cg
+		if report.CGroups == nil {
+			report.CGroups = map[int]string{}
+		}
+		report.CGroups[pid] = cg.Path
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var prev *procfs.Stat
+sampling:
+	for len(report.Samples) < count {
+		if len(report.Samples) > 0 {
+			select {
+			case <-ctx.Done():
+				p.Log("pressure capture stopped after %d samples: %s", len(report.Samples), ctx.Err())
+				break sampling
+			case <-ticker.C:
+			}
+		}
+		sample, stat, err := p.samplePressure(fs, cgroups, prev == nil)
+		if err != nil {
+			if len(report.Samples) == 0 {
+				return nil, fmt.Errorf("failed to sample the pressure: %w", err)
+			}
+			p.Log("failed to sample the pressure: %s", err.Error())
+			break
+		}
+		if prev != nil {
+			elapsed := sample.Time.Sub(report.Samples[len(report.Samples)-1].Time).Seconds()
+			if elapsed > 0 {
+				sample.ContextSwitches = float64(counterDelta(stat.ContextSwitches, prev.ContextSwitches)) / elapsed
+				sample.Interrupts = float64(counterDelta(stat.Interrupts, prev.Interrupts)) / elapsed
+			}
+		}
+		report.Samples = append(report.Samples, sample)
+		prev = stat
+	}
+	return report, nil
+}
+
+// samplePressure reads a sample of the saturation, and the statistics of the
+// host it is computed from. The pressure files which can't be read, like on
+// kernels without PSI, are skipped, and logged for the first sample.
+func (p *Pressure) samplePressure(fs *procfs.FS, cgroups map[int]*procfs.CGroup, first bool) (*PressureSample, *procfs.Stat, error) {
+	stat, err := fs.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	load, err := fs.LoadAvg()
+	if err != nil {
+		return nil, nil, err
+	}
+	sample := &PressureSample{
+		Time:         time.Now(),
+		LoadAvg:      load,
+		ProcsRunning: stat.ProcsRunning,
+		ProcsBlocked: stat.ProcsBlocked,
+	}
+
+	for _, resource := range pressureResources {
+		if psi, err := fs.Pressure(resource); err == nil {
+			if sample.Pressure == nil {
+				sample.Pressure = map[string]*procfs.PSI{}
+			}
+			sample.Pressure[resource] = psi
+		} else if first {
+			p.Log("failed to read the %s pressure: %s", resource, err.Error())
+		}
+		for pid, cg := range cgroups {
+			psi, err := cg.Pressure(resource)
+			if err != nil {
+				continue
+			}
+			if sample.CGroupPressure == nil {
+				sample.CGroupPressure = map[int]map[string]*procfs.PSI{}
+			}
+			if sample.CGroupPressure[pid] == nil {
+				sample.CGroupPressure[pid] = map[string]*procfs.PSI{}
+			}
+			sample.CGroupPressure[pid][resource] = psi
+		}
+	}
+	return sample, stat, nil
+}
+
+// UploadCapturedFile uploads the captured file to the configured endpoint.
+func (p *Pressure) UploadCapturedFile(file *os.File) Result {
+	msg, ok := p.PostData("pressure", file)
+	return Result{
+		Msg: msg,
+		Ok:  ok,
+	}
+}
@@ -0,0 +1,53 @@
+package capture
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPressure_CaptureToFile(t *testing.T) {
+	withFixtureProcFS(t)
+
+	p := &Pressure{Pids: []int{42, 1, 7}, Interval: time.Millisecond, Duration: 2 * time.Millisecond}
+	p.SetDir(t.TempDir())
+	file, err := p.CaptureToFile()
+	require.NoError(t, err)
+	defer file.Close()
+
+	data, err := os.ReadFile(file.Name())
+	require.NoError(t, err)
+	var report PressureReport
+	require.NoError(t, json.Unmarshal(data, &report))
+	assert.Equal(t, map[int]string{42: "/kubepods/pod1/app", 1: "/docker/abc"}, report.CGroups)
+	require.Len(t, report.Samples, 3)
+
+	sample := report.Samples[0]
+	assert.EqualValues(t, 30000000, sample.Pressure["cpu"].Some.Total)
+	assert.EqualValues(t, 1.1, sample.Pressure["memory"].Full.Avg10)
+	assert.EqualValues(t, 40, sample.CGroupPressure[42]["cpu"].Some.Avg10)
+	assert.NotContains(t, sample.CGroupPressure, 1, "the cgroup of pid 1 has no pressure files")
+	assert.EqualValues(t, 2, sample.ProcsRunning)
+	assert.EqualValues(t, 300, sample.LoadAvg.Threads)
+	assert.Zero(t, report.Samples[2].ContextSwitches, "the fixture doesn't change")
+
+	// The samples taken before ctx is done are kept
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p = &Pressure{Interval: time.Hour}
+	p.SetDir(t.TempDir())
+	file, err = p.captureToFile(ctx)
+	require.NoError(t, err)
+	file.Close()
+	data, err = os.ReadFile(file.Name())
+	require.NoError(t, err)
+	report = PressureReport{}
+	require.NoError(t, json.Unmarshal(data, &report))
+	assert.Nil(t, report.CGroups)
+	assert.Len(t, report.Samples, 1)
+}
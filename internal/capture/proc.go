@@ -3,7 +3,12 @@ package capture
 import (
 	"os"
 	"runtime"
+	"strings"
 	"syscall"
+
+	"yc-agent/internal/capture/jvms"
+	"yc-agent/internal/config"
+	"yc-agent/internal/metrics"
 )
 
 func IsProcessExists(pid int) (exists bool) {
@@ -29,3 +34,43 @@ func IsProcessExists(pid int) (exists bool) {
 	}
 	return false
 }
+
+// matchJVMs returns the pids of the JVMs matching one of the tokens but none of
+// the excludes, see jvms.JVM.Matches for the syntax of a token. The part of a
+// token after its last $ is the app name of the matched pids, so a regex ending
+// with $ is written like regex=App$$ or regex=App$$appName.
+func matchJVMs(discovered []*jvms.JVM, tokens config.ProcessTokens, excludes config.ProcessTokens) map[int]string {
+	pids := make(map[int]string)
+	matched := make(map[string]int, len(tokens))
+	for _, t := range tokens {
+		matched[string(t)] = 0
+	}
+	defer func() {
+		for token, n := range matched {
+			metrics.DiscoveredJVMs.WithLabelValues(token).Set(float64(n))
+		}
+	}()
+Next:
+	for _, jvm := range discovered {
+		for _, exclude := range excludes {
+			if jvm.Matches(string(exclude)) {
+				continue Next
+			}
+		}
+		for _, t := range tokens {
+			token := string(t)
+			var appName string
+			index := strings.LastIndex(token, "$")
+			if index >= 0 {
+				appName = token[index+1:]
+				token = token[:index]
+			}
+			if jvm.Matches(token) {
+				matched[string(t)]++
+				pids[jvm.Pid] = appName
+				continue Next
+			}
+		}
+	}
+	return pids
+}
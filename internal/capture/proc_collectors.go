@@ -0,0 +1,154 @@
+package capture
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+
+	"yc-agent/internal/capture/procfs"
+	"yc-agent/internal/config"
+	"yc-agent/internal/logger"
+)
+
+// The modes of the /proc collectors, which capture top, vmstat, ps and df
+// without the commands on Linux.
+const (
+	// ProcCollectorsFallback runs the /proc collectors when the commands fail.
+	ProcCollectorsFallback = "fallback"
+	// ProcCollectorsPrimary runs the /proc collectors, and the commands when they fail.
+	ProcCollectorsPrimary = "primary"
+	// ProcCollectorsOff runs the commands only.
+	ProcCollectorsOff = "off"
+)
+
+// The methods recorded in the manifest of the tasks with a /proc collector.
+const (
+	methodCommand = "command"
+	methodProcfs  = "procfs"
+)
+
+// procFS returns the proc file system read by the /proc collectors.
+var procFS = procfs.Default
+
+// ValidateProcCollectors returns an error if mode is not one of the modes of the /proc collectors.
+func ValidateProcCollectors(mode string) error {
+	switch mode {
+	case "", ProcCollectorsFallback, ProcCollectorsPrimary, ProcCollectorsOff:
+		return nil
+	}
+	return fmt.Errorf("unknown mode %q, should be one of %s, %s, %s", mode, ProcCollectorsFallback, ProcCollectorsPrimary, ProcCollectorsOff)
+}
+
+// procCollectorsMode returns the mode of the /proc collectors, off on the
+// systems without a proc file system.
+func procCollectorsMode() string {
+	if runtime.GOOS != "linux" {
+		return ProcCollectorsOff
+	}
+	if config.GlobalConfig.ProcCollectors == "" {
+		return ProcCollectorsFallback
+	}
+	return config.GlobalConfig.ProcCollectors
+}
+
+// captureWithProc writes the output of the task to f with the commands, or
+// with its /proc collector proc, in the order of procCollectorsMode. The
+// output of a failed attempt is discarded before the next one, unless it
+// failed because ctx is done, like when the task is killed: its partial
+// output is kept, without trying the other method.
+func (cap *Capture) captureWithProc(ctx context.Context, f *os.File, name string, commands func(f *os.File) error, proc func(w io.Writer) error) error {
+	mode := procCollectorsMode()
+	if mode == ProcCollectorsOff {
+		if err := commands(f); err != nil && !cap.stopped(ctx, name, err) {
+			return err
+		}
+		return nil
+	}
+
+	first, second := commands, func(f *os.File) error { return proc(f) }
+	firstMethod, secondMethod := methodCommand, methodProcfs
+	if mode == ProcCollectorsPrimary {
+		first, second = second, first
+		firstMethod, secondMethod = secondMethod, firstMethod
+	}
+
+	cap.SetMethod(firstMethod)
+	err := first(f)
+	if err == nil || cap.stopped(ctx, name, err) {
+		return nil
+	}
+	cap.Log("%s %s failed, trying %s: %s", name, firstMethod, secondMethod, err.Error())
+	if err := resetFile(f); err != nil {
+		return err
+	}
+	cap.SetMethod(secondMethod)
+	if err2 := second(f); err2 != nil && !cap.stopped(ctx, name, err2) {
+		return fmt.Errorf("%s %s failed: %w, %s failed: %w", name, firstMethod, err, secondMethod, err2)
+	}
+	return nil
+}
+
+// stopped reports whether the capture failed with err because ctx is done,
+// logging that its partial output is kept.
+func (cap *Capture) stopped(ctx context.Context, name string, err error) bool {
+	if ctx.Err() == nil {
+		return false
+	}
+	cap.Log("%s stopped: %s, keeping the partial output: %s", name, ctx.Err(), err.Error())
+	return true
+}
+
+// topProcessWithProc returns the java process using the most CPU, or memory
+// if byMem, with the ps command, or read from /proc, in the order of
+// procCollectorsMode.
+func topProcessWithProc(command func() (int, error), byMem bool) (pid int, err error) {
+	switch procCollectorsMode() {
+	case ProcCollectorsPrimary:
+		if pid, err = procTopProcess(byMem); err == nil {
+			return
+		}
+		logger.Log("failed to read the top process from /proc, trying ps: %s", err.Error())
+		return command()
+	case ProcCollectorsFallback:
+		if pid, err = command(); err == nil {
+			return
+		}
+		logger.Log("failed to run ps for the top process, trying /proc: %s", err.Error())
+		return procTopProcess(byMem)
+	}
+	return command()
+}
+
+// procTopProcess returns the java process other than the agent using the most
+// CPU, or memory if byMem, over its lifetime like ps, 0 if there is none.
+func procTopProcess(byMem bool) (int, error) {
+	processes, err := procFS().Processes()
+	if err != nil {
+		return 0, err
+	}
+	usage := func(p *procfs.Process) float64 {
+		if byMem {
+			return p.Mem
+		}
+		return p.CPU
+	}
+	sort.SliceStable(processes, func(i, j int) bool { return usage(processes[i]) > usage(processes[j]) })
+	for _, p := range processes {
+		if p.Pid != os.Getpid() && strings.Contains(p.Cmdline, "java") {
+			return p.Pid, nil
+		}
+	}
+	return 0, nil
+}
+
+// resetFile discards the content of f, to write it again from the start.
+func resetFile(f *os.File) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return f.Truncate(0)
+}
@@ -0,0 +1,203 @@
+package capture
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"yc-agent/internal/capture/procfs"
+	"yc-agent/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcCollectors(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the /proc collectors only run on linux")
+	}
+	old := config.GlobalConfig.ProcCollectors
+	t.Cleanup(func() { config.GlobalConfig.ProcCollectors = old })
+
+	commandsOk := func(f *os.File) error {
+		_, err := io.WriteString(f, "command")
+		return err
+	}
+	commandsFail := func(f *os.File) error {
+		_, _ = io.WriteString(f, "command not found")
+		return errors.New("exit status 127")
+	}
+	proc := func(w io.Writer) error {
+		_, err := io.WriteString(w, "procfs")
+		return err
+	}
+
+	tests := []struct {
+		mode     string
+		commands func(f *os.File) error
+		output   string
+		method   string
+		events   int
+	}{
+		{ProcCollectorsFallback, commandsOk, "command", methodCommand, 0},
+		{ProcCollectorsFallback, commandsFail, "procfs", methodProcfs, 1},
+		{ProcCollectorsPrimary, commandsFail, "procfs", methodProcfs, 0},
+		{ProcCollectorsOff, commandsOk, "command", "", 0},
+	}
+	for _, tt := range tests {
+		config.GlobalConfig.ProcCollectors = tt.mode
+		f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+		require.NoError(t, err)
+
+		var task Capture
+		var events []Event
+		task.SetEvents(func(e Event) { events = append(events, e) })
+		require.NoError(t, task.captureWithProc(context.Background(), f, "test", tt.commands, proc))
+		data, err := os.ReadFile(f.Name())
+		require.NoError(t, err)
+		f.Close()
+
+		assert.Equal(t, tt.output, string(data), tt.mode)
+		assert.Equal(t, tt.method, task.method, tt.mode)
+		assert.Len(t, events, tt.events, tt.mode)
+	}
+
+	config.GlobalConfig.ProcCollectors = ProcCollectorsOff
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	require.NoError(t, err)
+	defer f.Close()
+	var task Capture
+	assert.Error(t, task.captureWithProc(context.Background(), f, "test", commandsFail, proc))
+
+	// A capture stopped by its context keeps its partial output, the other method isn't tried
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, mode := range []string{ProcCollectorsFallback, ProcCollectorsOff} {
+		config.GlobalConfig.ProcCollectors = mode
+		f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+		require.NoError(t, err)
+		var task Capture
+		require.NoError(t, task.captureWithProc(ctx, f, "test", commandsFail, proc), mode)
+		data, err := os.ReadFile(f.Name())
+		require.NoError(t, err)
+		f.Close()
+		assert.Equal(t, "command not found", string(data), mode)
+	}
+
+	assert.NoError(t, ValidateProcCollectors(""))
+	assert.Error(t, ValidateProcCollectors("always"))
+}
+
+// withFixtureProcFS makes the /proc collectors read the fixture of the procfs package.
+func withFixtureProcFS(t *testing.T) {
+	old := procFS
+	t.Cleanup(func() { procFS = old })
+	// The tests run in testdata, see the init of heap_test.go
+	procFS = func() *procfs.FS {
+		fs := procfs.NewFS("../procfs/testdata/proc")
+		fs.MountRoot = "../procfs/testdata"
+		return fs
+	}
+}
+
+func TestProcTopProcess(t *testing.T) {
+	withFixtureProcFS(t)
+
+	pid, err := procTopProcess(false)
+	require.NoError(t, err)
+	assert.Equal(t, 42, pid)
+	pid, err = procTopProcess(true)
+	require.NoError(t, err)
+	assert.Equal(t, 42, pid)
+}
+
+func TestTopHThreadCPU(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the /proc collectors only run on linux")
+	}
+	withFixtureProcFS(t)
+	oldMode, oldDelay := config.GlobalConfig.ProcCollectors, procfs.TopFirstDelay
+	t.Cleanup(func() { config.GlobalConfig.ProcCollectors, procfs.TopFirstDelay = oldMode, oldDelay })
+	config.GlobalConfig.ProcCollectors = ProcCollectorsPrimary
+	procfs.TopFirstDelay = time.Millisecond
+	dir := t.TempDir()
+
+	var sampler *procfs.ThreadSampler
+	for n := 1; n <= 2; n++ {
+		topH := &TopH{Pid: 42, N: n, Sampler: sampler}
+		topH.SetDir(dir)
+		file, err := topH.CaptureToFile()
+		require.NoError(t, err)
+		file.Close()
+		require.NotNil(t, topH.Sampler)
+		if sampler != nil {
+			assert.Same(t, sampler, topH.Sampler, "the sampler is kept from a thread dump to the next")
+		}
+		sampler = topH.Sampler
+
+		data, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("topdashH.%d.out", n)))
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "Threads:   2 total")
+		assert.Contains(t, string(data), " VM Thread\n")
+		assert.FileExists(t, filepath.Join(dir, fmt.Sprintf("threadcpu.%d.json", n)))
+	}
+
+	td := &ThreadDump{}
+	td.SetDir(dir)
+	file, err := td.mergeThreadCPU()
+	require.NoError(t, err)
+	require.NotNil(t, file)
+	file.Close()
+	data, err := os.ReadFile(filepath.Join(dir, "threadcpu.json"))
+	require.NoError(t, err)
+	var merged []ThreadCPU
+	require.NoError(t, json.Unmarshal(data, &merged))
+	require.Len(t, merged, 2)
+	assert.Equal(t, 1, merged[0].N)
+	assert.Equal(t, map[string]float64{"0x2a": 0, "0x2b": 0}, merged[1].Threads)
+}
+
+func TestTop_KillStopsProcSampler(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the /proc collectors only run on linux")
+	}
+	withFixtureProcFS(t)
+	oldMode, oldDelay := config.GlobalConfig.ProcCollectors, procfs.TopFirstDelay
+	t.Cleanup(func() { config.GlobalConfig.ProcCollectors, procfs.TopFirstDelay = oldMode, oldDelay })
+	config.GlobalConfig.ProcCollectors = ProcCollectorsPrimary
+	procfs.TopFirstDelay = time.Millisecond
+
+	top := &Top{}
+	top.SetDir(t.TempDir())
+	type captured struct {
+		file *os.File
+		err  error
+	}
+	done := make(chan captured, 1)
+	go func() {
+		file, err := top.CaptureToFile()
+		done <- captured{file, err}
+	}()
+
+	// The first frame is written after TopFirstDelay, the next one topDelay later
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, top.Kill())
+	select {
+	case c := <-done:
+		require.NoError(t, c.err)
+		defer c.file.Close()
+		data, err := os.ReadFile(c.file.Name())
+		require.NoError(t, err)
+		assert.NotEmpty(t, data, "the frame sampled before the kill is kept")
+		assert.Equal(t, methodProcfs, top.method, "the command isn't run after the kill")
+	case <-time.After(time.Second):
+		t.Fatal("the /proc sampler is still running after the kill")
+	}
+}
@@ -6,14 +6,21 @@ package capture
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"os"
 	"strconv"
 	"strings"
 	"yc-agent/internal/capture/executils"
+	"yc-agent/internal/capture/jvms"
 	"yc-agent/internal/config"
 )
 
+// GetTopCpu returns the pid of the java process using the most CPU.
 func GetTopCpu() (pid int, err error) {
+	return topProcessWithProc(getTopCpu, false)
+}
+
+func getTopCpu() (pid int, err error) {
 	output, err := executils.CommandCombinedOutput(executils.ProcessTopCPU)
 	if err != nil {
 		return
@@ -52,7 +59,12 @@ Next:
 	return
 }
 
+// GetTopMem returns the pid of the java process using the most memory.
 func GetTopMem() (pid int, err error) {
+	return topProcessWithProc(getTopMem, true)
+}
+
+func getTopMem() (pid int, err error) {
 	output, err := executils.CommandCombinedOutput(executils.ProcessTopMEM)
 	if err != nil {
 		return
@@ -91,7 +103,21 @@ Next:
 	return
 }
 
+// GetProcessIds returns the pids of the JVMs matching one of the tokens but none
+// of the excludes, see matchJVMs. Where the JVMs can't be discovered, the tokens
+// are looked up in the lines of ps.
 func GetProcessIds(tokens config.ProcessTokens, excludes config.ProcessTokens) (pids map[int]string, err error) {
+	discovered, err := jvms.Discover()
+	if errors.Is(err, jvms.ErrUnsupported) {
+		return getProcessIdsFromPS(tokens, excludes)
+	}
+	if err != nil {
+		return
+	}
+	return matchJVMs(discovered, tokens, excludes), nil
+}
+
+func getProcessIdsFromPS(tokens config.ProcessTokens, excludes config.ProcessTokens) (pids map[int]string, err error) {
 	output, err := executils.CommandCombinedOutput(executils.M3PS)
 	if err != nil {
 		return
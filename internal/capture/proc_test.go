@@ -3,6 +3,10 @@ package capture
 import (
 	"testing"
 	"yc-agent/internal/capture/executils"
+	"yc-agent/internal/capture/jvms"
+	"yc-agent/internal/config"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestCheckProcessExists(t *testing.T) {
@@ -22,3 +26,17 @@ func TestCheckProcessExists(t *testing.T) {
 		t.Fatal("process should be exists")
 	}
 }
+
+func TestMatchJVMs(t *testing.T) {
+	orders := jvms.ParseCmdline([]string{"java", "-Dapp.name=orders", "-jar", "orders.jar"})
+	orders.Pid = 10
+	billing := jvms.ParseCmdline([]string{"java", "-Dapp.name=billing", "com.example.Billing"})
+	billing.Pid = 20
+	batch := jvms.ParseCmdline([]string{"java", "-Dapp.name=billing-batch", "com.example.Batch"})
+	batch.Pid = 30
+
+	pids := matchJVMs([]*jvms.JVM{orders, billing, batch},
+		config.ProcessTokens{"jar=orders.jar$orders", "regex=Billing$$billing", "billing"},
+		config.ProcessTokens{"mainClass=com.example.Batch"})
+	assert.Equal(t, map[int]string{10: "orders", 20: "billing"}, pids)
+}
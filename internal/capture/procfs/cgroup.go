@@ -0,0 +1,451 @@
+package procfs
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The controllers whose statistics CGroup.Stats reads.
+var cgroupControllers = []string{"memory", "cpu", "cpuacct", "pids", "blkio"}
+
+// CGroup is the cgroup of a process, in the unified hierarchy of cgroup v2 or
+// in the hierarchies of the controllers of cgroup v1.
+type CGroup struct {
+	Pid int
+	// Version is 1 if the controllers are mounted as cgroup v1 hierarchies, 2 otherwise.
+	Version int
+	// Path is the cgroup of the process in the unified hierarchy, or in the
+	// hierarchy of the memory controller for v1.
+	Path string
+
+	fs *FS
+	// dirs are the directories of the cgroup by controller for v1, "" for v2.
+	dirs map[string]string
+}
+
+// cgroupMount is a cgroup hierarchy of /proc/self/mountinfo.
+type cgroupMount struct {
+	// Root is the cgroup mounted at Target, "/" unless a cgroup namespace or a
+	// bind mount hides its ancestors.
+	Root, Target string
+	// Controllers are those of a v1 hierarchy, nil for the unified hierarchy.
+	Controllers []string
+}
+
+// CGroup returns the cgroup of the process pid, from /proc/<pid>/cgroup,
+// located in the cgroup hierarchies mounted in the mount namespace of the agent.
+func (fs *FS) CGroup(pid int) (*CGroup, error) {
+	data, err := os.ReadFile(fs.path(strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := fs.cgroupMounts()
+	if err != nil {
+		return nil, err
+	}
+
+	cg := &CGroup{Pid: pid, Version: 2, fs: fs, dirs: map[string]string{}}
+	var unified string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[0] == "0" && fields[1] == "" {
+			unified = fields[2]
+			if dir := resolveCGroup(mounts, "", fields[2]); dir != "" {
+				cg.dirs[""] = dir
+			}
+			continue
+		}
+		for _, controller := range strings.Split(fields[1], ",") {
+			if dir := resolveCGroup(mounts, controller, fields[2]); dir != "" {
+				cg.dirs[controller] = dir
+				if cg.Path == "" || controller == "memory" {
+					cg.Path = fields[2]
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, controller := range cgroupControllers {
+		if _, ok := cg.dirs[controller]; ok {
+			cg.Version = 1
+		}
+	}
+	if cg.Version == 2 {
+		if _, ok := cg.dirs[""]; !ok {
+			return nil, fmt.Errorf("no cgroup hierarchy of process %d is mounted", pid)
+		}
+		cg.Path = unified
+	}
+	return cg, nil
+}
+
+// cgroupMounts reads the cgroup hierarchies mounted in the mount namespace of the agent.
+func (fs *FS) cgroupMounts() ([]cgroupMount, error) {
+	data, err := os.ReadFile(fs.path("self", "mountinfo"))
+	if err != nil {
+		return nil, err
+	}
+	var mounts []cgroupMount
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// id parent major:minor root target options [optional...] - fstype source super-options
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 0 || sep+3 >= len(fields) {
+			continue
+		}
+		m := cgroupMount{
+			Root:   unescapeMount(fields[3]),
+			Target: filepath.Join(fs.MountRoot, unescapeMount(fields[4])),
+		}
+		switch fields[sep+1] {
+		case "cgroup2":
+		case "cgroup":
+			for _, option := range strings.Split(fields[sep+3], ",") {
+				for _, controller := range cgroupControllers {
+					if option == controller {
+						m.Controllers = append(m.Controllers, controller)
+					}
+				}
+			}
+			if len(m.Controllers) == 0 {
+				continue
+			}
+		default:
+			continue
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, scanner.Err()
+}
+
+// resolveCGroup returns the directory of the cgroup path in the hierarchy of
+// the controller, the unified one if "", or "" if it is not mounted.
+func resolveCGroup(mounts []cgroupMount, controller, path string) string {
+	if path == "/.." || strings.HasPrefix(path, "/../") {
+		// The cgroup is outside of the cgroup namespace of the agent
+		return ""
+	}
+	for _, m := range mounts {
+		if (controller == "") != (m.Controllers == nil) {
+			continue
+		}
+		if controller != "" && !contains(m.Controllers, controller) {
+			continue
+		}
+		rel, err := filepath.Rel(m.Root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			// The cgroup is outside of the mounted part of the hierarchy
+			continue
+		}
+		return filepath.Join(m.Target, rel)
+	}
+	return ""
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// CGroupStats are the resource usage and the limits of a cgroup. The limits are
+// -1 if unlimited, or if their controller isn't enabled.
+type CGroupStats struct {
+	Time   time.Time    `json:"time"`
+	Memory CGroupMemory `json:"memory"`
+	CPU    CGroupCPU    `json:"cpu"`
+	Pids   CGroupPids   `json:"pids"`
+	IO     []CGroupIO   `json:"io,omitempty"`
+}
+
+// CGroupMemory is the memory usage of a cgroup, in bytes.
+type CGroupMemory struct {
+	Limit int64  `json:"limit"`
+	Usage uint64 `json:"usage"`
+	// Stat is memory.stat.
+	Stat map[string]uint64 `json:"stat,omitempty"`
+	// Events are the counters of memory.events for v2, like oom and oom_kill,
+	// and failcnt, under_oom and oom_kill of memory.oom_control for v1.
+	Events map[string]uint64 `json:"events,omitempty"`
+}
+
+// CGroupCPU is the CPU usage of a cgroup, in microseconds.
+type CGroupCPU struct {
+	// Quota is the CPU time the cgroup can use every Period.
+	Quota  int64  `json:"quota_usec"`
+	Period uint64 `json:"period_usec"`
+	Usage  uint64 `json:"usage_usec"`
+	// Periods are the enforcement periods elapsed, Throttled those in which
+	// the cgroup exhausted its quota, ThrottledTime the time it was throttled.
+	Periods       uint64 `json:"nr_periods"`
+	Throttled     uint64 `json:"nr_throttled"`
+	ThrottledTime uint64 `json:"throttled_usec"`
+}
+
+// CGroupPids are the tasks of a cgroup.
+type CGroupPids struct {
+	Max     int64  `json:"max"`
+	Current uint64 `json:"current"`
+}
+
+// CGroupIO is the IO of a cgroup on a block device.
+type CGroupIO struct {
+	// Device is major:minor.
+	Device     string `json:"device"`
+	ReadBytes  uint64 `json:"rbytes"`
+	WriteBytes uint64 `json:"wbytes"`
+	ReadIOs    uint64 `json:"rios"`
+	WriteIOs   uint64 `json:"wios"`
+}
+
+// Stats reads the resource usage and the limits of the cgroup. The files of the
+// controllers which aren't enabled are skipped.
+func (cg *CGroup) Stats() (*CGroupStats, error) {
+	stats := &CGroupStats{
+		Time:   cg.fs.now(),
+		Memory: CGroupMemory{Limit: -1},
+		CPU:    CGroupCPU{Quota: -1},
+		Pids:   CGroupPids{Max: -1},
+	}
+	// The cgroup is removed once its processes exit
+	if _, err := os.Stat(cg.dir()); err != nil {
+		return nil, err
+	}
+	var err error
+	if cg.Version == 2 {
+		err = cg.readV2(stats)
+	} else {
+		err = cg.readV1(stats)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// dir returns the directory of the cgroup in the unified hierarchy, or in the
+// first hierarchy of the controllers for v1.
+func (cg *CGroup) dir() string {
+	if cg.Version == 2 {
+		return cg.dirs[""]
+	}
+	for _, controller := range cgroupControllers {
+		if dir, ok := cg.dirs[controller]; ok {
+			return dir
+		}
+	}
+	return ""
+}
+
+func (cg *CGroup) readV2(stats *CGroupStats) error {
+	dir := cg.dirs[""]
+	file := func(name string) string { return filepath.Join(dir, name) }
+	r := &cgroupReader{}
+
+	stats.Memory.Limit = r.limit(file("memory.max"))
+	stats.Memory.Usage = r.uint(file("memory.current"))
+	stats.Memory.Stat = r.keyValues(file("memory.stat"))
+	stats.Memory.Events = r.keyValues(file("memory.events"))
+
+	if fields := r.fields(file("cpu.max")); len(fields) == 2 {
+		stats.CPU.Quota = parseLimit(fields[0])
+		stats.CPU.Period, _ = strconv.ParseUint(fields[1], 10, 64)
+	}
+	cpu := r.keyValues(file("cpu.stat"))
+	stats.CPU.Usage = cpu["usage_usec"]
+	stats.CPU.Periods = cpu["nr_periods"]
+	stats.CPU.Throttled = cpu["nr_throttled"]
+	stats.CPU.ThrottledTime = cpu["throttled_usec"]
+
+	stats.Pids.Max = r.limit(file("pids.max"))
+	stats.Pids.Current = r.uint(file("pids.current"))
+
+	// major:minor rbytes=1 wbytes=2 rios=3 wios=4 dbytes=0 dios=0
+	for _, line := range r.lines(file("io.stat")) {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		io := CGroupIO{Device: fields[0]}
+		for _, field := range fields[1:] {
+			key, value, _ := strings.Cut(field, "=")
+			v, _ := strconv.ParseUint(value, 10, 64)
+			switch key {
+			case "rbytes":
+				io.ReadBytes = v
+			case "wbytes":
+				io.WriteBytes = v
+			case "rios":
+				io.ReadIOs = v
+			case "wios":
+				io.WriteIOs = v
+			}
+		}
+		stats.IO = append(stats.IO, io)
+	}
+	return r.err
+}
+
+func (cg *CGroup) readV1(stats *CGroupStats) error {
+	r := &cgroupReader{}
+	file := func(controller, name string) string {
+		dir, ok := cg.dirs[controller]
+		if !ok {
+			return ""
+		}
+		return filepath.Join(dir, name)
+	}
+
+	stats.Memory.Limit = r.limit(file("memory", "memory.limit_in_bytes"))
+	stats.Memory.Usage = r.uint(file("memory", "memory.usage_in_bytes"))
+	stats.Memory.Stat = r.keyValues(file("memory", "memory.stat"))
+	if events := r.keyValues(file("memory", "memory.oom_control")); events != nil {
+		delete(events, "oom_kill_disable")
+		events["failcnt"] = r.uint(file("memory", "memory.failcnt"))
+		stats.Memory.Events = events
+	}
+
+	stats.CPU.Quota = r.limit(file("cpu", "cpu.cfs_quota_us"))
+	stats.CPU.Period = r.uint(file("cpu", "cpu.cfs_period_us"))
+	cpu := r.keyValues(file("cpu", "cpu.stat"))
+	stats.CPU.Periods = cpu["nr_periods"]
+	stats.CPU.Throttled = cpu["nr_throttled"]
+	stats.CPU.ThrottledTime = cpu["throttled_time"] / 1000
+	stats.CPU.Usage = r.uint(file("cpuacct", "cpuacct.usage")) / 1000
+
+	stats.Pids.Max = r.limit(file("pids", "pids.max"))
+	stats.Pids.Current = r.uint(file("pids", "pids.current"))
+
+	// major:minor Read|Write|Sync|Async|Discard|Total value
+	devices := map[string]*CGroupIO{}
+	device := func(name string) *CGroupIO {
+		if devices[name] == nil {
+			devices[name] = &CGroupIO{Device: name}
+		}
+		return devices[name]
+	}
+	for _, name := range []string{"blkio.throttle.io_service_bytes", "blkio.throttle.io_serviced"} {
+		for _, line := range r.lines(file("blkio", name)) {
+			fields := strings.Fields(line)
+			if len(fields) != 3 {
+				continue
+			}
+			v, _ := strconv.ParseUint(fields[2], 10, 64)
+			io := device(fields[0])
+			switch {
+			case fields[1] == "Read" && name == "blkio.throttle.io_service_bytes":
+				io.ReadBytes = v
+			case fields[1] == "Write" && name == "blkio.throttle.io_service_bytes":
+				io.WriteBytes = v
+			case fields[1] == "Read":
+				io.ReadIOs = v
+			case fields[1] == "Write":
+				io.WriteIOs = v
+			}
+		}
+	}
+	for _, io := range devices {
+		stats.IO = append(stats.IO, *io)
+	}
+	sort.Slice(stats.IO, func(i, j int) bool { return stats.IO[i].Device < stats.IO[j].Device })
+
+	return r.err
+}
+
+// cgroupReader reads the files of a cgroup, skipping those which don't exist,
+// like the files of the controllers which aren't enabled. It keeps the first
+// other error.
+type cgroupReader struct {
+	err error
+}
+
+func (r *cgroupReader) read(path string) string {
+	if path == "" {
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) && r.err == nil {
+			r.err = err
+		}
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func (r *cgroupReader) lines(path string) []string {
+	if data := r.read(path); data != "" {
+		return strings.Split(data, "\n")
+	}
+	return nil
+}
+
+func (r *cgroupReader) fields(path string) []string {
+	return strings.Fields(r.read(path))
+}
+
+func (r *cgroupReader) uint(path string) uint64 {
+	v, _ := strconv.ParseUint(r.read(path), 10, 64)
+	return v
+}
+
+func (r *cgroupReader) limit(path string) int64 {
+	if data := r.read(path); data != "" {
+		return parseLimit(data)
+	}
+	return -1
+}
+
+func (r *cgroupReader) keyValues(path string) map[string]uint64 {
+	if path == "" {
+		return nil
+	}
+	values, err := readKeyValuesFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) && r.err == nil {
+			r.err = err
+		}
+		return nil
+	}
+	return values
+}
+
+// parseLimit parses a cgroup limit, -1 if unlimited: max for v2, -1 or the
+// page-aligned maximum of the memory limits for v1.
+func parseLimit(s string) int64 {
+	if s == "max" {
+		return -1
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < 0 || v >= math.MaxInt64&^(1<<20-1) {
+		return -1
+	}
+	return v
+}
@@ -0,0 +1,114 @@
+package procfs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Mount is a mounted file system of /proc/self/mounts.
+type Mount struct {
+	Source, Target, FSType string
+}
+
+// Mounts reads the file systems mounted in the mount namespace of the agent.
+func (fs *FS) Mounts() ([]Mount, error) {
+	data, err := os.ReadFile(fs.path("self", "mounts"))
+	if err != nil {
+		data, err = os.ReadFile(fs.path("mounts"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	var mounts []Mount
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		mounts = append(mounts, Mount{Source: unescapeMount(fields[0]), Target: unescapeMount(fields[1]), FSType: fields[2]})
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMount decodes the octal escapes of the spaces, tabs, newlines and
+// backslashes in the fields of the mounts, like \040.
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// Statfs is the usage of a file system.
+type Statfs struct {
+	BlockSize uint64
+	// Blocks is the size of the file system, Free the blocks free, Available
+	// the blocks free for unprivileged users.
+	Blocks, Free, Available uint64
+}
+
+// WriteDF writes the usage of the mounted file systems in KiB to w, like df -hk.
+// Like df, the file systems with no blocks, like proc, and those which can't
+// be read are skipped.
+func (fs *FS) WriteDF(ctx context.Context, w io.Writer) error {
+	mounts, err := fs.Mounts()
+	if err != nil {
+		return err
+	}
+
+	rows := [][]string{{"Filesystem", "1K-blocks", "Used", "Available", "Use%", "Mounted on"}}
+	seen := map[Mount]bool{}
+	for _, m := range mounts {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if seen[m] {
+			continue
+		}
+		seen[m] = true
+		st, err := fs.statfs(m.Target)
+		if err != nil || st.Blocks == 0 {
+			continue
+		}
+		kib := func(blocks uint64) uint64 { return blocks * st.BlockSize / 1024 }
+		used := sub(st.Blocks, st.Free)
+		use := "-"
+		if used+st.Available > 0 {
+			use = fmt.Sprintf("%d%%", (used*100+used+st.Available-1)/(used+st.Available))
+		}
+		rows = append(rows, []string{m.Source, fmt.Sprint(kib(st.Blocks)), fmt.Sprint(kib(used)), fmt.Sprint(kib(st.Available)), use, m.Target})
+	}
+
+	// The columns are as wide as their widest cell, at least as wide as df makes them.
+	widths := []int{14, 9, 9, 9, 4}
+	for _, row := range rows {
+		for i := range widths {
+			widths[i] = max(widths[i], len(row[i]))
+		}
+	}
+	var b strings.Builder
+	for _, row := range rows {
+		fmt.Fprintf(&b, "%-*s %*s %*s %*s %*s %s\n", widths[0], row[0], widths[1], row[1], widths[2], row[2],
+			widths[3], row[3], widths[4], row[4], row[5])
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
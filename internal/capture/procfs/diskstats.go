@@ -0,0 +1,156 @@
+package procfs
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sectorSize is the unit of the sectors of /proc/diskstats, whatever the
+// sector size of the device.
+const sectorSize = 512
+
+// DiskStats are the IO statistics of a block device since boot, a line of
+// /proc/diskstats. The times are in milliseconds.
+type DiskStats struct {
+	Device string
+	// ReadIOs are the reads completed, ReadMerges the adjacent reads merged
+	// into them, ReadSectors the sectors read and ReadTime the time spent
+	// by the reads.
+	ReadIOs, ReadMerges, ReadSectors, ReadTime uint64
+	// Write* are the same for the writes.
+	WriteIOs, WriteMerges, WriteSectors, WriteTime uint64
+	// InFlight are the IOs in progress now, IOTime the time the device was
+	// busy and QueueTime the time the IOs spent, weighted by their number.
+	InFlight, IOTime, QueueTime uint64
+}
+
+// DiskStats reads the statistics of the block devices of /proc/diskstats.
+// The devices which never did any IO, like the unused loop devices, are skipped.
+func (fs *FS) DiskStats() ([]DiskStats, error) {
+	data, err := os.ReadFile(fs.path("diskstats"))
+	if err != nil {
+		return nil, err
+	}
+	var disks []DiskStats
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// major minor name reads... writes... in-flight io-ticks time-in-queue [discards... flushes...]
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 14 {
+			continue
+		}
+		v := parseUints(fields[3:14])
+		d := DiskStats{
+			Device:  fields[2],
+			ReadIOs: v[0], ReadMerges: v[1], ReadSectors: v[2], ReadTime: v[3],
+			WriteIOs: v[4], WriteMerges: v[5], WriteSectors: v[6], WriteTime: v[7],
+			InFlight: v[8], IOTime: v[9], QueueTime: v[10],
+		}
+		if d.ReadIOs+d.WriteIOs == 0 {
+			continue
+		}
+		disks = append(disks, d)
+	}
+	return disks, scanner.Err()
+}
+
+// DiskIO is the IO of a block device over an interval, like iostat -x.
+type DiskIO struct {
+	Device string `json:"device"`
+	// ReadIOPS and WriteIOPS are the IOs completed per second.
+	ReadIOPS  float64 `json:"r/s"`
+	WriteIOPS float64 `json:"w/s"`
+	// ReadKB and WriteKB are the KiB transferred per second.
+	ReadKB  float64 `json:"rkB/s"`
+	WriteKB float64 `json:"wkB/s"`
+	// ReadAwait and WriteAwait are the average times in milliseconds the IOs
+	// took, queued and served.
+	ReadAwait  float64 `json:"r_await"`
+	WriteAwait float64 `json:"w_await"`
+	// QueueSize is the average number of IOs queued or in progress.
+	QueueSize float64 `json:"aqu-sz"`
+	// Util is the percentage of the time the device was busy.
+	Util float64 `json:"%util"`
+}
+
+// Rate returns the IO of the device since prev, elapsed earlier.
+func (d DiskStats) Rate(prev DiskStats, elapsed time.Duration) DiskIO {
+	io := DiskIO{Device: d.Device}
+	seconds := elapsed.Seconds()
+	if seconds <= 0 {
+		return io
+	}
+	reads, writes := sub(d.ReadIOs, prev.ReadIOs), sub(d.WriteIOs, prev.WriteIOs)
+	io.ReadIOPS = float64(reads) / seconds
+	io.WriteIOPS = float64(writes) / seconds
+	io.ReadKB = float64(sub(d.ReadSectors, prev.ReadSectors)) * sectorSize / 1024 / seconds
+	io.WriteKB = float64(sub(d.WriteSectors, prev.WriteSectors)) * sectorSize / 1024 / seconds
+	if reads > 0 {
+		io.ReadAwait = float64(sub(d.ReadTime, prev.ReadTime)) / float64(reads)
+	}
+	if writes > 0 {
+		io.WriteAwait = float64(sub(d.WriteTime, prev.WriteTime)) / float64(writes)
+	}
+	io.QueueSize = float64(sub(d.QueueTime, prev.QueueTime)) / 1000 / seconds
+	io.Util = min(float64(sub(d.IOTime, prev.IOTime))/10/seconds, 100)
+	return io
+}
+
+// ProcIO are the IO statistics of a process, /proc/<pid>/io.
+type ProcIO struct {
+	// RChar and WChar are the bytes read and written by the syscalls, SyscR
+	// and SyscW the syscalls.
+	RChar, WChar, SyscR, SyscW uint64
+	// ReadBytes and WriteBytes are the bytes fetched from and sent to the
+	// storage, the page cache aside.
+	ReadBytes, WriteBytes uint64
+}
+
+// ProcIO reads the IO statistics of the process pid. Only root and the user
+// of the process can read them.
+func (fs *FS) ProcIO(pid int) (*ProcIO, error) {
+	values, err := fs.readKeyValues(strconv.Itoa(pid) + "/io")
+	if err != nil {
+		return nil, err
+	}
+	return &ProcIO{
+		RChar:      values["rchar"],
+		WChar:      values["wchar"],
+		SyscR:      values["syscr"],
+		SyscW:      values["syscw"],
+		ReadBytes:  values["read_bytes"],
+		WriteBytes: values["write_bytes"],
+	}, nil
+}
+
+// ProcIORate is the IO of a process over an interval, per second.
+type ProcIORate struct {
+	ReadBytes  float64 `json:"readBytes"`
+	WriteBytes float64 `json:"writeBytes"`
+	// ReadChars and WriteChars count the bytes served by the page cache too.
+	ReadChars     float64 `json:"readChars"`
+	WriteChars    float64 `json:"writeChars"`
+	ReadSyscalls  float64 `json:"readSyscalls"`
+	WriteSyscalls float64 `json:"writeSyscalls"`
+}
+
+// Rate returns the IO of the process since prev, elapsed earlier.
+func (p *ProcIO) Rate(prev *ProcIO, elapsed time.Duration) ProcIORate {
+	seconds := elapsed.Seconds()
+	if seconds <= 0 {
+		return ProcIORate{}
+	}
+	rate := func(cur, prev uint64) float64 { return float64(sub(cur, prev)) / seconds }
+	return ProcIORate{
+		ReadBytes:     rate(p.ReadBytes, prev.ReadBytes),
+		WriteBytes:    rate(p.WriteBytes, prev.WriteBytes),
+		ReadChars:     rate(p.RChar, prev.RChar),
+		WriteChars:    rate(p.WChar, prev.WChar),
+		ReadSyscalls:  rate(p.SyscR, prev.SyscR),
+		WriteSyscalls: rate(p.SyscW, prev.SyscW),
+	}
+}
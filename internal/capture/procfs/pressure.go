@@ -0,0 +1,96 @@
+package procfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// PSI is the pressure stall information of a resource, cpu, memory or io: the
+// share of the time the tasks were stalled waiting for it.
+type PSI struct {
+	// Some is the time at least one task was stalled, Full the time all the
+	// non-idle tasks were stalled at once, nil if not reported like for the
+	// cpu of the host before Linux 5.13.
+	Some *PSIStall `json:"some,omitempty"`
+	Full *PSIStall `json:"full,omitempty"`
+}
+
+// PSIStall is a line of a pressure file.
+type PSIStall struct {
+	// Avg10, Avg60 and Avg300 are the percentages of the time stalled over the
+	// last 10, 60 and 300 seconds.
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+	// Total is the time stalled in microseconds.
+	Total uint64 `json:"total"`
+}
+
+// Pressure reads the pressure of the host on the resource, /proc/pressure/<resource>.
+// It fails if the kernel doesn't support PSI or has it disabled.
+func (fs *FS) Pressure(resource string) (*PSI, error) {
+	return readPSI(fs.path("pressure", resource))
+}
+
+// Pressure reads the pressure of the cgroup on the resource, the <resource>.pressure
+// file of the unified hierarchy.
+func (cg *CGroup) Pressure(resource string) (*PSI, error) {
+	dir, ok := cg.dirs[""]
+	if !ok {
+		return nil, fmt.Errorf("the cgroup of process %d is not in a mounted unified hierarchy", cg.Pid)
+	}
+	return readPSI(filepath.Join(dir, resource+".pressure"))
+}
+
+// readPSI parses the lines of a pressure file like:
+//
+//	some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+func readPSI(path string) (*PSI, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	psi := &PSI{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		stall := &PSIStall{}
+		for _, field := range fields[1:] {
+			key, value, _ := strings.Cut(field, "=")
+			switch key {
+			case "avg10":
+				stall.Avg10, err = strconv.ParseFloat(value, 64)
+			case "avg60":
+				stall.Avg60, err = strconv.ParseFloat(value, 64)
+			case "avg300":
+				stall.Avg300, err = strconv.ParseFloat(value, 64)
+			case "total":
+				stall.Total, err = strconv.ParseUint(value, 10, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", path, err)
+			}
+		}
+		switch fields[0] {
+		case "some":
+			psi.Some = stall
+		case "full":
+			psi.Full = stall
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if psi.Some == nil {
+		return nil, fmt.Errorf("no some line in %s", path)
+	}
+	return psi, nil
+}
@@ -0,0 +1,181 @@
+package procfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ProcStat is the status of a process or a thread, /proc/<pid>/stat or
+// /proc/<pid>/task/<tid>/stat.
+type ProcStat struct {
+	Pid   int
+	Comm  string
+	State string
+	PPid  int
+	// TTY is the device number of the controlling terminal, 0 if none.
+	TTY int
+	// UTime and STime are the clock ticks spent in user and kernel mode.
+	UTime, STime uint64
+	Priority     int64
+	Nice         int64
+	NumThreads   int
+	// StartTime is the clock ticks after boot the process started at.
+	StartTime uint64
+	// VSize is the virtual memory size in bytes, RSS the resident set size in pages.
+	VSize uint64
+	RSS   int64
+	// Processor is the CPU the thread last ran on.
+	Processor int
+}
+
+// CPUTime returns the clock ticks spent in user and kernel mode.
+func (s *ProcStat) CPUTime() uint64 {
+	return s.UTime + s.STime
+}
+
+// ProcStat reads /proc/<pid>/stat.
+func (fs *FS) ProcStat(pid int) (*ProcStat, error) {
+	return fs.readProcStat(fs.path(strconv.Itoa(pid), "stat"))
+}
+
+// TaskStat reads /proc/<pid>/task/<tid>/stat, the status of the thread tid of the process.
+func (fs *FS) TaskStat(pid, tid int) (*ProcStat, error) {
+	return fs.readProcStat(fs.path(strconv.Itoa(pid), "task", strconv.Itoa(tid), "stat"))
+}
+
+func (fs *FS) readProcStat(path string) (*ProcStat, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseProcStat(string(data))
+}
+
+func parseProcStat(data string) (*ProcStat, error) {
+	// The comm is in parentheses and may contain spaces and parentheses itself.
+	lparen, rparen := strings.IndexByte(data, '('), strings.LastIndexByte(data, ')')
+	if lparen < 0 || rparen < lparen {
+		return nil, fmt.Errorf("invalid stat %q", data)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(data[:lparen]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid stat pid: %w", err)
+	}
+	fields := strings.Fields(data[rparen+1:])
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("invalid stat of %d: %d fields", pid, len(fields))
+	}
+
+	s := &ProcStat{Pid: pid, Comm: data[lparen+1 : rparen], State: fields[0]}
+	s.PPid, _ = strconv.Atoi(fields[1])
+	s.TTY, _ = strconv.Atoi(fields[4])
+	s.UTime, _ = strconv.ParseUint(fields[11], 10, 64)
+	s.STime, _ = strconv.ParseUint(fields[12], 10, 64)
+	s.Priority, _ = strconv.ParseInt(fields[15], 10, 64)
+	s.Nice, _ = strconv.ParseInt(fields[16], 10, 64)
+	s.NumThreads, _ = strconv.Atoi(fields[17])
+	s.StartTime, _ = strconv.ParseUint(fields[19], 10, 64)
+	s.VSize, _ = strconv.ParseUint(fields[20], 10, 64)
+	s.RSS, _ = strconv.ParseInt(fields[21], 10, 64)
+	if len(fields) > 36 {
+		s.Processor, _ = strconv.Atoi(fields[36])
+	}
+	return s, nil
+}
+
+// Pids returns the pids of the processes, in ascending order.
+func (fs *FS) Pids() ([]int, error) {
+	return fs.readPids(fs.Root)
+}
+
+// Tasks returns the ids of the threads of the process, in ascending order.
+func (fs *FS) Tasks(pid int) ([]int, error) {
+	return fs.readPids(fs.path(strconv.Itoa(pid), "task"))
+}
+
+func (fs *FS) readPids(dir string) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if pid, err := strconv.Atoi(entry.Name()); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+	return pids, nil
+}
+
+// Cmdline returns the command line of the process, its arguments joined by
+// spaces, or its comm in brackets if it has none, like kernel threads.
+func (fs *FS) Cmdline(pid int, comm string) string {
+	data, _ := os.ReadFile(fs.path(strconv.Itoa(pid), "cmdline"))
+	data = bytes.TrimRight(data, "\x00")
+	if len(data) == 0 {
+		return "[" + comm + "]"
+	}
+	return string(bytes.ReplaceAll(data, []byte{0}, []byte{' '}))
+}
+
+// UID returns the effective user id of the process, of the Uid line of /proc/<pid>/status.
+func (fs *FS) UID(pid int) (string, error) {
+	data, err := os.ReadFile(fs.path(strconv.Itoa(pid), "status"))
+	if err != nil {
+		return "", err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 3 && fields[0] == "Uid:" {
+			return fields[2], nil
+		}
+	}
+	return "", fmt.Errorf("no Uid in the status of %d", pid)
+}
+
+// Statm is the memory usage of a process of /proc/<pid>/statm, in pages.
+type Statm struct {
+	Size, Resident, Shared uint64
+}
+
+// Statm reads /proc/<pid>/statm.
+func (fs *FS) Statm(pid int) (Statm, error) {
+	data, err := os.ReadFile(fs.path(strconv.Itoa(pid), "statm"))
+	if err != nil {
+		return Statm{}, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return Statm{}, fmt.Errorf("invalid statm of %d", pid)
+	}
+	v := parseUints(fields[:3])
+	return Statm{Size: v[0], Resident: v[1], Shared: v[2]}, nil
+}
+
+// ttyName returns the name of the terminal with the device number tty, like
+// pts/0, or ? if it has none.
+func ttyName(tty int) string {
+	major := (tty >> 8) & 0xfff
+	minor := (tty & 0xff) | ((tty >> 12) & 0xfff00)
+	switch {
+	case tty == 0:
+		return "?"
+	case major >= 136 && major <= 143:
+		return fmt.Sprintf("pts/%d", minor+(major-136)*256)
+	case major == 4 && minor < 64:
+		return fmt.Sprintf("tty%d", minor)
+	case major == 4:
+		return fmt.Sprintf("ttyS%d", minor-64)
+	}
+	return "?"
+}
@@ -0,0 +1,132 @@
+// Package procfs reads the Linux proc file system, so that the agent can
+// capture the system and process statistics without the procps binaries, top,
+// vmstat, ps and df, that slim containers don't have and whose output differs
+// across distros.
+//
+// The writers produce the same text formats as those commands, the formats the
+// yCrash server parses:
+//
+//	WriteTop    top -bc -d <delay> -n <frames>
+//	WriteTopH   top -bH -n 1 -p <pid>, of a ThreadSample
+//	WriteVMStat vmstat <interval> <count>, every line prefixed by the time
+//	WritePS     ps -eLf
+//	WriteDF     df -hk
+//
+// The proc file system is read from the root of the FS, so that the parsing
+// can be tested against fixture trees.
+package procfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultRoot is where the proc file system is mounted.
+const DefaultRoot = "/proc"
+
+// ClockTicks is the number of clock ticks per second the CPU times are counted
+// in, USER_HZ, which is 100 on every architecture Linux supports.
+const ClockTicks = 100
+
+// FS is a proc file system mounted at Root.
+type FS struct {
+	Root string
+	// MountRoot is prepended to the mount points read from the file system,
+	// like those of the cgroup hierarchies, empty for the host.
+	MountRoot string
+
+	now        func() time.Time
+	statfs     func(path string) (Statfs, error)
+	lookupUser func(uid string) string
+	pageSize   int64
+}
+
+// NewFS returns the proc file system mounted at root.
+func NewFS(root string) *FS {
+	return &FS{
+		Root:       root,
+		now:        time.Now,
+		statfs:     statfs,
+		lookupUser: lookupUser,
+		pageSize:   int64(os.Getpagesize()),
+	}
+}
+
+// Default returns the proc file system mounted at DefaultRoot.
+func Default() *FS {
+	return NewFS(DefaultRoot)
+}
+
+func (fs *FS) path(elem ...string) string {
+	return filepath.Join(append([]string{fs.Root}, elem...)...)
+}
+
+// userName returns the name of the user uid, uid itself if it has no name.
+func (fs *FS) userName(uid string) string {
+	if name := fs.lookupUser(uid); name != "" {
+		return name
+	}
+	return uid
+}
+
+func lookupUser(uid string) string {
+	u, err := user.LookupId(uid)
+	if err != nil {
+		return ""
+	}
+	return u.Username
+}
+
+// readKeyValues parses the "key value [unit]" or "key: value [unit]" lines of
+// the file, like /proc/meminfo and /proc/vmstat, ignoring the units.
+func (fs *FS) readKeyValues(name string) (map[string]uint64, error) {
+	return readKeyValuesFile(fs.path(name))
+}
+
+func readKeyValuesFile(path string) (map[string]uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]uint64{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[strings.TrimSuffix(fields[0], ":")] = v
+	}
+	return values, scanner.Err()
+}
+
+// parseUints parses the fields as unsigned integers, 0 for the invalid ones.
+func parseUints(fields []string) []uint64 {
+	values := make([]uint64, len(fields))
+	for i, field := range fields {
+		values[i], _ = strconv.ParseUint(field, 10, 64)
+	}
+	return values
+}
+
+// formatDuration formats the clock ticks as [DD-]HH:MM:SS, like the TIME of ps.
+func formatDuration(ticks uint64) string {
+	seconds := ticks / ClockTicks
+	days, seconds := seconds/86400, seconds%86400
+	s := fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	if days > 0 {
+		return fmt.Sprintf("%d-%s", days, s)
+	}
+	return s
+}
@@ -0,0 +1,322 @@
+package procfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The time of the fixture, 10000 seconds after its boot time.
+var fixtureNow = time.Unix(1699960000, 0).UTC()
+
+// fixtureFS returns the proc file system of testdata/proc, with the users and
+// the file systems of the fixture.
+func fixtureFS() *FS {
+	fs := NewFS("testdata/proc")
+	fs.MountRoot = "testdata"
+	fs.now = func() time.Time { return fixtureNow }
+	fs.pageSize = 4096
+	fs.lookupUser = func(uid string) string {
+		return map[string]string{"0": "root", "1000": "app"}[uid]
+	}
+	fs.statfs = func(path string) (Statfs, error) {
+		switch path {
+		case "/":
+			return Statfs{BlockSize: 1024, Blocks: 61255492, Free: 53325984, Available: 50184660}, nil
+		case "/proc":
+			return Statfs{BlockSize: 4096}, nil
+		case "/dev":
+			return Statfs{BlockSize: 4096, Blocks: 16384, Free: 16384, Available: 16384}, nil
+		case "/data dir":
+			return Statfs{BlockSize: 4096, Blocks: 1000, Free: 500, Available: 400}, nil
+		}
+		return Statfs{}, fmt.Errorf("no file system at %s", path)
+	}
+	return fs
+}
+
+func lines(s ...string) string {
+	return strings.Join(s, "\n") + "\n"
+}
+
+func TestParseProcStat(t *testing.T) {
+	s, err := parseProcStat("7 (a (b) c) S 1 7 7 0 -1 4194560 1 0 0 0 5 6 0 0 20 -5 3 0 100 2048 3")
+	require.NoError(t, err)
+	assert.Equal(t, &ProcStat{Pid: 7, Comm: "a (b) c", State: "S", PPid: 1, UTime: 5, STime: 6,
+		Priority: 20, Nice: -5, NumThreads: 3, StartTime: 100, VSize: 2048, RSS: 3}, s)
+
+	for _, data := range []string{"", "7 a S 1", "x (a) S 1 7", "7 (a) S 1 7 7"} {
+		_, err := parseProcStat(data)
+		assert.Error(t, err, data)
+	}
+}
+
+func TestStat(t *testing.T) {
+	fs := fixtureFS()
+	stat, err := fs.Stat()
+	require.NoError(t, err)
+	assert.Equal(t, CPUTimes{User: 100000, Nice: 500, System: 50000, Idle: 9000000, IOWait: 2000, SoftIRQ: 1000}, stat.CPU)
+	assert.Equal(t, 4, stat.CPUs)
+	assert.EqualValues(t, 1699950000, stat.BootTime)
+	assert.EqualValues(t, 10000000, stat.ContextSwitches)
+
+	mem, err := fs.Meminfo()
+	require.NoError(t, err)
+	assert.EqualValues(t, 8000000, mem["MemTotal"])
+	assert.EqualValues(t, 3300000, mem.Used())
+
+	tasks, err := fs.Tasks(42)
+	require.NoError(t, err)
+	assert.Equal(t, []int{42, 43}, tasks)
+	assert.Equal(t, "java -Xmx1g MyApp", fs.Cmdline(42, "java"))
+	assert.Equal(t, "[kthreadd]", fs.Cmdline(2, "kthreadd"))
+
+	mounts, err := fs.Mounts()
+	require.NoError(t, err)
+	assert.Equal(t, Mount{Source: "/dev/sda1", Target: "/data dir", FSType: "ext4"}, mounts[3])
+
+	_, err = NewFS(t.TempDir()).Stat()
+	assert.Error(t, err)
+}
+
+func TestWritePS(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, fixtureFS().WritePS(context.Background(), &out))
+	assert.Equal(t, lines(
+		"UID        PID  PPID   LWP  C NLWP STIME TTY          TIME CMD",
+		"root         1     0     1  0    1 08:20 pts/0    00:00:00 /bin/bash",
+		"root         2     0     2  0    1 08:20 ?        00:00:00 [kthreadd]",
+		"app         42     1    42  0    2 09:43 pts/0    00:00:20 java -Xmx1g MyApp",
+		"app         42     1    43  6    2 09:43 pts/0    00:05:00 java -Xmx1g MyApp",
+	), out.String())
+
+	assert.Equal(t, "Nov13", psStartTime(fixtureNow.Add(-24*time.Hour), fixtureNow))
+	assert.Equal(t, "2022", psStartTime(fixtureNow.AddDate(-1, 0, 0), fixtureNow))
+	assert.Equal(t, "1-00:00:01", formatDuration(86401*ClockTicks))
+}
+
+func TestWriteTop(t *testing.T) {
+	fs := fixtureFS()
+	prev := &topSample{
+		time:  fixtureNow.Add(-10 * time.Second),
+		cpu:   CPUTimes{User: 100000 - 800, Nice: 500, System: 50000 - 200, Idle: 9000000 - 3000, IOWait: 2000, SoftIRQ: 1000},
+		procs: map[int]uint64{1: 18, 42: 31000},
+	}
+	var out bytes.Buffer
+	cur, err := fs.writeTopFrame(&out, prev)
+	require.NoError(t, err)
+	assert.Equal(t, lines(
+		"top - 11:06:40 up  2:46,  0 users,  load average: 0.52, 0.58, 0.59",
+		"Tasks:   3 total,   1 running,   2 sleeping,   0 stopped,   0 zombie",
+		"%Cpu(s): 20.0 us,  5.0 sy,  0.0 ni, 75.0 id,  0.0 wa,  0.0 hi,  0.0 si,  0.0 st",
+		"MiB Mem :   7812.5 total,   1953.1 free,   3222.7 used,   2636.7 buff/cache",
+		"MiB Swap:   1024.0 total,   1024.0 free,      0.0 used.   4882.8 avail Mem ",
+		"",
+		"  PID USER      PR  NI    VIRT    RES    SHR S  %CPU  %MEM     TIME+ COMMAND",
+		"   42 app       20   0 4194304  40000  12000 R 100.0   0.5   5:20.00 java -Xmx1g MyApp",
+		"    1 root      20   0    4000   3272   2724 S   0.0   0.0   0:00.18 /bin/bash",
+		"    2 root      20   0       0      0      0 S   0.0   0.0   0:00.00 [kthreadd]",
+		"",
+	), out.String())
+	assert.EqualValues(t, 32000, cur.procs[42])
+
+	assert.Equal(t, "45 min", formatUptime(45*60))
+	assert.Equal(t, "3 days,  2:03", formatUptime(3*86400+2*3600+3*60))
+
+	// The frames are written until ctx is done
+	old := TopFirstDelay
+	t.Cleanup(func() { TopFirstDelay = old })
+	TopFirstDelay = time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	out.Reset()
+	err = fs.WriteTop(ctx, &out, time.Hour, 3)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, strings.Count(out.String(), "top - "))
+}
+
+func TestThreadSampler(t *testing.T) {
+	fs := fixtureFS()
+	sampler, err := fs.NewThreadSampler(42)
+	require.NoError(t, err)
+	sampler.prev.time = fixtureNow.Add(-10 * time.Second)
+	sampler.prev.procs[43] = 29000
+
+	sample, err := sampler.Sample()
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Second, sample.Elapsed)
+	assert.Equal(t, map[string]float64{"0x2a": 0, "0x2b": 100}, sample.NIDs())
+
+	var out bytes.Buffer
+	require.NoError(t, fs.WriteTopH(&out, sample))
+	assert.Equal(t, lines(
+		"top - 11:06:40 up  2:46,  0 users,  load average: 0.52, 0.58, 0.59",
+		"Threads:   2 total,   1 running,   1 sleeping,   0 stopped,   0 zombie",
+		"%Cpu(s):  0.0 us,  0.0 sy,  0.0 ni,100.0 id,  0.0 wa,  0.0 hi,  0.0 si,  0.0 st",
+		"MiB Mem :   7812.5 total,   1953.1 free,   3222.7 used,   2636.7 buff/cache",
+		"MiB Swap:   1024.0 total,   1024.0 free,      0.0 used.   4882.8 avail Mem ",
+		"",
+		"  PID USER      PR  NI    VIRT    RES    SHR S  %CPU  %MEM     TIME+ COMMAND",
+		"   43 app       20   0 4194304  40000  12000 R 100.0   0.5   5:00.00 VM Thread",
+		"   42 app       20   0 4194304  40000  12000 S   0.0   0.5   0:20.00 java",
+		"",
+	), out.String())
+
+	// The next sample is since this one
+	sample, err = sampler.Sample()
+	require.NoError(t, err)
+	assert.Zero(t, sample.Threads[0].CPU)
+
+	_, err = fs.NewThreadSampler(7)
+	assert.Error(t, err)
+}
+
+func TestCGroup(t *testing.T) {
+	fs := fixtureFS()
+
+	// cgroup v2
+	cg, err := fs.CGroup(42)
+	require.NoError(t, err)
+	assert.Equal(t, 2, cg.Version)
+	assert.Equal(t, "/kubepods/pod1/app", cg.Path)
+	stats, err := cg.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, &CGroupStats{
+		Time: fixtureNow,
+		Memory: CGroupMemory{
+			Limit:  1073741824,
+			Usage:  536870912,
+			Stat:   map[string]uint64{"anon": 400000000, "file": 100000000, "kernel": 10000000},
+			Events: map[string]uint64{"low": 0, "high": 0, "max": 12, "oom": 1, "oom_kill": 1},
+		},
+		CPU:  CGroupCPU{Quota: 200000, Period: 100000, Usage: 50000000, Periods: 1000, Throttled: 250, ThrottledTime: 12000000},
+		Pids: CGroupPids{Max: -1, Current: 57},
+		IO:   []CGroupIO{{Device: "8:0", ReadBytes: 4096, WriteBytes: 8192, ReadIOs: 1, WriteIOs: 2}},
+	}, stats)
+
+	// cgroup v1, the memory hierarchy mounted from /docker
+	cg, err = fs.CGroup(1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, cg.Version)
+	assert.Equal(t, "/docker/abc", cg.Path)
+	stats, err = cg.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, &CGroupStats{
+		Time: fixtureNow,
+		Memory: CGroupMemory{
+			Limit:  -1,
+			Usage:  268435456,
+			Stat:   map[string]uint64{"cache": 1000, "rss": 2000},
+			Events: map[string]uint64{"under_oom": 0, "oom_kill": 2, "failcnt": 5},
+		},
+		CPU:  CGroupCPU{Quota: 50000, Period: 100000, Usage: 90000000, Periods: 400, Throttled: 100, ThrottledTime: 3000000},
+		Pids: CGroupPids{Max: 100, Current: 20},
+		IO: []CGroupIO{
+			{Device: "7:0", ReadBytes: 10, ReadIOs: 1},
+			{Device: "8:0", ReadBytes: 4096, WriteBytes: 512, ReadIOs: 1, WriteIOs: 1},
+		},
+	}, stats)
+
+	// The cgroup of pid 2 is outside of the mounted hierarchies
+	_, err = fs.CGroup(2)
+	assert.Error(t, err)
+	_, err = fs.CGroup(7)
+	assert.Error(t, err)
+}
+
+func TestPressure(t *testing.T) {
+	fs := fixtureFS()
+	psi, err := fs.Pressure("memory")
+	require.NoError(t, err)
+	assert.Equal(t, &PSI{
+		Some: &PSIStall{Avg10: 3.2, Avg60: 1, Avg300: 0.25, Total: 5000000},
+		Full: &PSIStall{Avg10: 1.1, Avg60: 0.5, Avg300: 0.1, Total: 2000000},
+	}, psi)
+	_, err = fs.Pressure("gpu")
+	assert.Error(t, err)
+
+	cg, err := fs.CGroup(42)
+	require.NoError(t, err)
+	psi, err = cg.Pressure("cpu")
+	require.NoError(t, err)
+	assert.EqualValues(t, 90000000, psi.Some.Total)
+	assert.EqualValues(t, 38, psi.Full.Avg10)
+
+	// The cgroup of pid 1 is in the unified hierarchy too, without pressure files
+	cg, err = fs.CGroup(1)
+	require.NoError(t, err)
+	_, err = cg.Pressure("cpu")
+	assert.Error(t, err)
+
+	load, err := fs.LoadAvg()
+	require.NoError(t, err)
+	assert.Equal(t, LoadAvg{Load1: 0.52, Load5: 0.58, Load15: 0.59, Runnable: 2, Threads: 300}, load)
+}
+
+func TestDiskStats(t *testing.T) {
+	fs := fixtureFS()
+	disks, err := fs.DiskStats()
+	require.NoError(t, err)
+	require.Len(t, disks, 3, "loop0 never did any IO")
+	sda := disks[0]
+	assert.Equal(t, DiskStats{Device: "sda", ReadIOs: 10000, ReadMerges: 500, ReadSectors: 800000, ReadTime: 20000,
+		WriteIOs: 40000, WriteMerges: 2000, WriteSectors: 1600000, WriteTime: 120000,
+		InFlight: 2, IOTime: 90000, QueueTime: 140000}, sda)
+
+	// 10 seconds earlier
+	prev := sda
+	prev.ReadIOs, prev.ReadSectors, prev.ReadTime = 9000, 780000, 19000
+	prev.WriteIOs, prev.WriteSectors, prev.WriteTime = 38000, 1560000, 100000
+	prev.IOTime, prev.QueueTime = 85000, 110000
+	assert.Equal(t, DiskIO{Device: "sda", ReadIOPS: 100, WriteIOPS: 200, ReadKB: 1000, WriteKB: 2000,
+		ReadAwait: 1, WriteAwait: 10, QueueSize: 3, Util: 50}, sda.Rate(prev, 10*time.Second))
+	assert.Equal(t, DiskIO{Device: "sda"}, sda.Rate(prev, 0))
+
+	io, err := fs.ProcIO(42)
+	require.NoError(t, err)
+	assert.Equal(t, &ProcIO{RChar: 104857600, WChar: 52428800, SyscR: 20000, SyscW: 10000,
+		ReadBytes: 41943040, WriteBytes: 20971520}, io)
+	assert.Equal(t, ProcIORate{ReadBytes: 4194304, WriteBytes: 2097152, ReadChars: 10485760, WriteChars: 5242880,
+		ReadSyscalls: 2000, WriteSyscalls: 1000}, io.Rate(&ProcIO{}, 10*time.Second))
+	_, err = fs.ProcIO(1)
+	assert.Error(t, err)
+}
+
+func TestWriteVMStat(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, fixtureFS().WriteVMStat(context.Background(), &out, time.Millisecond, 2))
+	assert.Equal(t, lines(
+		"11:06:40procs -----------memory---------- ---swap-- -----io---- -system-- ------cpu-----",
+		"11:06:40 r  b   swpd   free   buff  cache   si   so    bi    bo   in   cs us sy id wa st",
+		"11:06:40 2  0      0 2000000 100000 2600000    0    0    20    50  500 1000  1  1 98  0  0",
+		"11:06:40 2  0      0 2000000 100000 2600000    0    0     0     0    0    0  0  0  0  0  0",
+	), out.String())
+}
+
+func TestWriteDF(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, fixtureFS().WriteDF(context.Background(), &out))
+	assert.Equal(t, lines(
+		"Filesystem     1K-blocks      Used Available Use% Mounted on",
+		"overlay         61255492   7929508  50184660  14% /",
+		"tmpfs              65536         0     65536   0% /dev",
+		"/dev/sda1           4000      2000      1600  56% /data dir",
+	), out.String())
+}
+
+func TestProcesses(t *testing.T) {
+	processes, err := fixtureFS().Processes()
+	require.NoError(t, err)
+	require.Len(t, processes, 3)
+	p := processes[2]
+	assert.Equal(t, 42, p.Pid)
+	assert.Equal(t, "app", p.User)
+	assert.InDelta(t, 6.4, p.CPU, 0.001)
+	assert.InDelta(t, 0.5, p.Mem, 0.001)
+}
@@ -0,0 +1,123 @@
+package procfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Process is a process of the Processes snapshot.
+type Process struct {
+	*ProcStat
+	User    string
+	Cmdline string
+	// CPU is the percentage of CPU time used over the lifetime of the process,
+	// Mem the percentage of the physical memory resident, like the %CPU and
+	// %MEM of ps.
+	CPU float64
+	Mem float64
+}
+
+// Processes returns the processes running, in ascending pid order. The
+// processes exiting meanwhile are skipped.
+func (fs *FS) Processes() ([]*Process, error) {
+	pids, err := fs.Pids()
+	if err != nil {
+		return nil, err
+	}
+	uptime, err := fs.Uptime()
+	if err != nil {
+		return nil, err
+	}
+	mem, err := fs.Meminfo()
+	if err != nil {
+		return nil, err
+	}
+
+	processes := make([]*Process, 0, len(pids))
+	for _, pid := range pids {
+		stat, err := fs.ProcStat(pid)
+		if err != nil {
+			continue
+		}
+		p := &Process{ProcStat: stat, User: "?", Cmdline: fs.Cmdline(pid, stat.Comm)}
+		if uid, err := fs.UID(pid); err == nil {
+			p.User = fs.userName(uid)
+		}
+		p.CPU = lifetimeCPU(stat, uptime)
+		p.Mem = float64(stat.RSS*fs.pageSize/1024) * 100 / float64(mem["MemTotal"])
+		processes = append(processes, p)
+	}
+	return processes, nil
+}
+
+// lifetimeCPU returns the percentage of CPU time the process or thread used since it started.
+func lifetimeCPU(stat *ProcStat, uptime float64) float64 {
+	elapsed := uptime - float64(stat.StartTime)/ClockTicks
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(stat.CPUTime()) / ClockTicks * 100 / elapsed
+}
+
+const psHeader = "UID        PID  PPID   LWP  C NLWP STIME TTY          TIME CMD\n"
+
+// WritePS writes the threads of all the processes to w, like ps -eLf.
+func (fs *FS) WritePS(ctx context.Context, w io.Writer) error {
+	processes, err := fs.Processes()
+	if err != nil {
+		return err
+	}
+	stat, err := fs.Stat()
+	if err != nil {
+		return err
+	}
+	uptime, err := fs.Uptime()
+	if err != nil {
+		return err
+	}
+	now := fs.now()
+	boot := time.Unix(int64(stat.BootTime), 0)
+
+	if _, err := io.WriteString(w, psHeader); err != nil {
+		return err
+	}
+	for _, p := range processes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		tids, err := fs.Tasks(p.Pid)
+		if err != nil {
+			continue
+		}
+		nlwp := len(tids)
+		start := psStartTime(boot.Add(time.Duration(p.StartTime)*time.Second/ClockTicks), now)
+		for _, tid := range tids {
+			task, err := fs.TaskStat(p.Pid, tid)
+			if err != nil {
+				continue
+			}
+			c := min(int(lifetimeCPU(task, uptime)), 99)
+			_, err = fmt.Fprintf(w, "%-8s %5d %5d %5d %2d %4d %-5s %-8s %8s %s\n",
+				p.User, p.Pid, p.PPid, tid, c, nlwp, start, ttyName(p.TTY), formatDuration(task.CPUTime()), p.Cmdline)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// psStartTime formats the start time like the STIME of ps: the time if the
+// process started today, the date if it started this year, else the year.
+func psStartTime(start, now time.Time) string {
+	start, now = start.Local(), now.Local()
+	switch {
+	case start.YearDay() == now.YearDay() && start.Year() == now.Year():
+		return start.Format("15:04")
+	case start.Year() == now.Year():
+		return start.Format("Jan02")
+	}
+	return start.Format("2006")
+}
@@ -0,0 +1,183 @@
+package procfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// CPUTimes are the clock ticks the CPUs spent in each state, a cpu line of /proc/stat.
+type CPUTimes struct {
+	User, Nice, System, Idle, IOWait, IRQ, SoftIRQ, Steal uint64
+}
+
+// Total returns the clock ticks spent in all the states.
+func (t CPUTimes) Total() uint64 {
+	return t.User + t.Nice + t.System + t.Idle + t.IOWait + t.IRQ + t.SoftIRQ + t.Steal
+}
+
+// Sub returns the clock ticks spent in each state since prev.
+func (t CPUTimes) Sub(prev CPUTimes) CPUTimes {
+	return CPUTimes{
+		User:    sub(t.User, prev.User),
+		Nice:    sub(t.Nice, prev.Nice),
+		System:  sub(t.System, prev.System),
+		Idle:    sub(t.Idle, prev.Idle),
+		IOWait:  sub(t.IOWait, prev.IOWait),
+		IRQ:     sub(t.IRQ, prev.IRQ),
+		SoftIRQ: sub(t.SoftIRQ, prev.SoftIRQ),
+		Steal:   sub(t.Steal, prev.Steal),
+	}
+}
+
+// Stat is the system statistics of /proc/stat.
+type Stat struct {
+	// CPU is the total of all the CPUs, CPUs the number of CPUs.
+	CPU  CPUTimes
+	CPUs int
+	// Interrupts and ContextSwitches are counted since boot.
+	Interrupts      uint64
+	ContextSwitches uint64
+	// BootTime is in seconds since the epoch.
+	BootTime uint64
+	// ProcsRunning are the runnable threads, ProcsBlocked those waiting for IO.
+	ProcsRunning uint64
+	ProcsBlocked uint64
+}
+
+// Stat reads /proc/stat.
+func (fs *FS) Stat() (*Stat, error) {
+	data, err := os.ReadFile(fs.path("stat"))
+	if err != nil {
+		return nil, err
+	}
+	stat := &Stat{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024) // the intr line is long
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch key := fields[0]; {
+		case key == "cpu":
+			stat.CPU = parseCPUTimes(fields[1:])
+		case strings.HasPrefix(key, "cpu"):
+			stat.CPUs++
+		case key == "intr":
+			stat.Interrupts, _ = strconv.ParseUint(fields[1], 10, 64)
+		case key == "ctxt":
+			stat.ContextSwitches, _ = strconv.ParseUint(fields[1], 10, 64)
+		case key == "btime":
+			stat.BootTime, _ = strconv.ParseUint(fields[1], 10, 64)
+		case key == "procs_running":
+			stat.ProcsRunning, _ = strconv.ParseUint(fields[1], 10, 64)
+		case key == "procs_blocked":
+			stat.ProcsBlocked, _ = strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if stat.CPU.Total() == 0 {
+		return nil, fmt.Errorf("no cpu line in %s", fs.path("stat"))
+	}
+	return stat, nil
+}
+
+func parseCPUTimes(fields []string) CPUTimes {
+	v := parseUints(fields)
+	v = append(v, make([]uint64, 8)...)
+	return CPUTimes{
+		User: v[0], Nice: v[1], System: v[2], Idle: v[3],
+		IOWait: v[4], IRQ: v[5], SoftIRQ: v[6], Steal: v[7],
+	}
+}
+
+// Meminfo is the memory statistics of /proc/meminfo, in KiB by name, like MemTotal.
+type Meminfo map[string]uint64
+
+// Meminfo reads /proc/meminfo.
+func (fs *FS) Meminfo() (Meminfo, error) {
+	values, err := fs.readKeyValues("meminfo")
+	if err != nil {
+		return nil, err
+	}
+	if values["MemTotal"] == 0 {
+		return nil, fmt.Errorf("no MemTotal in %s", fs.path("meminfo"))
+	}
+	return values, nil
+}
+
+// BuffCache returns the KiB of the buffers and the page cache, reclaimable slab included.
+func (m Meminfo) BuffCache() uint64 {
+	return m["Buffers"] + m.Cache()
+}
+
+// Cache returns the KiB of the page cache, reclaimable slab included.
+func (m Meminfo) Cache() uint64 {
+	return m["Cached"] + m["SReclaimable"]
+}
+
+// Used returns the KiB of memory used, neither free nor buffers and cache.
+func (m Meminfo) Used() uint64 {
+	unused := m["MemFree"] + m.BuffCache()
+	if unused > m["MemTotal"] {
+		return 0
+	}
+	return m["MemTotal"] - unused
+}
+
+// Available returns the KiB of memory available to start applications without swapping.
+func (m Meminfo) Available() uint64 {
+	if available, ok := m["MemAvailable"]; ok {
+		return available
+	}
+	return m["MemFree"] + m.BuffCache()
+}
+
+// VMStat reads the virtual memory counters of /proc/vmstat, like pswpin.
+func (fs *FS) VMStat() (map[string]uint64, error) {
+	return fs.readKeyValues("vmstat")
+}
+
+// LoadAvg is the load average of /proc/loadavg, over 1, 5 and 15 minutes.
+type LoadAvg struct {
+	Load1  float64 `json:"load1"`
+	Load5  float64 `json:"load5"`
+	Load15 float64 `json:"load15"`
+	// Runnable are the threads runnable now, Threads all the threads.
+	Runnable uint64 `json:"runnable"`
+	Threads  uint64 `json:"threads"`
+}
+
+// LoadAvg reads /proc/loadavg.
+func (fs *FS) LoadAvg() (LoadAvg, error) {
+	data, err := os.ReadFile(fs.path("loadavg"))
+	if err != nil {
+		return LoadAvg{}, err
+	}
+	var avg LoadAvg
+	_, err = fmt.Sscanf(string(data), "%f %f %f %d/%d", &avg.Load1, &avg.Load5, &avg.Load15, &avg.Runnable, &avg.Threads)
+	if err != nil {
+		return LoadAvg{}, fmt.Errorf("invalid %s: %w", fs.path("loadavg"), err)
+	}
+	return avg, nil
+}
+
+// Uptime reads the seconds since boot of /proc/uptime.
+func (fs *FS) Uptime() (float64, error) {
+	data, err := os.ReadFile(fs.path("uptime"))
+	if err != nil {
+		return 0, err
+	}
+	var uptime float64
+	_, err = fmt.Sscan(string(data), &uptime)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", fs.path("uptime"), err)
+	}
+	return uptime, nil
+}
@@ -0,0 +1,17 @@
+//go:build linux
+
+package procfs
+
+import "syscall"
+
+func statfs(path string) (Statfs, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return Statfs{}, err
+	}
+	size := uint64(st.Frsize)
+	if size == 0 {
+		size = uint64(st.Bsize)
+	}
+	return Statfs{BlockSize: size, Blocks: st.Blocks, Free: st.Bfree, Available: st.Bavail}, nil
+}
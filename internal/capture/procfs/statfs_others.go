@@ -0,0 +1,12 @@
+//go:build !linux
+
+package procfs
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func statfs(path string) (Statfs, error) {
+	return Statfs{}, fmt.Errorf("statfs is not supported on %s", runtime.GOOS)
+}
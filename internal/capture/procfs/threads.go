@@ -0,0 +1,144 @@
+package procfs
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ThreadSampler samples the CPU usage of the threads of a process, like
+// top -H, reading /proc/<pid>/task/<tid>/stat.
+type ThreadSampler struct {
+	fs   *FS
+	pid  int
+	prev *topSample
+}
+
+// NewThreadSampler returns a sampler of the threads of the process pid. The
+// CPU usage of its first sample is since now.
+func (fs *FS) NewThreadSampler(pid int) (*ThreadSampler, error) {
+	s := &ThreadSampler{fs: fs, pid: pid}
+	prev, err := s.sample()
+	if err != nil {
+		return nil, err
+	}
+	s.prev = prev
+	return s, nil
+}
+
+func (s *ThreadSampler) sample() (*topSample, error) {
+	stat, err := s.fs.Stat()
+	if err != nil {
+		return nil, err
+	}
+	tids, err := s.fs.Tasks(s.pid)
+	if err != nil {
+		return nil, err
+	}
+	sample := &topSample{time: s.fs.now(), cpu: stat.CPU, procs: make(map[int]uint64, len(tids))}
+	for _, tid := range tids {
+		if t, err := s.fs.TaskStat(s.pid, tid); err == nil {
+			sample.procs[tid] = t.CPUTime()
+		}
+	}
+	return sample, nil
+}
+
+// Thread is a thread of a ThreadSample, its Pid is the tid of the thread and
+// its Comm the name of the thread.
+type Thread struct {
+	*ProcStat
+	// CPU is the percentage of a CPU the thread used since the previous sample.
+	CPU float64
+}
+
+// ThreadSample is the CPU usage of the threads of a process since the previous sample.
+type ThreadSample struct {
+	Pid  int
+	Time time.Time
+	// Elapsed is the time since the previous sample.
+	Elapsed time.Duration
+	// Threads are sorted by CPU usage, the busiest first.
+	Threads []*Thread
+
+	cpu CPUTimes
+}
+
+// Sample returns the CPU usage of the threads since the previous sample. The
+// threads exiting meanwhile are skipped.
+func (s *ThreadSampler) Sample() (*ThreadSample, error) {
+	cur, err := s.sample()
+	if err != nil {
+		return nil, err
+	}
+	sample := &ThreadSample{
+		Pid:     s.pid,
+		Time:    cur.time,
+		Elapsed: cur.time.Sub(s.prev.time),
+		cpu:     cur.cpu.Sub(s.prev.cpu),
+	}
+	for tid := range cur.procs {
+		t, err := s.fs.TaskStat(s.pid, tid)
+		if err != nil {
+			continue
+		}
+		sample.Threads = append(sample.Threads, &Thread{
+			ProcStat: t,
+			CPU:      cpuPercent(cur.procs[tid], s.prev.procs[tid], sample.Elapsed.Seconds()),
+		})
+	}
+	sort.Slice(sample.Threads, func(i, j int) bool {
+		if sample.Threads[i].CPU != sample.Threads[j].CPU {
+			return sample.Threads[i].CPU > sample.Threads[j].CPU
+		}
+		return sample.Threads[i].Pid < sample.Threads[j].Pid
+	})
+	s.prev = cur
+	return sample, nil
+}
+
+// NIDs returns the CPU usage of the threads by nid, the tid in hex like the
+// nid=0x2a of the threads in the jstack thread dumps.
+func (s *ThreadSample) NIDs() map[string]float64 {
+	nids := make(map[string]float64, len(s.Threads))
+	for _, t := range s.Threads {
+		nids[fmt.Sprintf("0x%x", t.Pid)] = t.CPU
+	}
+	return nids
+}
+
+// WriteTopH writes the sample to w like top -bH -n 1 -p <pid>, the threads
+// named after their comm.
+func (fs *FS) WriteTopH(w io.Writer, sample *ThreadSample) error {
+	statm, err := fs.Statm(sample.Pid)
+	if err != nil {
+		return err
+	}
+	mem, err := fs.Meminfo()
+	if err != nil {
+		return err
+	}
+	user := "?"
+	if uid, err := fs.UID(sample.Pid); err == nil {
+		user = fs.userName(uid)
+	}
+
+	states := make([]string, len(sample.Threads))
+	for i, t := range sample.Threads {
+		states[i] = t.State
+	}
+	var b strings.Builder
+	if err := fs.writeTopSummary(&b, sample.Time, "Threads", states, sample.cpu); err != nil {
+		return err
+	}
+	memPercent := float64(statm.Resident*uint64(fs.pageSize)/1024) * 100 / float64(mem["MemTotal"])
+	for _, t := range sample.Threads {
+		fs.writeTopRow(&b, t.ProcStat, user, statm, t.CPU, memPercent, t.Comm)
+	}
+	b.WriteString("\n")
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,213 @@
+package procfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TopFirstDelay is the delay of the first frame of WriteTop, so that its CPU
+// usage is sampled over a short interval, like top does.
+var TopFirstDelay = time.Second
+
+// topSample is the CPU time used up to a time, to compute the CPU usage of the next frame.
+type topSample struct {
+	time  time.Time
+	cpu   CPUTimes
+	procs map[int]uint64
+}
+
+func (fs *FS) topSample() (*topSample, error) {
+	stat, err := fs.Stat()
+	if err != nil {
+		return nil, err
+	}
+	pids, err := fs.Pids()
+	if err != nil {
+		return nil, err
+	}
+	sample := &topSample{time: fs.now(), cpu: stat.CPU, procs: make(map[int]uint64, len(pids))}
+	for _, pid := range pids {
+		if p, err := fs.ProcStat(pid); err == nil {
+			sample.procs[pid] = p.CPUTime()
+		}
+	}
+	return sample, nil
+}
+
+// WriteTop writes frames of the processes to w, delay apart, like
+// top -bc -d <delay> -n <frames>. The frames written so far are kept when ctx
+// is done.
+//
+// The number of users logged in isn't in the proc file system, it's always 0.
+func (fs *FS) WriteTop(ctx context.Context, w io.Writer, delay time.Duration, frames int) error {
+	prev, err := fs.topSample()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < frames; i++ {
+		wait := delay
+		if i == 0 {
+			wait = min(TopFirstDelay, delay)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		prev, err = fs.writeTopFrame(w, prev)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTopFrame writes a frame with the CPU usage since prev, and returns the sample of the frame.
+func (fs *FS) writeTopFrame(w io.Writer, prev *topSample) (*topSample, error) {
+	stat, err := fs.Stat()
+	if err != nil {
+		return nil, err
+	}
+	processes, err := fs.Processes()
+	if err != nil {
+		return nil, err
+	}
+
+	cur := &topSample{time: fs.now(), cpu: stat.CPU, procs: make(map[int]uint64, len(processes))}
+	elapsed := cur.time.Sub(prev.time).Seconds()
+	states := make([]string, len(processes))
+	for i, p := range processes {
+		cur.procs[p.Pid] = p.CPUTime()
+		p.CPU = cpuPercent(p.CPUTime(), prev.procs[p.Pid], elapsed)
+		states[i] = p.State
+	}
+	sort.SliceStable(processes, func(i, j int) bool { return processes[i].CPU > processes[j].CPU })
+
+	var b strings.Builder
+	if err := fs.writeTopSummary(&b, cur.time, "Tasks", states, stat.CPU.Sub(prev.cpu)); err != nil {
+		return nil, err
+	}
+	for _, p := range processes {
+		statm, err := fs.Statm(p.Pid)
+		if err != nil {
+			continue
+		}
+		fs.writeTopRow(&b, p.ProcStat, p.User, statm, p.CPU, p.Mem, p.Cmdline)
+	}
+	b.WriteString("\n")
+
+	_, err = io.WriteString(w, b.String())
+	return cur, err
+}
+
+// cpuPercent returns the percentage of a CPU used over elapsed seconds, the
+// clock ticks used going from prev to cur.
+func cpuPercent(cur, prev uint64, elapsed float64) float64 {
+	if elapsed <= 0 || cur < prev {
+		return 0
+	}
+	return float64(cur-prev) / ClockTicks * 100 / elapsed
+}
+
+// writeTopSummary writes the summary of a frame up to the header of the
+// columns: the uptime and load, the tasks, or threads, by state, the usage of
+// the CPUs and of the memory.
+func (fs *FS) writeTopSummary(b *strings.Builder, now time.Time, tasks string, states []string, cpu CPUTimes) error {
+	mem, err := fs.Meminfo()
+	if err != nil {
+		return err
+	}
+	uptime, err := fs.Uptime()
+	if err != nil {
+		return err
+	}
+	load, err := fs.LoadAvg()
+	if err != nil {
+		return err
+	}
+
+	var running, sleeping, stopped, zombie int
+	for _, state := range states {
+		switch state {
+		case "R":
+			running++
+		case "T", "t":
+			stopped++
+		case "Z":
+			zombie++
+		default:
+			sleeping++
+		}
+	}
+
+	fmt.Fprintf(b, "top - %s up %s, %2d users,  load average: %.2f, %.2f, %.2f\n",
+		now.Format("15:04:05"), formatUptime(uptime), 0, load.Load1, load.Load5, load.Load15)
+	fmt.Fprintf(b, "%s:%4d total,%4d running,%4d sleeping,%4d stopped,%4d zombie\n",
+		tasks, len(states), running, sleeping, stopped, zombie)
+
+	percent := func(ticks uint64) float64 {
+		if cpu.Total() == 0 {
+			return 0
+		}
+		return float64(ticks) * 100 / float64(cpu.Total())
+	}
+	idle := percent(cpu.Idle)
+	if cpu.Total() == 0 {
+		idle = 100
+	}
+	fmt.Fprintf(b, "%%Cpu(s):%5.1f us,%5.1f sy,%5.1f ni,%5.1f id,%5.1f wa,%5.1f hi,%5.1f si,%5.1f st\n",
+		percent(cpu.User), percent(cpu.System), percent(cpu.Nice), idle,
+		percent(cpu.IOWait), percent(cpu.IRQ), percent(cpu.SoftIRQ), percent(cpu.Steal))
+
+	mib := func(kib uint64) float64 { return float64(kib) / 1024 }
+	fmt.Fprintf(b, "MiB Mem :%9.1f total,%9.1f free,%9.1f used,%9.1f buff/cache\n",
+		mib(mem["MemTotal"]), mib(mem["MemFree"]), mib(mem.Used()), mib(mem.BuffCache()))
+	fmt.Fprintf(b, "MiB Swap:%9.1f total,%9.1f free,%9.1f used.%9.1f avail Mem \n\n",
+		mib(mem["SwapTotal"]), mib(mem["SwapFree"]), mib(sub(mem["SwapTotal"], mem["SwapFree"])), mib(mem.Available()))
+
+	b.WriteString("  PID USER      PR  NI    VIRT    RES    SHR S  %CPU  %MEM     TIME+ COMMAND\n")
+	return nil
+}
+
+// writeTopRow writes the row of the process, or thread, stat with the memory usage statm.
+func (fs *FS) writeTopRow(b *strings.Builder, stat *ProcStat, user string, statm Statm, cpu, mem float64, command string) {
+	pr := fmt.Sprint(stat.Priority)
+	if stat.Priority <= -100 {
+		pr = "rt"
+	}
+	kib := func(pages uint64) uint64 { return pages * uint64(fs.pageSize) / 1024 }
+	fmt.Fprintf(b, "%5d %-8s %3s %3d %7d %6d %6d %s %5.1f %5.1f %9s %s\n",
+		stat.Pid, user, pr, stat.Nice, stat.VSize/1024, kib(statm.Resident), kib(statm.Shared),
+		stat.State, cpu, mem, formatTopTime(stat.CPUTime()), command)
+}
+
+// formatUptime formats the seconds since boot like top: 3 days,  2:03 or 45 min.
+func formatUptime(uptime float64) string {
+	minutes := int(uptime) / 60
+	days, hours, minutes := minutes/1440, minutes/60%24, minutes%60
+	var s string
+	switch days {
+	case 0:
+	case 1:
+		s = "1 day, "
+	default:
+		s = fmt.Sprintf("%d days, ", days)
+	}
+	if hours > 0 {
+		return s + fmt.Sprintf("%2d:%02d", hours, minutes)
+	}
+	return s + fmt.Sprintf("%d min", minutes)
+}
+
+// formatTopTime formats the clock ticks like the TIME+ of top, minutes:seconds.hundredths.
+func formatTopTime(ticks uint64) string {
+	hundredths := ticks * 100 / ClockTicks
+	return fmt.Sprintf("%d:%02d.%02d", hundredths/6000, hundredths/100%60, hundredths%100)
+}
@@ -0,0 +1,128 @@
+package procfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const vmstatHeader = "procs -----------memory---------- ---swap-- -----io---- -system-- ------cpu-----\n" +
+	" r  b   swpd   free   buff  cache   si   so    bi    bo   in   cs us sy id wa st\n"
+
+// vmstatSample is the counters since boot a vmstat line is computed from.
+type vmstatSample struct {
+	stat   *Stat
+	vm     map[string]uint64
+	uptime float64
+}
+
+func (fs *FS) vmstatSample() (*vmstatSample, error) {
+	stat, err := fs.Stat()
+	if err != nil {
+		return nil, err
+	}
+	vm, err := fs.VMStat()
+	if err != nil {
+		return nil, err
+	}
+	uptime, err := fs.Uptime()
+	if err != nil {
+		return nil, err
+	}
+	return &vmstatSample{stat: stat, vm: vm, uptime: uptime}, nil
+}
+
+// WriteVMStat writes count lines of the system statistics to w, interval
+// apart, like vmstat <interval> <count> piped through awk by the agent: every
+// line, the headers included, is prefixed by the time. Like vmstat, the first
+// line averages the statistics since boot. The lines written so far are kept
+// when ctx is done.
+func (fs *FS) WriteVMStat(ctx context.Context, w io.Writer, interval time.Duration, count int) error {
+	if err := fs.writeTimestamped(w, vmstatHeader); err != nil {
+		return err
+	}
+	var prev *vmstatSample
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			timer := time.NewTimer(interval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		cur, err := fs.vmstatSample()
+		if err != nil {
+			return err
+		}
+		mem, err := fs.Meminfo()
+		if err != nil {
+			return err
+		}
+		if err := fs.writeTimestamped(w, fs.vmstatLine(prev, cur, mem)); err != nil {
+			return err
+		}
+		prev = cur
+	}
+	return nil
+}
+
+// vmstatLine formats the statistics since prev, since boot if prev is nil.
+func (fs *FS) vmstatLine(prev, cur *vmstatSample, mem Meminfo) string {
+	seconds := cur.uptime
+	cpu := cur.stat.CPU
+	counter := func(name string) uint64 { return cur.vm[name] }
+	intr, ctxt := cur.stat.Interrupts, cur.stat.ContextSwitches
+	if prev != nil {
+		seconds = cur.uptime - prev.uptime
+		cpu = cpu.Sub(prev.stat.CPU)
+		counter = func(name string) uint64 { return sub(cur.vm[name], prev.vm[name]) }
+		intr, ctxt = sub(intr, prev.stat.Interrupts), sub(ctxt, prev.stat.ContextSwitches)
+	}
+	rate := func(v uint64) uint64 {
+		if seconds <= 0 {
+			return 0
+		}
+		return uint64(float64(v)/seconds + 0.5)
+	}
+	percent := func(ticks uint64) uint64 {
+		if cpu.Total() == 0 {
+			return 0
+		}
+		return (100*ticks + cpu.Total()/2) / cpu.Total()
+	}
+	kibPerPage := uint64(fs.pageSize / 1024)
+
+	return fmt.Sprintf("%2d %2d %6d %6d %6d %6d %4d %4d %5d %5d %4d %4d %2d %2d %2d %2d %2d\n",
+		cur.stat.ProcsRunning, cur.stat.ProcsBlocked,
+		sub(mem["SwapTotal"], mem["SwapFree"]), mem["MemFree"], mem["Buffers"], mem.Cache(),
+		rate(counter("pswpin")*kibPerPage), rate(counter("pswpout")*kibPerPage),
+		rate(counter("pgpgin")), rate(counter("pgpgout")),
+		rate(intr), rate(ctxt),
+		percent(cpu.User+cpu.Nice), percent(cpu.System+cpu.IRQ+cpu.SoftIRQ), percent(cpu.Idle),
+		percent(cpu.IOWait), percent(cpu.Steal))
+}
+
+// writeTimestamped writes the lines to w, each prefixed by the time.
+func (fs *FS) writeTimestamped(w io.Writer, lines string) error {
+	now := fs.now().Format("15:04:05")
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(lines, "\n") {
+		if line != "" {
+			b.WriteString(now + line)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func sub(a, b uint64) uint64 {
+	if a < b {
+		return 0
+	}
+	return a - b
+}
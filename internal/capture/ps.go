@@ -1,12 +1,12 @@
 package capture
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
 
 	"yc-agent/internal/capture/executils"
-	"yc-agent/internal/logger"
 )
 
 const psOutputPath = "ps.out"
@@ -23,8 +23,8 @@ func NewPS() *PS {
 
 // Run executes the process status capture and uploads the captured file
 // to the specified endpoint.
-func (p *PS) Run() (Result, error) {
-	capturedFile, err := p.CaptureToFile()
+func (p *PS) Run(ctx context.Context) (Result, error) {
+	capturedFile, err := p.captureToFile(ctx)
 	if err != nil {
 		return Result{Msg: err.Error(), Ok: false}, err
 	}
@@ -37,19 +37,26 @@ func (p *PS) Run() (Result, error) {
 // CaptureToFile captures process status output to a file.
 // It returns the file handle for the captured data.
 func (p *PS) CaptureToFile() (*os.File, error) {
-	file, err := os.Create(psOutputPath)
+	return p.captureToFile(context.Background())
+}
+
+func (p *PS) captureToFile(ctx context.Context) (*os.File, error) {
+	file, err := os.Create(p.OutputPath(psOutputPath))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create output file: %w", err)
 	}
 
-	if err := p.captureOutput(file); err != nil {
+	err = p.captureWithProc(ctx, file, "ps", p.captureOutput, func(w io.Writer) error {
+		return p.captureProc(ctx, w)
+	})
+	if err != nil {
 		file.Close()
 		return nil, err
 	}
 
 	// Ensures all file data is written to disk.
 	if err := file.Sync(); err != nil {
-		logger.Log("warning: failed to sync file: %v", err)
+		p.Log("warning: failed to sync file: %v", err)
 	}
 
 	return file, nil
@@ -75,7 +82,7 @@ func (p *PS) captureOutput(f *os.File) error {
 			return fmt.Errorf("failed to truncate file: %w", err)
 		}
 
-		logger.Log("trying %v, cause %v exit code != 0", executils.PS2, executils.PS)
+		p.Log("trying %v, cause %v exit code != 0", executils.PS2, executils.PS)
 		psCmd = executils.PS2
 
 		if _, err := fmt.Fprintf(f, "\n%s\n", executils.NowString()); err != nil {
@@ -100,9 +107,23 @@ func (p *PS) captureOutput(f *os.File) error {
 	return nil
 }
 
+// captureProc writes the threads read from /proc, as many times as captureOutput runs ps.
+func (p *PS) captureProc(ctx context.Context, w io.Writer) error {
+	iterations := executils.SCRIPT_SPAN / executils.JAVACORE_INTERVAL
+	for i := 1; i < iterations; i++ {
+		if _, err := fmt.Fprintf(w, "\n%s\n", executils.NowString()); err != nil {
+			return fmt.Errorf("failed to write timestamp: %w", err)
+		}
+		if err := procFS().WritePS(ctx, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // UploadCapturedFile uploads the captured file to the configured endpoint.
 func (p *PS) UploadCapturedFile(file *os.File) Result {
-	msg, ok := PostData(p.Endpoint(), "ps", file)
+	msg, ok := p.PostData("ps", file)
 	return Result{
 		Msg: msg,
 		Ok:  ok,
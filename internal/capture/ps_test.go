@@ -6,12 +6,18 @@ import (
 	"testing"
 
 	"yc-agent/internal/capture/executils"
+	"yc-agent/internal/config"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestPS_CaptureToFile(t *testing.T) {
+	// The commands are tested, not the /proc collector they fall back to
+	oldMode := config.GlobalConfig.ProcCollectors
+	config.GlobalConfig.ProcCollectors = ProcCollectorsOff
+	defer func() { config.GlobalConfig.ProcCollectors = oldMode }()
+
 	// Create temporary directory for test execution
 	tmpDir, err := os.MkdirTemp("", "ps-capture-test-*")
 	require.NoError(t, err, "Failed to create temp directory")
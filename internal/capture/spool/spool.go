@@ -0,0 +1,360 @@
+// Package spool persists uploads that failed to reach their destination
+// and retries them in the background with exponential backoff and jitter.
+//
+// Every queued upload is stored as two files in the spool directory:
+// <id>.data holds the exact bytes that should have been sent, and <id>.json
+// holds the Entry describing where to send them. The json file is written
+// last, so a half-written entry is never picked up, and the queue survives
+// agent restarts.
+package spool
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"yc-agent/internal/logger"
+)
+
+const (
+	DefaultMaxAttempts  = 20
+	DefaultBaseDelay    = 10 * time.Second
+	DefaultMaxDelay     = 30 * time.Minute
+	DefaultPollInterval = 5 * time.Second
+
+	dataExt  = ".data"
+	entryExt = ".json"
+)
+
+// Entry describes a queued upload.
+type Entry struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// ContentEncoding is the Content-Encoding of the spooled data, if it's compressed.
+	ContentEncoding string `json:"contentEncoding,omitempty"`
+	// Chunked is set when the data is resent in parts, resuming a chunked upload.
+	Chunked     *Chunked  `json:"chunked,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"nextAttempt"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+// Chunked describes how the data of an entry is resent in parts.
+type Chunked struct {
+	PartSize int64 `json:"partSize"`
+	// Compression compresses every part on its own, empty for none.
+	Compression string `json:"compression,omitempty"`
+}
+
+// Sender re-sends a queued upload. body is positioned at the start of the spooled data.
+// retry reports whether a failed attempt is worth retrying later.
+type Sender func(e *Entry, body *os.File) (retry bool, err error)
+
+// Spool is a directory backed queue of failed uploads.
+type Spool struct {
+	Dir          string
+	MaxAttempts  int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	PollInterval time.Duration
+
+	send    Sender
+	flushMu sync.Mutex
+	entryMu sync.Mutex
+}
+
+// New opens (or creates) the spool in dir. Entries left over from a previous run
+// are picked up by the next Flush.
+func New(dir string, send Sender) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create spool dir %s: %w", dir, err)
+	}
+
+	return &Spool{
+		Dir:          dir,
+		MaxAttempts:  DefaultMaxAttempts,
+		BaseDelay:    DefaultBaseDelay,
+		MaxDelay:     DefaultMaxDelay,
+		PollInterval: DefaultPollInterval,
+		send:         send,
+	}, nil
+}
+
+// Enqueue copies body into the spool and schedules the first retry.
+// contentEncoding is the Content-Encoding of body, empty if it's not compressed.
+func (s *Spool) Enqueue(url, contentEncoding string, body io.Reader) (*Entry, error) {
+	return s.enqueue(&Entry{URL: url, ContentEncoding: contentEncoding}, func(dataPath string) error {
+		return copyTo(dataPath, body)
+	})
+}
+
+// EnqueueFile queues the file at path, hardlinked into the spool so that a large
+// file, like a heap dump, doesn't take twice its size on disk. It's copied when it
+// can't be linked, like across file systems. contentEncoding is as for Enqueue.
+func (s *Spool) EnqueueFile(url, contentEncoding, path string) (*Entry, error) {
+	return s.enqueue(&Entry{URL: url, ContentEncoding: contentEncoding}, linkTo(path))
+}
+
+// EnqueueChunkedFile queues the file at path as EnqueueFile, to be resent in parts
+// as described by chunked.
+func (s *Spool) EnqueueChunkedFile(url string, chunked Chunked, path string) (*Entry, error) {
+	return s.enqueue(&Entry{URL: url, Chunked: &chunked}, linkTo(path))
+}
+
+// enqueue writes the data of the new entry e with write and schedules its first retry.
+func (s *Spool) enqueue(e *Entry, write func(dataPath string) error) (*Entry, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := write(s.dataPath(id)); err != nil {
+		_ = os.Remove(s.dataPath(id))
+		return nil, fmt.Errorf("failed to write spool data file: %w", err)
+	}
+
+	now := time.Now()
+	e.ID = id
+	e.CreatedAt = now
+	e.NextAttempt = now.Add(Backoff(0, s.BaseDelay, s.MaxDelay))
+	if err := s.save(e); err != nil {
+		_ = os.Remove(s.dataPath(id))
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// linkTo returns a writer of the data file which hardlinks the file at path,
+// or copies it when it can't be linked.
+func linkTo(path string) func(dataPath string) error {
+	return func(dataPath string) error {
+		if err := os.Link(path, dataPath); err == nil {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return copyTo(dataPath, f)
+	}
+}
+
+// copyTo writes body to the new file path, synced to disk.
+func copyTo(path string, body io.Reader) error {
+	data, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(data, body)
+	if err == nil {
+		err = data.Sync()
+	}
+	if e := data.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// Pending returns all queued entries, oldest first.
+func (s *Spool) Pending() ([]*Entry, error) {
+	names, err := filepath.Glob(filepath.Join(s.Dir, "*"+entryExt))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*Entry, 0, len(names))
+	for _, name := range names {
+		e, err := load(name)
+		if err != nil {
+			logger.Warn().Err(err).Str("file", name).Msg("spool: skipped unreadable entry")
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+
+	return entries, nil
+}
+
+// Len returns the number of queued entries.
+func (s *Spool) Len() int {
+	names, err := filepath.Glob(filepath.Join(s.Dir, "*"+entryExt))
+	if err != nil {
+		return 0
+	}
+	return len(names)
+}
+
+// Flush tries to send every entry whose retry time has come.
+func (s *Spool) Flush() {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	entries, err := s.Pending()
+	if err != nil {
+		logger.Warn().Err(err).Msg("spool: failed to list entries")
+		return
+	}
+
+	now := time.Now()
+	for _, e := range entries {
+		if e.NextAttempt.After(now) {
+			continue
+		}
+		s.attempt(e)
+	}
+}
+
+// Run flushes the spool every PollInterval until stop is closed.
+func (s *Spool) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.Flush()
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Spool) attempt(e *Entry) {
+	body, err := os.Open(s.dataPath(e.ID))
+	if err != nil {
+		logger.Log("spool: dropped %s, data file is unreadable: %s", e.ID, err)
+		s.remove(e)
+		return
+	}
+
+	retry, err := s.send(e, body)
+	_ = body.Close()
+
+	if err == nil {
+		logger.Log("spool: transmitted %s on retry %d: %s", e.ID, e.Attempts+1, e.URL)
+		s.remove(e)
+		return
+	}
+
+	e.Attempts++
+	e.LastError = err.Error()
+
+	if !retry || e.Attempts >= s.MaxAttempts {
+		logger.Log("spool: dropped %s after %d retries: %s", e.ID, e.Attempts, e.LastError)
+		s.remove(e)
+		return
+	}
+
+	e.NextAttempt = time.Now().Add(Backoff(e.Attempts, s.BaseDelay, s.MaxDelay))
+	logger.Log("spool: retry %d of %s failed, next retry at %s: %s",
+		e.Attempts, e.ID, e.NextAttempt.Format(time.RFC3339), e.LastError)
+
+	if err := s.save(e); err != nil {
+		logger.Warn().Err(err).Str("id", e.ID).Msg("spool: failed to update entry")
+	}
+}
+
+// Backoff returns the delay before the next retry: base doubled per attempt,
+// capped at max, with half of it randomized to spread out retries of many agents.
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(half)))
+	if err != nil {
+		return d
+	}
+	return half + time.Duration(n.Int64())
+}
+
+func (s *Spool) save(e *Entry) error {
+	s.entryMu.Lock()
+	defer s.entryMu.Unlock()
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.entryPath(e.ID) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return fmt.Errorf("failed to write spool entry: %w", err)
+	}
+	return os.Rename(tmp, s.entryPath(e.ID))
+}
+
+func (s *Spool) remove(e *Entry) {
+	s.entryMu.Lock()
+	defer s.entryMu.Unlock()
+
+	for _, name := range []string{s.entryPath(e.ID), s.dataPath(e.ID)} {
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Warn().Err(err).Str("file", name).Msg("spool: failed to remove file")
+		}
+	}
+}
+
+// DataPath returns the path of the spooled data of e.
+func (s *Spool) DataPath(e *Entry) string {
+	return s.dataPath(e.ID)
+}
+
+func (s *Spool) dataPath(id string) string {
+	return filepath.Join(s.Dir, id+dataExt)
+}
+
+func (s *Spool) entryPath(id string) string {
+	return filepath.Join(s.Dir, id+entryExt)
+}
+
+func load(name string) (*Entry, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Entry{}
+	if err := json.Unmarshal(b, e); err != nil {
+		return nil, err
+	}
+	if e.ID == "" {
+		e.ID = strings.TrimSuffix(filepath.Base(name), entryExt)
+	}
+	return e, nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + hex.EncodeToString(b), nil
+}
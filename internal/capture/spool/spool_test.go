@@ -0,0 +1,178 @@
+package spool
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpool_FlushSendsDueEntries(t *testing.T) {
+	var sent []string
+	s, err := New(t.TempDir(), func(e *Entry, body *os.File) (bool, error) {
+		b, err := io.ReadAll(body)
+		require.NoError(t, err)
+		sent = append(sent, e.URL+" "+string(b))
+		return false, nil
+	})
+	require.NoError(t, err)
+	s.BaseDelay = 0
+
+	_, err = s.Enqueue("http://localhost/ycrash-receiver?dt=gc", "", strings.NewReader("gc data"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, s.Len())
+
+	s.Flush()
+
+	assert.Equal(t, []string{"http://localhost/ycrash-receiver?dt=gc gc data"}, sent)
+	assert.Equal(t, 0, s.Len())
+
+	files, err := os.ReadDir(s.Dir)
+	require.NoError(t, err)
+	assert.Empty(t, files, "data and entry files should be removed after a successful retry")
+}
+
+func TestSpool_FailedRetryIsRescheduled(t *testing.T) {
+	s, err := New(t.TempDir(), func(e *Entry, body *os.File) (bool, error) {
+		return true, errors.New("connection refused")
+	})
+	require.NoError(t, err)
+	s.BaseDelay = 0
+
+	_, err = s.Enqueue("http://localhost/ycrash-receiver?dt=top", "", strings.NewReader("top data"))
+	require.NoError(t, err)
+
+	s.BaseDelay = time.Hour
+	s.MaxDelay = time.Hour
+	s.Flush()
+
+	entries, err := s.Pending()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, 1, entries[0].Attempts)
+	assert.Equal(t, "connection refused", entries[0].LastError)
+	assert.True(t, entries[0].NextAttempt.After(time.Now().Add(29*time.Minute)), "next retry should be backed off")
+
+	// Not due yet, so another flush must not retry it.
+	s.Flush()
+	entries, err = s.Pending()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, 1, entries[0].Attempts)
+}
+
+func TestSpool_DropsEntries(t *testing.T) {
+	t.Run("not retriable", func(t *testing.T) {
+		s, err := New(t.TempDir(), func(e *Entry, body *os.File) (bool, error) {
+			return false, errors.New("status code 401")
+		})
+		require.NoError(t, err)
+		s.BaseDelay = 0
+
+		_, err = s.Enqueue("http://localhost/ycrash-receiver?dt=ps", "", strings.NewReader("ps data"))
+		require.NoError(t, err)
+
+		s.Flush()
+		assert.Equal(t, 0, s.Len())
+	})
+
+	t.Run("max attempts", func(t *testing.T) {
+		attempts := 0
+		s, err := New(t.TempDir(), func(e *Entry, body *os.File) (bool, error) {
+			attempts++
+			return true, errors.New("status code 503")
+		})
+		require.NoError(t, err)
+		s.BaseDelay = 0
+		s.MaxDelay = 0
+		s.MaxAttempts = 3
+
+		_, err = s.Enqueue("http://localhost/ycrash-receiver?dt=ps", "", strings.NewReader("ps data"))
+		require.NoError(t, err)
+
+		for i := 0; i < 5; i++ {
+			s.Flush()
+		}
+		assert.Equal(t, 3, attempts)
+		assert.Equal(t, 0, s.Len())
+	})
+}
+
+func TestSpool_PicksUpEntriesAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := New(dir, func(e *Entry, body *os.File) (bool, error) {
+		return true, errors.New("unreachable")
+	})
+	require.NoError(t, err)
+	s.BaseDelay = 0
+	_, err = s.Enqueue("http://localhost/m3-receiver?dt=td", "", strings.NewReader("td data"))
+	require.NoError(t, err)
+
+	var sent string
+	restarted, err := New(dir, func(e *Entry, body *os.File) (bool, error) {
+		b, err := io.ReadAll(body)
+		require.NoError(t, err)
+		sent = string(b)
+		return false, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, restarted.Len())
+
+	restarted.Flush()
+	assert.Equal(t, "td data", sent)
+	assert.Equal(t, 0, restarted.Len())
+}
+
+func TestBackoff(t *testing.T) {
+	base := 10 * time.Second
+	max := 5 * time.Minute
+
+	for attempt := 0; attempt < 10; attempt++ {
+		want := base << attempt
+		if want > max {
+			want = max
+		}
+		for i := 0; i < 20; i++ {
+			d := Backoff(attempt, base, max)
+			assert.GreaterOrEqual(t, d, want/2, "attempt %d", attempt)
+			assert.LessOrEqual(t, d, want, "attempt %d", attempt)
+		}
+	}
+}
+
+func TestSpool_EnqueueFileLinks(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/heap.hprof"
+	require.NoError(t, os.WriteFile(path, []byte("heap dump"), 0600))
+
+	var sent string
+	s, err := New(dir+"/spool", func(e *Entry, body *os.File) (bool, error) {
+		b, err := io.ReadAll(body)
+		require.NoError(t, err)
+		sent = string(b)
+		return false, nil
+	})
+	require.NoError(t, err)
+	s.BaseDelay = 0
+
+	e, err := s.EnqueueFile("http://localhost/yc-receiver-heap?dt=hd", "", path)
+	require.NoError(t, err)
+
+	orig, err := os.Stat(path)
+	require.NoError(t, err)
+	spooled, err := os.Stat(s.dataPath(e.ID))
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(orig, spooled), "the file should be hardlinked, not copied")
+
+	// The spooled data outlives the capture dir
+	require.NoError(t, os.Remove(path))
+	s.Flush()
+	assert.Equal(t, "heap dump", sent)
+	assert.Equal(t, 0, s.Len())
+}
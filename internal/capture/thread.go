@@ -1,13 +1,17 @@
 package capture
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"yc-agent/internal/capture/executils"
-	"yc-agent/internal/logger"
 )
 
 const tdOut = "threaddump.out"
@@ -25,32 +29,34 @@ type ThreadDump struct {
 
 // Run executes the thread dump capture and uploads the captured file
 // to the specified endpoint.
-func (t *ThreadDump) Run() (Result, error) {
-	capturedFile, err := t.CaptureToFile()
+func (t *ThreadDump) Run(ctx context.Context) (Result, error) {
+	capturedFile, err := t.CaptureToFile(ctx)
 	if err != nil {
 		return Result{Msg: err.Error(), Ok: false}, err
 	}
 	defer capturedFile.Close()
 
 	result := t.UploadCapturedFile(capturedFile)
+	t.uploadThreadCPU()
 	return result, nil
 }
 
 // CaptureToFile attempts to obtain a thread dump either by copying an existing file
 // or by capturing from a running process. It returns the file containing the thread dump.
-func (t *ThreadDump) CaptureToFile() (*os.File, error) {
+func (t *ThreadDump) CaptureToFile(ctx context.Context) (*os.File, error) {
 	// Try copying existing thread dump file if path is provided
 	if t.TdPath != "" {
 		file, err := t.copyThreadDumpFile()
 		if err == nil {
+			t.SetMethod("td file")
 			return file, nil
 		}
-		logger.Log("failed to copy thread dump from %q: %v", t.TdPath, err)
+		t.Log("failed to copy thread dump from %q: %v", t.TdPath, err)
 	}
 
 	// Fall back to capturing from process if valid PID is provided
 	if t.Pid > 0 {
-		return t.captureFromProcess()
+		return t.captureFromProcess(ctx)
 	}
 
 	return nil, fmt.Errorf("no valid thread dump source: requires either TdPath or valid Pid")
@@ -58,10 +64,71 @@ func (t *ThreadDump) CaptureToFile() (*os.File, error) {
 
 // UploadCapturedFile uploads the thread dump file to the configured endpoint.
 func (t *ThreadDump) UploadCapturedFile(file *os.File) Result {
-	msg, ok := PostData(t.Endpoint(), "td", file)
+	msg, ok := t.PostData("td", file)
 	return Result{Msg: msg, Ok: ok}
 }
 
+// ThreadCPU is the CPU usage of the threads sampled with the thread dump N, by nid.
+type ThreadCPU struct {
+	N       int
+	Threads map[string]float64
+}
+
+// uploadThreadCPU merges the CPU usage of the threads sampled with the thread
+// dumps into "threadcpu.json", in the order of the dumps, and uploads it for
+// the server to join it with the threads of the dumps by nid.
+func (t *ThreadDump) uploadThreadCPU() {
+	file, err := t.mergeThreadCPU()
+	if err != nil {
+		t.Log("failed to merge the CPU usage of the threads: %v", err)
+		return
+	}
+	if file == nil {
+		return
+	}
+	defer file.Close()
+
+	msg, ok := t.PostData("threadcpu", file)
+	if !ok {
+		t.Log("failed to upload the CPU usage of the threads: %s", msg)
+	}
+}
+
+// mergeThreadCPU merges the "threadcpu.<N>.json" files written by TopH, nil if there are none.
+func (t *ThreadDump) mergeThreadCPU() (*os.File, error) {
+	paths, err := filepath.Glob(t.OutputPath(strings.ReplaceAll(threadCPUOutputPath, "%d", "*")))
+	if err != nil || len(paths) == 0 {
+		return nil, err
+	}
+
+	var merged []ThreadCPU
+	for _, path := range paths {
+		var cpu ThreadCPU
+		if _, err := fmt.Sscanf(filepath.Base(path), threadCPUOutputPath, &cpu.N); err != nil {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &cpu.Threads); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", path, err)
+		}
+		merged = append(merged, cpu)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].N < merged[j].N })
+
+	file, err := os.Create(t.OutputPath(threadCPUMergedOutputPath))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.NewEncoder(file).Encode(merged); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
 // copyThreadDumpFile copies an existing thread dump file to the output location.
 func (t *ThreadDump) copyThreadDumpFile() (*os.File, error) {
 	srcFile, err := os.Open(t.TdPath)
@@ -70,7 +137,7 @@ func (t *ThreadDump) copyThreadDumpFile() (*os.File, error) {
 	}
 	defer srcFile.Close()
 
-	dstFile, err := os.Create(tdOut)
+	dstFile, err := os.Create(t.OutputPath(tdOut))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create destination file %q: %w", tdOut, err)
 	}
@@ -89,12 +156,12 @@ func (t *ThreadDump) copyThreadDumpFile() (*os.File, error) {
 }
 
 // captureFromProcess captures a thread dump from a running Java process.
-func (t *ThreadDump) captureFromProcess() (*os.File, error) {
+func (t *ThreadDump) captureFromProcess(ctx context.Context) (*os.File, error) {
 	if !IsProcessExists(t.Pid) {
 		return nil, fmt.Errorf("process %d does not exist", t.Pid)
 	}
 
-	logger.Log("Collecting thread dump using JStack...")
+	t.Log("Collecting thread dump using JStack...")
 
 	var jstack *JStack
 	if t.TdCaptureDuration != 0 {
@@ -102,23 +169,26 @@ func (t *ThreadDump) captureFromProcess() (*os.File, error) {
 	} else {
 		jstack = NewJStack(t.JavaHome, t.Pid)
 	}
+	jstack.SetDir(t.Dir())
+	jstack.SetLogger(t.Logger())
 
-	if _, err := jstack.Run(); err != nil {
-		logger.Log("jstack error: %v", err)
+	if _, err := jstack.Run(ctx); err != nil {
+		t.Log("jstack error: %v", err)
 	} else {
-		logger.Log("Collected thread dump...")
+		t.Log("Collected thread dump...")
 	}
+	t.SetMethod(jstack.method)
 
-	if err := executils.CommandRun(executils.AppendJavaCoreFiles); err != nil {
+	if err := executils.CommandRun(executils.AppendJavaCoreFiles, executils.DirHooker(t.Dir())); err != nil {
 		return nil, err
 	}
 
 	// In order to be valid, it should run after TopH
 	// TODO(Andy): This order dependency with TopH is hidden;
 	// it's not a good design, we should refactor this later.
-	if err := executils.CommandRun(executils.AppendTopHFiles); err != nil {
+	if err := executils.CommandRun(executils.AppendTopHFiles, executils.DirHooker(t.Dir())); err != nil {
 		return nil, err
 	}
 
-	return os.Open(tdOut)
+	return os.Open(t.OutputPath(tdOut))
 }
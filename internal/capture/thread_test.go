@@ -1,6 +1,7 @@
 package capture
 
 import (
+	"context"
 	"testing"
 
 	"yc-agent/internal/capture/executils"
@@ -17,7 +18,7 @@ func TestThread(t *testing.T) {
 			Pid: noGC.GetPid(),
 		}
 		td.SetEndpoint(endpoint)
-		result, err := td.Run()
+		result, err := td.Run(context.Background())
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -31,7 +32,7 @@ func TestThread(t *testing.T) {
 			TdPath: "threaddump-usr.out",
 		}
 		td.SetEndpoint(endpoint)
-		result, err := td.Run()
+		result, err := td.Run(context.Background())
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -45,7 +46,7 @@ func TestThread(t *testing.T) {
 			TdPath: "threaddump-non.out",
 		}
 		td.SetEndpoint(endpoint)
-		result, err := td.Run()
+		result, err := td.Run(context.Background())
 		if err != nil {
 			t.Fatal(err)
 		}
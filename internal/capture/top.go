@@ -1,16 +1,28 @@
 package capture
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"strconv"
+	"time"
 
 	"yc-agent/internal/capture/executils"
-	"yc-agent/internal/logger"
+	"yc-agent/internal/capture/procfs"
 )
 
-const topOutputPath = "top.out"
+const (
+	topOutputPath = "top.out"
+	// The delay between the frames of top, and their number, like executils.Top.
+	topDelay  = 10 * time.Second
+	topFrames = 3
+	// The CPU usage of the threads by nid sampled by TopH, and all of them
+	// merged for the upload.
+	threadCPUOutputPath       = "threadcpu.%d.json"
+	threadCPUMergedOutputPath = "threadcpu.json"
+)
 
 // Top captures system "top" data.
 type Top struct {
@@ -19,13 +31,13 @@ type Top struct {
 
 // Run implements the capture by creating the output file, capturing output,
 // and then uploading the captured file.
-func (t *Top) Run() (Result, error) {
+func (t *Top) Run(ctx context.Context) (Result, error) {
 	// If the primary top command isn’t configured, skip capturing.
 	if len(executils.Top) == 0 {
 		return Result{Msg: "skipped capturing Top", Ok: false}, nil
 	}
 
-	capturedFile, err := t.CaptureToFile()
+	capturedFile, err := t.captureToFile(ctx)
 	if err != nil {
 		return Result{Msg: err.Error(), Ok: false}, err
 	}
@@ -36,40 +48,53 @@ func (t *Top) Run() (Result, error) {
 
 // CaptureToFile captures the ps to a file and returns it
 func (t *Top) CaptureToFile() (*os.File, error) {
-	file, err := os.Create(topOutputPath)
+	return t.captureToFile(context.Background())
+}
+
+func (t *Top) captureToFile(ctx context.Context) (*os.File, error) {
+	// Killed once the other captures are done, keeping what was sampled so far
+	ctx, cancel := t.killable(ctx)
+	defer cancel()
+
+	file, err := os.Create(t.OutputPath(topOutputPath))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create output file: %w", err)
 	}
 
-	if err := t.captureOutput(file); err != nil {
+	err = t.captureWithProc(ctx, file, "top", func(f *os.File) error {
+		return t.captureOutput(ctx, f)
+	}, func(w io.Writer) error {
+		return procFS().WriteTop(ctx, w, topDelay, topFrames)
+	})
+	if err != nil {
 		file.Close()
 		return nil, err
 	}
 
 	if err := file.Sync(); err != nil {
-		logger.Log("failed to sync file: %v", err)
+		t.Log("failed to sync file: %v", err)
 	}
 
 	return file, nil
 }
 
 // captureOutput executes the primary top command. If that fails and a fallback
-// command is available, it resets the file and retries.
-func (t *Top) captureOutput(f *os.File) error {
+// command is available, it resets the file and retries, unless ctx is done.
+func (t *Top) captureOutput(ctx context.Context, f *os.File) error {
 	// Try the primary top command.
 	var err error
-	t.Cmd, err = executils.CommandStartInBackgroundToWriter(f, executils.Top)
+	t.Cmd, err = executils.CommandStartInBackgroundToWriter(f, executils.Top, executils.ContextHooker{Ctx: ctx})
 	if err != nil {
-		logger.Log("primary top command failed with err: %s", err.Error())
+		t.Log("primary top command failed with err: %s", err.Error())
 	} else {
 		err = t.Cmd.Wait()
 		if err != nil {
-			logger.Log("primary top failed during wait cmd: %s", err.Error())
+			t.Log("primary top failed during wait cmd: %s", err.Error())
 		}
 	}
 
 	// If a fallback exists, try it.
-	if (err != nil || t.Cmd.ExitCode() != 0) && executils.Top2 != nil && len(executils.Top2) > 0 {
+	if (err != nil || t.Cmd.ExitCode() != 0) && ctx.Err() == nil && executils.Top2 != nil && len(executils.Top2) > 0 {
 		// Reset file before retrying.
 		if _, err := f.Seek(0, io.SeekStart); err != nil {
 			return err
@@ -78,8 +103,8 @@ func (t *Top) captureOutput(f *os.File) error {
 			return err
 		}
 
-		logger.Log("primary top command failed, trying fallback: %v", executils.Top2)
-		t.Cmd, err = executils.CommandStartInBackgroundToWriter(f, executils.Top2)
+		t.Log("primary top command failed, trying fallback: %v", executils.Top2)
+		t.Cmd, err = executils.CommandStartInBackgroundToWriter(f, executils.Top2, executils.ContextHooker{Ctx: ctx})
 		if err != nil {
 			return err
 		}
@@ -97,7 +122,7 @@ func (t *Top) captureOutput(f *os.File) error {
 
 // UploadCapturedFile sends the file data to the endpoint using the service key "top".
 func (t *Top) UploadCapturedFile(file *os.File) Result {
-	msg, ok := PostData(t.Endpoint(), "top", file)
+	msg, ok := t.PostData("top", file)
 	return Result{Msg: msg, Ok: ok}
 }
 
@@ -106,12 +131,16 @@ type TopH struct {
 	Capture
 	Pid int
 	N   int // used to distinguish output files (e.g. topdashH.1.out, topdashH.2.out, …)
+	// Sampler samples the CPU usage of the threads from /proc, since the
+	// previous TopH of the thread dumps. If nil, a sampler is created and the
+	// usage is sampled over procfs.TopFirstDelay, like top -H does.
+	Sampler *procfs.ThreadSampler
 }
 
 // Run captures the "top -H "output (with fallback if needed)
 // and then returns a Result.
 // (Note that unlike Top, TopH does not upload the captured file.)
-func (t *TopH) Run() (Result, error) {
+func (t *TopH) Run(ctx context.Context) (Result, error) {
 	// If the primary topH command isn’t configured, skip capturing.
 	if len(executils.TopH) == 0 {
 		return Result{Msg: "skipped capturing TopH", Ok: false}, nil
@@ -122,9 +151,9 @@ func (t *TopH) Run() (Result, error) {
 		return Result{}, fmt.Errorf("process %d does not exist", t.Pid)
 	}
 
-	logger.Log("Collection of top dash H data started for PID %d.", t.Pid)
+	t.Log("Collection of top dash H data started for PID %d.", t.Pid)
 
-	capturedFile, err := t.CaptureToFile()
+	capturedFile, err := t.captureToFile(ctx)
 	if err != nil {
 		return Result{Msg: err.Error(), Ok: false}, err
 	}
@@ -138,23 +167,79 @@ func (t *TopH) Run() (Result, error) {
 // CaptureToFile creates an output file named "topdashH.<N>.out", writes the
 // command output into it (with fallback if needed), syncs the file and returns it.
 func (t *TopH) CaptureToFile() (*os.File, error) {
+	return t.captureToFile(context.Background())
+}
+
+// captureToFile is CaptureToFile. Unless the /proc collectors are off, it also
+// writes the CPU usage of the threads by nid to "threadcpu.<N>.json", whether
+// the command or the sampler writes "topdashH.<N>.out".
+func (t *TopH) captureToFile(ctx context.Context) (*os.File, error) {
 	fileName := fmt.Sprintf("topdashH.%d.out", t.N)
-	file, err := os.Create(fileName)
+	file, err := os.Create(t.OutputPath(fileName))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create output file: %w", err)
 	}
 
-	if err := t.captureOutput(file); err != nil {
+	var sample *procfs.ThreadSample
+	if procCollectorsMode() != ProcCollectorsOff {
+		sample, err = t.sampleThreads(ctx)
+		if err == nil {
+			err = t.writeThreadCPU(sample)
+		}
+		if err != nil {
+			t.Log("failed to sample the threads of %d: %s", t.Pid, err.Error())
+		}
+	}
+
+	err = t.captureWithProc(ctx, file, "top -H", t.captureOutput, func(w io.Writer) error {
+		if sample == nil {
+			var err error
+			if sample, err = t.sampleThreads(ctx); err != nil {
+				return err
+			}
+		}
+		return procFS().WriteTopH(w, sample)
+	})
+	if err != nil {
 		file.Close()
 		return nil, err
 	}
 
 	if err := file.Sync(); err != nil {
-		logger.Log("failed to sync file: %v", err)
+		t.Log("failed to sync file: %v", err)
 	}
 	return file, nil
 }
 
+// sampleThreads samples the CPU usage of the threads with the Sampler,
+// creating it if there is none yet.
+func (t *TopH) sampleThreads(ctx context.Context) (*procfs.ThreadSample, error) {
+	if t.Sampler == nil {
+		sampler, err := procFS().NewThreadSampler(t.Pid)
+		if err != nil {
+			return nil, err
+		}
+		timer := time.NewTimer(procfs.TopFirstDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		t.Sampler = sampler
+	}
+	return t.Sampler.Sample()
+}
+
+// writeThreadCPU writes the CPU usage of the threads of the sample by nid to "threadcpu.<N>.json".
+func (t *TopH) writeThreadCPU(sample *procfs.ThreadSample) error {
+	data, err := json.Marshal(sample.NIDs())
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(t.OutputPath(fmt.Sprintf(threadCPUOutputPath, t.N)), data, 0644)
+}
+
 // captureOutput builds and executes the primary topH command (adding the dynamic
 // PID argument). If that fails and a fallback command is available, it resets the file and retries.
 func (t *TopH) captureOutput(f *os.File) error {
@@ -171,7 +256,7 @@ func (t *TopH) captureOutput(f *os.File) error {
 
 	err = t.Cmd.Wait()
 	if err != nil {
-		logger.Log("failed to wait cmd: %s", err.Error())
+		t.Log("failed to wait cmd: %s", err.Error())
 	}
 
 	// If a fallback exists, try it.
@@ -184,7 +269,7 @@ func (t *TopH) captureOutput(f *os.File) error {
 			return err
 		}
 
-		logger.Log("primary top dash H command failed, trying fallback: %v", executils.TopH2)
+		t.Log("primary top dash H command failed, trying fallback: %v", executils.TopH2)
 
 		// Append the PID to the fallback command.
 		fallbackCmd := executils.Append(executils.TopH2, strconv.Itoa(t.Pid))
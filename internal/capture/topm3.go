@@ -1,13 +1,13 @@
 package capture
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"time"
 
 	"yc-agent/internal/capture/executils"
-	"yc-agent/internal/logger"
 )
 
 const top4m3OutputPath = "top4m3.out"
@@ -23,7 +23,7 @@ type Top4M3 struct {
 // Run is the main entry point for capturing Top4M3 data.
 // It creates an output file, writes three rounds of data with delays,
 // and then uploads the captured file.
-func (t *Top4M3) Run() (Result, error) {
+func (t *Top4M3) Run(ctx context.Context) (Result, error) {
 	// If the command is not available, skip capturing.
 	if len(executils.Top4M3) < 1 {
 		return Result{
@@ -32,27 +32,27 @@ func (t *Top4M3) Run() (Result, error) {
 		}, nil
 	}
 
-	capturedFile, err := t.captureToFile()
+	capturedFile, err := t.captureToFile(ctx)
 	if err != nil {
 		return Result{Msg: err.Error(), Ok: false}, err
 	}
 	defer capturedFile.Close()
 
 	if err := capturedFile.Sync(); err != nil {
-		logger.Log("warning: failed to sync file: %v", err)
+		t.Log("warning: failed to sync file: %v", err)
 	}
 
 	return t.UploadCapturedFile(capturedFile), nil
 }
 
 // captureToFile creates the output file and writes the captured data to it.
-func (t *Top4M3) captureToFile() (*os.File, error) {
-	file, err := os.Create(top4m3OutputPath)
+func (t *Top4M3) captureToFile(ctx context.Context) (*os.File, error) {
+	file, err := os.Create(t.OutputPath(top4m3OutputPath))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create output file: %w", err)
 	}
 
-	if err := t.captureOutput(file); err != nil {
+	if err := t.captureOutput(ctx, file); err != nil {
 		file.Close()
 		return nil, err
 	}
@@ -61,15 +61,15 @@ func (t *Top4M3) captureToFile() (*os.File, error) {
 }
 
 // captureOutput runs the top capture command 3 times, separated by line breaks
-// and delays, writing the output into the provided writer.
-func (t *Top4M3) captureOutput(w io.Writer) error {
+// and delays, writing the output into the provided writer. It stops early once ctx is done.
+func (t *Top4M3) captureOutput(ctx context.Context, w io.Writer) error {
 	iterations := 3
 	if t.sleepBetweenCaptures == 0 {
 		t.sleepBetweenCaptures = defaultSleepBetweenTop4M3Capture
 	}
 
 	for i := 0; i < iterations; i++ {
-		cmd, err := executils.CommandStartInBackgroundToWriter(w, executils.Top4M3)
+		cmd, err := executils.CommandStartInBackgroundToWriter(w, executils.Top4M3, executils.ContextHooker{Ctx: ctx})
 		if err != nil {
 			return fmt.Errorf("failed to start top command: %w", err)
 		}
@@ -80,16 +80,20 @@ func (t *Top4M3) captureOutput(w io.Writer) error {
 		}
 
 		if err := cmd.Wait(); err != nil {
-			logger.Log("top command failed: %v", err)
+			t.Log("top command failed: %v", err)
 		}
 
 		if _, err := w.Write([]byte("\n\n\n")); err != nil {
-			logger.Log("failed to insert line breaks: %v", err)
+			t.Log("failed to insert line breaks: %v", err)
 		}
 
 		// Do not sleep after the last iteration.
 		if i < iterations-1 {
-			time.Sleep(t.sleepBetweenCaptures)
+			select {
+			case <-time.After(t.sleepBetweenCaptures):
+			case <-ctx.Done():
+				return nil
+			}
 		}
 	}
 
@@ -98,7 +102,7 @@ func (t *Top4M3) captureOutput(w io.Writer) error {
 
 // UploadCapturedFile uploads the captured file to the configured endpoint.
 func (t *Top4M3) UploadCapturedFile(file *os.File) Result {
-	msg, ok := PostData(t.Endpoint(), "top", file)
+	msg, ok := t.PostData("top", file)
 	return Result{
 		Msg: msg,
 		Ok:  ok,
@@ -1,6 +1,7 @@
 package capture
 
 import (
+	"context"
 	"os"
 	"testing"
 	"time"
@@ -23,7 +24,7 @@ func TestTop4M3_CaptureToFile_WritesData(t *testing.T) {
 	top := &Top4M3{sleepBetweenCaptures: 1 * time.Millisecond}
 
 	// Call CaptureToFile() and verify no error
-	file, err := top.captureToFile()
+	file, err := top.captureToFile(context.Background())
 	require.NoError(t, err, "captureToFile should not return error")
 	defer file.Close()
 
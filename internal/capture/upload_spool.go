@@ -0,0 +1,236 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"yc-agent/internal/capture/httpclient"
+	"yc-agent/internal/capture/spool"
+	"yc-agent/internal/config"
+	"yc-agent/internal/logger"
+	"yc-agent/internal/metrics"
+)
+
+const uploadSpoolDir = "yc-upload-spool"
+
+// queuedMsgPrefix marks the message of an upload that failed and was saved to the spool.
+const queuedMsgPrefix = "queued for retry"
+
+var (
+	uploadSpoolMtx sync.RWMutex
+	uploadSpool    *spool.Spool
+
+	uploadStateMtx sync.RWMutex
+	uploadStateDir string
+
+	_ = metrics.NewGaugeFunc("yc_agent_spool_queue_depth", "Uploads queued for retry in the upload spool.", func() float64 {
+		s := getUploadSpool()
+		if s == nil {
+			return 0
+		}
+		return float64(s.Len())
+	})
+)
+
+// InitUploadState resolves the directory the upload spool and the chunked upload
+// sessions are kept in, the storage path, to an absolute path once at startup,
+// so that they don't move if the working directory changes.
+func InitUploadState() error {
+	dir, err := filepath.Abs(config.GlobalConfig.StoragePath)
+	if err != nil {
+		return err
+	}
+
+	uploadStateMtx.Lock()
+	defer uploadStateMtx.Unlock()
+	uploadStateDir = dir
+	return nil
+}
+
+// uploadStatePath returns the path of name in the upload state dir, see InitUploadState.
+// It falls back to the temp dir when the state dir is not initialized.
+func uploadStatePath(name string) string {
+	uploadStateMtx.RLock()
+	defer uploadStateMtx.RUnlock()
+	if uploadStateDir == "" {
+		return filepath.Join(os.TempDir(), name)
+	}
+	return filepath.Join(uploadStateDir, name)
+}
+
+// StartUploadSpool opens the upload spool under the storage path and keeps retrying
+// the queued uploads in the background until stop is closed. Uploads left over
+// from a previous run are picked up right away.
+func StartUploadSpool(stop <-chan struct{}) (*spool.Spool, error) {
+	dir := uploadStatePath(uploadSpoolDir)
+
+	s, err := spool.New(dir, sendSpooledUpload)
+	if err != nil {
+		return nil, err
+	}
+	if config.GlobalConfig.UploadRetryMax > 0 {
+		s.MaxAttempts = config.GlobalConfig.UploadRetryMax
+	}
+
+	SetUploadSpool(s)
+
+	if n := s.Len(); n > 0 {
+		logger.Log("upload spool %s has %d queued uploads", dir, n)
+	}
+	go s.Run(stop)
+
+	return s, nil
+}
+
+// SetUploadSpool sets the spool failed uploads are saved to. A nil spool disables spooling.
+func SetUploadSpool(s *spool.Spool) {
+	uploadSpoolMtx.Lock()
+	defer uploadSpoolMtx.Unlock()
+	uploadSpool = s
+}
+
+func getUploadSpool() *spool.Spool {
+	uploadSpoolMtx.RLock()
+	defer uploadSpoolMtx.RUnlock()
+	return uploadSpool
+}
+
+// TransmissionStatus describes the outcome of an upload in the capture logs:
+// "true", "false", or "queued" when the upload failed and will be retried from the spool.
+func TransmissionStatus(ok bool, msg string) string {
+	if !ok && strings.HasPrefix(msg, queuedMsgPrefix) {
+		return "queued"
+	}
+	return fmt.Sprintf("%t", ok)
+}
+
+// Transmission describes the upload outcome of the result, see TransmissionStatus.
+func (r Result) Transmission() string {
+	return TransmissionStatus(r.Ok, r.Msg)
+}
+
+// spoolFailedUpload saves the data of a failed upload to the spool, compressed again
+// with compression unless it's empty, and returns the message to report. cause is
+// returned as is when spooling is disabled or fails.
+// The file is reopened by name since the http client closes the request body. The
+// whole file uploaded as is, like a heap dump, is hardlinked rather than copied.
+func spoolFailedUpload(url string, file *os.File, position func(file *os.File) error, compression, cause string) string {
+	s := getUploadSpool()
+	if s == nil {
+		return cause
+	}
+
+	data, err := os.Open(file.Name())
+	if err != nil {
+		return fmt.Sprintf("%s\nfailed to queue for retry: %s", cause, err.Error())
+	}
+	defer data.Close()
+
+	err = position(data)
+	if err != nil {
+		return fmt.Sprintf("%s\nfailed to queue for retry: %s", cause, err.Error())
+	}
+	offset, err := data.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Sprintf("%s\nfailed to queue for retry: %s", cause, err.Error())
+	}
+
+	var e *spool.Entry
+	if offset == 0 && compression == "" {
+		e, err = s.EnqueueFile(url, "", data.Name())
+	} else {
+		var body io.Reader = data
+		if compression != "" {
+			compressed, err := newCompressingReader(data, compression)
+			if err != nil {
+				return fmt.Sprintf("%s\nfailed to queue for retry: %s", cause, err.Error())
+			}
+			defer compressed.Close()
+			body = compressed
+		}
+		e, err = s.Enqueue(url, compression, body)
+	}
+	if err != nil {
+		return fmt.Sprintf("%s\nfailed to queue for retry: %s", cause, err.Error())
+	}
+
+	return fmt.Sprintf("%s as %s\n%s", queuedMsgPrefix, e.ID, cause)
+}
+
+// spoolChunkedUpload saves the file of a failed chunked upload to url to the spool, and
+// returns the message to report. The spooled upload resumes from the parts the receiver
+// acknowledged. cause is returned as is when spooling is disabled or fails.
+// The file is hardlinked rather than copied, like spoolFailedUpload does for a whole file.
+func spoolChunkedUpload(upload *ChunkedUpload, url string, file *os.File, cause string) string {
+	s := getUploadSpool()
+	if s == nil {
+		return cause
+	}
+
+	e, err := s.EnqueueChunkedFile(url, spool.Chunked{PartSize: upload.PartSize, Compression: upload.Compression}, file.Name())
+	if err != nil {
+		return fmt.Sprintf("%s\nfailed to queue for retry: %s", cause, err.Error())
+	}
+
+	err = upload.moveSession(url, file, s.DataPath(e))
+	if err != nil {
+		upload.log().Warn().Err(err).Msg("failed to move chunked upload state to the spool, the retry starts over")
+	}
+
+	return fmt.Sprintf("%s as %s, resuming from the acknowledged parts\n%s", queuedMsgPrefix, e.ID, cause)
+}
+
+// resender is implemented by the uploaders that can send the spooled uploads.
+type resender interface {
+	resend(e *spool.Entry, body *os.File) (retry bool, err error)
+}
+
+// sendSpooledUpload sends a spooled upload with the uploader every capture is transmitted with.
+func sendSpooledUpload(e *spool.Entry, body *os.File) (retry bool, err error) {
+	if r, ok := GetUploader().(resender); ok {
+		return r.resend(e, body)
+	}
+	return true, errors.New("the uploader can't send the spooled uploads")
+}
+
+// resend posts the data of a spooled upload to the yCrash server. A chunked upload
+// is resumed, see ChunkedUpload.
+func (h *HTTPUploader) resend(e *spool.Entry, body *os.File) (retry bool, err error) {
+	if e.Chunked != nil {
+		upload := NewChunkedUpload(e.Chunked.Compression)
+		upload.PartSize = e.Chunked.PartSize
+		msg, ok := upload.Upload(context.Background(), e.URL, body)
+		if !ok {
+			return true, errors.New(msg)
+		}
+		return false, nil
+	}
+
+	httpClient, err := httpclient.Shared()
+	if err != nil {
+		return true, err
+	}
+
+	statusCode, respBody, err := postBody(httpClient, e.URL, body)
+	if err != nil {
+		return true, err
+	}
+	if statusCode != http.StatusOK {
+		return isRetriableStatus(statusCode), fmt.Errorf("status code %d\n%s", statusCode, respBody)
+	}
+	return false, nil
+}
+
+// isRetriableStatus reports whether an upload rejected with statusCode may succeed later.
+func isRetriableStatus(statusCode int) bool {
+	return statusCode == http.StatusRequestTimeout ||
+		statusCode == http.StatusTooManyRequests ||
+		statusCode >= http.StatusInternalServerError
+}
@@ -0,0 +1,145 @@
+package capture
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"yc-agent/internal/config"
+)
+
+const (
+	UploaderHTTP = "http"
+	UploaderDir  = "dir"
+	UploaderS3   = "s3"
+)
+
+// Uploader transmits the captured data.
+type Uploader interface {
+	// Upload transmits the content of file starting from where position puts it.
+	// endpoint is the yCrash receiver endpoint of the capture, and params are
+	// the query parameters describing the data, for example: dt=gc&pid=3121.
+	Upload(endpoint, params string, file *os.File, position func(file *os.File) error) (msg string, ok bool)
+}
+
+// CompressingUploader is implemented by the uploaders that compress the data while
+// transmitting it, without writing the compressed data to disk first.
+type CompressingUploader interface {
+	// UploadCompressed transmits the content of file starting from where position puts it,
+	// compressed with compression, see Upload.
+	UploadCompressed(endpoint, params string, file *os.File, position func(file *os.File) error, compression string) (msg string, ok bool)
+}
+
+var (
+	uploaderMtx sync.RWMutex
+	uploader    Uploader = &HTTPUploader{}
+)
+
+// NewUploader creates the uploader selected by the options.
+func NewUploader(opts config.Options) (Uploader, error) {
+	switch opts.Uploader {
+	case "", UploaderHTTP:
+		return &HTTPUploader{}, nil
+	case UploaderDir:
+		return NewDirUploader(opts.UploadDir)
+	case UploaderS3:
+		return NewS3Uploader(opts)
+	}
+	return nil, fmt.Errorf("unknown uploader %q, should be one of %s, %s, %s", opts.Uploader, UploaderHTTP, UploaderDir, UploaderS3)
+}
+
+// SetUploader sets the uploader every capture is transmitted with.
+func SetUploader(u Uploader) {
+	uploaderMtx.Lock()
+	defer uploaderMtx.Unlock()
+	uploader = u
+}
+
+// GetUploader returns the uploader every capture is transmitted with, the yCrash server by default.
+func GetUploader() Uploader {
+	uploaderMtx.RLock()
+	defer uploaderMtx.RUnlock()
+	return uploader
+}
+
+// UploadsToServer reports whether the captures are transmitted to the yCrash server,
+// which is the only uploader that can answer the fin and attendance requests.
+func UploadsToServer() bool {
+	_, ok := GetUploader().(*HTTPUploader)
+	return ok
+}
+
+// uploadObjectName returns the slash separated name a capture is stored under
+// by the dir and s3 uploaders: <de>/<ts>/<receiver>/<dt>[-<other param values>][.<content encoding>].
+// The content encoding parameter is the only sign of compressed data, so it's kept in the name.
+// For example, dt=applog&logName=app.log posted to /ycrash-receiver?de=10.0.0.1&ts=2024-01-02T15-04-05
+// is stored as 10.0.0.1/2024-01-02T15-04-05/ycrash-receiver/applog-app.log.
+func uploadObjectName(endpoint, params string) string {
+	var receiver string
+	query := url.Values{}
+	if u, err := url.Parse(endpoint); err == nil {
+		receiver = path.Base(u.Path)
+		query = u.Query()
+	}
+	if p, err := url.ParseQuery(params); err == nil {
+		for k, v := range p {
+			query[k] = append(query[k], v...)
+		}
+	}
+
+	var encoding string
+	var keys []string
+	for k := range query {
+		switch strings.ToLower(k) {
+		case "de", "ts", "dt", "timezoneid", "captureid":
+		case "content-encoding":
+			encoding = query.Get(k)
+		default:
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	name := query.Get("dt")
+	if name == "" {
+		name = "data"
+	}
+	for _, k := range keys {
+		if v := query.Get(k); v != "" {
+			name += "-" + v
+		}
+	}
+	if encoding == compressionExt(CompressionZstd) {
+		// The extension the zstd tools recognize
+		encoding = "zst"
+	}
+	if encoding != "" {
+		name += "." + encoding
+	}
+
+	return path.Join(
+		sanitizeObjectName(query.Get("de"), "unknown"),
+		sanitizeObjectName(query.Get("ts"), "unknown"),
+		sanitizeObjectName(receiver, "receiver"),
+		sanitizeObjectName(name, "data"))
+}
+
+// sanitizeObjectName keeps letters, digits, '.', '-' and '_' of s, and replaces
+// everything else with '_' so s is safe to use as a single path element.
+func sanitizeObjectName(s, fallback string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+	if strings.Trim(s, ".") == "" {
+		return fallback
+	}
+	return s
+}
@@ -0,0 +1,112 @@
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"yc-agent/internal/capture/spool"
+)
+
+// DirUploader writes the captured data to a local directory, laid out as
+// <dir>/<de>/<ts>/<receiver>/<name>, so it can be analyzed later.
+// Failed writes are saved to the upload spool, if any.
+type DirUploader struct {
+	Dir string
+}
+
+func NewDirUploader(dir string) (*DirUploader, error) {
+	if dir == "" {
+		return nil, errors.New("uploadDir is required by the dir uploader")
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload dir %s: %w", dir, err)
+	}
+	return &DirUploader{Dir: dir}, nil
+}
+
+func (d *DirUploader) Upload(endpoint, params string, file *os.File, position func(file *os.File) error) (msg string, ok bool) {
+	return d.upload(endpoint, params, file, position, "")
+}
+
+// UploadCompressed writes file compressed with compression to the directory.
+func (d *DirUploader) UploadCompressed(endpoint, params string, file *os.File, position func(file *os.File) error, compression string) (msg string, ok bool) {
+	return d.upload(endpoint, params, file, position, compression)
+}
+
+func (d *DirUploader) upload(endpoint, params string, file *os.File, position func(file *os.File) error, compression string) (msg string, ok bool) {
+	err := position(file)
+	if err != nil {
+		msg = fmt.Sprintf("PostData position err %s", err.Error())
+		return
+	}
+
+	var r io.Reader = file
+	if compression != "" {
+		compressed, err := newCompressingReader(file, compression)
+		if err != nil {
+			msg = fmt.Sprintf("PostData compress err %s", err.Error())
+			return
+		}
+		defer compressed.Close()
+		r = compressed
+	}
+
+	url := fmt.Sprintf("%s&%s", endpoint, params)
+	dst, err := d.write(url, r)
+	if err != nil {
+		msg = spoolFailedUpload(url, file, position, compression, err.Error())
+		return
+	}
+
+	msg = fmt.Sprintf("written to %s", dst)
+	ok = true
+	return
+}
+
+// resend writes the data of a spooled upload, see sendSpooledUpload.
+func (d *DirUploader) resend(e *spool.Entry, body *os.File) (retry bool, err error) {
+	_, err = d.write(e.URL, body)
+	return err != nil, err
+}
+
+// write writes r to the path of the upload to url in the directory.
+func (d *DirUploader) write(url string, r io.Reader) (dst string, err error) {
+	dst = filepath.Join(d.Dir, filepath.FromSlash(uploadObjectName(url, "")))
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return "", fmt.Errorf("PostData mkdir err %w", err)
+	}
+	err = writeFileAtomic(dst, r)
+	if err != nil {
+		return "", fmt.Errorf("PostData write err %w", err)
+	}
+	return dst, nil
+}
+
+// writeFileAtomic writes r to a temp file next to dst and renames it to dst,
+// so a partially written capture is never mistaken for a complete one.
+func writeFileAtomic(dst string, r io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dst)
+}
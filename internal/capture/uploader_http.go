@@ -0,0 +1,60 @@
+package capture
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"yc-agent/internal/capture/httpclient"
+)
+
+// HTTPUploader posts the captured data to the yCrash server receiver endpoints.
+// Uploads failing with a transient error are saved to the upload spool, if any.
+type HTTPUploader struct{}
+
+func (h *HTTPUploader) Upload(endpoint, params string, file *os.File, position func(file *os.File) error) (msg string, ok bool) {
+	return h.upload(endpoint, params, file, position, "")
+}
+
+// UploadCompressed streams file through the compressor straight into the request body.
+func (h *HTTPUploader) UploadCompressed(endpoint, params string, file *os.File, position func(file *os.File) error, compression string) (msg string, ok bool) {
+	return h.upload(endpoint, params, file, position, compression)
+}
+
+func (h *HTTPUploader) upload(endpoint, params string, file *os.File, position func(file *os.File) error, compression string) (msg string, ok bool) {
+	url := fmt.Sprintf("%s&%s", endpoint, params)
+	httpClient, err := httpclient.Shared()
+	if err != nil {
+		msg = err.Error()
+		return
+	}
+	err = position(file)
+	if err != nil {
+		msg = fmt.Sprintf("PostData position err %s", err.Error())
+		return
+	}
+	var reqBody io.Reader = file
+	if compression != "" {
+		compressed, err := newCompressingReader(file, compression)
+		if err != nil {
+			msg = fmt.Sprintf("PostData compress err %s", err.Error())
+			return
+		}
+		defer compressed.Close()
+		reqBody = compressed
+	}
+	statusCode, body, err := postBody(httpClient, url, reqBody)
+	if err != nil {
+		msg = spoolFailedUpload(url, file, position, compression, fmt.Sprintf("PostData post err %s", err.Error()))
+		return
+	}
+	msg = fmt.Sprintf("%s\nstatus code %d\n%s", url, statusCode, body)
+
+	if statusCode == http.StatusOK {
+		ok = true
+	} else if isRetriableStatus(statusCode) {
+		msg = spoolFailedUpload(url, file, position, compression, msg)
+	}
+	return
+}
@@ -0,0 +1,489 @@
+package capture
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"yc-agent/internal/capture/httpclient"
+	"yc-agent/internal/capture/spool"
+	"yc-agent/internal/config"
+)
+
+const (
+	s3Service       = "s3"
+	s3SignAlgorithm = "AWS4-HMAC-SHA256"
+	s3AmzDateFormat = "20060102T150405Z"
+	s3DateFormat    = "20060102"
+
+	// s3DefaultPartSize is the size of the parts of the multipart uploads, held in memory
+	// while sent. S3 requires at least 5 MiB for every part but the last.
+	s3DefaultPartSize = 16 << 20
+	s3MaxParts        = 10000
+)
+
+// S3Uploader puts the captured data into a bucket of an S3-compatible object store,
+// such as AWS S3 or MinIO, under <prefix>/<de>/<ts>/<receiver>/<name>.
+// Requests are signed with AWS Signature Version 4. Data larger than a part
+// is sent with a multipart upload. Uploads failing with a transient error are
+// saved to the upload spool, if any.
+type S3Uploader struct {
+	Endpoint     *url.URL
+	Region       string
+	Bucket       string
+	Prefix       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	PathStyle    bool
+	// PartSize is the size of the parts of the multipart uploads, s3DefaultPartSize if 0.
+	PartSize int64
+
+	now func() time.Time
+}
+
+func NewS3Uploader(opts config.Options) (*S3Uploader, error) {
+	if opts.S3Bucket == "" {
+		return nil, errors.New("s3Bucket is required by the s3 uploader")
+	}
+
+	region := opts.S3Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	endpoint := opts.S3Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3Endpoint %s: %w", endpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid s3Endpoint %s: should be like http(s)://host[:port]", endpoint)
+	}
+
+	accessKey := opts.S3AccessKey
+	if accessKey == "" {
+		accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	secretKey := opts.S3SecretKey
+	if secretKey == "" {
+		secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if accessKey == "" || secretKey == "" {
+		return nil, errors.New("s3AccessKey and s3SecretKey are required by the s3 uploader")
+	}
+
+	return &S3Uploader{
+		Endpoint:     u,
+		Region:       region,
+		Bucket:       opts.S3Bucket,
+		Prefix:       strings.Trim(opts.S3Prefix, "/"),
+		AccessKey:    accessKey,
+		SecretKey:    secretKey,
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		PathStyle:    opts.S3PathStyle,
+	}, nil
+}
+
+func (s *S3Uploader) Upload(endpoint, params string, file *os.File, position func(file *os.File) error) (msg string, ok bool) {
+	return s.upload(endpoint, params, file, position, "")
+}
+
+// UploadCompressed streams file through the compressor into the object, a part at a time.
+func (s *S3Uploader) UploadCompressed(endpoint, params string, file *os.File, position func(file *os.File) error, compression string) (msg string, ok bool) {
+	return s.upload(endpoint, params, file, position, compression)
+}
+
+func (s *S3Uploader) upload(endpoint, params string, file *os.File, position func(file *os.File) error, compression string) (msg string, ok bool) {
+	err := position(file)
+	if err != nil {
+		msg = fmt.Sprintf("PostData position err %s", err.Error())
+		return
+	}
+
+	offset, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		msg = fmt.Sprintf("PostData seek err %s", err.Error())
+		return
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		msg = fmt.Sprintf("PostData stat err %s", err.Error())
+		return
+	}
+
+	var body io.Reader = file
+	if compression != "" {
+		compressed, err := newCompressingReader(file, compression)
+		if err != nil {
+			msg = fmt.Sprintf("PostData compress err %s", err.Error())
+			return
+		}
+		defer compressed.Close()
+		body = compressed
+	}
+
+	url := fmt.Sprintf("%s&%s", endpoint, params)
+	msg, ok, retry := s.put(path.Join(s.Prefix, uploadObjectName(url, "")), body, stat.Size()-offset)
+	if retry {
+		msg = spoolFailedUpload(url, file, position, compression, msg)
+	}
+	return
+}
+
+// resend puts the data of a spooled upload, see sendSpooledUpload.
+func (s *S3Uploader) resend(e *spool.Entry, body *os.File) (retry bool, err error) {
+	stat, err := body.Stat()
+	if err != nil {
+		return true, err
+	}
+	msg, ok, retry := s.put(path.Join(s.Prefix, uploadObjectName(e.URL, "")), body, stat.Size())
+	if !ok {
+		return retry, errors.New(msg)
+	}
+	return false, nil
+}
+
+// put stores the content of r, about size bytes, under key. A content larger than a
+// part is sent with a multipart upload, since a single PUT is limited to 5 GB, and only
+// a part is held in memory at a time. retry reports whether a failure is transient.
+// A content smaller than a part, like most captures, gets a buffer of its size only.
+func (s *S3Uploader) put(key string, r io.Reader, size int64) (msg string, ok, retry bool) {
+	objectURL := s.objectURL(key)
+
+	partSize := s.partSize(size)
+	bufSize := partSize
+	if size >= 0 && size < partSize {
+		bufSize = size + 1
+	}
+	buf := make([]byte, bufSize)
+	n, err := io.ReadFull(r, buf)
+	if err == nil && bufSize < partSize {
+		// The content is larger than size, like compressed data which didn't shrink,
+		// read the rest of the first part
+		buf = append(buf, make([]byte, partSize-bufSize)...)
+		var m int
+		m, err = io.ReadFull(r, buf[n:])
+		n += m
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return s.do(http.MethodPut, objectURL, buf[:n])
+	}
+	if err != nil {
+		return fmt.Sprintf("PostData read err %s", err.Error()), false, false
+	}
+
+	uploadID, msg, ok, retry := s.createMultipartUpload(objectURL)
+	if !ok {
+		return msg, false, retry
+	}
+
+	var parts []s3CompletedPart
+	for partNumber := 1; n > 0; partNumber++ {
+		if partNumber > s3MaxParts {
+			msg, ok, retry = fmt.Sprintf("PostData put err more than %d parts", s3MaxParts), false, false
+			break
+		}
+		partURL := s3WithQuery(objectURL, url.Values{
+			"partNumber": {strconv.Itoa(partNumber)},
+			"uploadId":   {uploadID},
+		})
+		var etag string
+		etag, msg, ok, retry = s.doPart(partURL, buf[:n])
+		if !ok {
+			break
+		}
+		parts = append(parts, s3CompletedPart{PartNumber: partNumber, ETag: etag})
+
+		n, err = io.ReadFull(r, buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		if err != nil {
+			msg, ok, retry = fmt.Sprintf("PostData read err %s", err.Error()), false, false
+			break
+		}
+	}
+	if !ok {
+		return s.abortMultipartUpload(objectURL, uploadID, msg), false, retry
+	}
+
+	completion, err := xml.Marshal(s3CompleteMultipartUpload{Parts: parts})
+	if err != nil {
+		return s.abortMultipartUpload(objectURL, uploadID, fmt.Sprintf("PostData complete err %s", err.Error())), false, false
+	}
+	msg, ok, retry = s.do(http.MethodPost, s3WithQuery(objectURL, url.Values{"uploadId": {uploadID}}), completion)
+	if !ok {
+		msg = s.abortMultipartUpload(objectURL, uploadID, msg)
+	}
+	return msg, ok, retry
+}
+
+// partSize returns the size of the parts of an upload of about size bytes,
+// large enough to fit it in s3MaxParts parts.
+func (s *S3Uploader) partSize(size int64) int64 {
+	partSize := s.PartSize
+	if partSize <= 0 {
+		partSize = s3DefaultPartSize
+	}
+	if min := (size + s3MaxParts - 1) / s3MaxParts; partSize < min {
+		partSize = min
+	}
+	return partSize
+}
+
+type s3InitiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type s3CompleteMultipartUpload struct {
+	XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletedPart `xml:"Part"`
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (s *S3Uploader) createMultipartUpload(objectURL *url.URL) (uploadID, msg string, ok, retry bool) {
+	req, err := s.newRequest(http.MethodPost, s3WithQuery(objectURL, url.Values{"uploads": {""}}), nil)
+	if err != nil {
+		return "", fmt.Sprintf("PostData new req err %s", err.Error()), false, false
+	}
+	statusCode, respBody, _, err := s.send(req)
+	if err != nil {
+		return "", fmt.Sprintf("PostData create multipart upload err %s", err.Error()), false, true
+	}
+	msg = fmt.Sprintf("%s\nstatus code %d\n%s", req.URL.String(), statusCode, respBody)
+	if statusCode != http.StatusOK {
+		return "", msg, false, isRetriableStatus(statusCode)
+	}
+
+	var result s3InitiateMultipartUploadResult
+	err = xml.Unmarshal(respBody, &result)
+	if err != nil || result.UploadID == "" {
+		return "", fmt.Sprintf("%s\ninvalid create multipart upload response", msg), false, false
+	}
+	return result.UploadID, msg, true, false
+}
+
+// abortMultipartUpload aborts the upload, and returns msg with the reason the abort failed if it did.
+func (s *S3Uploader) abortMultipartUpload(objectURL *url.URL, uploadID string, msg string) string {
+	abortMsg, ok, _ := s.do(http.MethodDelete, s3WithQuery(objectURL, url.Values{"uploadId": {uploadID}}), nil)
+	if !ok {
+		return fmt.Sprintf("%s\nfailed to abort the multipart upload %s: %s", msg, uploadID, abortMsg)
+	}
+	return msg
+}
+
+// doPart uploads a part and returns its ETag.
+func (s *S3Uploader) doPart(partURL *url.URL, body []byte) (etag, msg string, ok, retry bool) {
+	req, err := s.newRequest(http.MethodPut, partURL, body)
+	if err != nil {
+		return "", fmt.Sprintf("PostData new req err %s", err.Error()), false, false
+	}
+	statusCode, respBody, header, err := s.send(req)
+	if err != nil {
+		return "", fmt.Sprintf("PostData put err %s", err.Error()), false, true
+	}
+	msg = fmt.Sprintf("%s\nstatus code %d\n%s", partURL.String(), statusCode, respBody)
+	if statusCode != http.StatusOK {
+		return "", msg, false, isRetriableStatus(statusCode)
+	}
+	return header.Get("ETag"), msg, true, false
+}
+
+// do sends a signed request with body to u.
+func (s *S3Uploader) do(method string, u *url.URL, body []byte) (msg string, ok, retry bool) {
+	req, err := s.newRequest(method, u, body)
+	if err != nil {
+		return fmt.Sprintf("PostData new req err %s", err.Error()), false, false
+	}
+	statusCode, respBody, _, err := s.send(req)
+	if err != nil {
+		return fmt.Sprintf("PostData %s err %s", strings.ToLower(method), err.Error()), false, true
+	}
+	msg = fmt.Sprintf("%s\nstatus code %d\n%s", u.String(), statusCode, respBody)
+	ok = statusCode == http.StatusOK || method == http.MethodDelete && statusCode == http.StatusNoContent
+	return msg, ok, !ok && isRetriableStatus(statusCode)
+}
+
+func (s *S3Uploader) newRequest(method string, u *url.URL, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	if method == http.MethodPut {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	s.sign(req, hexSHA256(body))
+	return req, nil
+}
+
+func (s *S3Uploader) send(req *http.Request) (statusCode int, body []byte, header http.Header, err error) {
+	httpClient, err := httpclient.Shared()
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, _ = ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, body, resp.Header, nil
+}
+
+// s3WithQuery returns a copy of u with the query.
+func s3WithQuery(u *url.URL, query url.Values) *url.URL {
+	c := *u
+	c.RawQuery = query.Encode()
+	return &c
+}
+
+// objectURL returns the URL of the object key, addressing the bucket by the
+// host name (bucket.host/key) or by the path (host/bucket/key) with PathStyle.
+func (s *S3Uploader) objectURL(key string) *url.URL {
+	u := *s.Endpoint
+	objectPath := "/" + key
+	if s.PathStyle {
+		objectPath = "/" + s.Bucket + objectPath
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+	}
+	u.Path = path.Join(strings.TrimSuffix(u.Path, "/"), objectPath)
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = ""
+	return &u
+}
+
+// sign adds the AWS Signature Version 4 authorization header to req,
+// see https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html.
+// Every header already set on req is signed.
+func (s *S3Uploader) sign(req *http.Request, payloadHash string) {
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	t := now().UTC()
+	amzDate := t.Format(s3AmzDateFormat)
+	date := t.Format(s3DateFormat)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, s.Region, s3Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		s3SignAlgorithm,
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, s3Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3SignAlgorithm, s.AccessKey, scope, signedHeaders, signature))
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func s3EscapePath(p string) string {
+	return s3Escape(p, false)
+}
+
+// s3Escape URI-encodes every byte of s but the unreserved characters, and '/' unless encodeSlash.
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -2,15 +2,16 @@ package capture
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"yc-agent/internal/capture/executils"
-	"yc-agent/internal/logger"
 )
 
 const (
@@ -25,8 +26,8 @@ type VMStat struct {
 
 // Run executes the VMStat capture process and uploads the captured file
 // to the specified endpoint.
-func (v *VMStat) Run() (Result, error) {
-	capturedFile, err := v.CaptureToFile()
+func (v *VMStat) Run(ctx context.Context) (Result, error) {
+	capturedFile, err := v.captureToFile(ctx)
 	if err != nil {
 		return Result{Msg: err.Error(), Ok: false}, err
 	}
@@ -39,25 +40,38 @@ func (v *VMStat) Run() (Result, error) {
 // CaptureToFile captures VMStat output to a file.
 // It returns the file handle for the captured data.
 func (v *VMStat) CaptureToFile() (*os.File, error) {
-	file, err := os.Create(vmstatOutputPath)
+	return v.captureToFile(context.Background())
+}
+
+func (v *VMStat) captureToFile(ctx context.Context) (*os.File, error) {
+	// Killed once the other captures are done, keeping what was sampled so far
+	ctx, cancel := v.killable(ctx)
+	defer cancel()
+
+	file, err := os.Create(v.OutputPath(vmstatOutputPath))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create output file: %w", err)
 	}
 
-	if err := v.captureOutput(file); err != nil {
+	err = v.captureWithProc(ctx, file, "vmstat", func(f *os.File) error {
+		return v.captureOutput(ctx, f)
+	}, func(w io.Writer) error {
+		return procFS().WriteVMStat(ctx, w, time.Duration(executils.VMSTAT_INTERVAL)*time.Second, vmstatCount)
+	})
+	if err != nil {
 		file.Close()
 		return nil, err
 	}
 
 	if err := file.Sync(); err != nil {
-		logger.Log("warning: failed to sync file: %v", err)
+		v.Log("warning: failed to sync file: %v", err)
 	}
 
 	return file, nil
 }
 
 // captureOutput handles the actual VMStat capture process.
-func (v *VMStat) captureOutput(f *os.File) error {
+func (v *VMStat) captureOutput(ctx context.Context, f *os.File) error {
 	// The first vmstat command to try
 	cmd, err := executils.VMState.AddDynamicArg(
 		strconv.Itoa(executils.VMSTAT_INTERVAL),
@@ -68,25 +82,26 @@ func (v *VMStat) captureOutput(f *os.File) error {
 		return fmt.Errorf("failed to build initial command: %w", err)
 	}
 
-	if err := v.executeCommand(f, cmd); err != nil {
-		if runtime.GOOS != "linux" {
+	if err := v.executeCommand(ctx, f, cmd); err != nil {
+		if runtime.GOOS != "linux" || ctx.Err() != nil {
 			return err
 		}
 
 		// If failed, fallback to the next command
-		return v.fallbackCommand(f)
+		return v.fallbackCommand(ctx, f)
 	}
 
 	return nil
 }
 
-// executeCommand starts and monitors a command writing to the specified writer.
-func (v *VMStat) executeCommand(w io.Writer, cmd []string) error {
+// executeCommand starts and monitors a command writing to the specified writer,
+// until ctx is done.
+func (v *VMStat) executeCommand(ctx context.Context, w io.Writer, cmd []string) error {
 	// Create a buffer to capture the output
 	var outputBuffer bytes.Buffer
 	multiWriter := io.MultiWriter(w, &outputBuffer)
 
-	command, err := executils.CommandStartInBackgroundToWriter(multiWriter, cmd)
+	command, err := executils.CommandStartInBackgroundToWriter(multiWriter, cmd, executils.ContextHooker{Ctx: ctx})
 	if err != nil {
 		return fmt.Errorf("failed to start command: %w", err)
 	}
@@ -98,6 +113,9 @@ func (v *VMStat) executeCommand(w io.Writer, cmd []string) error {
 
 	command.Wait()
 
+	if ctx.Err() != nil {
+		return fmt.Errorf("vmstat: command stopped: %w", ctx.Err())
+	}
 	if command.ExitCode() != 0 {
 		return fmt.Errorf("vmstat: command failed with exit code: %d", command.ExitCode())
 	}
@@ -148,7 +166,7 @@ func validateLinuxVMStatOutput(output string) (bool, string) {
 }
 
 // fallbackCommand attempts to run VMStat with an alternative approach when the initial command fails.
-func (v *VMStat) fallbackCommand(file *os.File) error {
+func (v *VMStat) fallbackCommand(ctx context.Context, file *os.File) error {
 	// Preserve the original output for logging
 	output, readErr := v.preserveOriginalOutput(file)
 
@@ -167,14 +185,14 @@ func (v *VMStat) fallbackCommand(file *os.File) error {
 		return fmt.Errorf("failed to build fallback command: %w", err)
 	}
 
-	logger.Info().
+	v.Logger().Info().
 		Strs("cmd", cmd).
 		Err(readErr).
 		Bytes("output", output).
 		Str("failed cmd", v.Cmd.String()).
 		Msg("vmstat failed, trying to use -vmstatMode")
 
-	return v.executeCommand(file, cmd)
+	return v.executeCommand(ctx, file, cmd)
 }
 
 // preserveOriginalOutput saves the original command output and returns it as bytes.
@@ -201,7 +219,7 @@ func (v *VMStat) preserveOriginalOutput(file *os.File) ([]byte, error) {
 
 // UploadCapturedFile uploads the captured file to the configured endpoint.
 func (v *VMStat) UploadCapturedFile(file *os.File) Result {
-	msg, ok := PostData(v.Endpoint(), "vmstat", file)
+	msg, ok := v.PostData("vmstat", file)
 	return Result{
 		Msg: msg,
 		Ok:  ok,
@@ -2,11 +2,15 @@ package cli
 
 import "C"
 import (
+	"context"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"yc-agent/internal/agent"
+	"yc-agent/internal/agent/ondemand"
+	"yc-agent/internal/capture"
 	"yc-agent/internal/capture/executils"
 	"yc-agent/internal/config"
 	"yc-agent/internal/logger"
@@ -34,6 +38,16 @@ func Run() {
 		return
 	}
 
+	if config.GlobalConfig.DryRun {
+		plan, err := ondemand.ResolvePlan(config.GlobalConfig.Pipeline, config.GlobalConfig.SkipCollectors)
+		if err != nil {
+			logger.Log("Invalid capture pipeline: %s", err)
+			os.Exit(1)
+		}
+		plan.Print(os.Stdout)
+		return
+	}
+
 	err := validate()
 	if err == ErrInvalidArgumentCantContinue {
 		config.ShowUsage()
@@ -53,23 +67,41 @@ func Run() {
 	}
 }
 
-func runToCompletionOrSigterm(f func() error) error {
+// shutdownTimeout is how long the running captures are given to stop
+// and transmit their partial results after a signal.
+var shutdownTimeout = capture.PartialResultTimeout + 15*time.Second
+
+func runToCompletionOrSigterm(f func(ctx context.Context) error) error {
 	// Setup OS signal channel
 	osSigChan := make(chan os.Signal, 1)
 	signal.Notify(osSigChan, syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM)
+	defer signal.Stop(osSigChan)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	completed := make(chan error)
+	completed := make(chan error, 1)
 	var err error
 
 	go func(completed chan error) {
-		err := f()
+		err := f(ctx)
 		completed <- err
 	}(completed)
 
 	// Wait for either completion or sigterm signals
 	select {
 	case s := <-osSigChan:
-		logger.Log("Received OS signal: %s", s)
+		logger.Log("Received OS signal: %s, stopping the running captures", s)
+		cancel()
+
+		// Wait for the captures to stop, a second signal exits right away
+		select {
+		case err = <-completed:
+		case s = <-osSigChan:
+			logger.Log("Received OS signal: %s again, exiting", s)
+		case <-time.After(shutdownTimeout):
+			logger.Log("The captures didn't stop in %s, exiting", shutdownTimeout)
+		}
 	case err = <-completed:
 	}
 
@@ -21,9 +21,24 @@ func initLogger() {
 		config.GlobalConfig.LogFileMaxCount,
 		config.GlobalConfig.LogFileMaxSize,
 		config.GlobalConfig.LogLevel,
+		config.GlobalConfig.LogFormat,
 	)
 
 	if err != nil {
 		log.Fatal(err.Error())
 	}
+
+	secrets := []string{
+		config.GlobalConfig.ApiKey,
+		config.GlobalConfig.BoomiPassword,
+		config.GlobalConfig.S3SecretKey,
+	}
+	for _, client := range config.GlobalConfig.ApiClients {
+		secrets = append(secrets, client.Token, client.Secret)
+	}
+	err = logger.SetRedaction(secrets, config.GlobalConfig.LogRedactions)
+
+	if err != nil {
+		log.Fatal(err.Error())
+	}
 }
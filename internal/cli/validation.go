@@ -5,6 +5,10 @@ import (
 	"errors"
 	"os"
 
+	"yc-agent/internal/agent/api"
+	"yc-agent/internal/agent/ondemand"
+	"yc-agent/internal/capture"
+	"yc-agent/internal/capture/httpclient"
 	"yc-agent/internal/config"
 	"yc-agent/internal/logger"
 )
@@ -12,7 +16,44 @@ import (
 var ErrInvalidArgumentCantContinue = errors.New("cli: invalid argument")
 
 func validate() error {
-	if !config.GlobalConfig.OnlyCapture {
+	uploader, err := capture.NewUploader(config.GlobalConfig.Options)
+	if err != nil {
+		logger.Log("Invalid uploader configuration: %s", err)
+		return ErrInvalidArgumentCantContinue
+	}
+	capture.SetUploader(uploader)
+
+	_, err = httpclient.Shared()
+	if err != nil {
+		logger.Log("Invalid HTTP client configuration: %s", err)
+		return ErrInvalidArgumentCantContinue
+	}
+
+	err = capture.ValidateCompression(config.GlobalConfig.UploadCompression)
+	if err != nil {
+		logger.Log("Invalid 'uploadCompression' argument: %s", err)
+		return ErrInvalidArgumentCantContinue
+	}
+
+	err = capture.ValidateProcCollectors(config.GlobalConfig.ProcCollectors)
+	if err != nil {
+		logger.Log("Invalid 'procCollectors' argument: %s", err)
+		return ErrInvalidArgumentCantContinue
+	}
+
+	_, err = ondemand.ResolvePlan(config.GlobalConfig.Pipeline, config.GlobalConfig.SkipCollectors)
+	if err != nil {
+		logger.Log("Invalid capture pipeline: %s", err)
+		return ErrInvalidArgumentCantContinue
+	}
+
+	_, err = api.ParseForwardAllowlist(config.GlobalConfig.ForwardAllowlist)
+	if err != nil {
+		logger.Log("Invalid 'forwardAllowlist' argument: %s", err)
+		return ErrInvalidArgumentCantContinue
+	}
+
+	if !config.GlobalConfig.OnlyCapture && capture.UploadsToServer() {
 		if len(config.GlobalConfig.Server) < 1 {
 			logger.Log("'-s' yCrash server URL argument not passed.")
 			return ErrInvalidArgumentCantContinue
@@ -138,3 +138,34 @@ func TestConfig(t *testing.T) {
 		}
 	})
 }
+
+func TestTaskTimeouts(t *testing.T) {
+	t.Run("flag", func(t *testing.T) {
+		var timeouts TaskTimeouts
+		err := timeouts.Set("jstack=2m, Ping=30s")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = timeouts.Set("heapdump=1h")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if timeouts["jstack"] != 2*time.Minute || timeouts["ping"] != 30*time.Second || timeouts["heapdump"] != time.Hour {
+			t.Fatalf("unexpected timeouts %v", timeouts)
+		}
+		if err := timeouts.Set("jstack"); err == nil {
+			t.Fatal("expect an error for a missing duration")
+		}
+	})
+
+	t.Run("yaml", func(t *testing.T) {
+		var o Options
+		err := yaml.Unmarshal([]byte("taskTimeouts:\n  jstack: 2m\n  heapdump: 0s\n"), &o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d, ok := o.TaskTimeouts["heapdump"]; !ok || d != 0 || o.TaskTimeouts["jstack"] != 2*time.Minute {
+			t.Fatalf("unexpected timeouts %v", o.TaskTimeouts)
+		}
+	})
+}
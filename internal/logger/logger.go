@@ -1,10 +1,11 @@
 package logger
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"os"
-	"sync"
 	"sync/atomic"
 	"time"
 
@@ -12,23 +13,29 @@ import (
 	"github.com/rs/zerolog"
 )
 
-type stackElement struct {
-	l *zerolog.Logger
-	f *os.File
-}
+// The formats of the log.
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+)
+
+// CaptureIDField is the field of the capture ID in the log lines of a capture.
+const CaptureIDField = "captureId"
+
+// ctxKey is the key of the logger of a capture in its context.
+type ctxKey struct{}
 
 var (
 	logger        atomic.Value
-	loggerMtx     sync.Mutex
-	loggerStack   []stackElement
 	rootLogWriter io.Writer
+	logFormat     = FormatConsole
 
 	stdLogger zerolog.Logger
 	Log2File  bool
 )
 
 func init() {
-	w := zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.UnixDate}
+	w := newWriter(os.Stdout, true)
 	rootLogWriter = w
 	l := zerolog.New(w).With().Timestamp().Logger()
 	logger.Store(&l)
@@ -42,44 +49,95 @@ func SetLogger(l *zerolog.Logger) {
 	logger.Store(l)
 }
 
-func StartWritingToFile(file string) (f *os.File, err error) {
-	loggerMtx.Lock()
-	defer loggerMtx.Unlock()
-
+// StartWritingToFile returns the logger of a capture, writing to file as well as to the
+// agent log, with every log line tagged with captureID unless it's empty. The global
+// logger is left as is, so that the log lines of concurrent captures don't mix: each
+// capture logs with its own logger, carried in its context, see WithContext. Once the
+// file is stopped, the logger keeps writing to the agent log.
+func StartWritingToFile(file string, captureID string) (l *zerolog.Logger, f *os.File, err error) {
 	f, err = os.Create(file)
 	if err != nil {
 		return
 	}
-	lw := zerolog.ConsoleWriter{Out: f, TimeFormat: time.UnixDate, NoColor: true}
-	l := GetLogger()
-	loggerStack = append(loggerStack, stackElement{l, f})
-	w := io.MultiWriter(lw, rootLogWriter)
-	nl := l.Output(w)
-	SetLogger(&nl)
+	nl := GetLogger().Output(io.MultiWriter(rootLogWriter, newWriter(closedFileWriter{f}, false)))
+	if captureID != "" {
+		nl = nl.With().Str(CaptureIDField, captureID).Logger()
+	}
+	l = &nl
 	return
 }
 
-func StopWritingToFile() (err error) {
-	loggerMtx.Lock()
-	defer loggerMtx.Unlock()
-
-	l := loggerStack[len(loggerStack)-1]
-	loggerStack = loggerStack[:len(loggerStack)-1]
-	SetLogger(l.l)
-	err = l.f.Close()
+// StopWritingToFile closes the log file f of a capture started by StartWritingToFile.
+func StopWritingToFile(f *os.File) (err error) {
+	err = f.Close()
 	if errors.Is(err, os.ErrClosed) {
 		err = nil
 	}
 	return
 }
 
-func Init(path string, count uint, size int64, logLevel string) (err error) {
+// closedFileWriter drops the log lines written once the file is closed by StopWritingToFile,
+// which keep going to the agent log.
+type closedFileWriter struct {
+	f *os.File
+}
+
+func (w closedFileWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	if errors.Is(err, os.ErrClosed) {
+		return len(p), nil
+	}
+	return n, err
+}
+
+// WithContext returns ctx carrying l, the logger of the capture run with ctx.
+func WithContext(ctx context.Context, l *zerolog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// Ctx returns the logger of the capture run with ctx, the global logger if none.
+func Ctx(ctx context.Context) *zerolog.Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(ctxKey{}).(*zerolog.Logger); ok {
+			return l
+		}
+	}
+	return GetLogger()
+}
+
+// LogCtx logs with the logger of the capture run with ctx, see Ctx.
+func LogCtx(ctx context.Context, format string, values ...interface{}) {
+	Ctx(ctx).Info().Msgf(format, values...)
+}
+
+// newWriter returns the writer of the log events to out in the log format, with
+// the secrets masked.
+func newWriter(out io.Writer, color bool) io.Writer {
+	out = redactWriter{out}
+	if logFormat == FormatJSON {
+		return out
+	}
+	return zerolog.ConsoleWriter{Out: out, TimeFormat: time.UnixDate, NoColor: !color}
+}
+
+// Init sets up the log, written in the format console or json to the rotated
+// files of path, or stderr if empty.
+func Init(path string, count uint, size int64, logLevel string, format string) (err error) {
 	level, err := zerolog.ParseLevel(logLevel)
 	if err != nil {
 		return
 	}
+	switch format {
+	case "", FormatConsole:
+		logFormat = FormatConsole
+	case FormatJSON:
+		logFormat = FormatJSON
+	default:
+		return fmt.Errorf("unknown log format %q, should be %s or %s", format, FormatConsole, FormatJSON)
+	}
 	var logWriter io.Writer
-	var stdout io.Writer = zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.UnixDate}
+	// The standard output is read by scripts, it stays in the console format
+	var stdout io.Writer = zerolog.ConsoleWriter{Out: redactWriter{os.Stdout}, TimeFormat: time.UnixDate}
 	if len(path) > 0 {
 		Log2File = true
 		f, err := rotatelogs.New(
@@ -90,9 +148,9 @@ func Init(path string, count uint, size int64, logLevel string) (err error) {
 		if err != nil {
 			return err
 		}
-		logWriter = zerolog.ConsoleWriter{Out: f, TimeFormat: time.UnixDate, NoColor: true}
+		logWriter = newWriter(f, false)
 	} else {
-		logWriter = zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.UnixDate}
+		logWriter = newWriter(os.Stderr, true)
 	}
 	rootLogWriter = logWriter
 	l := zerolog.New(logWriter).With().Timestamp().Logger().Level(level)
@@ -0,0 +1,44 @@
+package logger
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartWritingToFile_Concurrent(t *testing.T) {
+	dir := t.TempDir()
+	la, fa, err := StartWritingToFile(filepath.Join(dir, "a.out"), "capture-a")
+	require.NoError(t, err)
+	lb, fb, err := StartWritingToFile(filepath.Join(dir, "b.out"), "capture-b")
+	require.NoError(t, err)
+
+	ctxA := WithContext(context.Background(), la)
+	ctxB := WithContext(context.Background(), lb)
+	LogCtx(ctxA, "line of a")
+	LogCtx(ctxB, "line of b")
+	require.NoError(t, StopWritingToFile(fb))
+	LogCtx(ctxA, "line of a after b")
+	Log("line of the agent")
+	require.NoError(t, StopWritingToFile(fa))
+
+	a, err := os.ReadFile(fa.Name())
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(a), CaptureIDField+"=capture-a"))
+	assert.Contains(t, string(a), "line of a after b")
+	assert.NotContains(t, string(a), "line of b")
+	assert.NotContains(t, string(a), "line of the agent")
+
+	b, err := os.ReadFile(fb.Name())
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(b), "\n"))
+	assert.Contains(t, string(b), CaptureIDField+"=capture-b")
+	assert.Contains(t, string(b), "line of b")
+
+	assert.Same(t, GetLogger(), Ctx(context.Background()))
+}
@@ -0,0 +1,105 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// Mask replaces the secrets in the log.
+const Mask = "****"
+
+// urlCredentials matches the user:password@ of URLs, the scheme is kept.
+var urlCredentials = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)[^\s/@:]+:[^\s/@]*@`)
+
+type redaction struct {
+	secrets  []string
+	patterns []*regexp.Regexp
+}
+
+var redactions atomic.Value
+
+func init() {
+	redactions.Store(&redaction{})
+}
+
+// SetRedaction sets the secrets masked in the log, such as the API key, and the
+// regular expressions of the values to mask: the groups of the matches if the
+// expression has groups, otherwise the whole matches. The credentials of URLs
+// are always masked.
+func SetRedaction(secrets []string, patterns []string) error {
+	r := &redaction{}
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		r.secrets = append(r.secrets, secret)
+		// The secret as it's escaped in the json log
+		if escaped, err := json.Marshal(secret); err == nil {
+			if s := string(escaped[1 : len(escaped)-1]); s != secret {
+				r.secrets = append(r.secrets, s)
+			}
+		}
+	}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid log redaction %q: %w", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	redactions.Store(r)
+	return nil
+}
+
+// Redact returns s with the secrets masked.
+func Redact(s string) string {
+	r := redactions.Load().(*redaction)
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, Mask)
+	}
+	s = urlCredentials.ReplaceAllString(s, "${1}"+Mask+":"+Mask+"@")
+	for _, re := range r.patterns {
+		s = maskMatches(re, s)
+	}
+	return s
+}
+
+func maskMatches(re *regexp.Regexp, s string) string {
+	if re.NumSubexp() == 0 {
+		return re.ReplaceAllLiteralString(s, Mask)
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range re.FindAllStringSubmatchIndex(s, -1) {
+		for g := 2; g < len(m); g += 2 {
+			start, end := m[g], m[g+1]
+			if start < last || start == end {
+				// unmatched, empty or nested group
+				continue
+			}
+			b.WriteString(s[last:start])
+			b.WriteString(Mask)
+			last = end
+		}
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// redactWriter masks the secrets of every log event before it's written to w.
+type redactWriter struct {
+	w io.Writer
+}
+
+func (r redactWriter) Write(p []byte) (int, error) {
+	_, err := io.WriteString(r.w, Redact(string(p)))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,18 @@
+package metrics
+
+// The metrics of the agent.
+var (
+	CapturesStarted   = NewCounterVec("yc_agent_captures_started_total", "Captures started, per collector.", "collector")
+	CapturesSucceeded = NewCounterVec("yc_agent_captures_succeeded_total", "Captures transmitted, per collector.", "collector")
+	CapturesFailed    = NewCounterVec("yc_agent_captures_failed_total", "Captures failed or not transmitted, per collector.", "collector")
+
+	UploadBytes    = NewCounterVec("yc_agent_upload_bytes_total", "Bytes posted to the server, per data type.", "dt")
+	UploadDuration = NewHistogramVec("yc_agent_upload_duration_seconds", "Latency of the posts to the server, per data type.", DefBuckets, "dt")
+	UploadFailures = NewCounterVec("yc_agent_upload_failures_total", "Posts to the server failed, per data type.", "dt")
+
+	M3CycleDuration    = NewGauge("yc_agent_m3_cycle_duration_seconds", "Duration of the last M3 cycle.")
+	M3LastSuccess      = NewGauge("yc_agent_m3_last_success_timestamp_seconds", "Unix time of the last successful M3 cycle.")
+	DiscoveredJVMs     = NewGaugeVec("yc_agent_discovered_jvms", "JVMs matched by the last discovery, per process token.", "token")
+	HealthCheckLatency = NewGaugeVec("yc_agent_healthcheck_duration_seconds", "Round trip time of the last health check, per app.", "app")
+	HealthCheckUp      = NewGaugeVec("yc_agent_healthcheck_up", "Whether the last health check succeeded, per app.", "app")
+)
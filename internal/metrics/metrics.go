@@ -0,0 +1,364 @@
+// Package metrics exposes the internals of the agent in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefBuckets are the default histogram buckets, in seconds.
+var DefBuckets = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	registryMtx sync.Mutex
+	registry    = map[string]metric{}
+)
+
+func register(m metric) {
+	registryMtx.Lock()
+	defer registryMtx.Unlock()
+
+	if _, ok := registry[m.name()]; ok {
+		panic(fmt.Sprintf("metric %s registered twice", m.name()))
+	}
+	registry[m.name()] = m
+}
+
+// WriteText writes all the registered metrics in the Prometheus text format, ordered by name.
+func WriteText(w io.Writer) {
+	registryMtx.Lock()
+	metrics := make([]metric, 0, len(registry))
+	for _, m := range registry {
+		metrics = append(metrics, m)
+	}
+	registryMtx.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name() < metrics[j].name() })
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves the registered metrics.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteText(w)
+	})
+}
+
+// desc is the name, help and label names shared by the series of a metric.
+type desc struct {
+	n      string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) name() string {
+	return d.n
+}
+
+func (d *desc) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.n, escapeHelp(d.help), d.n, d.typ)
+}
+
+// labelPairs formats the labels of the values, with the extra pair if not empty.
+func (d *desc) labelPairs(values []string, extra ...string) string {
+	if len(values) == 0 && len(extra) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(values)+1)
+	for i, v := range values {
+		pairs = append(pairs, d.labels[i]+`="`+escapeLabel(v)+`"`)
+	}
+	if len(extra) == 2 {
+		pairs = append(pairs, extra[0]+`="`+escapeLabel(extra[1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s has labels %v, got values %v", d.n, d.labels, values))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// series are the label values of a metric and their series, written sorted by the values.
+type series[T any] struct {
+	desc
+	mtx    sync.Mutex
+	values map[string][]string
+	all    map[string]*T
+	create func() *T
+}
+
+func (s *series[T]) with(values []string) *T {
+	key := s.key(values)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if t, ok := s.all[key]; ok {
+		return t
+	}
+	t := s.create()
+	s.values[key] = append([]string(nil), values...)
+	s.all[key] = t
+	return t
+}
+
+func (s *series[T]) each(fn func(values []string, t *T)) {
+	s.mtx.Lock()
+	keys := make([]string, 0, len(s.all))
+	for k := range s.all {
+		keys = append(keys, k)
+	}
+	s.mtx.Unlock()
+
+	sort.Strings(keys)
+	for _, k := range keys {
+		s.mtx.Lock()
+		values, t := s.values[k], s.all[k]
+		s.mtx.Unlock()
+		fn(values, t)
+	}
+}
+
+func newSeries[T any](name, help, typ string, labels []string, create func() *T) *series[T] {
+	return &series[T]{
+		desc:   desc{n: name, help: help, typ: typ, labels: labels},
+		values: map[string][]string{},
+		all:    map[string]*T{},
+		create: create,
+	}
+}
+
+// value is a float64 updated atomically.
+type value struct {
+	mtx sync.Mutex
+	v   float64
+}
+
+func (v *value) add(d float64) {
+	v.mtx.Lock()
+	v.v += d
+	v.mtx.Unlock()
+}
+
+func (v *value) set(f float64) {
+	v.mtx.Lock()
+	v.v = f
+	v.mtx.Unlock()
+}
+
+func (v *value) get() float64 {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	return v.v
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	value
+}
+
+func (c *Counter) Inc() {
+	c.add(1)
+}
+
+// Add adds d, which must not be negative.
+func (c *Counter) Add(d float64) {
+	if d < 0 {
+		panic("counter can't decrease")
+	}
+	c.add(d)
+}
+
+func (c *Counter) Value() float64 {
+	return c.get()
+}
+
+// CounterVec is a counter per label values.
+type CounterVec struct {
+	*series[Counter]
+}
+
+// NewCounterVec registers the counter name with the labels.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{newSeries(name, help, "counter", labels, func() *Counter { return &Counter{} })}
+	register(v)
+	return v
+}
+
+func (v *CounterVec) WithLabelValues(values ...string) *Counter {
+	return v.with(values)
+}
+
+func (v *CounterVec) write(w io.Writer) {
+	v.writeHeader(w)
+	v.each(func(values []string, c *Counter) {
+		fmt.Fprintf(w, "%s%s %s\n", v.n, v.labelPairs(values), formatFloat(c.Value()))
+	})
+}
+
+// Gauge is a value that goes up and down.
+type Gauge struct {
+	value
+}
+
+func (g *Gauge) Set(f float64) {
+	g.set(f)
+}
+
+func (g *Gauge) Add(d float64) {
+	g.add(d)
+}
+
+// SetToCurrentTime sets the gauge to the current unix time in seconds.
+func (g *Gauge) SetToCurrentTime() {
+	g.set(float64(time.Now().UnixNano()) / 1e9)
+}
+
+func (g *Gauge) Value() float64 {
+	return g.get()
+}
+
+// GaugeVec is a gauge per label values.
+type GaugeVec struct {
+	*series[Gauge]
+}
+
+// NewGaugeVec registers the gauge name with the labels.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{newSeries(name, help, "gauge", labels, func() *Gauge { return &Gauge{} })}
+	register(v)
+	return v
+}
+
+// NewGauge registers the gauge name without labels.
+func NewGauge(name, help string) *Gauge {
+	return NewGaugeVec(name, help).WithLabelValues()
+}
+
+func (v *GaugeVec) WithLabelValues(values ...string) *Gauge {
+	return v.with(values)
+}
+
+func (v *GaugeVec) write(w io.Writer) {
+	v.writeHeader(w)
+	v.each(func(values []string, g *Gauge) {
+		fmt.Fprintf(w, "%s%s %s\n", v.n, v.labelPairs(values), formatFloat(g.Value()))
+	})
+}
+
+// GaugeFunc is a gauge whose value is read when the metrics are written.
+type GaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+// NewGaugeFunc registers the gauge name, valued by fn.
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{n: name, help: help, typ: "gauge"}, fn: fn}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", g.n, formatFloat(g.fn()))
+}
+
+// Histogram counts the observed values in buckets.
+type Histogram struct {
+	mtx     sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *Histogram) Observe(f float64) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	for i, upper := range h.buckets {
+		if f <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += f
+}
+
+// HistogramVec is a histogram per label values.
+type HistogramVec struct {
+	*series[Histogram]
+}
+
+// NewHistogramVec registers the histogram name with the buckets and the labels.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	v := &HistogramVec{newSeries(name, help, "histogram", labels, func() *Histogram {
+		return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	})}
+	register(v)
+	return v
+}
+
+func (v *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	return v.with(values)
+}
+
+func (v *HistogramVec) write(w io.Writer) {
+	v.writeHeader(w)
+	v.each(func(values []string, h *Histogram) {
+		h.mtx.Lock()
+		defer h.mtx.Unlock()
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.n, v.labelPairs(values, "le", formatFloat(upper)), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.n, v.labelPairs(values, "le", "+Inf"), h.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.n, v.labelPairs(values), formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.n, v.labelPairs(values), h.count)
+	})
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteText(t *testing.T) {
+	c := NewCounterVec("test_counter_total", "A counter\nof tests.", "name")
+	c.WithLabelValues(`b"1`).Add(2)
+	c.WithLabelValues("a").Inc()
+	g := NewGauge("test_gauge", "A gauge.")
+	g.Set(1.5)
+	h := NewHistogramVec("test_seconds", "A histogram.", []float64{1, 0.5}, "dt")
+	h.WithLabelValues("gc").Observe(0.7)
+	h.WithLabelValues("gc").Observe(3)
+	NewGaugeFunc("test_func", "A gauge func.", func() float64 { return 7 })
+
+	var buf bytes.Buffer
+	WriteText(&buf)
+	out := buf.String()
+
+	assert.Contains(t, out, `# HELP test_counter_total A counter\nof tests.
+# TYPE test_counter_total counter
+test_counter_total{name="a"} 1
+test_counter_total{name="b\"1"} 2
+`)
+	assert.Contains(t, out, "# TYPE test_gauge gauge\ntest_gauge 1.5\n")
+	assert.Contains(t, out, `test_seconds_bucket{dt="gc",le="0.5"} 0
+test_seconds_bucket{dt="gc",le="1"} 1
+test_seconds_bucket{dt="gc",le="+Inf"} 2
+test_seconds_sum{dt="gc"} 3.7
+test_seconds_count{dt="gc"} 2
+`)
+	assert.Contains(t, out, "test_func 7\n")
+
+	assert.Panics(t, func() { NewGauge("test_gauge", "Again.") })
+	assert.Panics(t, func() { c.WithLabelValues() })
+	assert.Panics(t, func() { c.WithLabelValues("a").Add(-1) })
+}